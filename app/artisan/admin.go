@@ -0,0 +1,63 @@
+package artisan
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+
+	"github.com/google/uuid"
+)
+
+func runAdmin(args []string) error {
+	target := args[0]
+	rest := args[1:]
+
+	switch target {
+	case "bootstrap":
+		return runAdminBootstrap(rest)
+	default:
+		return fmt.Errorf("unknown admin command: %s", target)
+	}
+}
+
+// runAdminBootstrap grants the superadmin role to an existing user, e.g.
+// `go run . artisan admin:bootstrap owner@example.com`
+func runAdminBootstrap(args []string) error {
+	if len(args) == 0 {
+		return errors.New("please provide the email of the user to promote")
+	}
+	email := strings.ToLower(strings.TrimSpace(args[0]))
+
+	if err := initializers.DbConnection(); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	seedRoles()
+
+	var user models.User
+	if err := initializers.Db.Where("LOWER(email) = ?", email).First(&user).Error; err != nil {
+		return fmt.Errorf("no user found with email %s", email)
+	}
+
+	var role models.Role
+	if err := initializers.Db.Where("name = ?", models.RoleSuperAdmin).First(&role).Error; err != nil {
+		return fmt.Errorf("superadmin role is missing: %w", err)
+	}
+
+	var existing models.UserRole
+	if initializers.Db.Where("user_id = ? AND role_id = ?", user.ID, role.ID).First(&existing).RowsAffected > 0 {
+		fmt.Printf("%s is already a superadmin\n", user.Email)
+		return nil
+	}
+
+	userRole := models.UserRole{ID: uuid.New().String(), UserID: user.ID, RoleID: role.ID}
+	if err := initializers.Db.Create(&userRole).Error; err != nil {
+		return fmt.Errorf("failed to grant superadmin: %w", err)
+	}
+
+	fmt.Printf("Granted superadmin to %s\n", user.Email)
+	return nil
+}
@@ -19,6 +19,16 @@ func Run(args []string) error {
 	switch cmd {
 	case "migrate":
 		return runMigrate(rest)
+	case "admin":
+		if len(rest) == 0 {
+			return errors.New("please specify an admin command (e.g. admin:bootstrap user@example.com)")
+		}
+		return runAdmin(rest)
+	case "ledger":
+		if len(rest) == 0 {
+			return errors.New("please specify a ledger command (e.g. ledger:reconcile)")
+		}
+		return runLedger(rest)
 	case "make":
 		if len(rest) == 0 {
 			return errors.New("please specify what to make (e.g. make model Foo)")
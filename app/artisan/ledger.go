@@ -0,0 +1,61 @@
+package artisan
+
+import (
+	"errors"
+	"fmt"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/services"
+)
+
+func runLedger(args []string) error {
+	target := args[0]
+	rest := args[1:]
+
+	switch target {
+	case "reconcile":
+		return runLedgerReconcile(rest)
+	default:
+		return fmt.Errorf("unknown ledger command: %s", target)
+	}
+}
+
+// runLedgerReconcile verifies that every journal balances and that the credit balances on users
+// match their ledger accounts, e.g. `go run . artisan ledger:reconcile`.
+// With `fix`, mismatched users get an adjustment journal that brings the ledger to their balances.
+func runLedgerReconcile(args []string) error {
+	fix := len(args) > 0 && args[0] == "fix"
+
+	if err := initializers.DbConnection(); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	report, err := services.ReconcileLedger(initializers.Db)
+	if err != nil {
+		return fmt.Errorf("failed to reconcile ledger: %w", err)
+	}
+
+	fmt.Printf("Checked %d users\n", report.Users)
+	for _, journal := range report.Unbalanced {
+		fmt.Printf("Unbalanced journal %s: entries sum to %d\n", journal.JournalID, journal.Sum)
+	}
+	for _, m := range report.Mismatches {
+		fmt.Printf("User %s: free %d (ledger %d), extra %d (ledger %d)\n",
+			m.UserID, m.FreeCredit, m.LedgerFree, m.Credit, m.LedgerExtra)
+	}
+	if report.OK() {
+		fmt.Println("Ledger is consistent")
+		return nil
+	}
+
+	if fix && len(report.Mismatches) > 0 {
+		if err := services.AdjustLedger(initializers.Db, report.Mismatches); err != nil {
+			return fmt.Errorf("failed to post adjustments: %w", err)
+		}
+		fmt.Printf("Posted adjustments for %d users\n", len(report.Mismatches))
+		if len(report.Unbalanced) == 0 {
+			return nil
+		}
+	}
+	return errors.New("ledger is inconsistent")
+}
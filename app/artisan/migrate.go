@@ -1,10 +1,14 @@
 package artisan
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"time"
 
 	"GoFiberMVC/app/initializers"
 	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
 
 	"github.com/google/uuid"
 )
@@ -19,9 +23,31 @@ var modelsToMigrate = []interface{}{
 	&models.PurchaseLog{},
 	&models.SystemConfig{},
 	&models.Transaction{},
+	&models.PaymentEvent{},
+	&models.PaymentReconcileRun{},
+	&models.Invoice{},
+	&models.Coupon{},
+	&models.Referral{},
+	&models.SubscriptionEvent{},
+	&models.InvoiceSequence{},
 	&models.CreditLog{},
+	&models.LedgerJournal{},
+	&models.LedgerEntry{},
+	&models.CreditBatch{},
 	&models.Session{},
+	&models.RefreshToken{},
+	&models.TwoFactorAuth{},
+	&models.RecoveryCode{},
+	&models.APIKey{},
 	&models.TVToken{},
+	&models.TVDevice{},
+	&models.SecurityAuditLog{},
+	&models.AccountToken{},
+	&models.UserIdentity{},
+	&models.OAuthState{},
+	&models.Role{},
+	&models.RolePermission{},
+	&models.UserRole{},
 }
 
 func runMigrate(args []string) error {
@@ -49,10 +75,65 @@ func runMigrate(args []string) error {
 		fmt.Println("All tables dropped successfully")
 	}
 
+	// Accounts that existed before email verification was introduced are treated as verified
+	backfillVerified := initializers.Db.Migrator().HasTable(&models.User{}) &&
+		!initializers.Db.Migrator().HasColumn(&models.User{}, "email_verified_at")
+
+	// Sessions used to store raw bearer tokens; they are rehashed after migrating
+	hashSessions := initializers.Db.Migrator().HasColumn(&models.Session{}, "token") &&
+		!initializers.Db.Migrator().HasColumn(&models.Session{}, "token_hash")
+
+	// Transactions used to carry Flip-specific columns; they move to the provider-neutral ones
+	migrateFlipColumns := initializers.Db.Migrator().HasColumn(&models.Transaction{}, "flip_url")
+
 	if err := initializers.Db.AutoMigrate(modelsToMigrate...); err != nil {
 		return fmt.Errorf("migration error: %w", err)
 	}
 
+	if backfillVerified {
+		result := initializers.Db.Model(&models.User{}).Where("email_verified_at IS NULL").Update("email_verified_at", time.Now())
+		fmt.Printf("Marked %d existing users as email-verified\n", result.RowsAffected)
+	}
+
+	if hashSessions {
+		if err := hashExistingSessions(); err != nil {
+			return fmt.Errorf("failed to hash existing sessions: %w", err)
+		}
+	}
+
+	if migrateFlipColumns {
+		if err := migrateFlipTransactions(); err != nil {
+			return fmt.Errorf("failed to migrate Flip transactions: %w", err)
+		}
+	}
+
+	// Balances that predate the ledger (or users never posted to) get an opening journal
+	opened, err := services.PostOpeningBalances(initializers.Db)
+	if err != nil {
+		return fmt.Errorf("failed to post opening balances: %w", err)
+	}
+	if opened > 0 {
+		fmt.Printf("Posted opening ledger balances for %d users\n", opened)
+	}
+
+	// Extra credits from before credit batches become a batch that never expires
+	batched, err := services.BackfillCreditBatches(initializers.Db)
+	if err != nil {
+		return fmt.Errorf("failed to backfill credit batches: %w", err)
+	}
+	if batched > 0 {
+		fmt.Printf("Created opening credit batches for %d users\n", batched)
+	}
+
+	// Upgrades settled before the replaced plan's expiry was stored read it from the subscription history
+	upgrades, err := backfillUpgradeExpiries()
+	if err != nil {
+		return fmt.Errorf("failed to backfill upgrade expiries: %w", err)
+	}
+	if upgrades > 0 {
+		fmt.Printf("Recorded the replaced plan's expiry for %d upgrades\n", upgrades)
+	}
+
 	// Seed default data
 	seedDefaults()
 
@@ -60,6 +141,73 @@ func runMigrate(args []string) error {
 	return nil
 }
 
+// hashExistingSessions replaces plaintext session tokens with their hashes so signed-in users stay signed in
+func hashExistingSessions() error {
+	var rows []struct {
+		ID        string
+		Token     string
+		CreatedAt time.Time
+	}
+	if err := initializers.Db.Table("sessions").Select("id, token, created_at").Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		sum := sha256.Sum256([]byte(row.Token))
+		err := initializers.Db.Model(&models.Session{}).Where("id = ?", row.ID).Updates(map[string]interface{}{
+			"token_hash":     hex.EncodeToString(sum[:]),
+			"last_used_at":   row.CreatedAt,
+			"max_expires_at": row.CreatedAt.Add(90 * 24 * time.Hour),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := initializers.Db.Migrator().DropColumn(&models.Session{}, "token"); err != nil {
+		return err
+	}
+	fmt.Printf("Hashed %d existing session tokens\n", len(rows))
+	return nil
+}
+
+// migrateFlipTransactions copies the Flip columns into the provider-neutral ones and drops them.
+// Pending transactions still hold the Flip bill link ID in external_id, which is what status checks need.
+func migrateFlipTransactions() error {
+	result := initializers.Db.Exec(`UPDATE transactions SET
+		provider = 'flip',
+		provider_ref = external_id,
+		payment_url = flip_url,
+		provider_data = json_build_object('company_code', flip_company_code, 'product_code', flip_product_code)::text
+		WHERE payment_method LIKE 'flip%' AND (provider IS NULL OR provider = '')`)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	for _, column := range []string{"flip_url", "flip_company_code", "flip_product_code"} {
+		if err := initializers.Db.Migrator().DropColumn(&models.Transaction{}, column); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Moved %d Flip transactions to provider-neutral columns\n", result.RowsAffected)
+	return nil
+}
+
+// backfillUpgradeExpiries sets from_expires_at on settled upgrades that lack it: the user's subscription
+// expiry as of the last subscription event before the upgrade was paid
+func backfillUpgradeExpiries() (int64, error) {
+	result := initializers.Db.Exec(`UPDATE transactions SET from_expires_at = last_event.expires_at
+		FROM (
+			SELECT DISTINCT ON (t.id) t.id AS transaction_id, e.expires_at
+			FROM transactions t
+			JOIN subscription_events e ON e.user_id = t.user_id AND e.expires_at IS NOT NULL AND e.created_at < t.paid_at
+			WHERE t.from_plan_id IS NOT NULL AND t.from_expires_at IS NULL AND t.paid_at IS NOT NULL
+			ORDER BY t.id, e.created_at DESC
+		) AS last_event
+		WHERE transactions.id = last_event.transaction_id`)
+	return result.RowsAffected, result.Error
+}
+
 // seedDefaults creates default configuration and packages
 func seedDefaults() {
 	// Seed default system configs
@@ -71,6 +219,22 @@ func seedDefaults() {
 		{ID: uuid.New().String(), Key: models.ConfigFlipSecretKey, Value: ""},          // Flip API Secret Key
 		{ID: uuid.New().String(), Key: models.ConfigFlipValidationToken, Value: ""},    // Flip Validation Token
 		{ID: uuid.New().String(), Key: models.ConfigFlipEnvironment, Value: "sandbox"}, // Flip environment (sandbox/production)
+		{ID: uuid.New().String(), Key: models.ConfigPaymentProvider, Value: "flip"},    // Provider for new bills
+		{ID: uuid.New().String(), Key: models.ConfigMidtransServerKey, Value: ""},      // Midtrans Server Key
+		{ID: uuid.New().String(), Key: models.ConfigMidtransEnvironment, Value: "sandbox"},
+		{ID: uuid.New().String(), Key: models.ConfigBillValidityHours, Value: "24"},  // Unpaid bills expire after 24 hours
+		{ID: uuid.New().String(), Key: models.ConfigInvoicePrefix, Value: "INV"},     // Invoice numbers: INV-2026-000001
+		{ID: uuid.New().String(), Key: models.ConfigCompanyName, Value: "Karayouke"}, // Seller details printed on invoices
+		{ID: uuid.New().String(), Key: models.ConfigCompanyAddress, Value: ""},
+		{ID: uuid.New().String(), Key: models.ConfigCompanyTaxID, Value: ""},
+		{ID: uuid.New().String(), Key: models.ConfigCompanyEmail, Value: ""},
+		{ID: uuid.New().String(), Key: models.ConfigReferrerCredits, Value: "5"},      // Extra credits for the inviting user
+		{ID: uuid.New().String(), Key: models.ConfigReferredCredits, Value: "5"},      // Extra credits for the invited user
+		{ID: uuid.New().String(), Key: models.ConfigReferralPurchase, Value: "false"}, // Reward on verification, not first purchase
+		{ID: uuid.New().String(), Key: models.ConfigReferralMaxPerIP, Value: "3"},     // Referred sign-ups per IP per day
+		{ID: uuid.New().String(), Key: models.ConfigReferralMaxDomain, Value: "5"},    // Referrals per email domain per referrer
+		{ID: uuid.New().String(), Key: models.ConfigRenewalLeadDays, Value: "3"},      // Renewal bills are created 3 days before expiry
+		{ID: uuid.New().String(), Key: models.ConfigRenewalGraceDays, Value: "3"},     // Plans stay active 3 days past expiry while unpaid
 	}
 
 	for _, config := range defaultConfigs {
@@ -95,4 +259,26 @@ func seedDefaults() {
 		initializers.Db.Create(&dummyPackage)
 		fmt.Println("Created dummy package: Starter Pack (10 credits for IDR 0)")
 	}
+
+	seedRoles()
+}
+
+// seedRoles creates the built-in admin roles. Permissions are only assigned when a role is first
+// created so that changes made from the admin panel are preserved.
+func seedRoles() {
+	for name, permissions := range models.DefaultRolePermissions {
+		var role models.Role
+		if initializers.Db.Where("name = ?", name).First(&role).RowsAffected > 0 {
+			continue
+		}
+		role = models.Role{ID: uuid.New().String(), Name: name}
+		if err := initializers.Db.Create(&role).Error; err != nil {
+			fmt.Printf("Warning: failed to create role %s: %v\n", name, err)
+			continue
+		}
+		for _, permission := range permissions {
+			initializers.Db.Create(&models.RolePermission{ID: uuid.New().String(), RoleID: role.ID, Permission: permission})
+		}
+		fmt.Printf("Created default role: %s\n", name)
+	}
 }
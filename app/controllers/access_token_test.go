@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/viper"
+)
+
+// useTestSigningKeys points oauth.* at a freshly generated RSA key pair
+func useTestSigningKeys(t *testing.T) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	dir := t.TempDir()
+	files := map[string]*pem.Block{
+		"oauth.privateKey": {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+		"oauth.publicKey":  {Type: "PUBLIC KEY", Bytes: public},
+	}
+	for setting, block := range files {
+		path := filepath.Join(dir, setting+".pem")
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatalf("write key: %v", err)
+		}
+		viper.Set(setting, path)
+	}
+}
+
+func TestAccessTokenNeedsLiveSession(t *testing.T) {
+	testDB(t, &models.User{}, &models.Session{}, &models.RefreshToken{})
+	useTestSigningKeys(t)
+
+	user := models.User{ID: generateID(), Name: "Token Test", Email: generateID() + "@example.test"}
+	now := time.Now()
+	session := models.Session{ID: generateID(), TokenHash: hashToken(generateToken()), UserID: user.ID, LastUsedAt: now, ExpiresAt: now.Add(time.Hour), MaxExpiresAt: now.Add(time.Hour)}
+	for _, row := range []interface{}{&user, &session} {
+		if err := initializers.Db.Create(row).Error; err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	t.Cleanup(func() {
+		initializers.Db.Where("user_id = ?", user.ID).Delete(&models.Session{})
+		initializers.Db.Delete(&user)
+	})
+
+	resp, err := issueJWTTokens(&session)
+	if err != nil {
+		t.Fatalf("issue tokens: %v", err)
+	}
+	whoami := func(ctx *fiber.Ctx) error {
+		if userFromBearer(ctx, strings.TrimPrefix(ctx.Get(fiber.HeaderAuthorization), "Bearer ")) == nil {
+			return ctx.SendStatus(401)
+		}
+		return ctx.SendStatus(200)
+	}
+	app := fiber.New()
+	app.Get("/me", whoami)
+	app.Post("/me", whoami)
+	status := func(method string) int {
+		req := httptest.NewRequest(method, "/me", nil)
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+resp.Token)
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("%s /me: %v", method, err)
+		}
+		return res.StatusCode
+	}
+
+	if got := status("GET"); got != 200 {
+		t.Fatalf("GET with a live session = %d", got)
+	}
+
+	// Deleting the row behind the app's back: reads may ride the cache, writes may not
+	initializers.Db.Delete(&session)
+	if got := status("POST"); got != 401 {
+		t.Fatalf("POST after the session row went away = %d", got)
+	}
+	if got := status("GET"); got != 401 {
+		t.Fatalf("GET after a failed lookup = %d", got)
+	}
+
+	// Revoking through the app takes effect at once, reads included
+	session.ID = generateID()
+	session.TokenHash = hashToken(generateToken())
+	if err := initializers.Db.Create(&session).Error; err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	if resp, err = issueJWTTokens(&session); err != nil {
+		t.Fatalf("issue tokens: %v", err)
+	}
+	if got := status("GET"); got != 200 {
+		t.Fatalf("GET with the new session = %d", got)
+	}
+	revokeSession(session.ID)
+	if got := status("GET"); got != 401 {
+		t.Fatalf("GET after revoking the session = %d", got)
+	}
+
+	// A token naming no session at all is refused
+	bare, err := services.SignAccessToken(user.ID, "", generateID())
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if getAccessTokenUser(nil, bare) != nil {
+		t.Fatal("access token without a session was accepted")
+	}
+}
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/middlewares"
+	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// capturedMail keeps sent messages instead of delivering them
+type capturedMail struct {
+	mu   sync.Mutex
+	sent []services.MailMessage
+}
+
+func (m *capturedMail) Send(msg services.MailMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *capturedMail) messages() []services.MailMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]services.MailMessage(nil), m.sent...)
+}
+
+// linkViews renders an email as nothing but its Link
+type linkViews struct{}
+
+func (linkViews) Load() error { return nil }
+
+func (linkViews) Render(w io.Writer, name string, data interface{}, layouts ...string) error {
+	_, err := fmt.Fprint(w, data.(fiber.Map)["Link"])
+	return err
+}
+
+// captureMail routes outgoing email into the returned capture for the rest of the test
+func captureMail(t *testing.T) *capturedMail {
+	t.Helper()
+	previous := services.DefaultMailer()
+	capture := &capturedMail{}
+	services.SetMailer(capture)
+	services.SetMailViews(linkViews{})
+	t.Cleanup(func() {
+		services.SetMailer(previous)
+		services.SetMailViews(nil)
+	})
+	return capture
+}
+
+func TestLoginLockoutAndUnlock(t *testing.T) {
+	testDB(t, &models.User{}, &models.Session{}, &models.RefreshToken{}, &models.AccountToken{}, &models.TwoFactorAuth{}, &models.SecurityAuditLog{})
+	t.Setenv("APP_URL", testFrontend)
+	mail := captureMail(t)
+
+	email := strings.ToLower(generateID()) + "@example.test"
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	user := models.User{ID: generateID(), Name: "Locked Out", Email: email, Password: string(hash)}
+	if err := initializers.Db.Create(&user).Error; err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	// Wired like the login route; requests carry their client IP in X-Forwarded-For
+	throttle := middlewares.NewThrottleMiddleware(models.AuditScopeLogin, ThrottleEmailKeys)
+	throttle.FailureStatuses = []int{fiber.StatusUnauthorized}
+	throttle.OnLockout = SendUnlockEmail
+	auth := &AuthController{}
+	app := fiber.New(fiber.Config{ProxyHeader: fiber.HeaderXForwardedFor})
+	app.Post("/login", throttle.Limit, auth.Login)
+	app.Post("/unlock", auth.UnlockAccount)
+
+	attacker, owner := "198.51.100.7", "203.0.113.9"
+	t.Cleanup(func() {
+		lockoutStore.Reset(loginAccountKey(email), models.AuditScopeLogin+":ip:"+attacker, models.AuditScopeLogin+":ip:"+owner)
+		initializers.Db.Where("user_id = ?", user.ID).Delete(&models.Session{})
+		initializers.Db.Where("user_id = ?", user.ID).Delete(&models.AccountToken{})
+		initializers.Db.Where("identifier = ?", models.AuditScopeLogin+":email:"+email).Delete(&models.SecurityAuditLog{})
+		initializers.Db.Delete(&user)
+	})
+
+	post := func(path string, ip string, body string) int {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		req.Header.Set(fiber.HeaderXForwardedFor, ip)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		return resp.StatusCode
+	}
+	login := func(ip string, password string) int {
+		return post("/login", ip, fmt.Sprintf(`{"email":%q,"password":%q}`, email, password))
+	}
+
+	// The free attempts don't lock the account
+	limiter := services.NewAttemptLimiter()
+	for i := 0; i < limiter.FreeAttempts; i++ {
+		if status := login(attacker, "guess"); status != fiber.StatusUnauthorized {
+			t.Fatalf("guess %d: status %d", i+1, status)
+		}
+	}
+	if wait := lockoutStore.RetryAfter(loginAccountKey(email)); wait != 0 || len(mail.messages()) != 0 {
+		t.Fatalf("locked for %s after the free attempts, %d emails", wait, len(mail.messages()))
+	}
+
+	// One more locks it and emails the owner an unlock link
+	login(attacker, "guess")
+	if lockoutStore.RetryAfter(loginAccountKey(email)) == 0 {
+		t.Fatal("account not locked past the free attempts")
+	}
+	messages := mail.messages()
+	if len(messages) != 1 || messages[0].To != email || !strings.HasPrefix(messages[0].HTMLBody, testFrontend+"/unlock-account?token=") {
+		t.Fatalf("unlock emails = %+v", messages)
+	}
+
+	// While locked, even the owner with the right password from another address is turned away
+	if status := login(owner, "correct horse"); status != fiber.StatusTooManyRequests {
+		t.Fatalf("correct password on a locked account: status %d", status)
+	}
+
+	link, _ := url.Parse(messages[0].HTMLBody)
+	token := link.Query().Get("token")
+	if status := post("/unlock", owner, fmt.Sprintf(`{"token":%q}`, token)); status != fiber.StatusOK {
+		t.Fatalf("unlock: status %d", status)
+	}
+	if status := login(owner, "correct horse"); status != fiber.StatusOK {
+		t.Fatalf("login after unlocking: status %d", status)
+	}
+
+	// The link works once
+	if status := post("/unlock", owner, fmt.Sprintf(`{"token":%q}`, token)); status != fiber.StatusBadRequest {
+		t.Fatalf("reused unlock token: status %d", status)
+	}
+}
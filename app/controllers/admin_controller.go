@@ -1,40 +1,129 @@
 package controllers
 
 import (
+	"errors"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"GoFiberMVC/app/initializers"
 	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// AdminEmail is the email address with admin access
-const AdminEmail = "me@ruzman.my.id"
-
 type AdminController struct{}
 
-// IsAdmin checks if the current user is an admin
-func IsAdmin(ctx *fiber.Ctx) bool {
-	user := GetUserFromToken(ctx)
-	if user == nil {
-		return false
+// permissionsLocalsKey caches the user's permission set on the request context
+const permissionsLocalsKey = "auth_permissions"
+
+// GetUserPermissions returns the permissions granted to the user through their roles
+func GetUserPermissions(userID string) map[string]bool {
+	var permissions []string
+	initializers.Db.Model(&models.RolePermission{}).
+		Joins("JOIN user_roles ON user_roles.role_id = role_permissions.role_id").
+		Where("user_roles.user_id = ?", userID).
+		Distinct().
+		Pluck("role_permissions.permission", &permissions)
+
+	set := make(map[string]bool, len(permissions))
+	for _, permission := range permissions {
+		set[permission] = true
 	}
-	return user.Email == AdminEmail
+	return set
 }
 
-// AdminMiddleware restricts access to admin users only
+// getRequestPermissions loads (once per request) the permission set of the authenticated user
+func getRequestPermissions(ctx *fiber.Ctx) map[string]bool {
+	if permissions, ok := ctx.Locals(permissionsLocalsKey).(map[string]bool); ok {
+		return permissions
+	}
+	permissions := map[string]bool{}
+	if user := GetUserFromToken(ctx); user != nil {
+		permissions = GetUserPermissions(user.ID)
+	}
+	ctx.Locals(permissionsLocalsKey, permissions)
+	return permissions
+}
+
+// HasPermission checks if the current user holds the permission (superadmins hold all)
+func HasPermission(ctx *fiber.Ctx, permission string) bool {
+	permissions := getRequestPermissions(ctx)
+	return permissions[models.PermissionAll] || permissions[permission]
+}
+
+// IsAdmin checks if the current user holds any admin role
+func IsAdmin(ctx *fiber.Ctx) bool {
+	return len(getRequestPermissions(ctx)) > 0
+}
+
+// AdminMiddleware restricts access to users holding at least one admin role.
+// Admins must have two-factor authentication enabled and use a session that passed it;
+// API keys and sessions signed in without the challenge are refused.
 func AdminMiddleware(ctx *fiber.Ctx) error {
 	if !IsAdmin(ctx) {
 		return ctx.Status(403).JSON(fiber.Map{"error": "Admin access required"})
 	}
+	if user := GetUserFromToken(ctx); !twoFactorEnabled(user.ID) {
+		return ctx.Status(403).JSON(fiber.Map{
+			"error":               "Two-factor authentication is required for admin accounts",
+			"two_factor_required": true,
+		})
+	}
+	if session := currentSession(ctx); session == nil || session.MFAVerifiedAt == nil {
+		return ctx.Status(403).JSON(fiber.Map{
+			"error":             "Sign in again with your two-factor code to use admin tools",
+			"two_factor_reauth": true,
+		})
+	}
 	return ctx.Next()
 }
 
-// CheckAdmin returns whether the current user is an admin
+// RequirePermission restricts a route to admins holding the given permission
+func RequirePermission(permission string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if !HasPermission(ctx, permission) {
+			return ctx.Status(403).JSON(fiber.Map{"error": "Missing permission: " + permission})
+		}
+		return ctx.Next()
+	}
+}
+
+// CheckAdmin returns whether the current user is an admin, with their roles and permissions
 func (c *AdminController) CheckAdmin(ctx *fiber.Ctx) error {
-	return ctx.JSON(fiber.Map{"is_admin": IsAdmin(ctx)})
+	permissions := []string{}
+	for permission := range getRequestPermissions(ctx) {
+		permissions = append(permissions, permission)
+	}
+	sort.Strings(permissions)
+
+	roles := []string{}
+	if user := GetUserFromToken(ctx); user != nil {
+		initializers.Db.Model(&models.Role{}).
+			Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+			Where("user_roles.user_id = ?", user.ID).
+			Order("roles.name ASC").
+			Pluck("roles.name", &roles)
+	}
+
+	twoFactor := false
+	if user := GetUserFromToken(ctx); user != nil {
+		twoFactor = twoFactorEnabled(user.ID)
+	}
+	session := currentSession(ctx)
+
+	return ctx.JSON(fiber.Map{
+		"is_admin":            len(permissions) > 0,
+		"roles":               roles,
+		"permissions":         permissions,
+		"two_factor_enabled":  twoFactor,
+		"two_factor_verified": session != nil && session.MFAVerifiedAt != nil,
+	})
 }
 
 // ========================================
@@ -60,11 +149,22 @@ func (c *AdminController) GetConfigs(ctx *fiber.Ctx) error {
 
 	// Set defaults if not present
 	defaults := map[string]string{
-		models.ConfigRoomMaxDuration:  "120", // 2 hours default
-		models.ConfigRoomCreationCost: "1",   // 1 credit default
-		models.ConfigDefaultCredits:   "0",   // 0 credits for new users
-		models.ConfigDailyFreeCredits: "5",   // 5 daily free credits for free plan
-		models.ConfigFlipEnvironment:  "sandbox",
+		models.ConfigRoomMaxDuration:   "120", // 2 hours default
+		models.ConfigRoomCreationCost:  "1",   // 1 credit default
+		models.ConfigDefaultCredits:    "0",   // 0 credits for new users
+		models.ConfigDailyFreeCredits:  "5",   // 5 daily free credits for free plan
+		models.ConfigFlipEnvironment:   "sandbox",
+		models.ConfigPaymentProvider:   "flip",
+		models.ConfigBillValidityHours: "24",
+		models.ConfigInvoicePrefix:     "INV",
+		models.ConfigCompanyName:       "Karayouke",
+		models.ConfigReferrerCredits:   "5",
+		models.ConfigReferredCredits:   "5",
+		models.ConfigReferralPurchase:  "false",
+		models.ConfigReferralMaxPerIP:  "3",
+		models.ConfigReferralMaxDomain: "5",
+		models.ConfigRenewalLeadDays:   "3",
+		models.ConfigRenewalGraceDays:  "3",
 	}
 	for key, defaultValue := range defaults {
 		if _, exists := configMap[key]; !exists {
@@ -149,6 +249,9 @@ func (c *AdminController) DeleteConfig(ctx *fiber.Ctx) error {
 		models.ConfigFlipSecretKey,
 		models.ConfigFlipValidationToken,
 		models.ConfigFlipEnvironment,
+		models.ConfigPaymentProvider,
+		models.ConfigMidtransServerKey,
+		models.ConfigMidtransEnvironment,
 	}
 	for _, critical := range criticalConfigs {
 		if key == critical {
@@ -177,6 +280,7 @@ type CreatePackageRequest struct {
 	PackageDetail string `json:"package_detail"`
 	Price         int64  `json:"price"`
 	CreditAmount  int    `json:"credit_amount"`
+	ValidityDays  int    `json:"validity_days"` // days until purchased credits expire, 0 = never
 	Visibility    bool   `json:"visibility"`
 }
 
@@ -185,6 +289,7 @@ type UpdatePackageRequest struct {
 	PackageDetail string `json:"package_detail"`
 	Price         int64  `json:"price"`
 	CreditAmount  int    `json:"credit_amount"`
+	ValidityDays  int    `json:"validity_days"` // days until purchased credits expire, 0 = never
 	Visibility    bool   `json:"visibility"`
 }
 
@@ -205,6 +310,9 @@ func (c *AdminController) CreatePackage(ctx *fiber.Ctx) error {
 	if req.PackageName == "" {
 		return ctx.Status(400).JSON(fiber.Map{"error": "Package name is required"})
 	}
+	if req.ValidityDays < 0 {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Validity days cannot be negative"})
+	}
 
 	pkg := models.Package{
 		ID:            generateID(),
@@ -212,6 +320,7 @@ func (c *AdminController) CreatePackage(ctx *fiber.Ctx) error {
 		PackageDetail: []byte(req.PackageDetail),
 		Price:         req.Price,
 		CreditAmount:  req.CreditAmount,
+		ValidityDays:  req.ValidityDays,
 		Visibility:    req.Visibility,
 	}
 
@@ -237,11 +346,15 @@ func (c *AdminController) UpdatePackage(ctx *fiber.Ctx) error {
 	if err := ctx.BodyParser(&req); err != nil {
 		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
+	if req.ValidityDays < 0 {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Validity days cannot be negative"})
+	}
 
 	pkg.PackageName = req.PackageName
 	pkg.PackageDetail = []byte(req.PackageDetail)
 	pkg.Price = req.Price
 	pkg.CreditAmount = req.CreditAmount
+	pkg.ValidityDays = req.ValidityDays
 	pkg.Visibility = req.Visibility
 
 	if err := initializers.Db.Save(&pkg).Error; err != nil {
@@ -414,25 +527,6 @@ func (c *AdminController) AwardCredits(ctx *fiber.Ctx) error {
 		return ctx.Status(404).JSON(fiber.Map{"error": "User not found"})
 	}
 
-	var newBalance int
-	if req.CreditType == "free" {
-		newBalance = user.FreeCredit + req.Amount
-		if newBalance < 0 {
-			return ctx.Status(400).JSON(fiber.Map{"error": "Cannot reduce free credits below zero"})
-		}
-		user.FreeCredit = newBalance
-	} else {
-		newBalance = user.Credit + req.Amount
-		if newBalance < 0 {
-			return ctx.Status(400).JSON(fiber.Map{"error": "Cannot reduce extra credits below zero"})
-		}
-		user.Credit = newBalance
-	}
-
-	if err := initializers.Db.Save(&user).Error; err != nil {
-		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to update user credits"})
-	}
-
 	// Log the credit change
 	description := req.Description
 	if description == "" {
@@ -443,24 +537,44 @@ func (c *AdminController) AwardCredits(ctx *fiber.Ctx) error {
 		}
 	}
 
-	creditLog := models.CreditLog{
-		ID:          generateID(),
+	posting := services.CreditPosting{
 		UserID:      user.ID,
-		Amount:      req.Amount,
-		Balance:     user.TotalCredits(),
 		Type:        models.CreditTypeAdminAward,
-		ReferenceID: "",
 		Description: description,
 	}
+	if req.CreditType == "free" {
+		posting.Free = req.Amount
+	} else {
+		posting.Extra = req.Amount
+	}
+	// A double-submitted award with the same Idempotency-Key is only applied once
+	if key := ctx.Get("Idempotency-Key"); key != "" {
+		posting.IdempotencyKey = "admin_award:" + key
+	}
+
+	posted, err := services.PostCredits(initializers.Db, posting)
+	if errors.Is(err, services.ErrNegativeBalance) {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Cannot reduce " + req.CreditType + " credits below zero"})
+	}
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to update user credits"})
+	}
+
+	newBalance := posted.Credit
+	if req.CreditType == "free" {
+		newBalance = posted.FreeCredit
+	}
 
-	initializers.Db.Create(&creditLog)
+	var creditLog models.CreditLog
+	initializers.Db.Where("id = ?", posted.Journal.ID).First(&creditLog)
 
 	return ctx.JSON(fiber.Map{
 		"success":      true,
 		"credit_type":  req.CreditType,
 		"new_balance":  newBalance,
-		"total_credit": user.TotalCredits(),
+		"total_credit": posted.FreeCredit + posted.Credit,
 		"credit_log":   creditLog,
+		"duplicate":    posted.Duplicate,
 	})
 }
 
@@ -519,12 +633,12 @@ func (c *AdminController) UpdateTransactionStatus(ctx *fiber.Ctx) error {
 		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
 
+	// Refunds reverse grants and go through RefundTransaction
 	validStatuses := []string{
 		models.TransactionStatusPending,
 		models.TransactionStatusSettlement,
 		models.TransactionStatusFailed,
 		models.TransactionStatusExpired,
-		models.TransactionStatusRefunded,
 	}
 
 	isValid := false
@@ -539,64 +653,170 @@ func (c *AdminController) UpdateTransactionStatus(ctx *fiber.Ctx) error {
 		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid status"})
 	}
 
+	// Locked like provider settlement, so an admin override can't race a callback into a double grant
 	var transaction models.Transaction
-	if err := initializers.Db.Where("id = ?", transactionID).Preload("Package").Preload("Plan").First(&transaction).Error; err != nil {
-		return ctx.Status(404).JSON(fiber.Map{"error": "Transaction not found"})
-	}
+	err := initializers.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", transactionID).First(&transaction).Error; err != nil {
+			return err
+		}
 
-	oldStatus := transaction.Status
-	transaction.Status = req.Status
-
-	// If transitioning to settlement, use settleTransaction logic
-	if oldStatus != models.TransactionStatusSettlement && req.Status == models.TransactionStatusSettlement {
-		now := time.Now()
-		transaction.PaidAt = &now
-
-		var user models.User
-		if err := initializers.Db.Where("id = ?", transaction.UserID).First(&user).Error; err == nil {
-			if transaction.TxType == models.TxTypeSubscription && transaction.Plan != nil {
-				// Activate subscription
-				user.SubscriptionPlanID = &transaction.Plan.ID
-				expiresAt := now.AddDate(0, 0, transaction.Plan.BillingPeriodDays)
-				user.SubscriptionExpiresAt = &expiresAt
-				initializers.Db.Save(&user)
-
-				creditLog := models.CreditLog{
-					ID:          generateID(),
-					UserID:      user.ID,
-					Amount:      0,
-					Balance:     user.TotalCredits(),
-					Type:        models.CreditTypeSubscription,
-					ReferenceID: transaction.ID,
-					Description: "Subscription activated: " + transaction.Plan.PlanName,
-				}
-				initializers.Db.Create(&creditLog)
-			} else if transaction.Package != nil {
-				// Award extra credits
-				user.Credit += transaction.Package.CreditAmount
-				initializers.Db.Save(&user)
-
-				creditLog := models.CreditLog{
-					ID:          generateID(),
-					UserID:      user.ID,
-					Amount:      transaction.Package.CreditAmount,
-					Balance:     user.TotalCredits(),
-					Type:        models.CreditTypePurchase,
-					ReferenceID: transaction.ID,
-					Description: "Purchase: " + transaction.Package.PackageName,
-				}
-				initializers.Db.Create(&creditLog)
+		oldStatus := transaction.Status
+		if oldStatus == models.TransactionStatusRefunded ||
+			(oldStatus == models.TransactionStatusSettlement && req.Status != models.TransactionStatusSettlement) {
+			return errSettledTransaction
+		}
+		transaction.Status = req.Status
+
+		// If transitioning to settlement, use settleTransaction logic
+		if oldStatus != models.TransactionStatusSettlement && req.Status == models.TransactionStatusSettlement {
+			now := time.Now()
+			transaction.PaidAt = &now
+			if err := settleTransaction(tx, &transaction); err != nil {
+				return err
 			}
 		}
-	}
 
-	if err := initializers.Db.Save(&transaction).Error; err != nil {
+		return tx.Save(&transaction).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ctx.Status(404).JSON(fiber.Map{"error": "Transaction not found"})
+	}
+	if errors.Is(err, errSettledTransaction) {
+		return ctx.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
 		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to update transaction"})
 	}
 
 	return ctx.JSON(transaction)
 }
 
+// errSettledTransaction is returned when a status change would silently undo a settlement
+var errSettledTransaction = errors.New("settled transactions can only be reversed through the refund endpoint")
+
+// RefundTransaction refunds a settled transaction: granted extra credits are clawed back (or the
+// subscription shortened) and the money is returned through the payment provider when it supports it
+func (c *AdminController) RefundTransaction(ctx *fiber.Ctx) error {
+	admin := GetUserFromToken(ctx)
+
+	var req struct {
+		Reason         string `json:"reason"`
+		AllowShortfall bool   `json:"allow_shortfall"` // refund even though some granted credits were spent
+		Manual         bool   `json:"manual"`          // money returned outside the provider
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		return ctx.Status(400).JSON(fiber.Map{"error": "A refund reason is required"})
+	}
+
+	transaction, err := refundTransaction(ctx.Params("id"), RefundOptions{
+		AdminID:        admin.ID,
+		Reason:         req.Reason,
+		AllowShortfall: req.AllowShortfall,
+		Manual:         req.Manual,
+	})
+	var spent *creditsSpentError
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ctx.Status(404).JSON(fiber.Map{"error": "Transaction not found"})
+	case errors.Is(err, errNotRefundable), errors.Is(err, errRefundInProgress):
+		return ctx.Status(409).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, errRefundUnconfirmed):
+		return ctx.Status(202).JSON(fiber.Map{"error": err.Error(), "refund_pending": true})
+	case errors.Is(err, errProviderRefundRequired):
+		return ctx.Status(409).JSON(fiber.Map{"error": err.Error(), "manual_required": true})
+	case errors.As(err, &spent):
+		return ctx.Status(409).JSON(fiber.Map{
+			"error":     "Some of the granted credits were already spent; retry with allow_shortfall to claw back what is left",
+			"granted":   spent.Granted,
+			"available": spent.Available,
+		})
+	case err != nil:
+		return ctx.Status(502).JSON(fiber.Map{"error": "Refund failed: " + err.Error()})
+	}
+
+	return ctx.JSON(transaction)
+}
+
+// DownloadInvoice returns the invoice PDF for any settled or refunded transaction
+func (c *AdminController) DownloadInvoice(ctx *fiber.Ctx) error {
+	invoice, err := issueInvoice(ctx.Params("id"))
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ctx.Status(404).JSON(fiber.Map{"error": "Transaction not found"})
+	case errors.Is(err, errNotInvoiceable):
+		return ctx.Status(409).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to issue invoice"})
+	}
+	return sendInvoice(ctx, invoice)
+}
+
+// RegenerateInvoice refreshes an invoice's buyer, item and company details (its number is kept)
+// and returns the new PDF
+func (c *AdminController) RegenerateInvoice(ctx *fiber.Ctx) error {
+	admin := GetUserFromToken(ctx)
+
+	invoice, err := regenerateInvoice(ctx.Params("id"), admin.ID)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ctx.Status(404).JSON(fiber.Map{"error": "Transaction not found"})
+	case errors.Is(err, errNotInvoiceable):
+		return ctx.Status(409).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to regenerate invoice"})
+	}
+	return sendInvoice(ctx, invoice)
+}
+
+// ListReconcileRuns returns the reports of the pending transaction reconciler, newest first
+func (c *AdminController) ListReconcileRuns(ctx *fiber.Ctx) error {
+	page, _ := strconv.Atoi(ctx.Query("page", "1"))
+	limit, _ := strconv.Atoi(ctx.Query("limit", "20"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	offset := (page - 1) * limit
+
+	var runs []models.PaymentReconcileRun
+	var total int64
+
+	query := initializers.Db.Model(&models.PaymentReconcileRun{})
+	query.Count(&total)
+
+	if err := query.Offset(offset).Limit(limit).Order("started_at DESC").Find(&runs).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to fetch reconciliation runs"})
+	}
+
+	return ctx.JSON(fiber.Map{
+		"runs":  runs,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+		"pages": (total + int64(limit) - 1) / int64(limit),
+	})
+}
+
+// RunReconcile starts a reconciliation run immediately and returns its report
+func (c *AdminController) RunReconcile(ctx *fiber.Ctx) error {
+	run, err := ReconcilePendingTransactions(models.ReconcileTriggerAdmin)
+	if errors.Is(err, errReconcileRunning) {
+		return ctx.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Reconciliation failed: " + err.Error()})
+	}
+	return ctx.JSON(run)
+}
+
 // ========================================
 // SUBSCRIPTION PLAN MANAGEMENT
 // ========================================
@@ -707,6 +927,209 @@ func (c *AdminController) DeleteSubscriptionPlan(ctx *fiber.Ctx) error {
 	return ctx.JSON(fiber.Map{"success": true})
 }
 
+// ========================================
+// COUPONS
+// ========================================
+
+// couponCodePattern limits codes to what is easy to type and share
+var couponCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+type CouponRequest struct {
+	Code              string     `json:"code"`
+	Description       string     `json:"description"`
+	DiscountType      string     `json:"discount_type"`
+	DiscountValue     int64      `json:"discount_value"`
+	PackageIDs        []string   `json:"package_ids"`
+	PlanIDs           []string   `json:"plan_ids"`
+	ValidFrom         *time.Time `json:"valid_from"`
+	ValidUntil        *time.Time `json:"valid_until"`
+	MaxUses           int        `json:"max_uses"`
+	MaxUsesPerUser    int        `json:"max_uses_per_user"`
+	FirstPurchaseOnly bool       `json:"first_purchase_only"`
+	Active            bool       `json:"active"`
+}
+
+// validate normalises the code and checks the discount, limits and validity window
+func (req *CouponRequest) validate() string {
+	req.Code = normalizeCouponCode(req.Code)
+	if !couponCodePattern.MatchString(req.Code) {
+		return "Code must be 3-32 letters, digits, '-' or '_'"
+	}
+	switch req.DiscountType {
+	case models.CouponDiscountPercent:
+		if req.DiscountValue < 1 || req.DiscountValue > 100 {
+			return "Percentage discount must be between 1 and 100"
+		}
+	case models.CouponDiscountFixed:
+		if req.DiscountValue < 1 {
+			return "Fixed discount must be a positive amount"
+		}
+	default:
+		return "Discount type must be percent or fixed"
+	}
+	if req.MaxUses < 0 || req.MaxUsesPerUser < 0 {
+		return "Usage limits cannot be negative"
+	}
+	if req.ValidFrom != nil && req.ValidUntil != nil && !req.ValidUntil.After(*req.ValidFrom) {
+		return "valid_until must be after valid_from"
+	}
+	if req.PackageIDs == nil {
+		req.PackageIDs = []string{}
+	}
+	if req.PlanIDs == nil {
+		req.PlanIDs = []string{}
+	}
+	return ""
+}
+
+// apply copies the request onto the coupon
+func (req *CouponRequest) apply(coupon *models.Coupon) {
+	coupon.Code = req.Code
+	coupon.Description = req.Description
+	coupon.DiscountType = req.DiscountType
+	coupon.DiscountValue = req.DiscountValue
+	coupon.PackageIDs = req.PackageIDs
+	coupon.PlanIDs = req.PlanIDs
+	coupon.ValidFrom = req.ValidFrom
+	coupon.ValidUntil = req.ValidUntil
+	coupon.MaxUses = req.MaxUses
+	coupon.MaxUsesPerUser = req.MaxUsesPerUser
+	coupon.FirstPurchaseOnly = req.FirstPurchaseOnly
+	coupon.Active = req.Active
+}
+
+// CouponResponse is a coupon with its redemptions so far
+type CouponResponse struct {
+	models.Coupon
+	Uses        int64 `json:"uses"`         // pending and settled transactions
+	Redeemed    int64 `json:"redeemed"`     // settled transactions
+	Revenue     int64 `json:"revenue"`      // paid by settled transactions
+	DiscountSum int64 `json:"discount_sum"` // given away on settled transactions
+}
+
+func (c *AdminController) ListCoupons(ctx *fiber.Ctx) error {
+	var coupons []models.Coupon
+	if err := initializers.Db.Order("created_at DESC").Find(&coupons).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to fetch coupons"})
+	}
+
+	var stats []struct {
+		CouponID    string
+		Uses        int64
+		Redeemed    int64
+		Revenue     int64
+		DiscountSum int64
+	}
+	initializers.Db.Model(&models.Transaction{}).
+		Select(`coupon_id,
+			COUNT(*) AS uses,
+			COUNT(*) FILTER (WHERE status = ?) AS redeemed,
+			COALESCE(SUM(amount) FILTER (WHERE status = ?), 0) AS revenue,
+			COALESCE(SUM(discount_amount) FILTER (WHERE status = ?), 0) AS discount_sum`,
+			models.TransactionStatusSettlement, models.TransactionStatusSettlement, models.TransactionStatusSettlement).
+		Where("coupon_id IS NOT NULL AND status IN ?", couponUseStatuses).
+		Group("coupon_id").
+		Scan(&stats)
+
+	response := make([]CouponResponse, len(coupons))
+	for i, coupon := range coupons {
+		response[i] = CouponResponse{Coupon: coupon}
+		for _, stat := range stats {
+			if stat.CouponID == coupon.ID {
+				response[i].Uses = stat.Uses
+				response[i].Redeemed = stat.Redeemed
+				response[i].Revenue = stat.Revenue
+				response[i].DiscountSum = stat.DiscountSum
+			}
+		}
+	}
+	return ctx.JSON(response)
+}
+
+func (c *AdminController) CreateCoupon(ctx *fiber.Ctx) error {
+	admin := GetUserFromToken(ctx)
+
+	var req CouponRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if msg := req.validate(); msg != "" {
+		return ctx.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	var existing int64
+	initializers.Db.Model(&models.Coupon{}).Where("code = ?", req.Code).Count(&existing)
+	if existing > 0 {
+		return ctx.Status(409).JSON(fiber.Map{"error": "A coupon with this code already exists"})
+	}
+
+	coupon := models.Coupon{ID: generateID(), CreatedBy: &admin.ID}
+	req.apply(&coupon)
+	if err := initializers.Db.Create(&coupon).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to create coupon"})
+	}
+
+	return ctx.JSON(coupon)
+}
+
+// UpdateCoupon changes a coupon; the code of a coupon that was already used stays fixed
+func (c *AdminController) UpdateCoupon(ctx *fiber.Ctx) error {
+	var coupon models.Coupon
+	if err := initializers.Db.Where("id = ?", ctx.Params("id")).First(&coupon).Error; err != nil {
+		return ctx.Status(404).JSON(fiber.Map{"error": "Coupon not found"})
+	}
+
+	var req CouponRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if msg := req.validate(); msg != "" {
+		return ctx.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	if req.Code != coupon.Code {
+		var used int64
+		initializers.Db.Model(&models.Transaction{}).Where("coupon_id = ?", coupon.ID).Count(&used)
+		if used > 0 {
+			return ctx.Status(409).JSON(fiber.Map{"error": "The code of a coupon that has been used cannot be changed"})
+		}
+		var existing int64
+		initializers.Db.Model(&models.Coupon{}).Where("code = ? AND id <> ?", req.Code, coupon.ID).Count(&existing)
+		if existing > 0 {
+			return ctx.Status(409).JSON(fiber.Map{"error": "A coupon with this code already exists"})
+		}
+	}
+
+	req.apply(&coupon)
+	if err := initializers.Db.Save(&coupon).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to update coupon"})
+	}
+
+	return ctx.JSON(coupon)
+}
+
+// DeleteCoupon removes an unused coupon; a used one is deactivated so its transactions keep their reference
+func (c *AdminController) DeleteCoupon(ctx *fiber.Ctx) error {
+	var coupon models.Coupon
+	if err := initializers.Db.Where("id = ?", ctx.Params("id")).First(&coupon).Error; err != nil {
+		return ctx.Status(404).JSON(fiber.Map{"error": "Coupon not found"})
+	}
+
+	var used int64
+	initializers.Db.Model(&models.Transaction{}).Where("coupon_id = ?", coupon.ID).Count(&used)
+	if used > 0 {
+		if err := initializers.Db.Model(&coupon).Update("active", false).Error; err != nil {
+			return ctx.Status(500).JSON(fiber.Map{"error": "Failed to deactivate coupon"})
+		}
+		return ctx.JSON(fiber.Map{"success": true, "deactivated": true})
+	}
+
+	if err := initializers.Db.Delete(&coupon).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to delete coupon"})
+	}
+	return ctx.JSON(fiber.Map{"success": true})
+}
+
 // ========================================
 // ROOMS MANAGEMENT
 // ========================================
@@ -787,6 +1210,344 @@ func (c *AdminController) ListRooms(ctx *fiber.Ctx) error {
 	})
 }
 
+// ========================================
+// ROLES & PERMISSIONS
+// ========================================
+
+type RoleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// validPermissions checks that every permission is known
+func validPermissions(permissions []string) bool {
+	for _, permission := range permissions {
+		known := permission == models.PermissionAll
+		for _, p := range models.AllPermissions {
+			if permission == p {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return false
+		}
+	}
+	return true
+}
+
+// setRolePermissions replaces the permissions of a role
+func setRolePermissions(roleID string, permissions []string) error {
+	if err := initializers.Db.Where("role_id = ?", roleID).Delete(&models.RolePermission{}).Error; err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, permission := range permissions {
+		if seen[permission] {
+			continue
+		}
+		seen[permission] = true
+		rp := models.RolePermission{ID: generateID(), RoleID: roleID, Permission: permission}
+		if err := initializers.Db.Create(&rp).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ungrantablePermission returns the first permission the admin may not hand out, or "" if there is none.
+// Superadmins may grant anything; other admins only permissions they hold themselves, and never "*".
+func ungrantablePermission(ctx *fiber.Ctx, permissions []string) string {
+	held := getRequestPermissions(ctx)
+	if held[models.PermissionAll] {
+		return ""
+	}
+	for _, permission := range permissions {
+		if permission == models.PermissionAll || !held[permission] {
+			return permission
+		}
+	}
+	return ""
+}
+
+// removedPermissions returns the permissions in current that next no longer has
+func removedPermissions(current []string, next []string) []string {
+	kept := make(map[string]bool, len(next))
+	for _, permission := range next {
+		kept[permission] = true
+	}
+	var removed []string
+	for _, permission := range current {
+		if !kept[permission] {
+			removed = append(removed, permission)
+		}
+	}
+	return removed
+}
+
+// rolePermissionList returns the permissions of a role
+func rolePermissionList(roleID string) []string {
+	var permissions []string
+	initializers.Db.Model(&models.RolePermission{}).Where("role_id = ?", roleID).Pluck("permission", &permissions)
+	return permissions
+}
+
+// grantsAll reports whether the permissions include "*", making their holders superadmins
+func grantsAll(permissions []string) bool {
+	for _, permission := range permissions {
+		if permission == models.PermissionAll {
+			return true
+		}
+	}
+	return false
+}
+
+// countSuperAdmins returns how many users hold a role granting every permission ("*"),
+// ignoring the role exceptRoleID (pass "" to count all)
+func countSuperAdmins(exceptRoleID string) int64 {
+	var count int64
+	query := initializers.Db.Model(&models.UserRole{}).
+		Joins("JOIN role_permissions ON role_permissions.role_id = user_roles.role_id").
+		Where("role_permissions.permission = ?", models.PermissionAll)
+	if exceptRoleID != "" {
+		query = query.Where("user_roles.role_id <> ?", exceptRoleID)
+	}
+	query.Distinct("user_roles.user_id").Count(&count)
+	return count
+}
+
+func (c *AdminController) ListPermissions(ctx *fiber.Ctx) error {
+	return ctx.JSON(models.AllPermissions)
+}
+
+func (c *AdminController) ListRoles(ctx *fiber.Ctx) error {
+	var roles []models.Role
+	if err := initializers.Db.Preload("Permissions").Order("name ASC").Find(&roles).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to fetch roles"})
+	}
+	return ctx.JSON(roles)
+}
+
+func (c *AdminController) CreateRole(ctx *fiber.Ctx) error {
+	var req RoleRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	req.Name = strings.ToLower(strings.TrimSpace(req.Name))
+	if req.Name == "" {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Role name is required"})
+	}
+	if !validPermissions(req.Permissions) {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Unknown permission"})
+	}
+	if permission := ungrantablePermission(ctx, req.Permissions); permission != "" {
+		return ctx.Status(403).JSON(fiber.Map{"error": "You cannot grant the permission " + permission})
+	}
+
+	var existing models.Role
+	if err := initializers.Db.Where("name = ?", req.Name).First(&existing).Error; err == nil {
+		return ctx.Status(409).JSON(fiber.Map{"error": "Role with this name already exists"})
+	}
+
+	role := models.Role{ID: generateID(), Name: req.Name, Description: req.Description}
+	if err := initializers.Db.Create(&role).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to create role"})
+	}
+	if err := setRolePermissions(role.ID, req.Permissions); err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to set role permissions"})
+	}
+
+	initializers.Db.Preload("Permissions").Where("id = ?", role.ID).First(&role)
+	return ctx.Status(201).JSON(role)
+}
+
+func (c *AdminController) UpdateRole(ctx *fiber.Ctx) error {
+	var role models.Role
+	if err := initializers.Db.Where("id = ?", ctx.Params("id")).First(&role).Error; err != nil {
+		return ctx.Status(404).JSON(fiber.Map{"error": "Role not found"})
+	}
+	if role.Name == models.RoleSuperAdmin {
+		return ctx.Status(400).JSON(fiber.Map{"error": "The superadmin role cannot be modified"})
+	}
+
+	var req RoleRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if !validPermissions(req.Permissions) {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Unknown permission"})
+	}
+	current := rolePermissionList(role.ID)
+	if grantsAll(current) && !getRequestPermissions(ctx)[models.PermissionAll] {
+		return ctx.Status(403).JSON(fiber.Map{"error": "Only a superadmin can modify a role with every permission"})
+	}
+	if permission := ungrantablePermission(ctx, req.Permissions); permission != "" {
+		return ctx.Status(403).JSON(fiber.Map{"error": "You cannot grant the permission " + permission})
+	}
+	// Taking a permission away from everyone holding the role is as strong as handing it out
+	if permission := ungrantablePermission(ctx, removedPermissions(current, req.Permissions)); permission != "" {
+		return ctx.Status(403).JSON(fiber.Map{"error": "You cannot remove the permission " + permission})
+	}
+	if grantsAll(current) && !grantsAll(req.Permissions) && countSuperAdmins(role.ID) == 0 {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Cannot remove the last superadmin"})
+	}
+
+	role.Description = req.Description
+	if err := initializers.Db.Save(&role).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to update role"})
+	}
+	if err := setRolePermissions(role.ID, req.Permissions); err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to set role permissions"})
+	}
+
+	initializers.Db.Preload("Permissions").Where("id = ?", role.ID).First(&role)
+	return ctx.JSON(role)
+}
+
+func (c *AdminController) DeleteRole(ctx *fiber.Ctx) error {
+	var role models.Role
+	if err := initializers.Db.Where("id = ?", ctx.Params("id")).First(&role).Error; err != nil {
+		return ctx.Status(404).JSON(fiber.Map{"error": "Role not found"})
+	}
+	if _, builtin := models.DefaultRolePermissions[role.Name]; builtin {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Built-in roles cannot be deleted"})
+	}
+	permissions := rolePermissionList(role.ID)
+	if grantsAll(permissions) {
+		if !getRequestPermissions(ctx)[models.PermissionAll] {
+			return ctx.Status(403).JSON(fiber.Map{"error": "Only a superadmin can delete a role with every permission"})
+		}
+		if countSuperAdmins(role.ID) == 0 {
+			return ctx.Status(400).JSON(fiber.Map{"error": "Cannot remove the last superadmin"})
+		}
+	}
+	if permission := ungrantablePermission(ctx, permissions); permission != "" {
+		return ctx.Status(403).JSON(fiber.Map{"error": "You cannot delete a role with the permission " + permission})
+	}
+
+	initializers.Db.Where("role_id = ?", role.ID).Delete(&models.UserRole{})
+	initializers.Db.Where("role_id = ?", role.ID).Delete(&models.RolePermission{})
+	if err := initializers.Db.Delete(&role).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to delete role"})
+	}
+	return ctx.JSON(fiber.Map{"success": true})
+}
+
+// AssignRole grants a role (by name) to a user
+func (c *AdminController) AssignRole(ctx *fiber.Ctx) error {
+	admin := GetUserFromToken(ctx)
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	var user models.User
+	if err := initializers.Db.Where("id = ?", ctx.Params("id")).First(&user).Error; err != nil {
+		return ctx.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	var role models.Role
+	if err := initializers.Db.Where("name = ?", req.Role).First(&role).Error; err != nil {
+		return ctx.Status(404).JSON(fiber.Map{"error": "Role not found"})
+	}
+	// Only superadmins can create more superadmins, and admins can't hand out more than they hold
+	permissions := rolePermissionList(role.ID)
+	if grantsAll(permissions) && !getRequestPermissions(ctx)[models.PermissionAll] {
+		return ctx.Status(403).JSON(fiber.Map{"error": "Only a superadmin can grant a role with every permission"})
+	}
+	if permission := ungrantablePermission(ctx, permissions); permission != "" {
+		return ctx.Status(403).JSON(fiber.Map{"error": "You cannot grant the permission " + permission})
+	}
+
+	var existing models.UserRole
+	if err := initializers.Db.Where("user_id = ? AND role_id = ?", user.ID, role.ID).First(&existing).Error; err == nil {
+		return ctx.Status(409).JSON(fiber.Map{"error": "User already has this role"})
+	}
+
+	userRole := models.UserRole{ID: generateID(), UserID: user.ID, RoleID: role.ID, GrantedBy: admin.ID}
+	if err := initializers.Db.Create(&userRole).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to assign role"})
+	}
+	return ctx.JSON(fiber.Map{"success": true, "user_id": user.ID, "role": role.Name})
+}
+
+// RevokeRole removes a role (by name) from a user
+func (c *AdminController) RevokeRole(ctx *fiber.Ctx) error {
+	var role models.Role
+	if err := initializers.Db.Where("name = ?", ctx.Params("role")).First(&role).Error; err != nil {
+		return ctx.Status(404).JSON(fiber.Map{"error": "Role not found"})
+	}
+	// Admins can only take away what they could have handed out
+	permissions := rolePermissionList(role.ID)
+	if grantsAll(permissions) {
+		if !getRequestPermissions(ctx)[models.PermissionAll] {
+			return ctx.Status(403).JSON(fiber.Map{"error": "Only a superadmin can revoke a role with every permission"})
+		}
+		if countSuperAdmins("") <= 1 {
+			return ctx.Status(400).JSON(fiber.Map{"error": "Cannot remove the last superadmin"})
+		}
+	}
+	if permission := ungrantablePermission(ctx, permissions); permission != "" {
+		return ctx.Status(403).JSON(fiber.Map{"error": "You cannot revoke a role with the permission " + permission})
+	}
+
+	result := initializers.Db.Where("user_id = ? AND role_id = ?", ctx.Params("id"), role.ID).Delete(&models.UserRole{})
+	if result.Error != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to revoke role"})
+	}
+	if result.RowsAffected == 0 {
+		return ctx.Status(404).JSON(fiber.Map{"error": "User does not have this role"})
+	}
+	return ctx.JSON(fiber.Map{"success": true})
+}
+
+// ========================================
+// SECURITY
+// ========================================
+
+// ListFailedLogins returns recorded failed attempts (login by default), newest first
+func (c *AdminController) ListFailedLogins(ctx *fiber.Ctx) error {
+	page, _ := strconv.Atoi(ctx.Query("page", "1"))
+	limit, _ := strconv.Atoi(ctx.Query("limit", "50"))
+	scope := ctx.Query("scope", models.AuditScopeLogin)
+	search := ctx.Query("search", "")
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	offset := (page - 1) * limit
+
+	var entries []models.SecurityAuditLog
+	var total int64
+
+	query := initializers.Db.Model(&models.SecurityAuditLog{}).Where("scope = ?", scope)
+	if search != "" {
+		searchPattern := "%" + search + "%"
+		query = query.Where("identifier ILIKE ? OR ip_address ILIKE ?", searchPattern, searchPattern)
+	}
+
+	query.Count(&total)
+
+	if err := query.Offset(offset).Limit(limit).Order("created_at DESC").Find(&entries).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to fetch failed attempts"})
+	}
+
+	return ctx.JSON(fiber.Map{
+		"entries": entries,
+		"total":   total,
+		"page":    page,
+		"limit":   limit,
+		"pages":   (total + int64(limit) - 1) / int64(limit),
+	})
+}
+
 // ========================================
 // DASHBOARD STATS
 // ========================================
@@ -802,6 +1563,7 @@ func (c *AdminController) GetDashboardStats(ctx *fiber.Ctx) error {
 	var failedTransactions int64
 	var totalPackages int64
 	var totalRevenue int64
+	var totalDiscounts int64
 	var totalCreditsAwarded int64
 	var totalSubscriptionPlans int64
 	var activeSubscribers int64
@@ -830,6 +1592,8 @@ func (c *AdminController) GetDashboardStats(ctx *fiber.Ctx) error {
 	initializers.Db.Model(&models.Package{}).Count(&totalPackages)
 	initializers.Db.Model(&models.Transaction{}).Where("status = ?", models.TransactionStatusSettlement).
 		Select("COALESCE(SUM(amount),0)").Scan(&totalRevenue)
+	initializers.Db.Model(&models.Transaction{}).Where("status = ?", models.TransactionStatusSettlement).
+		Select("COALESCE(SUM(discount_amount),0)").Scan(&totalDiscounts)
 	initializers.Db.Model(&models.CreditLog{}).Where("amount > 0").
 		Select("COALESCE(SUM(amount),0)").Scan(&totalCreditsAwarded)
 	initializers.Db.Model(&models.SubscriptionPlan{}).Count(&totalSubscriptionPlans)
@@ -912,6 +1676,7 @@ func (c *AdminController) GetDashboardStats(ctx *fiber.Ctx) error {
 		"failedTransactions":     failedTransactions,
 		"totalPackages":          totalPackages,
 		"totalRevenue":           totalRevenue,
+		"totalDiscounts":         totalDiscounts,
 		"totalCreditsAwarded":    totalCreditsAwarded,
 		"totalSubscriptionPlans": totalSubscriptionPlans,
 		"activeSubscribers":      activeSubscribers,
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAdminNeedsTwoFactorSession(t *testing.T) {
+	testDB(t, &models.User{}, &models.Session{}, &models.Role{}, &models.RolePermission{}, &models.UserRole{}, &models.TwoFactorAuth{})
+
+	now := time.Now()
+	admin := models.User{ID: generateID(), Name: "MFA Admin", Email: generateID() + "@example.test"}
+	role := models.Role{ID: generateID(), Name: "test-" + generateID()[:8]}
+	rows := []interface{}{
+		&admin, &role,
+		&models.UserRole{ID: generateID(), UserID: admin.ID, RoleID: role.ID},
+		&models.TwoFactorAuth{UserID: admin.ID, Secret: "JBSWY3DPEHPK3PXP", EnabledAt: &now},
+	}
+	for _, row := range rows {
+		if err := initializers.Db.Create(row).Error; err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	if err := setRolePermissions(role.ID, []string{models.PermissionUsersRead}); err != nil {
+		t.Fatalf("set permissions: %v", err)
+	}
+	t.Cleanup(func() {
+		initializers.Db.Where("user_id = ?", admin.ID).Delete(&models.Session{})
+		initializers.Db.Where("user_id = ?", admin.ID).Delete(&models.TwoFactorAuth{})
+		initializers.Db.Where("role_id = ?", role.ID).Delete(&models.UserRole{})
+		initializers.Db.Where("role_id = ?", role.ID).Delete(&models.RolePermission{})
+		initializers.Db.Delete(&role)
+		initializers.Db.Delete(&admin)
+	})
+
+	newSession := func(verifiedAt *time.Time) string {
+		token := generateToken()
+		session := models.Session{ID: generateID(), TokenHash: hashToken(token), UserID: admin.ID, LastUsedAt: now,
+			ExpiresAt: now.Add(time.Hour), MaxExpiresAt: now.Add(time.Hour), MFAVerifiedAt: verifiedAt}
+		if err := initializers.Db.Create(&session).Error; err != nil {
+			t.Fatalf("create session: %v", err)
+		}
+		return token
+	}
+
+	app := fiber.New()
+	app.Get("/admin", AdminMiddleware, func(ctx *fiber.Ctx) error { return ctx.SendStatus(200) })
+	// Stands in for an API key: the user is known but no session backs the request
+	app.Get("/keyed", func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, &admin)
+		return ctx.Next()
+	}, AdminMiddleware, func(ctx *fiber.Ctx) error { return ctx.SendStatus(200) })
+	status := func(path string, token string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		return resp.StatusCode
+	}
+
+	if got := status("/admin", newSession(nil)); got != 403 {
+		t.Fatalf("session signed in without the challenge: status %d", got)
+	}
+	if got := status("/keyed", ""); got != 403 {
+		t.Fatalf("request without a session: status %d", got)
+	}
+	if got := status("/admin", newSession(&now)); got != 200 {
+		t.Fatalf("session that passed the challenge: status %d", got)
+	}
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRoleChangesLimitedToHeldPermissions(t *testing.T) {
+	testDB(t, &models.User{}, &models.Role{}, &models.RolePermission{}, &models.UserRole{})
+
+	member := models.User{ID: generateID(), Name: "Role Member", Email: generateID() + "@example.test"}
+	if err := initializers.Db.Create(&member).Error; err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	newRole := func(permissions ...string) models.Role {
+		role := models.Role{ID: generateID(), Name: "test-" + generateID()[:8]}
+		if err := initializers.Db.Create(&role).Error; err != nil {
+			t.Fatalf("create role: %v", err)
+		}
+		if err := setRolePermissions(role.ID, permissions); err != nil {
+			t.Fatalf("set permissions: %v", err)
+		}
+		initializers.Db.Create(&models.UserRole{ID: generateID(), UserID: member.ID, RoleID: role.ID})
+		return role
+	}
+	strong := newRole(models.PermissionUsersRead, models.PermissionCreditsAward)
+	weak := newRole(models.PermissionUsersRead)
+	t.Cleanup(func() {
+		for _, role := range []models.Role{strong, weak} {
+			initializers.Db.Where("role_id = ?", role.ID).Delete(&models.UserRole{})
+			initializers.Db.Where("role_id = ?", role.ID).Delete(&models.RolePermission{})
+			initializers.Db.Delete(&role)
+		}
+		initializers.Db.Delete(&member)
+	})
+
+	// An admin who manages roles and can read users, but can't award credits
+	admin := &AdminController{}
+	app := fiber.New()
+	app.Use(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, &models.User{ID: generateID()})
+		ctx.Locals(permissionsLocalsKey, map[string]bool{models.PermissionRolesManage: true, models.PermissionUsersRead: true})
+		return ctx.Next()
+	})
+	app.Put("/roles/:id", admin.UpdateRole)
+	app.Delete("/roles/:id", admin.DeleteRole)
+	app.Delete("/users/:id/roles/:role", admin.RevokeRole)
+	request := func(method string, path string, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		return resp.StatusCode
+	}
+
+	if status := request("PUT", "/roles/"+strong.ID, `{"permissions":["users.read"]}`); status != 403 {
+		t.Fatalf("removing a permission the admin lacks: status %d", status)
+	}
+	if status := request("PUT", "/roles/"+strong.ID, `{"description":"kept","permissions":["users.read","credits.award"]}`); status != 403 {
+		t.Fatalf("keeping a permission the admin lacks still re-grants it: status %d", status)
+	}
+	if status := request("DELETE", "/users/"+member.ID+"/roles/"+strong.Name, ""); status != 403 {
+		t.Fatalf("revoking a role beyond the admin's permissions: status %d", status)
+	}
+	if status := request("DELETE", "/roles/"+strong.ID, ""); status != 403 {
+		t.Fatalf("deleting a role beyond the admin's permissions: status %d", status)
+	}
+	if permissions := rolePermissionList(strong.ID); len(permissions) != 2 {
+		t.Fatalf("refused changes altered the role: %v", permissions)
+	}
+
+	// Roles within the admin's own permissions can be changed freely
+	if status := request("PUT", "/roles/"+weak.ID, `{"permissions":[]}`); status != 200 {
+		t.Fatalf("emptying a role within reach: status %d", status)
+	}
+	if status := request("DELETE", "/users/"+member.ID+"/roles/"+weak.Name, ""); status != 200 {
+		t.Fatalf("revoking a role within reach: status %d", status)
+	}
+	if status := request("DELETE", "/roles/"+weak.ID, ""); status != 200 {
+		t.Fatalf("deleting a role within reach: status %d", status)
+	}
+}
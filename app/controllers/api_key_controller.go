@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"strings"
+	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// APIKeyController lets users mint and revoke personal API keys
+type APIKeyController struct{}
+
+// API keys start with this marker so they can be told apart from session tokens
+const apiKeyPrefix = "kyk_"
+
+// Maximum number of active keys per user
+const maxAPIKeysPerUser = 20
+
+// apiKeyLocalsKey caches the API key that authenticated the request
+const apiKeyLocalsKey = "auth_api_key"
+
+// apiScopeLocalsKey holds the scope declared by the route (see RequireAPIScope)
+const apiScopeLocalsKey = "auth_api_scope"
+
+// RequireAPIScope declares the scope an API key needs to call the route. Session and access
+// tokens are unaffected. Routes without a declared scope reject API keys.
+func RequireAPIScope(scope string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		ctx.Locals(apiScopeLocalsKey, scope)
+		return ctx.Next()
+	}
+}
+
+// getAPIKeyUser resolves an API key, if the current route declared a scope the key holds
+func getAPIKeyUser(ctx *fiber.Ctx, raw string) *models.User {
+	if ctx == nil {
+		return nil
+	}
+	scope, _ := ctx.Locals(apiScopeLocalsKey).(string)
+	if scope == "" {
+		return nil
+	}
+
+	now := time.Now()
+	var key models.APIKey
+	if err := initializers.Db.Where("key_hash = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", hashToken(raw), now).First(&key).Error; err != nil {
+		return nil
+	}
+	if !key.HasScope(scope) {
+		return nil
+	}
+
+	var user models.User
+	if err := initializers.Db.Where("id = ?", key.UserID).First(&user).Error; err != nil {
+		return nil
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= sessionTouchInterval {
+		initializers.Db.Model(&key).Updates(map[string]interface{}{"last_used_at": now, "last_used_ip": ctx.IP()})
+	}
+	ctx.Locals(apiKeyLocalsKey, &key)
+	return &user
+}
+
+// List returns the user's API keys (never the keys themselves)
+func (c *APIKeyController) List(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var keys []models.APIKey
+	if err := initializers.Db.Where("user_id = ? AND revoked_at IS NULL", user.ID).Order("created_at DESC").Find(&keys).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to fetch API keys"})
+	}
+	return ctx.JSON(fiber.Map{
+		"keys":   keys,
+		"scopes": models.AllAPIScopes,
+	})
+}
+
+// Create mints a new key. The key is only returned in this response.
+func (c *APIKeyController) Create(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"` // 0 = never
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Key name is required"})
+	}
+	if len(req.Scopes) == 0 {
+		return ctx.Status(400).JSON(fiber.Map{"error": "At least one scope is required"})
+	}
+	seen := map[string]bool{}
+	scopes := []string{}
+	for _, scope := range req.Scopes {
+		known := false
+		for _, s := range models.AllAPIScopes {
+			if scope == s {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return ctx.Status(400).JSON(fiber.Map{"error": "Unknown scope: " + scope})
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	if req.ExpiresInDays < 0 {
+		return ctx.Status(400).JSON(fiber.Map{"error": "expires_in_days cannot be negative"})
+	}
+
+	var count int64
+	initializers.Db.Model(&models.APIKey{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Count(&count)
+	if count >= maxAPIKeysPerUser {
+		return ctx.Status(400).JSON(fiber.Map{"error": "API key limit reached, revoke an unused key first"})
+	}
+
+	raw := apiKeyPrefix + generateToken()
+	key := models.APIKey{
+		ID:      generateID(),
+		UserID:  user.ID,
+		Name:    req.Name,
+		Prefix:  raw[:len(apiKeyPrefix)+8],
+		KeyHash: hashToken(raw),
+		Scopes:  strings.Join(scopes, ","),
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour)
+		key.ExpiresAt = &expiresAt
+	}
+	if err := initializers.Db.Create(&key).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to create API key"})
+	}
+
+	return ctx.Status(201).JSON(fiber.Map{
+		"key":     raw,
+		"api_key": key,
+	})
+}
+
+// Revoke disables a key immediately
+func (c *APIKeyController) Revoke(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	result := initializers.Db.Model(&models.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", ctx.Params("id"), user.ID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to revoke API key"})
+	}
+	if result.RowsAffected == 0 {
+		return ctx.Status(404).JSON(fiber.Map{"error": "API key not found"})
+	}
+	return ctx.JSON(fiber.Map{"success": true})
+}
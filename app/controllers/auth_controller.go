@@ -2,11 +2,19 @@ package controllers
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"GoFiberMVC/app/initializers"
 	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
 
 	"github.com/gofiber/fiber/v2"
 	"golang.org/x/crypto/bcrypt"
@@ -15,10 +23,11 @@ import (
 type AuthController struct{}
 
 type RegisterRequest struct {
-	Name     string `json:"name"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Name         string `json:"name"`
+	Username     string `json:"username"`
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	ReferralCode string `json:"referral_code"` // invite code from a /register?ref= link
 }
 
 type LoginRequest struct {
@@ -27,8 +36,10 @@ type LoginRequest struct {
 }
 
 type AuthResponse struct {
-	Token string       `json:"token"`
-	User  UserResponse `json:"user"`
+	Token        string       `json:"token"`
+	RefreshToken string       `json:"refresh_token,omitempty"` // JWT mode only
+	ExpiresIn    int          `json:"expires_in,omitempty"`    // access token lifetime in seconds, JWT mode only
+	User         UserResponse `json:"user"`
 }
 
 type UserResponse struct {
@@ -43,25 +54,59 @@ type UserResponse struct {
 	SubscriptionPlanName  *string `json:"subscription_plan_name"`
 	SubscriptionExpiresAt *string `json:"subscription_expires_at"`
 	RoomDuration          int     `json:"room_duration"`
+	EmailVerified         bool    `json:"email_verified"`
+	TwoFactorEnabled      bool    `json:"two_factor_enabled"`
 }
 
-// Session duration: 30 days
+// Session duration: 30 days, extended on activity
 const sessionDuration = 30 * 24 * time.Hour
 
+// Sessions end 90 days after sign-in, however active they are
+const sessionMaxLifetime = 90 * 24 * time.Hour
+
+// last_used_at is refreshed at most once per minute to avoid a write on every request
+const sessionTouchInterval = time.Minute
+
+// Password reset links are valid for 1 hour
+const passwordResetDuration = time.Hour
+
+// Email verification links are valid for 48 hours
+const emailVerificationDuration = 48 * time.Hour
+
+// Verification emails can be resent 3 times before backing off
+var resendVerificationLimiter = &services.AttemptLimiter{
+	Store:        services.DefaultAttemptStore(),
+	FreeAttempts: 3,
+	BaseLockout:  time.Minute,
+	MaxLockout:   time.Hour,
+	Window:       time.Hour,
+}
+
+// Minimum length for newly chosen passwords
+const minPasswordLength = 8
+
+// userLocalsKey caches the authenticated user on the request context
+const userLocalsKey = "auth_user"
+
+// sessionLocalsKey caches the current session on the request context
+const sessionLocalsKey = "auth_session"
+
 // buildUserResponse creates a UserResponse from a User model, including subscription info
 func buildUserResponse(user *models.User) UserResponse {
 	// Reset free credits if needed
 	ResetFreeCreditIfNeeded(user)
 
 	resp := UserResponse{
-		ID:           user.ID,
-		Name:         user.Name,
-		Username:     user.Username,
-		Email:        user.Email,
-		ExtraCredit:  user.Credit,
-		FreeCredit:   user.FreeCredit,
-		TotalCredit:  user.TotalCredits(),
-		RoomDuration: GetUserRoomDuration(user),
+		ID:               user.ID,
+		Name:             user.Name,
+		Username:         user.Username,
+		Email:            user.Email,
+		ExtraCredit:      user.Credit,
+		FreeCredit:       user.FreeCredit,
+		TotalCredit:      user.TotalCredits(),
+		RoomDuration:     GetUserRoomDuration(user),
+		EmailVerified:    user.IsEmailVerified(),
+		TwoFactorEnabled: twoFactorEnabled(user.ID),
 	}
 
 	if user.HasActiveSubscription() {
@@ -89,25 +134,207 @@ func generateToken() string {
 	return hex.EncodeToString(bytes)
 }
 
-// createSession creates a new session in the database
-func createSession(userID string) (string, error) {
+// hashToken returns the SHA-256 hex digest used to store bearer secrets at rest
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createSession creates a new session in the database for the requesting device. secondFactor
+// records that the sign-in passed a two-factor challenge.
+func createSession(ctx *fiber.Ctx, userID string, secondFactor bool) (*models.Session, string, error) {
 	token := generateToken()
+	now := time.Now()
 	session := models.Session{
-		ID:        generateID(),
-		Token:     token,
-		UserID:    userID,
-		ExpiresAt: time.Now().Add(sessionDuration),
+		ID:           generateID(),
+		TokenHash:    hashToken(token),
+		UserID:       userID,
+		UserAgent:    ctx.Get(fiber.HeaderUserAgent),
+		IP:           ctx.IP(),
+		LastUsedAt:   now,
+		ExpiresAt:    now.Add(sessionDuration),
+		MaxExpiresAt: now.Add(sessionMaxLifetime),
+	}
+	if secondFactor {
+		session.MFAVerifiedAt = &now
 	}
 	if err := initializers.Db.Create(&session).Error; err != nil {
-		return "", err
+		return nil, "", err
 	}
-	return token, nil
+	return &session, token, nil
 }
 
-// getSessionUser retrieves user from session token
-func getSessionUser(token string) *models.User {
+// signIn starts a session and returns the credentials for the configured auth mode: the session
+// token itself, or (JWT mode) an access token plus the first refresh token of the session.
+// JWT sessions never see their session token; it only keeps the row unique.
+func signIn(ctx *fiber.Ctx, userID string, secondFactor bool) (AuthResponse, error) {
+	session, token, err := createSession(ctx, userID, secondFactor)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+	if services.AuthMode() != services.AuthModeJWT {
+		return AuthResponse{Token: token}, nil
+	}
+	return issueJWTTokens(session)
+}
+
+// issueJWTTokens mints an access token and a new refresh token for a session
+func issueJWTTokens(session *models.Session) (AuthResponse, error) {
+	refreshToken := generateToken()
+	expiresAt := time.Now().Add(services.RefreshTokenTTL())
+	if expiresAt.After(session.MaxExpiresAt) {
+		expiresAt = session.MaxExpiresAt
+	}
+	refresh := models.RefreshToken{
+		ID:        generateID(),
+		SessionID: session.ID,
+		UserID:    session.UserID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: expiresAt,
+	}
+	if err := initializers.Db.Create(&refresh).Error; err != nil {
+		return AuthResponse{}, err
+	}
+
+	accessToken, err := services.SignAccessToken(session.UserID, session.ID, generateID())
+	if err != nil {
+		return AuthResponse{}, err
+	}
+	return AuthResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(services.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// accessSessionCacheTTL is how long a session lookup for an access token is reused on
+// read-only requests; a revoked session stops working for them within this window
+const accessSessionCacheTTL = 30 * time.Second
+
+type cachedAccessSession struct {
+	session   models.Session
+	checkedAt time.Time
+}
+
+var accessSessionCache = struct {
+	sync.Mutex
+	byID map[string]cachedAccessSession
+}{byID: map[string]cachedAccessSession{}}
+
+// accessTokenSession returns the live session named by an access token's sid. Fresh lookups
+// skip the cache; they are used for state-changing and admin requests.
+func accessTokenSession(sessionID string, userID string, fresh bool) (*models.Session, bool) {
+	if sessionID == "" {
+		return nil, false
+	}
+	now := time.Now()
+	if !fresh {
+		accessSessionCache.Lock()
+		cached, ok := accessSessionCache.byID[sessionID]
+		accessSessionCache.Unlock()
+		if ok && now.Sub(cached.checkedAt) < accessSessionCacheTTL && cached.session.UserID == userID {
+			return &cached.session, true
+		}
+	}
+
 	var session models.Session
-	if err := initializers.Db.Where("token = ? AND expires_at > ?", token, time.Now()).First(&session).Error; err != nil {
+	if err := initializers.Db.Where("id = ? AND user_id = ? AND expires_at > ? AND max_expires_at > ?", sessionID, userID, now, now).First(&session).Error; err != nil {
+		forgetAccessSession(sessionID)
+		return nil, false
+	}
+	accessSessionCache.Lock()
+	accessSessionCache.byID[sessionID] = cachedAccessSession{session: session, checkedAt: now}
+	accessSessionCache.Unlock()
+	return &session, true
+}
+
+// forgetAccessSession drops a cached session lookup so the next request re-reads it
+func forgetAccessSession(sessionID string) {
+	accessSessionCache.Lock()
+	delete(accessSessionCache.byID, sessionID)
+	accessSessionCache.Unlock()
+}
+
+// forgetUserAccessSessions drops every cached session lookup of a user
+func forgetUserAccessSessions(userID string) {
+	accessSessionCache.Lock()
+	for id, cached := range accessSessionCache.byID {
+		if cached.session.UserID == userID {
+			delete(accessSessionCache.byID, id)
+		}
+	}
+	accessSessionCache.Unlock()
+}
+
+// needsFreshSession reports whether a request must see the session row as it is now:
+// anything that changes state, and every admin route
+func needsFreshSession(ctx *fiber.Ctx) bool {
+	if ctx == nil {
+		return true
+	}
+	switch ctx.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return strings.HasPrefix(ctx.Path(), "/api/admin")
+	}
+	return true
+}
+
+// getAccessTokenUser verifies an access token and loads its user. The token is only honoured
+// while the session it was issued for still exists, so revoking a session signs out its access
+// tokens too (read-only requests may lag by accessSessionCacheTTL).
+func getAccessTokenUser(ctx *fiber.Ctx, token string) *models.User {
+	claims, err := services.VerifyAccessToken(token)
+	if err != nil {
+		return nil
+	}
+	session, ok := accessTokenSession(claims.SessionID, claims.Subject, needsFreshSession(ctx))
+	if !ok {
+		return nil
+	}
+
+	var user models.User
+	if err := initializers.Db.Where("id = ?", claims.Subject).First(&user).Error; err != nil {
+		return nil
+	}
+
+	if ctx != nil {
+		ctx.Locals(sessionLocalsKey, session)
+	}
+	return &user
+}
+
+// userFromBearer resolves any kind of bearer token (session token, JWT access token or API key)
+func userFromBearer(ctx *fiber.Ctx, token string) *models.User {
+	if token == "" {
+		return nil
+	}
+	if strings.HasPrefix(token, apiKeyPrefix) {
+		return getAPIKeyUser(ctx, token)
+	}
+	if services.LooksLikeJWT(token) {
+		return getAccessTokenUser(ctx, token)
+	}
+	return getSessionUser(ctx, token)
+}
+
+// findSession returns the active session for a raw token
+func findSession(token string) (*models.Session, bool) {
+	if token == "" {
+		return nil, false
+	}
+	var session models.Session
+	now := time.Now()
+	if err := initializers.Db.Where("token_hash = ? AND expires_at > ? AND max_expires_at > ?", hashToken(token), now, now).First(&session).Error; err != nil {
+		return nil, false
+	}
+	return &session, true
+}
+
+// getSessionUser retrieves user from session token. When ctx is given, the session's device
+// details are refreshed and the session is cached on the request.
+func getSessionUser(ctx *fiber.Ctx, token string) *models.User {
+	session, ok := findSession(token)
+	if !ok {
 		return nil
 	}
 
@@ -116,20 +343,57 @@ func getSessionUser(token string) *models.User {
 		return nil
 	}
 
-	// Extend session expiry on activity (rolling session)
-	initializers.Db.Model(&session).Update("expires_at", time.Now().Add(sessionDuration))
+	// Extend session expiry on activity (rolling session), capped by the absolute lifetime
+	now := time.Now()
+	if now.Sub(session.LastUsedAt) >= sessionTouchInterval {
+		expiresAt := now.Add(sessionDuration)
+		if expiresAt.After(session.MaxExpiresAt) {
+			expiresAt = session.MaxExpiresAt
+		}
+		updates := map[string]interface{}{"expires_at": expiresAt, "last_used_at": now}
+		if ctx != nil {
+			updates["user_agent"] = ctx.Get(fiber.HeaderUserAgent)
+			updates["ip"] = ctx.IP()
+		}
+		initializers.Db.Model(session).Updates(updates)
+	}
 
+	if ctx != nil {
+		ctx.Locals(sessionLocalsKey, session)
+	}
 	return &user
 }
 
 // deleteSession removes a session from the database
 func deleteSession(token string) {
-	initializers.Db.Where("token = ?", token).Delete(&models.Session{})
+	if services.LooksLikeJWT(token) {
+		if claims, err := services.VerifyAccessToken(token); err == nil {
+			revokeSession(claims.SessionID)
+		}
+		return
+	}
+	initializers.Db.Where("token_hash = ?", hashToken(token)).Delete(&models.Session{})
+}
+
+// revokeSession removes a session and its refresh tokens
+func revokeSession(sessionID string) {
+	forgetAccessSession(sessionID)
+	initializers.Db.Where("session_id = ?", sessionID).Delete(&models.RefreshToken{})
+	initializers.Db.Where("id = ?", sessionID).Delete(&models.Session{})
+}
+
+// deleteUserSessions signs the user out everywhere
+func deleteUserSessions(userID string) {
+	forgetUserAccessSessions(userID)
+	initializers.Db.Where("user_id = ?", userID).Delete(&models.RefreshToken{})
+	initializers.Db.Where("user_id = ?", userID).Delete(&models.Session{})
 }
 
 // cleanupExpiredSessions removes expired sessions (can be called periodically)
 func cleanupExpiredSessions() {
-	initializers.Db.Where("expires_at < ?", time.Now()).Delete(&models.Session{})
+	now := time.Now()
+	initializers.Db.Where("expires_at < ? OR max_expires_at < ?", now, now).Delete(&models.Session{})
+	initializers.Db.Where("expires_at < ?", now).Delete(&models.RefreshToken{})
 }
 
 func (c *AuthController) Register(ctx *fiber.Ctx) error {
@@ -148,6 +412,19 @@ func (c *AuthController) Register(ctx *fiber.Ctx) error {
 		return ctx.Status(409).JSON(fiber.Map{"error": "User with this email or username already exists"})
 	}
 
+	// An unknown invite code is reported before the account is created, so the user can fix a typo
+	var referrer *models.User
+	if strings.TrimSpace(req.ReferralCode) != "" {
+		found, err := findReferrer(req.ReferralCode)
+		if errors.Is(err, errReferralCodeNotFound) {
+			return ctx.Status(404).JSON(fiber.Map{"error": "Referral code not found"})
+		}
+		if err != nil {
+			return ctx.Status(500).JSON(fiber.Map{"error": "Failed to check referral code"})
+		}
+		referrer = found
+	}
+
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -167,15 +444,25 @@ func (c *AuthController) Register(ctx *fiber.Ctx) error {
 		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to create user"})
 	}
 
-	token, err := createSession(user.ID)
+	// Both users are rewarded once the new account verifies its email (see rewardReferral)
+	if referrer != nil {
+		if _, err := recordReferral(referrer, &user, ctx.IP()); err != nil {
+			log.Printf("[referral] failed to record referral of user %s by %s: %v", user.ID, referrer.ID, err)
+		}
+	}
+
+	// New accounts stay unverified (no rooms, no free credits) until the emailed link is opened
+	if err := sendVerificationEmail(&user); err != nil {
+		log.Printf("[auth] failed to send verification email to user %s: %v", user.ID, err)
+	}
+
+	resp, err := signIn(ctx, user.ID, false)
 	if err != nil {
 		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to create session"})
 	}
 
-	return ctx.JSON(AuthResponse{
-		Token: token,
-		User:  buildUserResponse(&user),
-	})
+	resp.User = buildUserResponse(&user)
+	return ctx.JSON(resp)
 }
 
 func (c *AuthController) Login(ctx *fiber.Ctx) error {
@@ -197,15 +484,21 @@ func (c *AuthController) Login(ctx *fiber.Ctx) error {
 		return ctx.Status(401).JSON(fiber.Map{"error": "Invalid credentials"})
 	}
 
-	token, err := createSession(user.ID)
+	// Earlier typos no longer count towards a lockout
+	lockoutStore.Reset(loginAccountKey(strings.ToLower(strings.TrimSpace(req.Email))))
+
+	// Accounts with two-factor authentication finish signing in at /api/auth/2fa/verify
+	if twoFactorEnabled(user.ID) {
+		return sendLoginChallenge(ctx, user.ID)
+	}
+
+	resp, err := signIn(ctx, user.ID, false)
 	if err != nil {
 		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to create session"})
 	}
 
-	return ctx.JSON(AuthResponse{
-		Token: token,
-		User:  buildUserResponse(&user),
-	})
+	resp.User = buildUserResponse(&user)
+	return ctx.JSON(resp)
 }
 
 func (c *AuthController) Me(ctx *fiber.Ctx) error {
@@ -219,7 +512,7 @@ func (c *AuthController) Me(ctx *fiber.Ctx) error {
 		token = token[7:]
 	}
 
-	user := getSessionUser(token)
+	user := userFromBearer(ctx, token)
 	if user == nil {
 		return ctx.Status(401).JSON(fiber.Map{"error": "Invalid or expired token"})
 	}
@@ -236,11 +529,519 @@ func (c *AuthController) Logout(ctx *fiber.Ctx) error {
 	return ctx.JSON(fiber.Map{"message": "Logged out successfully"})
 }
 
-// GetUserFromToken extracts user from authorization header
+// Refresh rotates a JWT-mode refresh token: the presented token is spent and a new access and
+// refresh token are returned. Presenting a spent token revokes the whole session, since it
+// means the token was copied.
+func (c *AuthController) Refresh(ctx *fiber.Ctx) error {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.RefreshToken == "" {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Refresh token is required"})
+	}
+
+	var refresh models.RefreshToken
+	if err := initializers.Db.Where("token_hash = ?", hashToken(req.RefreshToken)).First(&refresh).Error; err != nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Invalid refresh token"})
+	}
+
+	now := time.Now()
+	spent := initializers.Db.Model(&models.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL", refresh.ID).
+		Update("used_at", now)
+	if spent.Error != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to refresh session"})
+	}
+	if spent.RowsAffected != 1 {
+		log.Printf("[auth] refresh token reuse for session %s (user %s), revoking session", refresh.SessionID, refresh.UserID)
+		revokeSession(refresh.SessionID)
+		return ctx.Status(401).JSON(fiber.Map{"error": "Refresh token was already used, please log in again"})
+	}
+	if refresh.ExpiresAt.Before(now) {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Refresh token has expired"})
+	}
+
+	var session models.Session
+	if err := initializers.Db.Where("id = ? AND expires_at > ? AND max_expires_at > ?", refresh.SessionID, now, now).First(&session).Error; err != nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Session has ended, please log in again"})
+	}
+
+	expiresAt := now.Add(sessionDuration)
+	if expiresAt.After(session.MaxExpiresAt) {
+		expiresAt = session.MaxExpiresAt
+	}
+	initializers.Db.Model(&session).Updates(map[string]interface{}{
+		"expires_at":   expiresAt,
+		"last_used_at": now,
+		"user_agent":   ctx.Get(fiber.HeaderUserAgent),
+		"ip":           ctx.IP(),
+	})
+
+	resp, err := issueJWTTokens(&session)
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to refresh session"})
+	}
+	return ctx.JSON(fiber.Map{
+		"token":         resp.Token,
+		"refresh_token": resp.RefreshToken,
+		"expires_in":    resp.ExpiresIn,
+	})
+}
+
+// ========================================
+// Sessions
+// ========================================
+
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// currentSession returns the session that authenticated this request
+func currentSession(ctx *fiber.Ctx) *models.Session {
+	if GetUserFromToken(ctx) == nil {
+		return nil
+	}
+	session, _ := ctx.Locals(sessionLocalsKey).(*models.Session)
+	return session
+}
+
+// ListSessions returns the signed-in user's active sessions, most recently used first
+func (c *AuthController) ListSessions(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	current := currentSession(ctx)
+
+	var sessions []models.Session
+	now := time.Now()
+	if err := initializers.Db.Where("user_id = ? AND expires_at > ? AND max_expires_at > ?", user.ID, now, now).
+		Order("last_used_at DESC").Find(&sessions).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to fetch sessions"})
+	}
+
+	resp := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		resp = append(resp, SessionResponse{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    current != nil && current.ID == session.ID,
+		})
+	}
+	return ctx.JSON(resp)
+}
+
+// RevokeSession signs out one of the user's sessions
+func (c *AuthController) RevokeSession(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	initializers.Db.Where("session_id = ? AND user_id = ?", ctx.Params("id"), user.ID).Delete(&models.RefreshToken{})
+	result := initializers.Db.Where("id = ? AND user_id = ?", ctx.Params("id"), user.ID).Delete(&models.Session{})
+	forgetAccessSession(ctx.Params("id"))
+	if result.Error != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to revoke session"})
+	}
+	if result.RowsAffected == 0 {
+		return ctx.Status(404).JSON(fiber.Map{"error": "Session not found"})
+	}
+	return ctx.JSON(fiber.Map{"success": true})
+}
+
+// RevokeOtherSessions signs out every session except the one making the request
+func (c *AuthController) RevokeOtherSessions(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	current := currentSession(ctx)
+	if user == nil || current == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	revoked, err := revokeOtherSessions(user.ID, current.ID)
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to revoke sessions"})
+	}
+	return ctx.JSON(fiber.Map{"success": true, "revoked": revoked})
+}
+
+// revokeOtherSessions signs the user out of every session except keepID
+func revokeOtherSessions(userID string, keepID string) (int64, error) {
+	forgetUserAccessSessions(userID)
+	initializers.Db.Where("user_id = ? AND session_id <> ?", userID, keepID).Delete(&models.RefreshToken{})
+	result := initializers.Db.Where("user_id = ? AND id <> ?", userID, keepID).Delete(&models.Session{})
+	return result.RowsAffected, result.Error
+}
+
+// ========================================
+// Password reset
+// ========================================
+
+// issueAccountToken creates a single-use emailed token and returns its raw value.
+// Earlier unused tokens of the same purpose stop working.
+func issueAccountToken(userID string, purpose string, payload string, ttl time.Duration) (string, error) {
+	initializers.Db.Model(&models.AccountToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now())
+	return createAccountToken(userID, purpose, payload, ttl)
+}
+
+// createAccountToken stores a single-use token alongside any others of the same purpose
+func createAccountToken(userID string, purpose string, payload string, ttl time.Duration) (string, error) {
+	raw := generateToken()
+	token := models.AccountToken{
+		ID:        generateID(),
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashToken(raw),
+		Payload:   payload,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := initializers.Db.Create(&token).Error; err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// consumeAccountToken marks a valid token as used and returns it; each token works exactly once
+func consumeAccountToken(raw string, purpose string) (*models.AccountToken, bool) {
+	if raw == "" {
+		return nil, false
+	}
+	now := time.Now()
+	var token models.AccountToken
+	if err := initializers.Db.Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hashToken(raw), purpose, now).First(&token).Error; err != nil {
+		return nil, false
+	}
+	// Conditional update so two concurrent requests can't both use the token
+	result := initializers.Db.Model(&models.AccountToken{}).Where("id = ? AND used_at IS NULL", token.ID).Update("used_at", now)
+	if result.Error != nil || result.RowsAffected != 1 {
+		return nil, false
+	}
+	token.UsedAt = &now
+	return &token, true
+}
+
+// errAppURLNotSet is returned when an absolute frontend link is needed but APP_URL is not configured
+var errAppURLNotSet = errors.New("APP_URL is not configured")
+
+// frontendURL builds an absolute link into the frontend from APP_URL. The request's Origin and Host
+// are never used, as a client could point emailed tokens at its own site. Without APP_URL the relative
+// path is returned with errAppURLNotSet; it is only good for redirecting the browser back to this host.
+func frontendURL(path string) (string, error) {
+	base := strings.TrimRight(strings.TrimSpace(os.Getenv("APP_URL")), "/")
+	if base == "" {
+		return path, errAppURLNotSet
+	}
+	return base + path, nil
+}
+
+// ForgotPassword emails a password reset link. The response is the same whether or not the email exists.
+func (c *AuthController) ForgotPassword(ctx *fiber.Ctx) error {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.Email == "" {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Email is required"})
+	}
+
+	response := fiber.Map{"message": "If an account exists for this email, a reset link has been sent"}
+
+	// Checked before the lookup so the answer doesn't depend on whether the account exists
+	if _, err := frontendURL(""); err != nil {
+		log.Printf("[auth] refusing to send password reset email: %v", err)
+		return ctx.Status(503).JSON(fiber.Map{"error": "Password reset is not available"})
+	}
+
+	var user models.User
+	if err := initializers.Db.Where("email = ?", req.Email).First(&user).Error; err != nil {
+		return ctx.JSON(response)
+	}
+
+	raw, err := issueAccountToken(user.ID, models.AccountTokenPasswordReset, "", passwordResetDuration)
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to create reset token"})
+	}
+
+	link, _ := frontendURL("/reset-password?token=" + raw)
+	err = services.SendTemplate(user.Email, "Reset your Karayouke password", "emails/password_reset", fiber.Map{
+		"Name":         user.Name,
+		"Link":         link,
+		"ValidMinutes": int(passwordResetDuration.Minutes()),
+	})
+	if err != nil {
+		log.Printf("[auth] failed to send password reset email to user %s: %v", user.ID, err)
+	}
+
+	return ctx.JSON(response)
+}
+
+// ResetPassword sets a new password using an emailed reset token and revokes all existing sessions
+func (c *AuthController) ResetPassword(ctx *fiber.Ctx) error {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if len(req.Password) < minPasswordLength {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Password must be at least 8 characters"})
+	}
+
+	token, ok := consumeAccountToken(req.Token, models.AccountTokenPasswordReset)
+	if !ok {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid or expired reset token"})
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to hash password"})
+	}
+
+	if err := initializers.Db.Model(&models.User{}).Where("id = ?", token.UserID).Update("password", string(hashedPassword)).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to update password"})
+	}
+
+	deleteUserSessions(token.UserID)
+
+	return ctx.JSON(fiber.Map{"message": "Password has been reset, please log in again"})
+}
+
+// ========================================
+// Account lockout
+// ========================================
+
+// Unlock links are valid for 1 hour
+const accountUnlockDuration = time.Hour
+
+// lockoutStore reads and clears the login counters kept by the throttle middleware
+var lockoutStore = &services.AttemptLimiter{Store: services.DefaultAttemptStore()}
+
+// requestEmail reads the email field from a JSON or form body
+func requestEmail(ctx *fiber.Ctx) string {
+	var req struct {
+		Email string `json:"email" form:"email"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(req.Email))
+}
+
+// loginAccountKey is the throttle key of an account in the login scope
+func loginAccountKey(email string) string {
+	return models.AuditScopeLogin + ":email:" + email
+}
+
+// ThrottleEmailKeys returns the per-account throttle key for the email in the request body, if any
+func ThrottleEmailKeys(ctx *fiber.Ctx) []string {
+	if email := requestEmail(ctx); email != "" {
+		return []string{"email:" + email}
+	}
+	return nil
+}
+
+// SendUnlockEmail tells the account owner that sign-in was locked and offers an unlock link.
+// Lockouts that only hit the client IP are not reported.
+func SendUnlockEmail(ctx *fiber.Ctx, keys []string, lockout time.Duration) {
+	email := requestEmail(ctx)
+	if email == "" || lockoutStore.RetryAfter(loginAccountKey(email)) <= 0 {
+		return
+	}
+
+	if _, err := frontendURL(""); err != nil {
+		log.Printf("[auth] refusing to send unlock email: %v", err)
+		return
+	}
+
+	var user models.User
+	if err := initializers.Db.Where("LOWER(email) = ?", email).First(&user).Error; err != nil {
+		return
+	}
+
+	raw, err := issueAccountToken(user.ID, models.AccountTokenAccountUnlock, email, accountUnlockDuration)
+	if err != nil {
+		log.Printf("[auth] failed to create unlock token for user %s: %v", user.ID, err)
+		return
+	}
+	link, _ := frontendURL("/unlock-account?token=" + raw)
+	resetLink, _ := frontendURL("/forgot-password")
+
+	err = services.SendTemplate(user.Email, "Your Karayouke account was locked", "emails/account_unlock", fiber.Map{
+		"Name":          user.Name,
+		"IP":            ctx.IP(),
+		"LockedMinutes": int(lockout.Minutes()) + 1,
+		"ValidMinutes":  int(accountUnlockDuration.Minutes()),
+		"Link":          link,
+		"ResetLink":     resetLink,
+	})
+	if err != nil {
+		log.Printf("[auth] failed to send unlock email to user %s: %v", user.ID, err)
+	}
+}
+
+// UnlockAccount lifts a login lockout using the emailed unlock token
+func (c *AuthController) UnlockAccount(ctx *fiber.Ctx) error {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	token, ok := consumeAccountToken(req.Token, models.AccountTokenAccountUnlock)
+	if !ok {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid or expired unlock token"})
+	}
+
+	lockoutStore.Reset(loginAccountKey(token.Payload))
+	return ctx.JSON(fiber.Map{"message": "Your account has been unlocked, you can log in again"})
+}
+
+// ========================================
+// Email verification
+// ========================================
+
+// sendVerificationEmail emails a fresh verification link to the user's current address
+func sendVerificationEmail(user *models.User) error {
+	if _, err := frontendURL(""); err != nil {
+		return err
+	}
+	raw, err := issueAccountToken(user.ID, models.AccountTokenEmailVerification, user.Email, emailVerificationDuration)
+	if err != nil {
+		return err
+	}
+	link, _ := frontendURL("/verify-email?token=" + raw)
+	return services.SendTemplate(user.Email, "Verify your Karayouke email", "emails/verify_email", fiber.Map{
+		"Name":       user.Name,
+		"Link":       link,
+		"ValidHours": int(emailVerificationDuration.Hours()),
+	})
+}
+
+// VerifyEmail confirms the email address from an emailed verification token
+func (c *AuthController) VerifyEmail(ctx *fiber.Ctx) error {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	token, ok := consumeAccountToken(req.Token, models.AccountTokenEmailVerification)
+	if !ok {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid or expired verification token"})
+	}
+
+	var user models.User
+	if err := initializers.Db.Where("id = ?", token.UserID).First(&user).Error; err != nil {
+		return ctx.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	// The link only verifies the address it was sent to
+	if token.Payload != user.Email {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid or expired verification token"})
+	}
+
+	if !user.IsEmailVerified() {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		// Free credits were withheld while unverified; grant today's allowance now
+		user.FreeCreditResetAt = nil
+		if err := initializers.Db.Save(&user).Error; err != nil {
+			return ctx.Status(500).JSON(fiber.Map{"error": "Failed to verify email"})
+		}
+		rewardReferralAfterVerification(user.ID)
+	}
+
+	return ctx.JSON(fiber.Map{
+		"message": "Email verified successfully",
+		"user":    buildUserResponse(&user),
+	})
+}
+
+// ResendVerification sends another verification email (rate limited per account and IP)
+func (c *AuthController) ResendVerification(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	if user.IsEmailVerified() {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Email is already verified"})
+	}
+
+	keys := []string{"verify:user:" + user.ID, "verify:ip:" + ctx.IP()}
+	if wait := resendVerificationLimiter.RetryAfter(keys...); wait > 0 {
+		seconds := int(wait.Seconds()) + 1
+		ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+		return ctx.Status(429).JSON(fiber.Map{
+			"error":       "Too many verification emails requested, please try again later",
+			"retry_after": seconds,
+		})
+	}
+	resendVerificationLimiter.Fail(keys...)
+
+	if err := sendVerificationEmail(user); err != nil {
+		log.Printf("[auth] failed to resend verification email to user %s: %v", user.ID, err)
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to send verification email"})
+	}
+
+	return ctx.JSON(fiber.Map{"message": "Verification email sent"})
+}
+
+// GetUserFromToken extracts user from authorization header.
+// The result is cached on the request so middleware and handlers share one lookup.
 func GetUserFromToken(ctx *fiber.Ctx) *models.User {
+	if user, ok := ctx.Locals(userLocalsKey).(*models.User); ok {
+		return user
+	}
 	token := ctx.Get("Authorization")
 	if len(token) > 7 && token[:7] == "Bearer " {
 		token = token[7:]
 	}
-	return getSessionUser(token)
+	if token == "" {
+		return nil
+	}
+	user := userFromBearer(ctx, token)
+	if user != nil {
+		ctx.Locals(userLocalsKey, user)
+	}
+	return user
+}
+
+// UserFromWebsocketTicket spends a websocket ticket and returns its user, if it was issued for the room
+func UserFromWebsocketTicket(ticket string, roomKey string) *models.User {
+	token, ok := consumeAccountToken(ticket, models.AccountTokenWebsocket)
+	if !ok || token.Payload != roomKey {
+		return nil
+	}
+	var user models.User
+	if err := initializers.Db.Where("id = ?", token.UserID).First(&user).Error; err != nil {
+		return nil
+	}
+	return &user
+}
+
+// ThrottleAccountKeys returns the per-account throttle key for the authenticated user, if any
+func ThrottleAccountKeys(ctx *fiber.Ctx) []string {
+	if user := GetUserFromToken(ctx); user != nil {
+		return []string{"user:" + user.ID}
+	}
+	return nil
 }
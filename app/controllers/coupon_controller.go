@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CouponController lets users check a promo code before paying
+type CouponController struct{}
+
+// couponUseStatuses are the transaction statuses that count as a use of a coupon. Pending bills hold
+// their use until they expire; refunds give it back once they complete.
+var couponUseStatuses = []string{models.TransactionStatusPending, models.TransactionStatusSettlement, models.TransactionStatusRefundPending}
+
+// couponError explains why a coupon can't be used; the message is shown to the user
+type couponError struct {
+	message              string
+	notFound             bool   // unknown codes answer 404, which the coupon throttle counts
+	pendingTransactionID string // the user's unpaid bill that already uses the coupon
+}
+
+func (e *couponError) Error() string {
+	return e.message
+}
+
+// normalizeCouponCode upper-cases the code, so "karaoke50" and "KARAOKE50" are the same coupon
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// couponDiscount returns the discount the coupon gives on price, never more than the price
+func couponDiscount(coupon *models.Coupon, price int64) int64 {
+	discount := coupon.DiscountValue
+	if coupon.DiscountType == models.CouponDiscountPercent {
+		discount = price * coupon.DiscountValue / 100
+	}
+	if discount > price {
+		discount = price
+	}
+	if discount < 0 {
+		discount = 0
+	}
+	return discount
+}
+
+// applyCoupon checks that the coupon can be used by the user for the transaction's item and sets the
+// coupon, list price and discounted amount on it. The coupon row is always locked: inside a database
+// transaction the lock holds until it ends, so concurrent checkouts can't both take the last use.
+func applyCoupon(db *gorm.DB, code string, user *models.User, transaction *models.Transaction) error {
+	var coupon models.Coupon
+	if err := db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("code = ?", normalizeCouponCode(code)).First(&coupon).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &couponError{message: "Coupon code not found", notFound: true}
+		}
+		return err
+	}
+
+	now := time.Now()
+	if !coupon.Active {
+		return &couponError{message: "This coupon is no longer active"}
+	}
+	if coupon.ValidFrom != nil && now.Before(*coupon.ValidFrom) {
+		return &couponError{message: "This coupon is not valid yet"}
+	}
+	if coupon.ValidUntil != nil && now.After(*coupon.ValidUntil) {
+		return &couponError{message: "This coupon has expired"}
+	}
+	if !couponAppliesTo(&coupon, transaction) {
+		return &couponError{message: "This coupon cannot be used for this item"}
+	}
+
+	if coupon.MaxUses > 0 {
+		var used int64
+		if err := db.Model(&models.Transaction{}).
+			Where("coupon_id = ? AND status IN ?", coupon.ID, couponUseStatuses).
+			Count(&used).Error; err != nil {
+			return err
+		}
+		if used >= int64(coupon.MaxUses) {
+			return &couponError{message: "This coupon has been fully redeemed"}
+		}
+	}
+
+	if coupon.MaxUsesPerUser > 0 {
+		var uses []models.Transaction
+		if err := db.Select("id, status").
+			Where("coupon_id = ? AND user_id = ? AND status IN ?", coupon.ID, user.ID, couponUseStatuses).
+			Find(&uses).Error; err != nil {
+			return err
+		}
+		if len(uses) >= coupon.MaxUsesPerUser {
+			for _, use := range uses {
+				if use.Status == models.TransactionStatusPending {
+					return &couponError{
+						message:              "You already have an unpaid bill using this coupon",
+						pendingTransactionID: use.ID,
+					}
+				}
+			}
+			return &couponError{message: "You have already used this coupon"}
+		}
+	}
+
+	if coupon.FirstPurchaseOnly {
+		var paid int64
+		if err := db.Model(&models.Transaction{}).
+			Where("user_id = ? AND amount > 0 AND status IN ?", user.ID,
+				[]string{models.TransactionStatusSettlement, models.TransactionStatusRefundPending, models.TransactionStatusRefunded}).
+			Count(&paid).Error; err != nil {
+			return err
+		}
+		if paid > 0 {
+			return &couponError{message: "This coupon is only for your first purchase"}
+		}
+	}
+
+	listAmount := transaction.Amount
+	if transaction.ListAmount > 0 {
+		listAmount = transaction.ListAmount
+	}
+	discount := couponDiscount(&coupon, listAmount)
+	transaction.CouponID = &coupon.ID
+	transaction.CouponCode = coupon.Code
+	transaction.ListAmount = listAmount
+	transaction.DiscountAmount = discount
+	transaction.Amount = listAmount - discount
+	return nil
+}
+
+// couponAppliesTo reports whether the coupon covers the transaction's package or plan
+func couponAppliesTo(coupon *models.Coupon, transaction *models.Transaction) bool {
+	if len(coupon.PackageIDs) == 0 && len(coupon.PlanIDs) == 0 {
+		return true
+	}
+	if transaction.PackageID != nil {
+		for _, id := range coupon.PackageIDs {
+			if id == *transaction.PackageID {
+				return true
+			}
+		}
+	}
+	if transaction.PlanID != nil {
+		for _, id := range coupon.PlanIDs {
+			if id == *transaction.PlanID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// couponErrorResponse answers a checkout whose coupon was rejected
+func couponErrorResponse(ctx *fiber.Ctx, err *couponError) error {
+	response := fiber.Map{"error": err.message, "coupon_error": true}
+	if err.pendingTransactionID != "" {
+		response["pending_transaction_id"] = err.pendingTransactionID
+	}
+	if err.notFound {
+		return ctx.Status(404).JSON(response)
+	}
+	return ctx.Status(400).JSON(response)
+}
+
+// Validate previews a coupon for a package or plan, without using it
+func (c *CouponController) Validate(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req struct {
+		Code      string `json:"code"`
+		PackageID string `json:"package_id"`
+		PlanID    string `json:"plan_id"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if normalizeCouponCode(req.Code) == "" {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Coupon code is required"})
+	}
+
+	item, err := loadCheckoutItem(req.PackageID, req.PlanID)
+	if err != nil {
+		return ctx.Status(404).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	transaction := item.transaction(user.ID)
+	var rejected *couponError
+	if err := applyCoupon(initializers.Db, req.Code, user, &transaction); errors.As(err, &rejected) {
+		return couponErrorResponse(ctx, rejected)
+	} else if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to check coupon"})
+	}
+
+	return ctx.JSON(fiber.Map{
+		"code":        transaction.CouponCode,
+		"product":     item.Name,
+		"list_amount": transaction.ListAmount,
+		"discount":    transaction.DiscountAmount,
+		"amount":      transaction.Amount,
+		"message":     fmt.Sprintf("Coupon %s applied", transaction.CouponCode),
+	})
+}
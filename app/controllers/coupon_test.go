@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// couponTestCoupon creates a 10% coupon with the given limits, removed after the test
+func couponTestCoupon(t *testing.T, limits models.Coupon) *models.Coupon {
+	t.Helper()
+	coupon := limits
+	coupon.ID = generateID()
+	coupon.Code = "TEST" + strings.ToUpper(generateID()[:8])
+	coupon.DiscountType = models.CouponDiscountPercent
+	coupon.DiscountValue = 10
+	coupon.Active = true
+	if err := initializers.Db.Create(&coupon).Error; err != nil {
+		t.Fatalf("create coupon: %v", err)
+	}
+	t.Cleanup(func() {
+		initializers.Db.Where("coupon_id = ?", coupon.ID).Delete(&models.Transaction{})
+		initializers.Db.Delete(&coupon)
+	})
+	return &coupon
+}
+
+// checkoutWithCoupon checks pkg out with the coupon and returns the response
+func checkoutWithCoupon(t *testing.T, app *fiber.App, pkg *models.Package, coupon *models.Coupon) map[string]interface{} {
+	t.Helper()
+	return postJSON(t, app, "/checkout", fmt.Sprintf(`{"package_id":%q,"coupon_code":%q}`, pkg.ID, strings.ToLower(coupon.Code)), nil)
+}
+
+// couponRejection returns the error of a checkout the coupon was refused for, or "" if it went through
+func couponRejection(t *testing.T, out map[string]interface{}) string {
+	t.Helper()
+	if out["coupon_error"] == true {
+		message, _ := out["error"].(string)
+		return message
+	}
+	if id, _ := out["transaction_id"].(string); id == "" {
+		t.Fatalf("checkout neither went through nor was refused: %v", out)
+	}
+	return ""
+}
+
+func TestCouponMaxUses(t *testing.T) {
+	paymentTestDB(t)
+	coupon := couponTestCoupon(t, models.Coupon{MaxUses: 2})
+	var apps []*fiber.App
+	var pkg *models.Package
+	for i := 0; i < 3; i++ {
+		user, p := paymentTestCustomer(t)
+		apps, pkg = append(apps, paymentTestApp(user)), p
+	}
+
+	first := checkoutWithCoupon(t, apps[0], pkg, coupon)
+	if reason := couponRejection(t, first); reason != "" {
+		t.Fatalf("first use refused: %s", reason)
+	}
+	if reason := couponRejection(t, checkoutWithCoupon(t, apps[1], pkg, coupon)); reason != "" {
+		t.Fatalf("second use refused: %s", reason)
+	}
+	if reason := couponRejection(t, checkoutWithCoupon(t, apps[2], pkg, coupon)); reason != "This coupon has been fully redeemed" {
+		t.Fatalf("third use: %q", reason)
+	}
+
+	// An unpaid bill gives its use back when it expires
+	initializers.Db.Model(&models.Transaction{}).Where("id = ?", first["transaction_id"]).Update("status", models.TransactionStatusExpired)
+	if reason := couponRejection(t, checkoutWithCoupon(t, apps[2], pkg, coupon)); reason != "" {
+		t.Fatalf("use after a bill expired refused: %s", reason)
+	}
+}
+
+func TestCouponMaxUsesPerUser(t *testing.T) {
+	paymentTestDB(t)
+	coupon := couponTestCoupon(t, models.Coupon{MaxUsesPerUser: 1})
+	user, pkg := paymentTestCustomer(t)
+	app := paymentTestApp(user)
+
+	first := checkoutWithCoupon(t, app, pkg, coupon)
+	if reason := couponRejection(t, first); reason != "" {
+		t.Fatalf("first use refused: %s", reason)
+	}
+	txID, _ := first["transaction_id"].(string)
+
+	// While the first bill is open the user is pointed back to it
+	again := checkoutWithCoupon(t, app, pkg, coupon)
+	if couponRejection(t, again) == "" || again["pending_transaction_id"] != txID {
+		t.Fatalf("second use with the first unpaid = %v", again)
+	}
+
+	if status := sendFakeCallback(t, app, txID, services.PaymentStatusPaid, pkg.Price-pkg.Price/10, testPaymentSecret); status != "ok" {
+		t.Fatalf("paying the discounted bill: %q", status)
+	}
+	if reason := couponRejection(t, checkoutWithCoupon(t, app, pkg, coupon)); reason != "You have already used this coupon" {
+		t.Fatalf("second use after paying: %q", reason)
+	}
+
+	// Other users still can
+	other, _ := paymentTestCustomer(t)
+	if reason := couponRejection(t, checkoutWithCoupon(t, paymentTestApp(other), pkg, coupon)); reason != "" {
+		t.Fatalf("another user refused: %s", reason)
+	}
+}
+
+func TestCouponFirstPurchaseOnly(t *testing.T) {
+	paymentTestDB(t)
+	coupon := couponTestCoupon(t, models.Coupon{FirstPurchaseOnly: true})
+	returning, pkg := paymentTestCustomer(t)
+	returningApp := paymentTestApp(returning)
+	buyPackage(t, returningApp, pkg)
+
+	if reason := couponRejection(t, checkoutWithCoupon(t, returningApp, pkg, coupon)); reason != "This coupon is only for your first purchase" {
+		t.Fatalf("returning customer: %q", reason)
+	}
+	newcomer, _ := paymentTestCustomer(t)
+	if reason := couponRejection(t, checkoutWithCoupon(t, paymentTestApp(newcomer), pkg, coupon)); reason != "" {
+		t.Fatalf("first purchase refused: %s", reason)
+	}
+}
+
+func TestCouponLastUseGoesToOneCheckout(t *testing.T) {
+	paymentTestDB(t)
+	coupon := couponTestCoupon(t, models.Coupon{MaxUses: 1})
+	const buyers = 6
+	var apps []*fiber.App
+	var pkg *models.Package
+	for i := 0; i < buyers; i++ {
+		user, p := paymentTestCustomer(t)
+		apps, pkg = append(apps, paymentTestApp(user)), p
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded, refused := 0, 0
+	start := make(chan struct{})
+	for _, app := range apps {
+		wg.Add(1)
+		go func(app *fiber.App) {
+			defer wg.Done()
+			<-start
+			out := checkoutWithCoupon(t, app, pkg, coupon)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case out["coupon_error"] == true:
+				refused++
+			case out["transaction_id"] != nil:
+				succeeded++
+			}
+		}(app)
+	}
+	close(start)
+	wg.Wait()
+
+	var uses int64
+	initializers.Db.Model(&models.Transaction{}).Where("coupon_id = ?", coupon.ID).Count(&uses)
+	if succeeded != 1 || refused != buyers-1 || uses != 1 {
+		t.Fatalf("%d checkouts got the last use (%d refused, %d bills)", succeeded, refused, uses)
+	}
+}
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"testing"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+)
+
+func TestGuestJoinAndClaim(t *testing.T) {
+	testDB(t, &models.User{}, &models.Room{}, &models.Guest{}, &models.Song{})
+
+	roomID := generateID()
+	user := models.User{ID: generateID(), Name: "Former Guest", Email: generateID() + "@example.test"}
+	rival := models.User{ID: generateID(), Name: "Rival", Email: generateID() + "@example.test"}
+	for _, row := range []interface{}{&user, &rival} {
+		if err := initializers.Db.Create(row).Error; err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	t.Cleanup(func() {
+		initializers.Db.Where("room_id = ?", roomID).Delete(&models.Song{})
+		initializers.Db.Where("id_room = ?", roomID).Delete(&models.Guest{})
+		initializers.Db.Where("id IN ?", []string{user.ID, rival.ID}).Delete(&models.User{})
+	})
+
+	// A new guest gets a token; coming back with it finds the same guest
+	guest, token, err := JoinRoomAsGuest(roomID, "", "  Singer  ")
+	if err != nil || token == "" || guest.Name != "Singer" {
+		t.Fatalf("join: guest %+v, token %q, err %v", guest, token, err)
+	}
+	again, reissued, err := JoinRoomAsGuest(roomID, token, "")
+	if err != nil || again.ID != guest.ID || reissued != "" {
+		t.Fatalf("rejoin: guest %+v, token %q, err %v", again, reissued, err)
+	}
+	other, _, err := JoinRoomAsGuest(generateID(), token, "")
+	if err != nil {
+		t.Fatalf("join another room: %v", err)
+	}
+	initializers.Db.Delete(other)
+	if other.ID == guest.ID {
+		t.Fatal("a guest token from one room was accepted in another")
+	}
+
+	song := models.Song{ID: generateID(), RoomID: roomID, Title: "Song", RequestedByGuestID: &guest.ID}
+	if err := initializers.Db.Create(&song).Error; err != nil {
+		t.Fatalf("create song: %v", err)
+	}
+
+	result, err := ClaimGuests(user.ID, []string{token, "unknown-token"})
+	if err != nil || len(result.Claimed) != 1 || result.Claimed[0] != guest.ID || result.Songs != 1 {
+		t.Fatalf("claim: %+v, %v", result, err)
+	}
+	initializers.Db.Where("id = ?", song.ID).First(&song)
+	if song.RequestedByUserID == nil || *song.RequestedByUserID != user.ID {
+		t.Fatalf("song not moved to the user: %+v", song)
+	}
+
+	// Claiming again is a no-op for the owner and a conflict for anyone else
+	if result, _ := ClaimGuests(user.ID, []string{token}); len(result.Claimed) != 0 || len(result.Conflict) != 0 {
+		t.Fatalf("repeated claim: %+v", result)
+	}
+	if result, _ := ClaimGuests(rival.ID, []string{token}); len(result.Conflict) != 1 {
+		t.Fatalf("claim by another user: %+v", result)
+	}
+}
@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// GuestController lets a registered user take over guest identities they used in rooms
+type GuestController struct{}
+
+// Maximum number of guest tokens accepted in one claim
+const maxGuestClaims = 50
+
+// Maximum length of a guest's display name
+const maxGuestNameLength = 50
+
+// JoinRoomAsGuest returns the room's guest holding token, or registers a new guest and returns the
+// raw token its browser keeps to claim the guest's history later. Tokens from other rooms start a
+// new guest; the returned token is empty when an existing guest was found.
+func JoinRoomAsGuest(roomID string, token string, name string) (*models.Guest, string, error) {
+	name = strings.TrimSpace(name)
+	if len([]rune(name)) > maxGuestNameLength {
+		name = string([]rune(name)[:maxGuestNameLength])
+	}
+
+	var guest models.Guest
+	if token != "" && initializers.Db.Where("token_hash = ? AND id_room = ?", hashToken(token), roomID).First(&guest).Error == nil {
+		if name != "" && name != guest.Name {
+			initializers.Db.Model(&guest).Update("name", name)
+			guest.Name = name
+		}
+		return &guest, "", nil
+	}
+
+	if name == "" {
+		name = "Guest"
+	}
+	token = generateToken()
+	guest = models.Guest{
+		ID:        generateID(),
+		Name:      name,
+		RoomID:    roomID,
+		TokenHash: hashToken(token),
+	}
+	if err := initializers.Db.Create(&guest).Error; err != nil {
+		return nil, "", err
+	}
+	return &guest, token, nil
+}
+
+// GuestClaimResult reports what a claim linked to the user
+type GuestClaimResult struct {
+	Claimed  []string `json:"claimed"`  // guest IDs now linked to the user
+	Conflict []string `json:"conflict"` // guest IDs already claimed by someone else
+	Songs    int64    `json:"songs"`    // song requests moved to the user
+}
+
+// ClaimGuests links the guests identified by their tokens, and their song requests, to the user.
+// Guests from several rooms can be claimed at once; guests already linked to the user are skipped.
+func ClaimGuests(userID string, tokens []string) (GuestClaimResult, error) {
+	result := GuestClaimResult{Claimed: []string{}, Conflict: []string{}}
+
+	hashes := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if token != "" {
+			hashes = append(hashes, hashToken(token))
+		}
+	}
+	if len(hashes) == 0 {
+		return result, nil
+	}
+
+	err := initializers.Db.Transaction(func(tx *gorm.DB) error {
+		var guests []models.Guest
+		if err := tx.Where("token_hash IN ?", hashes).Find(&guests).Error; err != nil {
+			return err
+		}
+
+		guestIDs := []string{}
+		for _, guest := range guests {
+			switch {
+			case guest.UserID == nil:
+				guestIDs = append(guestIDs, guest.ID)
+			case *guest.UserID != userID:
+				result.Conflict = append(result.Conflict, guest.ID)
+			}
+		}
+		if len(guestIDs) == 0 {
+			return nil
+		}
+
+		// Only unclaimed rows, so a concurrent claim by another user can't be overwritten
+		now := time.Now()
+		if err := tx.Model(&models.Guest{}).
+			Where("id IN ? AND id_user IS NULL", guestIDs).
+			Updates(map[string]interface{}{"id_user": userID, "claimed_at": now}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Guest{}).Where("id IN ? AND id_user = ?", guestIDs, userID).Pluck("id", &result.Claimed).Error; err != nil {
+			return err
+		}
+		if len(result.Claimed) == 0 {
+			return nil
+		}
+
+		// Songs keep requested_by_guest so the room history still shows the guest name
+		songs := tx.Model(&models.Song{}).
+			Where("requested_by_guest IN ? AND requested_by_user IS NULL", result.Claimed).
+			Update("requested_by_user", userID)
+		if songs.Error != nil {
+			return songs.Error
+		}
+		result.Songs = songs.RowsAffected
+		return nil
+	})
+	return result, err
+}
+
+// Claim links guest identities (by the tokens the browser kept for each room) to the signed-in user
+func (c *GuestController) Claim(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req struct {
+		GuestTokens []string `json:"guest_tokens"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if len(req.GuestTokens) == 0 {
+		return ctx.Status(400).JSON(fiber.Map{"error": "At least one guest token is required"})
+	}
+	if len(req.GuestTokens) > maxGuestClaims {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Too many guest tokens"})
+	}
+
+	result, err := ClaimGuests(user.ID, req.GuestTokens)
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to claim guest history"})
+	}
+	if len(result.Claimed) == 0 && len(result.Conflict) > 0 {
+		return ctx.Status(409).JSON(fiber.Map{"error": "These guests were already claimed by another account", "result": result})
+	}
+	return ctx.JSON(result)
+}
+
+// errNoGuestTokens is returned when an in-room claim carries no tokens
+var errNoGuestTokens = errors.New("no guest tokens provided")
+
+// ClaimGuestsInRoom is the websocket entry point: a signed-in connection claims the guest tokens it held
+func ClaimGuestsInRoom(userID string, tokens []string) (GuestClaimResult, error) {
+	if len(tokens) == 0 {
+		return GuestClaimResult{}, errNoGuestTokens
+	}
+	if len(tokens) > maxGuestClaims {
+		tokens = tokens[:maxGuestClaims]
+	}
+	return ClaimGuests(userID, tokens)
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// InvoiceController serves invoice PDFs for the user's own settled transactions
+type InvoiceController struct{}
+
+// errNotInvoiceable is returned for transactions that were never paid
+var errNotInvoiceable = errors.New("invoices are only issued for settled transactions")
+
+// Download returns the invoice PDF for one of the user's transactions, issuing its number on first download
+func (c *InvoiceController) Download(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var transaction models.Transaction
+	if err := initializers.Db.Where("id = ? AND user_id = ?", ctx.Params("id"), user.ID).First(&transaction).Error; err != nil {
+		return ctx.Status(404).JSON(fiber.Map{"error": "Transaction not found"})
+	}
+
+	invoice, err := issueInvoice(transaction.ID)
+	if errors.Is(err, errNotInvoiceable) {
+		return ctx.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to issue invoice"})
+	}
+	return sendInvoice(ctx, invoice)
+}
+
+// sendInvoice renders the invoice as a PDF download
+func sendInvoice(ctx *fiber.Ctx, invoice *models.Invoice) error {
+	var transaction models.Transaction
+	initializers.Db.Select("status").Where("id = ?", invoice.TransactionID).First(&transaction)
+
+	ctx.Set("Content-Type", "application/pdf")
+	ctx.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, invoice.Number))
+	return ctx.Send(services.RenderInvoicePDF(invoice, transaction.Status == models.TransactionStatusRefunded))
+}
+
+// issueInvoice returns the transaction's invoice, creating it with the next number of the year if it has none.
+// Only settled transactions get a new invoice; a refunded one keeps the invoice it already had.
+func issueInvoice(transactionID string) (*models.Invoice, error) {
+	var invoice models.Invoice
+	if err := initializers.Db.Where("transaction_id = ?", transactionID).First(&invoice).Error; err == nil {
+		return &invoice, nil
+	}
+
+	err := initializers.Db.Transaction(func(tx *gorm.DB) error {
+		// The transaction row lock serialises concurrent first downloads of the same invoice
+		var transaction models.Transaction
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", transactionID).First(&transaction).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("transaction_id = ?", transactionID).First(&invoice).Error; err == nil {
+			return nil
+		}
+		if transaction.Status != models.TransactionStatusSettlement {
+			return errNotInvoiceable
+		}
+
+		now := time.Now()
+		invoice = models.Invoice{
+			ID:            generateID(),
+			TransactionID: transaction.ID,
+			UserID:        transaction.UserID,
+			Year:          now.Year(),
+			IssuedAt:      now,
+		}
+		sequence, err := nextInvoiceSequence(tx, invoice.Year)
+		if err != nil {
+			return err
+		}
+		invoice.Sequence = sequence
+		invoice.Number = fmt.Sprintf("%s-%d-%06d", GetConfigValue(models.ConfigInvoicePrefix, "INV"), invoice.Year, sequence)
+
+		if err := fillInvoice(tx, &invoice, &transaction); err != nil {
+			return err
+		}
+		return tx.Create(&invoice).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// nextInvoiceSequence takes the next invoice number of the year. The counter row stays locked
+// until tx ends, and a rolled back invoice gives its number back.
+func nextInvoiceSequence(tx *gorm.DB, year int) (int, error) {
+	counter := models.InvoiceSequence{Year: year}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&counter).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("year = ?", year).First(&counter).Error; err != nil {
+		return 0, err
+	}
+	counter.LastNumber++
+	if err := tx.Model(&models.InvoiceSequence{}).Where("year = ?", year).Update("last_number", counter.LastNumber).Error; err != nil {
+		return 0, err
+	}
+	return counter.LastNumber, nil
+}
+
+// fillInvoice copies the buyer, item, payment and company details onto the invoice
+func fillInvoice(tx *gorm.DB, invoice *models.Invoice, transaction *models.Transaction) error {
+	var user models.User
+	if err := tx.Where("id = ?", transaction.UserID).First(&user).Error; err != nil {
+		return fmt.Errorf("user not found: %w", err)
+	}
+
+	invoice.BuyerName = user.Name
+	invoice.BuyerEmail = user.Email
+	invoice.Amount = transaction.Amount
+	invoice.ListAmount = transaction.ListAmount
+	invoice.Discount = transaction.DiscountAmount
+	invoice.CouponCode = transaction.CouponCode
+	invoice.PaymentMethod = transaction.PaymentMethod
+	invoice.PaymentID = transaction.ExternalID
+	invoice.PaidAt = transaction.PaidAt
+
+	switch transaction.TxType {
+	case models.TxTypeExtraCredit:
+		invoice.ItemName = "Extra Credits"
+		if transaction.PackageID != nil {
+			var pkg models.Package
+			if err := tx.Where("id = ?", *transaction.PackageID).First(&pkg).Error; err == nil {
+				invoice.ItemName = "Extra Credits: " + pkg.PackageName
+				invoice.ItemDetail = strconv.Itoa(pkg.CreditAmount) + " credits"
+			}
+		}
+	case models.TxTypeSubscription:
+		invoice.ItemName = "Subscription"
+		if transaction.PlanID != nil {
+			var plan models.SubscriptionPlan
+			if err := tx.Where("id = ?", *transaction.PlanID).First(&plan).Error; err == nil {
+				invoice.ItemName = "Subscription: " + plan.PlanName
+				invoice.ItemDetail = fmt.Sprintf("%d days, %d daily credits, %d minute rooms",
+					plan.BillingPeriodDays, plan.DailyFreeCredits, plan.RoomDurationMinutes)
+			}
+		}
+	}
+
+	// An upgrade's credit for the unused days of the old plan is shown as its discount
+	if transaction.FromPlanID != nil && transaction.ListAmount > transaction.Amount && transaction.CouponCode == "" {
+		invoice.Discount = transaction.ListAmount - transaction.Amount
+		invoice.ItemDetail += " (credit for unused days of the previous plan applied)"
+	}
+
+	invoice.CompanyName = GetConfigValue(models.ConfigCompanyName, "Karayouke")
+	invoice.CompanyAddress = GetConfigValue(models.ConfigCompanyAddress, "")
+	invoice.CompanyTaxID = GetConfigValue(models.ConfigCompanyTaxID, "")
+	invoice.CompanyEmail = GetConfigValue(models.ConfigCompanyEmail, "")
+	return nil
+}
+
+// regenerateInvoice refreshes the invoice's details from the transaction, user and company settings,
+// keeping its number. A settled transaction without an invoice gets one issued.
+func regenerateInvoice(transactionID string, adminID string) (*models.Invoice, error) {
+	var invoice models.Invoice
+	if err := initializers.Db.Where("transaction_id = ?", transactionID).First(&invoice).Error; err != nil {
+		return issueInvoice(transactionID)
+	}
+
+	err := initializers.Db.Transaction(func(tx *gorm.DB) error {
+		var transaction models.Transaction
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", transactionID).First(&transaction).Error; err != nil {
+			return err
+		}
+		if err := fillInvoice(tx, &invoice, &transaction); err != nil {
+			return err
+		}
+		now := time.Now()
+		invoice.RegeneratedAt = &now
+		invoice.RegeneratedBy = &adminID
+		return tx.Save(&invoice).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
+)
+
+// ledgerTestUser creates a user with no credits, removed with its ledger rows after the test
+func ledgerTestUser(t *testing.T) *models.User {
+	t.Helper()
+	testDB(t, &models.User{}, &models.LedgerJournal{}, &models.LedgerEntry{}, &models.CreditBatch{}, &models.CreditLog{})
+	user := models.User{ID: generateID(), Name: "Ledger Test", Email: generateID() + "@example.test"}
+	if err := initializers.Db.Create(&user).Error; err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	t.Cleanup(func() {
+		db := initializers.Db
+		var journalIDs []string
+		db.Model(&models.LedgerJournal{}).Where("user_id = ?", user.ID).Pluck("id", &journalIDs)
+		db.Where("journal_id IN ?", append(journalIDs, "")).Delete(&models.LedgerEntry{})
+		db.Where("user_id = ?", user.ID).Delete(&models.LedgerJournal{})
+		db.Where("user_id = ?", user.ID).Delete(&models.CreditBatch{})
+		db.Where("user_id = ?", user.ID).Delete(&models.CreditLog{})
+		db.Delete(&user)
+	})
+	return &user
+}
+
+func postCredits(t *testing.T, posting services.CreditPosting) *services.LedgerResult {
+	t.Helper()
+	result, err := services.PostCredits(initializers.Db, posting)
+	if err != nil {
+		t.Fatalf("post %+v: %v", posting, err)
+	}
+	return result
+}
+
+func batchRemaining(t *testing.T, id string) int {
+	t.Helper()
+	var batch models.CreditBatch
+	if err := initializers.Db.Where("id = ?", id).First(&batch).Error; err != nil {
+		t.Fatalf("batch %s: %v", id, err)
+	}
+	return batch.Remaining
+}
+
+func TestLedgerIdempotencyKeyReplay(t *testing.T) {
+	user := ledgerTestUser(t)
+	posting := services.CreditPosting{UserID: user.ID, Type: models.CreditTypePurchase, ReferenceID: "tx-1", Extra: 10, IdempotencyKey: "grant:" + user.ID}
+
+	first := postCredits(t, posting)
+	replay := postCredits(t, posting)
+	if first.Duplicate || !replay.Duplicate || replay.Journal == nil || replay.Journal.ID != first.Journal.ID {
+		t.Fatalf("first %+v, replay %+v", first, replay)
+	}
+	if replay.Credit != 10 {
+		t.Fatalf("credit after a replay = %d, want 10", replay.Credit)
+	}
+
+	var journals, batches, logs int64
+	initializers.Db.Model(&models.LedgerJournal{}).Where("user_id = ?", user.ID).Count(&journals)
+	initializers.Db.Model(&models.CreditBatch{}).Where("user_id = ?", user.ID).Count(&batches)
+	initializers.Db.Model(&models.CreditLog{}).Where("user_id = ?", user.ID).Count(&logs)
+	if journals != 1 || batches != 1 || logs != 1 {
+		t.Fatalf("replay wrote again: %d journals, %d batches, %d logs", journals, batches, logs)
+	}
+}
+
+func TestLedgerSpendsFreeCreditsFirst(t *testing.T) {
+	user := ledgerTestUser(t)
+	postCredits(t, services.CreditPosting{UserID: user.ID, Type: models.CreditTypeFreeReset, Free: 3, NoHistory: true})
+	postCredits(t, services.CreditPosting{UserID: user.ID, Type: models.CreditTypePurchase, ReferenceID: "tx-1", Extra: 10})
+
+	result := postCredits(t, services.CreditPosting{UserID: user.ID, Type: models.CreditTypeRoomCreation, Spend: 5})
+	if result.FreeCredit != 0 || result.Credit != 8 {
+		t.Fatalf("after spending 5 of 3 free + 10 extra: free %d, extra %d", result.FreeCredit, result.Credit)
+	}
+	result = postCredits(t, services.CreditPosting{UserID: user.ID, Type: models.CreditTypeRoomCreation, Spend: 2})
+	if result.FreeCredit != 0 || result.Credit != 6 {
+		t.Fatalf("after spending 2 more: free %d, extra %d", result.FreeCredit, result.Credit)
+	}
+
+	if _, err := services.PostCredits(initializers.Db, services.CreditPosting{UserID: user.ID, Type: models.CreditTypeRoomCreation, Spend: 7}); !errors.Is(err, services.ErrInsufficientCredits) {
+		t.Fatalf("overspend: %v", err)
+	}
+}
+
+func TestLedgerConsumesBatchesByExpiry(t *testing.T) {
+	user := ledgerTestUser(t)
+	soon, later := time.Now().Add(24*time.Hour), time.Now().Add(10*24*time.Hour)
+
+	// Granted out of expiry order: the later batch first, then one that never expires, then the soonest
+	postCredits(t, services.CreditPosting{UserID: user.ID, Type: models.CreditTypePurchase, ReferenceID: "later", Extra: 6, ExpiresAt: &later})
+	postCredits(t, services.CreditPosting{UserID: user.ID, Type: models.CreditTypePurchase, ReferenceID: "never", Extra: 5})
+	postCredits(t, services.CreditPosting{UserID: user.ID, Type: models.CreditTypePurchase, ReferenceID: "soon", Extra: 4, ExpiresAt: &soon})
+	batch := map[string]string{}
+	var batches []models.CreditBatch
+	initializers.Db.Where("user_id = ?", user.ID).Find(&batches)
+	for _, b := range batches {
+		batch[b.ReferenceID] = b.ID
+	}
+
+	// A spend drains the batch that expires first, then the next
+	postCredits(t, services.CreditPosting{UserID: user.ID, Type: models.CreditTypeRoomCreation, Spend: 7})
+	if soonLeft, laterLeft, neverLeft := batchRemaining(t, batch["soon"]), batchRemaining(t, batch["later"]), batchRemaining(t, batch["never"]); soonLeft != 0 || laterLeft != 3 || neverLeft != 5 {
+		t.Fatalf("after spending 7: soon %d, later %d, never %d; want 0, 3, 5", soonLeft, laterLeft, neverLeft)
+	}
+
+	// A loss naming a purchase comes out of that purchase's batch first
+	postCredits(t, services.CreditPosting{UserID: user.ID, Type: models.CreditTypeRefund, ReferenceID: "never", Extra: -2})
+	if laterLeft, neverLeft := batchRemaining(t, batch["later"]), batchRemaining(t, batch["never"]); laterLeft != 3 || neverLeft != 3 {
+		t.Fatalf("after refunding 2 of the never-expiring purchase: later %d, never %d; want 3, 3", laterLeft, neverLeft)
+	}
+}
+
+func TestReconcileLedgerFindsDrift(t *testing.T) {
+	user := ledgerTestUser(t)
+	postCredits(t, services.CreditPosting{UserID: user.ID, Type: models.CreditTypePurchase, ReferenceID: "tx-1", Extra: 10})
+	postCredits(t, services.CreditPosting{UserID: user.ID, Type: models.CreditTypeRoomCreation, Spend: 4})
+
+	mismatchOf := func(report *services.ReconcileReport) *services.BalanceMismatch {
+		for i := range report.Mismatches {
+			if report.Mismatches[i].UserID == user.ID {
+				return &report.Mismatches[i]
+			}
+		}
+		return nil
+	}
+	report, err := services.ReconcileLedger(initializers.Db)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if mismatch := mismatchOf(report); mismatch != nil {
+		t.Fatalf("balances posted through the ledger drifted: %+v", mismatch)
+	}
+
+	// A balance written behind the ledger's back is reported with both sides
+	initializers.Db.Model(&models.User{}).Where("id = ?", user.ID).Update("credit", 9)
+	if report, err = services.ReconcileLedger(initializers.Db); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	mismatch := mismatchOf(report)
+	if mismatch == nil || mismatch.Credit != 9 || mismatch.LedgerExtra != 6 || report.OK() {
+		t.Fatalf("drift not reported: %+v", mismatch)
+	}
+}
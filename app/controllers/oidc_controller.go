@@ -0,0 +1,405 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// OIDCController handles social login through OpenID Connect providers
+type OIDCController struct{}
+
+// Pending authorization requests expire after 10 minutes
+const oauthStateDuration = 10 * time.Minute
+
+// The state is also kept in a cookie scoped to the OIDC routes, so a callback only completes in the
+// browser that started it; a state lifted from someone else's authorization URL is refused
+const (
+	oauthStateCookie     = "oidc_state"
+	oauthStateCookiePath = "/api/auth/oidc"
+)
+
+// A link ticket only has to survive the hop from the link request to the browser navigation
+const oidcLinkTicketDuration = time.Minute
+
+var usernameUnsafeChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// ListProviders returns the names of the configured OIDC providers
+func (c *OIDCController) ListProviders(ctx *fiber.Ctx) error {
+	names := []string{}
+	for name := range services.OIDCProviders() {
+		names = append(names, name)
+	}
+	return ctx.JSON(fiber.Map{"providers": names})
+}
+
+// startAuthorization stores a pending state, binds it to the browser and returns the provider's authorization URL
+func startAuthorization(ctx *fiber.Ctx, provider *services.OIDCProvider, linkUserID *string) (string, error) {
+	state := generateToken()
+	nonce := generateToken()
+	verifier := generateToken()
+
+	// Drop abandoned attempts
+	initializers.Db.Where("expires_at < ?", time.Now()).Delete(&models.OAuthState{})
+
+	pending := models.OAuthState{
+		ID:           generateID(),
+		StateHash:    hashToken(state),
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(oauthStateDuration),
+	}
+	if err := initializers.Db.Create(&pending).Error; err != nil {
+		return "", err
+	}
+	authURL, err := provider.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		return "", err
+	}
+	setStateCookie(ctx, state, pending.ExpiresAt)
+	return authURL, nil
+}
+
+// setStateCookie stores the state for the callback; a zero expiry clears it
+func setStateCookie(ctx *fiber.Ctx, state string, expiresAt time.Time) {
+	if expiresAt.IsZero() {
+		expiresAt = time.Unix(0, 0)
+	}
+	ctx.Cookie(&fiber.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     oauthStateCookiePath,
+		Expires:  expiresAt,
+		HTTPOnly: true,
+		Secure:   ctx.Protocol() == "https",
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+// Login redirects the browser to the provider's consent screen
+func (c *OIDCController) Login(ctx *fiber.Ctx) error {
+	provider, ok := services.GetOIDCProvider(ctx.Params("provider"))
+	if !ok {
+		return ctx.Status(404).JSON(fiber.Map{"error": "Unknown login provider"})
+	}
+
+	authURL, err := startAuthorization(ctx, provider, nil)
+	if err != nil {
+		log.Printf("[oidc] %s: failed to start login: %v", provider.Name, err)
+		return ctx.Status(502).JSON(fiber.Map{"error": "Login provider is unavailable"})
+	}
+	return ctx.Redirect(authURL, fiber.StatusFound)
+}
+
+// Link hands the signed-in user a one-time link ticket. The frontend opens the returned URL on
+// the API origin as a top-level navigation, so the state cookie is set by a normal page load
+// rather than by a cross-origin XHR the browser may not keep cookies for.
+func (c *OIDCController) Link(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	provider, ok := services.GetOIDCProvider(ctx.Params("provider"))
+	if !ok {
+		return ctx.Status(404).JSON(fiber.Map{"error": "Unknown login provider"})
+	}
+
+	ticket, err := issueAccountToken(user.ID, models.AccountTokenOIDCLink, provider.Name, oidcLinkTicketDuration)
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to start linking"})
+	}
+	return ctx.JSON(fiber.Map{
+		"link_url":   "/api/auth/oidc/" + url.PathEscape(provider.Name) + "/link/start?ticket=" + url.QueryEscape(ticket),
+		"expires_in": int(oidcLinkTicketDuration.Seconds()),
+	})
+}
+
+// StartLink spends a link ticket, binds the state to this browser and redirects to the provider
+func (c *OIDCController) StartLink(ctx *fiber.Ctx) error {
+	provider, ok := services.GetOIDCProvider(ctx.Params("provider"))
+	if !ok {
+		return redirectToFrontend(ctx, "/account?error=unknown_provider")
+	}
+	ticket, ok := consumeAccountToken(ctx.Query("ticket"), models.AccountTokenOIDCLink)
+	if !ok || ticket.Payload != provider.Name {
+		return redirectToFrontend(ctx, "/account?error=invalid_link_ticket")
+	}
+
+	authURL, err := startAuthorization(ctx, provider, &ticket.UserID)
+	if err != nil {
+		log.Printf("[oidc] %s: failed to start link: %v", provider.Name, err)
+		return redirectToFrontend(ctx, "/account?error=provider_unavailable")
+	}
+	return ctx.Redirect(authURL, fiber.StatusFound)
+}
+
+// redirectToFrontend sends the browser to a frontend page; without APP_URL it stays on this host
+func redirectToFrontend(ctx *fiber.Ctx, path string) error {
+	target, _ := frontendURL(path)
+	return ctx.Redirect(target, fiber.StatusFound)
+}
+
+// Callback completes the authorization code flow, then signs the user in (or links the account)
+// and redirects back to the frontend
+func (c *OIDCController) Callback(ctx *fiber.Ctx) error {
+	fail := func(reason string) error {
+		return redirectToFrontend(ctx, "/login?error="+url.QueryEscape(reason))
+	}
+
+	provider, ok := services.GetOIDCProvider(ctx.Params("provider"))
+	if !ok {
+		return fail("unknown_provider")
+	}
+	if errParam := ctx.Query("error"); errParam != "" {
+		return fail(errParam)
+	}
+
+	// The state must come back to the browser that started the flow
+	state := ctx.Query("state")
+	cookieState := ctx.Cookies(oauthStateCookie)
+	setStateCookie(ctx, "", time.Time{})
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookieState)) != 1 {
+		return fail("invalid_state")
+	}
+
+	// The state is single-use: delete it before doing anything else
+	var pending models.OAuthState
+	if err := initializers.Db.Where("state_hash = ? AND provider = ? AND expires_at > ?", hashToken(state), provider.Name, time.Now()).First(&pending).Error; err != nil {
+		return fail("invalid_state")
+	}
+	if result := initializers.Db.Delete(&pending); result.Error != nil || result.RowsAffected != 1 {
+		return fail("invalid_state")
+	}
+
+	rawIDToken, err := provider.Exchange(ctx.Query("code"), pending.CodeVerifier)
+	if err != nil {
+		log.Printf("[oidc] %s: code exchange failed: %v", provider.Name, err)
+		return fail("exchange_failed")
+	}
+	claims, err := provider.VerifyIDToken(rawIDToken, pending.Nonce)
+	if err != nil {
+		log.Printf("[oidc] %s: id token rejected: %v", provider.Name, err)
+		return fail("invalid_id_token")
+	}
+
+	var identity models.UserIdentity
+	hasIdentity := initializers.Db.Where("provider = ? AND subject = ?", provider.Name, claims.Subject).First(&identity).Error == nil
+
+	// Linking to the signed-in account
+	if pending.LinkUserID != nil {
+		if hasIdentity && identity.UserID != *pending.LinkUserID {
+			return fail("identity_in_use")
+		}
+		if !hasIdentity {
+			if err := createIdentity(*pending.LinkUserID, provider.Name, claims); err != nil {
+				return fail("link_failed")
+			}
+		}
+		return redirectToFrontend(ctx, "/account?linked="+url.QueryEscape(provider.Name))
+	}
+
+	user, reason := resolveIdentityUser(provider.Name, claims, hasIdentity, &identity)
+	if user == nil {
+		return fail(reason)
+	}
+
+	now := time.Now()
+	initializers.Db.Model(&models.UserIdentity{}).
+		Where("provider = ? AND subject = ?", provider.Name, claims.Subject).
+		Update("last_login_at", now)
+
+	// Two-factor accounts finish at the frontend's code prompt
+	if twoFactorEnabled(user.ID) {
+		challenge, err := issueAccountToken(user.ID, models.AccountTokenLoginChallenge, "", loginChallengeDuration)
+		if err != nil {
+			return fail("session_failed")
+		}
+		return redirectToFrontend(ctx, "/auth/callback#two_factor_required=1&challenge_token="+challenge)
+	}
+
+	resp, err := signIn(ctx, user.ID, false)
+	if err != nil {
+		return fail("session_failed")
+	}
+	// Tokens go in the fragment so they never reach server logs
+	fragment := "token=" + url.QueryEscape(resp.Token)
+	if resp.RefreshToken != "" {
+		fragment += "&refresh_token=" + url.QueryEscape(resp.RefreshToken) + "&expires_in=" + strconv.Itoa(resp.ExpiresIn)
+	}
+	return redirectToFrontend(ctx, "/auth/callback#"+fragment)
+}
+
+// resolveIdentityUser finds the user for a provider login: an existing link, an account with the same
+// verified email, or a newly created account
+func resolveIdentityUser(provider string, claims *services.IDTokenClaims, hasIdentity bool, identity *models.UserIdentity) (*models.User, string) {
+	var user models.User
+	if hasIdentity {
+		if err := initializers.Db.Where("id = ?", identity.UserID).First(&user).Error; err != nil {
+			return nil, "account_not_found"
+		}
+		return &user, ""
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, "email_not_verified"
+	}
+
+	now := time.Now()
+	if err := initializers.Db.Where("LOWER(email) = ?", strings.ToLower(claims.Email)).First(&user).Error; err != nil {
+		// New account - the provider already verified the email
+		name := claims.Name
+		if name == "" {
+			name = strings.Split(claims.Email, "@")[0]
+		}
+		user = models.User{
+			ID:              generateID(),
+			Name:            name,
+			Username:        uniqueUsername(claims.Email),
+			Email:           claims.Email,
+			EmailVerifiedAt: &now,
+		}
+		if err := initializers.Db.Create(&user).Error; err != nil {
+			return nil, "account_creation_failed"
+		}
+	} else if !user.IsEmailVerified() {
+		// The provider proved ownership of the address; whoever registered it never did
+		if err := claimUnverifiedAccount(&user, now); err != nil {
+			log.Printf("[oidc] %s: failed to claim unverified account %s: %v", provider, user.ID, err)
+			return nil, "link_failed"
+		}
+		rewardReferralAfterVerification(user.ID)
+	}
+
+	if err := createIdentity(user.ID, provider, claims); err != nil {
+		return nil, "link_failed"
+	}
+	return &user, ""
+}
+
+// claimUnverifiedAccount hands an account registered with an unproven email to the address owner.
+// Anyone could have registered it first, so the password and every session, key, second factor and
+// linked provider they set up are dropped before the account is marked verified.
+func claimUnverifiedAccount(user *models.User, now time.Time) error {
+	err := initializers.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"password":          "",
+			"email_verified_at": now,
+		}).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{
+			&models.RefreshToken{},
+			&models.Session{},
+			&models.AccountToken{},
+			&models.UserIdentity{},
+			&models.TwoFactorAuth{},
+			&models.RecoveryCode{},
+			&models.TVDevice{},
+			&models.APIKey{},
+		} {
+			if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	forgetUserAccessSessions(user.ID)
+	user.Password = ""
+	user.EmailVerifiedAt = &now
+	return nil
+}
+
+func createIdentity(userID string, provider string, claims *services.IDTokenClaims) error {
+	identity := models.UserIdentity{
+		ID:       generateID(),
+		UserID:   userID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+	return initializers.Db.Create(&identity).Error
+}
+
+// uniqueUsername derives an unused username from an email address
+func uniqueUsername(email string) string {
+	base := usernameUnsafeChars.ReplaceAllString(strings.ToLower(strings.Split(email, "@")[0]), "")
+	if base == "" {
+		base = "singer"
+	}
+	candidate := base
+	for i := 0; i < 10; i++ {
+		var count int64
+		initializers.Db.Model(&models.User{}).Where("username = ?", candidate).Count(&count)
+		if count == 0 {
+			return candidate
+		}
+		candidate = base + "_" + generateID()[:4]
+	}
+	return base + "_" + generateID()[:8]
+}
+
+// ListIdentities returns the provider accounts linked to the signed-in user
+func (c *OIDCController) ListIdentities(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var identities []models.UserIdentity
+	if err := initializers.Db.Where("user_id = ?", user.ID).Order("created_at ASC").Find(&identities).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to fetch linked accounts"})
+	}
+	return ctx.JSON(fiber.Map{
+		"identities":   identities,
+		"has_password": user.Password != "",
+	})
+}
+
+// Unlink removes a provider account, as long as the user keeps another way to sign in
+func (c *OIDCController) Unlink(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	provider := strings.ToLower(ctx.Params("provider"))
+
+	var identities []models.UserIdentity
+	initializers.Db.Where("user_id = ?", user.ID).Find(&identities)
+
+	remaining := 0
+	found := false
+	for _, identity := range identities {
+		if identity.Provider == provider {
+			found = true
+		} else {
+			remaining++
+		}
+	}
+	if !found {
+		return ctx.Status(404).JSON(fiber.Map{"error": "Provider is not linked"})
+	}
+	if user.Password == "" && remaining == 0 {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Set a password or link another provider before unlinking the last one"})
+	}
+
+	if err := initializers.Db.Where("user_id = ? AND provider = ?", user.ID, provider).Delete(&models.UserIdentity{}).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to unlink provider"})
+	}
+	return ctx.JSON(fiber.Map{"success": true})
+}
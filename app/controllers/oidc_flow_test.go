@@ -0,0 +1,314 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
+	"GoFiberMVC/app/services/oidctest"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const testFrontend = "http://front.test"
+
+// oidcTestServer registers a stand-in provider under name for the duration of the test
+func oidcTestServer(t *testing.T, name string) *oidctest.Server {
+	t.Helper()
+	server := oidctest.NewServer("client-" + name)
+	t.Cleanup(server.Close)
+	services.RegisterOIDCProvider(server.Provider(name))
+	t.Setenv("APP_URL", testFrontend)
+	return server
+}
+
+// oidcTestApp serves the OIDC routes; signed-in routes act as user
+func oidcTestApp(user *models.User) *fiber.App {
+	oidc := &OIDCController{}
+	asUser := func(handler fiber.Handler) fiber.Handler {
+		return func(ctx *fiber.Ctx) error {
+			ctx.Locals(userLocalsKey, user)
+			return handler(ctx)
+		}
+	}
+	app := fiber.New()
+	app.Get("/api/auth/oidc/:provider/login", oidc.Login)
+	app.Get("/api/auth/oidc/:provider/callback", oidc.Callback)
+	app.Post("/api/auth/oidc/:provider/link", asUser(oidc.Link))
+	app.Get("/api/auth/oidc/:provider/link/start", oidc.StartLink)
+	app.Delete("/api/auth/identities/:provider", asUser(oidc.Unlink))
+	return app
+}
+
+func oidcRequest(t *testing.T, app *fiber.App, method string, path string, stateCookie string) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	if stateCookie != "" {
+		req.AddCookie(&http.Cookie{Name: oauthStateCookie, Value: stateCookie})
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return resp
+}
+
+func stateCookieValue(resp *http.Response) string {
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == oauthStateCookie {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// oidcLogin starts a login and returns the authorization URL and the state cookie it set
+func oidcLogin(t *testing.T, app *fiber.App, provider string) (string, string) {
+	t.Helper()
+	resp := oidcRequest(t, app, "GET", "/api/auth/oidc/"+provider+"/login", "")
+	if resp.StatusCode != fiber.StatusFound {
+		t.Fatalf("login: status %d", resp.StatusCode)
+	}
+	return resp.Header.Get("Location"), stateCookieValue(resp)
+}
+
+// oidcCallback returns where the callback redirects the browser
+func oidcCallback(t *testing.T, app *fiber.App, provider string, code string, state string, stateCookie string) string {
+	t.Helper()
+	query := url.Values{"code": {code}, "state": {state}}
+	resp := oidcRequest(t, app, "GET", "/api/auth/oidc/"+provider+"/callback?"+query.Encode(), stateCookie)
+	if resp.StatusCode != fiber.StatusFound {
+		t.Fatalf("callback: status %d", resp.StatusCode)
+	}
+	return resp.Header.Get("Location")
+}
+
+func oidcTestDB(t *testing.T) {
+	t.Helper()
+	testDB(t,
+		&models.User{},
+		&models.UserIdentity{},
+		&models.OAuthState{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.AccountToken{},
+		&models.TwoFactorAuth{},
+		&models.RecoveryCode{},
+		&models.TVDevice{},
+		&models.APIKey{},
+		&models.Referral{},
+		&models.CreditLog{},
+		&models.LedgerJournal{},
+		&models.LedgerEntry{},
+		&models.CreditBatch{},
+	)
+}
+
+// oidcTestUser creates a user that is removed, with its identities and sessions, after the test
+func oidcTestUser(t *testing.T, user models.User) *models.User {
+	t.Helper()
+	user.ID = generateID()
+	if err := initializers.Db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() { cleanupOIDCUser(user.ID) })
+	return &user
+}
+
+func cleanupOIDCUser(userID string) {
+	for _, model := range []interface{}{&models.UserIdentity{}, &models.Session{}, &models.RefreshToken{}, &models.APIKey{}} {
+		initializers.Db.Where("user_id = ?", userID).Delete(model)
+	}
+	initializers.Db.Where("id = ?", userID).Delete(&models.User{})
+}
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	oidcTestServer(t, "csrf")
+	app := oidcTestApp(nil)
+
+	// A state lifted from someone else's authorization URL fails before it is looked up
+	for name, cookie := range map[string]string{"no cookie": "", "other browser": "someone-elses-state"} {
+		location := oidcCallback(t, app, "csrf", "code", "attacker-state", cookie)
+		if location != testFrontend+"/login?error=invalid_state" {
+			t.Errorf("%s: redirected to %q", name, location)
+		}
+	}
+}
+
+func TestOIDCLoginCreatesVerifiedAccount(t *testing.T) {
+	oidcTestDB(t)
+	server := oidcTestServer(t, "standin")
+	app := oidcTestApp(nil)
+	email := generateID() + "@example.test"
+
+	authURL, cookie := oidcLogin(t, app, "standin")
+	code, state, err := server.Authorize(authURL, map[string]interface{}{"sub": "new-" + email, "email": email, "email_verified": true, "name": "New Singer"})
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	if cookie != state {
+		t.Fatalf("state cookie %q does not carry the state %q", cookie, state)
+	}
+
+	location := oidcCallback(t, app, "standin", code, state, cookie)
+	if !strings.HasPrefix(location, testFrontend+"/auth/callback#token=") {
+		t.Fatalf("callback redirected to %q", location)
+	}
+	var user models.User
+	if err := initializers.Db.Where("email = ?", email).First(&user).Error; err != nil {
+		t.Fatalf("account not created: %v", err)
+	}
+	t.Cleanup(func() { cleanupOIDCUser(user.ID) })
+	if !user.IsEmailVerified() || user.Name != "New Singer" {
+		t.Fatalf("created user = %+v", user)
+	}
+
+	// The state is single-use
+	if location := oidcCallback(t, app, "standin", code, state, cookie); location != testFrontend+"/login?error=invalid_state" {
+		t.Fatalf("replayed callback redirected to %q", location)
+	}
+}
+
+func TestOIDCCallbackRejectsBadNonceAndVerifier(t *testing.T) {
+	oidcTestDB(t)
+	server := oidcTestServer(t, "standin")
+	app := oidcTestApp(nil)
+	claims := map[string]interface{}{"sub": "nonce-test", "email": generateID() + "@example.test", "email_verified": true}
+
+	// An ID token minted for another authorization request
+	authURL, cookie := oidcLogin(t, app, "standin")
+	replayed := map[string]interface{}{"nonce": "nonce-of-another-request"}
+	for key, value := range claims {
+		replayed[key] = value
+	}
+	code, state, _ := server.Authorize(authURL, replayed)
+	if location := oidcCallback(t, app, "standin", code, state, cookie); location != testFrontend+"/login?error=invalid_id_token" {
+		t.Fatalf("nonce mismatch redirected to %q", location)
+	}
+
+	// A code redeemed without the verifier that produced its challenge
+	authURL, cookie = oidcLogin(t, app, "standin")
+	code, state, _ = server.Authorize(authURL, claims)
+	initializers.Db.Model(&models.OAuthState{}).Where("state_hash = ?", hashToken(state)).Update("code_verifier", generateToken())
+	if location := oidcCallback(t, app, "standin", code, state, cookie); location != testFrontend+"/login?error=exchange_failed" {
+		t.Fatalf("PKCE mismatch redirected to %q", location)
+	}
+}
+
+func TestOIDCLoginClaimsUnverifiedAccount(t *testing.T) {
+	oidcTestDB(t)
+	server := oidcTestServer(t, "standin")
+	app := oidcTestApp(nil)
+
+	// Someone registered the address first, without ever proving they own it
+	squatter := oidcTestUser(t, models.User{Name: "Squatter", Email: generateID() + "@example.test", Password: "squatter-password-hash"})
+	session := models.Session{ID: generateID(), TokenHash: hashToken(generateToken()), UserID: squatter.ID, ExpiresAt: time.Now().Add(time.Hour), MaxExpiresAt: time.Now().Add(time.Hour)}
+	apiKey := models.APIKey{ID: generateID(), UserID: squatter.ID, Name: "squatter", KeyHash: hashToken(generateToken())}
+	squatterIdentity := models.UserIdentity{ID: generateID(), UserID: squatter.ID, Provider: "elsewhere", Subject: generateID()}
+	for _, row := range []interface{}{&session, &apiKey, &squatterIdentity} {
+		if err := initializers.Db.Create(row).Error; err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	authURL, cookie := oidcLogin(t, app, "standin")
+	code, state, _ := server.Authorize(authURL, map[string]interface{}{"sub": "owner-" + squatter.ID, "email": strings.ToUpper(squatter.Email), "email_verified": true})
+	if location := oidcCallback(t, app, "standin", code, state, cookie); !strings.HasPrefix(location, testFrontend+"/auth/callback#token=") {
+		t.Fatalf("callback redirected to %q", location)
+	}
+
+	var owner models.User
+	initializers.Db.Where("id = ?", squatter.ID).First(&owner)
+	if owner.Password != "" || !owner.IsEmailVerified() {
+		t.Fatalf("claimed account kept password %q, verified %v", owner.Password, owner.IsEmailVerified())
+	}
+	var count int64
+	initializers.Db.Model(&models.Session{}).Where("id = ?", session.ID).Count(&count)
+	if count != 0 {
+		t.Error("the squatter's session survived")
+	}
+	initializers.Db.Model(&models.APIKey{}).Where("id = ?", apiKey.ID).Count(&count)
+	if count != 0 {
+		t.Error("the squatter's API key survived")
+	}
+	initializers.Db.Model(&models.UserIdentity{}).Where("user_id = ?", owner.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("claimed account has %d linked providers, want only the one just used", count)
+	}
+}
+
+func TestOIDCLinkAndUnlink(t *testing.T) {
+	oidcTestDB(t)
+	server := oidcTestServer(t, "standin")
+	user := oidcTestUser(t, models.User{Name: "Linker", Email: generateID() + "@example.test", Password: "password-hash"})
+	app := oidcTestApp(user)
+	subject := "link-" + user.ID
+
+	// The signed-in request only returns a ticket; the browser then navigates to it, picking up
+	// the state cookie from that page load, and carries the cookie back to the callback
+	linkTicketURL := func(app *fiber.App) string {
+		resp := oidcRequest(t, app, "POST", "/api/auth/oidc/standin/link", "")
+		var body struct {
+			LinkURL string `json:"link_url"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		if stateCookieValue(resp) != "" || body.LinkURL == "" {
+			t.Fatalf("link request: cookie %q, link url %q", stateCookieValue(resp), body.LinkURL)
+		}
+		return body.LinkURL
+	}
+	link := func(app *fiber.App) string {
+		start := oidcRequest(t, app, "GET", linkTicketURL(app), "")
+		if start.StatusCode != fiber.StatusFound {
+			t.Fatalf("link start: status %d", start.StatusCode)
+		}
+		code, state, err := server.Authorize(start.Header.Get("Location"), map[string]interface{}{"sub": subject, "email": "other@example.test", "email_verified": false})
+		if err != nil {
+			t.Fatalf("authorize: %v", err)
+		}
+		return oidcCallback(t, app, "standin", code, state, stateCookieValue(start))
+	}
+
+	// A ticket works once
+	ticketURL := linkTicketURL(app)
+	oidcRequest(t, app, "GET", ticketURL, "")
+	if location := oidcRequest(t, app, "GET", ticketURL, "").Header.Get("Location"); location != testFrontend+"/account?error=invalid_link_ticket" {
+		t.Fatalf("reused ticket redirected to %q", location)
+	}
+
+	if location := link(app); location != testFrontend+"/account?linked=standin" {
+		t.Fatalf("link redirected to %q", location)
+	}
+	var identity models.UserIdentity
+	if err := initializers.Db.Where("provider = ? AND subject = ?", "standin", subject).First(&identity).Error; err != nil || identity.UserID != user.ID {
+		t.Fatalf("identity not linked to the user: %+v, %v", identity, err)
+	}
+
+	// The provider account can't be attached to a second user
+	other := oidcTestUser(t, models.User{Name: "Other", Email: generateID() + "@example.test", Password: "password-hash"})
+	if location := link(oidcTestApp(other)); location != testFrontend+"/login?error=identity_in_use" {
+		t.Fatalf("second link redirected to %q", location)
+	}
+
+	// Without a password the last provider can't be unlinked
+	initializers.Db.Model(&models.User{}).Where("id = ?", user.ID).Update("password", "")
+	user.Password = ""
+	if resp := oidcRequest(t, app, "DELETE", "/api/auth/identities/standin", ""); resp.StatusCode != 400 {
+		t.Fatalf("unlinking the only sign-in method: status %d", resp.StatusCode)
+	}
+
+	user.Password = "password-hash"
+	if resp := oidcRequest(t, app, "DELETE", "/api/auth/identities/standin", ""); resp.StatusCode != 200 {
+		t.Fatalf("unlink: status %d", resp.StatusCode)
+	}
+	if resp := oidcRequest(t, app, "DELETE", "/api/auth/identities/standin", ""); resp.StatusCode != 404 {
+		t.Fatalf("unlinking twice: status %d", resp.StatusCode)
+	}
+}
@@ -3,12 +3,18 @@ package controllers
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
 	"strconv"
+	"sync"
+	"time"
 
 	"GoFiberMVC/app/initializers"
 	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type PackageController struct{}
@@ -84,6 +90,7 @@ func (c *PackageController) ListPublic(ctx *fiber.Ctx) error {
 		PackageDetail string `json:"package_detail"`
 		Price         int64  `json:"price"` // IDR
 		CreditAmount  int    `json:"credit_amount"`
+		ValidityDays  int    `json:"validity_days"` // 0 = credits never expire
 	}
 
 	response := make([]PackageResponse, len(packages))
@@ -94,6 +101,7 @@ func (c *PackageController) ListPublic(ctx *fiber.Ctx) error {
 			PackageDetail: string(pkg.PackageDetail),
 			Price:         pkg.Price,
 			CreditAmount:  pkg.CreditAmount,
+			ValidityDays:  pkg.ValidityDays,
 		}
 	}
 
@@ -211,18 +219,22 @@ func (c *PackageController) GetTransaction(ctx *fiber.Ctx) error {
 	}
 
 	return ctx.JSON(fiber.Map{
-		"id":                transaction.ID,
-		"item_name":         itemName,
-		"credit_amount":     creditAmt,
-		"amount":            transaction.Amount,
-		"status":            transaction.Status,
-		"payment_method":    transaction.PaymentMethod,
-		"tx_type":           transaction.TxType,
-		"external_id":       transaction.ExternalID,
-		"flip_url":          transaction.FlipURL,
-		"flip_company_code": transaction.FlipCompanyCode,
-		"flip_product_code": transaction.FlipProductCode,
-		"created_at":        transaction.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		"id":             transaction.ID,
+		"item_name":      itemName,
+		"credit_amount":  creditAmt,
+		"amount":         transaction.Amount,
+		"list_amount":    transaction.ListAmount,
+		"discount":       transaction.DiscountAmount,
+		"coupon_code":    transaction.CouponCode,
+		"proration":      transaction.ProrationCredit,
+		"status":         transaction.Status,
+		"payment_method": transaction.PaymentMethod,
+		"tx_type":        transaction.TxType,
+		"external_id":    transaction.ExternalID,
+		"provider":       transaction.Provider,
+		"payment_url":    transaction.PaymentURL,
+		"provider_data":  transaction.ProviderData,
+		"created_at":     transaction.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
 	})
 }
 
@@ -249,6 +261,8 @@ func (c *PackageController) GetMyCredits(ctx *fiber.Ctx) error {
 				"daily_free_credits":    plan.DailyFreeCredits,
 				"room_duration_minutes": plan.RoomDurationMinutes,
 				"expires_at":            user.SubscriptionExpiresAt.Format("2006-01-02T15:04:05Z07:00"),
+				"auto_renew":            user.AutoRenew,
+				"grace_until":           user.SubscriptionGraceUntil,
 			}
 		}
 	}
@@ -256,6 +270,21 @@ func (c *PackageController) GetMyCredits(ctx *fiber.Ctx) error {
 	var creditLogs []models.CreditLog
 	initializers.Db.Where("user_id = ?", user.ID).Order("created_at DESC").Limit(50).Find(&creditLogs)
 
+	// Extra credits that will expire, soonest first
+	var batches []models.CreditBatch
+	initializers.Db.Where("user_id = ? AND remaining > 0 AND expires_at IS NOT NULL AND expired_at IS NULL", user.ID).
+		Order("expires_at ASC").
+		Limit(20).
+		Find(&batches)
+	expiring := make([]fiber.Map, 0, len(batches))
+	for _, batch := range batches {
+		expiring = append(expiring, fiber.Map{
+			"amount":     batch.Remaining,
+			"source":     batch.Source,
+			"expires_at": batch.ExpiresAt.Format("2006-01-02T15:04:05Z07:00"),
+		})
+	}
+
 	return ctx.JSON(fiber.Map{
 		"extra_credit":  user.Credit,
 		"free_credit":   user.FreeCredit,
@@ -263,19 +292,111 @@ func (c *PackageController) GetMyCredits(ctx *fiber.Ctx) error {
 		"subscription":  subscriptionInfo,
 		"room_duration": GetUserRoomDuration(user),
 		"history":       creditLogs,
+		"expiring":      expiring,
 	})
 }
 
 // ========================================
-// Helpers
+// Credit Expiry
 // ========================================
 
-func generateCreditLogID() string {
-	bytes := make([]byte, 16)
-	rand.Read(bytes)
-	return hex.EncodeToString(bytes)
+// creditExpiryBatchSize caps the batches expired per run; the rest are picked up by the next run
+const creditExpiryBatchSize = 200
+
+// creditExpiryMu keeps expiry runs from overlapping
+var creditExpiryMu sync.Mutex
+
+// StartCreditExpiry removes the leftovers of expired credit batches every interval until the process
+// exits. A non-positive interval disables the worker.
+func StartCreditExpiry(interval time.Duration) {
+	if interval <= 0 {
+		fmt.Println("[Credits] Credit expiry worker disabled")
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			expired, err := ExpireCreditBatches()
+			if err != nil {
+				fmt.Printf("[Credits] Expiry run failed: %v\n", err)
+				continue
+			}
+			if expired > 0 {
+				fmt.Printf("[Credits] Expired %d credit batches\n", expired)
+			}
+		}
+	}()
+}
+
+// ExpireCreditBatches takes the remaining credits of batches past their expiry off their users'
+// balances, with a CreditLog entry each, and returns how many batches it expired
+func ExpireCreditBatches() (int, error) {
+	if !creditExpiryMu.TryLock() {
+		return 0, nil
+	}
+	defer creditExpiryMu.Unlock()
+
+	var due []models.CreditBatch
+	if err := initializers.Db.
+		Where("expires_at <= ? AND expired_at IS NULL", time.Now()).
+		Order("expires_at ASC").
+		Limit(creditExpiryBatchSize).
+		Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, batch := range due {
+		if err := expireCreditBatch(batch.ID, batch.UserID); err != nil {
+			fmt.Printf("[Credits] Failed to expire batch %s of user %s: %v\n", batch.ID, batch.UserID, err)
+			continue
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// expireCreditBatch removes what is left of one batch. The user row is locked first, so a room
+// being paid for at the same moment either spends from the batch before it expires or not at all.
+func expireCreditBatch(batchID string, userID string) error {
+	return initializers.Db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", userID).First(&user).Error; err != nil {
+			return err
+		}
+		var batch models.CreditBatch
+		if err := tx.Where("id = ? AND expired_at IS NULL", batchID).First(&batch).Error; err != nil {
+			return err
+		}
+
+		// Balances changed outside the ledger can be below the batch; never go negative
+		leftover := batch.Remaining
+		if leftover > user.Credit {
+			leftover = user.Credit
+		}
+		if leftover > 0 {
+			if _, err := services.PostCredits(tx, services.CreditPosting{
+				UserID:         user.ID,
+				Type:           models.CreditTypeExpiry,
+				ReferenceID:    batch.ID,
+				Description:    fmt.Sprintf("%d purchased credits expired", leftover),
+				IdempotencyKey: "expire:" + batch.ID,
+				Extra:          -leftover,
+			}); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.CreditBatch{}).Where("id = ?", batch.ID).Updates(map[string]interface{}{
+			"remaining":  0,
+			"expired_at": time.Now(),
+		}).Error
+	})
 }
 
+// ========================================
+// Helpers
+// ========================================
+
 func generateTransactionID() string {
 	bytes := make([]byte, 16)
 	rand.Read(bytes)
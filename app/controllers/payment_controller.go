@@ -0,0 +1,1308 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PaymentController creates bills and processes payment results through the configured provider
+type PaymentController struct{}
+
+// ========================================
+// Provider Configuration
+// ========================================
+
+// GetPaymentProvider builds the named provider from SystemConfig; an empty name selects the active provider
+func GetPaymentProvider(name string) (services.PaymentProvider, error) {
+	if name == "" {
+		name = GetConfigValue(models.ConfigPaymentProvider, services.PaymentProviderFlip)
+	}
+	return services.NewPaymentProvider(name, services.PaymentConfig{
+		FlipSecretKey:       GetConfigValue(models.ConfigFlipSecretKey, ""),
+		FlipValidationToken: GetConfigValue(models.ConfigFlipValidationToken, ""),
+		FlipEnvironment:     GetConfigValue(models.ConfigFlipEnvironment, "sandbox"),
+		MidtransServerKey:   GetConfigValue(models.ConfigMidtransServerKey, ""),
+		MidtransEnvironment: GetConfigValue(models.ConfigMidtransEnvironment, "sandbox"),
+		FakeSecret:          GetConfigValue(models.ConfigFakePaymentSecret, ""),
+	})
+}
+
+// ========================================
+// Create Bill
+// ========================================
+
+// CreateBill creates a bill with the active payment provider
+func (c *PaymentController) CreateBill(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	// Reset free credits if needed
+	ResetFreeCreditIfNeeded(user)
+
+	var req struct {
+		PackageID  string `json:"package_id"`  // For extra credit packages
+		PlanID     string `json:"plan_id"`     // For subscription plans
+		CouponCode string `json:"coupon_code"` // Optional promo code
+	}
+
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if req.PackageID == "" && req.PlanID == "" {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Either package_id or plan_id is required"})
+	}
+
+	item, err := loadCheckoutItem(req.PackageID, req.PlanID)
+	if err != nil {
+		return ctx.Status(404).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// Switching plans goes through the upgrade and downgrade endpoints, which account for the days left.
+	// Buying the current plan extends it, and the plan of a scheduled downgrade can be paid for early.
+	if item.PlanID != nil && user.HasActiveSubscription() && *item.PlanID != *user.SubscriptionPlanID &&
+		(user.PendingPlanID == nil || *item.PlanID != *user.PendingPlanID) {
+		return ctx.Status(409).JSON(fiber.Map{
+			"error":       "You already have a subscription; upgrade or downgrade it to change plans",
+			"change_plan": true,
+		})
+	}
+
+	return c.checkout(ctx, user, item.Name, item.transaction(user.ID), req.CouponCode)
+}
+
+// checkout applies the coupon and settles the transaction straight away when nothing is left to pay,
+// or creates the provider bill for it
+func (c *PaymentController) checkout(ctx *fiber.Ctx, user *models.User, productName string, transaction models.Transaction, couponCode string) error {
+	// Check the coupon up front to pick the free or paid flow; it is checked again below, inside the transaction that holds its lock
+	var rejected *couponError
+	if couponCode != "" {
+		if err := applyCoupon(initializers.Db, couponCode, user, &transaction); errors.As(err, &rejected) {
+			return couponErrorResponse(ctx, rejected)
+		} else if err != nil {
+			return ctx.Status(500).JSON(fiber.Map{"error": "Failed to check coupon"})
+		}
+	}
+
+	// Handle free items (price = 0, or fully discounted)
+	if transaction.Amount == 0 {
+		return c.handleFreeItem(ctx, user, transaction, couponCode)
+	}
+
+	provider, err := GetPaymentProvider("")
+	if err != nil {
+		fmt.Printf("[Payment] %v\n", err)
+		return ctx.Status(500).JSON(fiber.Map{"error": "Payment provider is not configured"})
+	}
+
+	// Create transaction record; the reconciler first checks it a minute in and expires it after the validity window
+	txID := generateTransactionID()
+	now := time.Now()
+	expiresAt := now.Add(billValidity())
+	nextCheckAt := now.Add(reconcileBackoff[0])
+	transaction.ID = txID
+	transaction.Status = models.TransactionStatusPending
+	transaction.PaymentMethod = provider.Name()
+	transaction.Provider = provider.Name()
+	transaction.ExpiresAt = &expiresAt
+	transaction.NextCheckAt = &nextCheckAt
+
+	err = initializers.Db.Transaction(func(tx *gorm.DB) error {
+		if couponCode != "" {
+			if err := applyCoupon(tx, couponCode, user, &transaction); err != nil {
+				return err
+			}
+			if transaction.Amount == 0 {
+				return &couponError{message: "This coupon changed while you were checking out; please try again"}
+			}
+		}
+		return tx.Create(&transaction).Error
+	})
+	if errors.As(err, &rejected) {
+		return couponErrorResponse(ctx, rejected)
+	}
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to create transaction"})
+	}
+
+	// Build redirect URL
+	baseURL := ctx.Protocol() + "://" + ctx.Hostname()
+	origin := ctx.Get("Origin")
+	if origin != "" {
+		baseURL = origin
+	}
+
+	bill, err := provider.CreateBill(services.BillRequest{
+		Reference:     txID,
+		Title:         productName,
+		Amount:        transaction.Amount,
+		CustomerName:  user.Name,
+		CustomerEmail: user.Email,
+		RedirectURL:   baseURL + "/payment/status/" + txID,
+		ExpiresAt:     expiresAt,
+	})
+	if err != nil {
+		// Rollback transaction
+		initializers.Db.Delete(&transaction)
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to create bill: " + err.Error()})
+	}
+
+	transaction.ProviderRef = bill.ProviderRef
+	transaction.PaymentURL = bill.PaymentURL
+	transaction.ProviderData = bill.Data
+	initializers.Db.Save(&transaction)
+
+	return ctx.JSON(fiber.Map{
+		"transaction_id": txID,
+		"provider":       transaction.Provider,
+		"payment_url":    transaction.PaymentURL,
+		"provider_data":  transaction.ProviderData,
+		"amount":         transaction.Amount,
+		"list_amount":    transaction.ListAmount,
+		"discount":       transaction.DiscountAmount,
+		"product":        productName,
+	})
+}
+
+// checkoutItem is the package or plan being bought
+type checkoutItem struct {
+	Name      string
+	Price     int64
+	TxType    string
+	PackageID *string
+	PlanID    *string
+}
+
+// loadCheckoutItem finds the visible plan (when planID is set) or package being bought
+func loadCheckoutItem(packageID string, planID string) (*checkoutItem, error) {
+	if planID != "" {
+		// Subscription plan purchase
+		var plan models.SubscriptionPlan
+		if err := initializers.Db.Where("id = ? AND visibility = ?", planID, true).First(&plan).Error; err != nil {
+			return nil, errors.New("Subscription plan not found")
+		}
+		return &checkoutItem{
+			Name:   "Subscription: " + plan.PlanName,
+			Price:  plan.Price,
+			TxType: models.TxTypeSubscription,
+			PlanID: &plan.ID,
+		}, nil
+	}
+
+	// Extra credit package purchase
+	var pkg models.Package
+	if err := initializers.Db.Where("id = ? AND visibility = ?", packageID, true).First(&pkg).Error; err != nil {
+		return nil, errors.New("Package not found")
+	}
+	return &checkoutItem{
+		Name:      "Extra Credits: " + pkg.PackageName,
+		Price:     pkg.Price,
+		TxType:    models.TxTypeExtraCredit,
+		PackageID: &pkg.ID,
+	}, nil
+}
+
+// transaction starts the user's transaction for the item at its list price
+func (item *checkoutItem) transaction(userID string) models.Transaction {
+	return models.Transaction{
+		UserID:     userID,
+		PackageID:  item.PackageID,
+		PlanID:     item.PlanID,
+		Amount:     item.Price,
+		ListAmount: item.Price,
+		TxType:     item.TxType,
+	}
+}
+
+// handleFreeItem settles free packages/plans, and fully discounted ones, without payment
+func (c *PaymentController) handleFreeItem(ctx *fiber.Ctx, user *models.User, transaction models.Transaction, couponCode string) error {
+	// Free grants are withheld until the email address is verified
+	if !user.IsEmailVerified() {
+		return ctx.Status(403).JSON(fiber.Map{"error": "Please verify your email to claim free items", "email_verified": false})
+	}
+
+	txID := generateTransactionID()
+	now := time.Now()
+
+	transaction.ID = txID
+	transaction.Status = models.TransactionStatusSettlement
+	transaction.PaymentMethod = "free"
+	transaction.PaidAt = &now
+
+	// The record, the coupon use and the grant succeed or fail together
+	err := initializers.Db.Transaction(func(tx *gorm.DB) error {
+		if couponCode != "" {
+			if err := applyCoupon(tx, couponCode, user, &transaction); err != nil {
+				return err
+			}
+			if transaction.Amount != 0 {
+				return &couponError{message: "This coupon changed while you were checking out; please try again"}
+			}
+		}
+		if err := tx.Create(&transaction).Error; err != nil {
+			return err
+		}
+		return settleTransaction(tx, &transaction)
+	})
+	var rejected *couponError
+	if errors.As(err, &rejected) {
+		return couponErrorResponse(ctx, rejected)
+	}
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to create transaction"})
+	}
+
+	return ctx.JSON(fiber.Map{
+		"free":           true,
+		"transaction_id": txID,
+		"message":        "Free item claimed successfully",
+	})
+}
+
+// ========================================
+// Payment Callback
+// ========================================
+
+// HandleCallback processes payment callbacks for the provider named in the route (Flip for the legacy route).
+// Every verified callback is stored as a PaymentEvent; redeliveries of the same event are acknowledged without processing.
+// IMPORTANT: Flip requires HTTP 200 response. Non-200 causes retries (5x, 2min interval).
+func (c *PaymentController) HandleCallback(ctx *fiber.Ctx) error {
+	providerName := ctx.Params("provider", services.PaymentProviderFlip)
+	fmt.Printf("[Payment Callback] %s: Content-Type=%s, body length=%d\n", providerName, ctx.Get("Content-Type"), len(ctx.Body()))
+
+	provider, err := GetPaymentProvider(providerName)
+	if err != nil {
+		return ctx.Status(404).JSON(fiber.Map{"status": "error", "message": "unknown provider"})
+	}
+
+	headers := map[string]string{}
+	ctx.Request().Header.VisitAll(func(key, value []byte) {
+		headers[strings.ToLower(string(key))] = string(value)
+	})
+
+	result, err := provider.VerifyCallback(ctx.Get("Content-Type"), ctx.Body(), headers)
+	if err != nil {
+		fmt.Printf("[Payment Callback] %s: rejected: %v\n", providerName, err)
+		// Always return 200 to avoid provider retries
+		return ctx.Status(200).JSON(fiber.Map{"status": "error", "message": "invalid callback"})
+	}
+
+	fmt.Printf("[Payment Callback] %s: reference=%s provider_ref=%s payment_id=%s status=%s amount=%d\n",
+		providerName, result.Reference, result.ProviderRef, result.PaymentID, result.Status, result.Amount)
+
+	// Find transaction by our reference first, then by the provider's bill ID
+	var transaction models.Transaction
+	txFound := false
+	if result.Reference != "" {
+		if err := initializers.Db.Where("id = ? AND provider = ?", result.Reference, providerName).First(&transaction).Error; err == nil {
+			txFound = true
+		}
+	}
+	if !txFound && result.ProviderRef != "" {
+		if err := initializers.Db.Where("provider_ref = ? AND provider = ?", result.ProviderRef, providerName).First(&transaction).Error; err == nil {
+			txFound = true
+		}
+	}
+
+	event, isNew, err := recordPaymentEvent(providerName, result, ctx.Body(), transaction.ID)
+	if err != nil {
+		// Not acknowledged, so the provider delivers it again
+		fmt.Printf("[Payment Callback] %s: failed to store event: %v\n", providerName, err)
+		return ctx.Status(500).JSON(fiber.Map{"status": "error", "message": "failed to store event"})
+	}
+	if !isNew {
+		fmt.Printf("[Payment Callback] %s: duplicate event %s\n", providerName, event.EventID)
+		return ctx.Status(200).JSON(fiber.Map{"status": "duplicate"})
+	}
+
+	if !txFound {
+		fmt.Printf("[Payment Callback] %s: transaction not found: reference=%s provider_ref=%s\n",
+			providerName, result.Reference, result.ProviderRef)
+		finishPaymentEvent(event, models.PaymentEventNotFound)
+		return ctx.Status(200).JSON(fiber.Map{"status": "error", "message": "transaction not found"})
+	}
+
+	err = applyPaymentResult(&transaction, result)
+	switch {
+	case errors.Is(err, errPaymentNeedsRefund):
+		finishPaymentEvent(event, models.PaymentEventNeedsRefund)
+		return ctx.Status(200).JSON(fiber.Map{"status": "needs_refund"})
+	case errors.Is(err, errTransactionNotPending):
+		fmt.Printf("[Payment Callback] Transaction already processed: %s (status: %s)\n", transaction.ID, transaction.Status)
+		finishPaymentEvent(event, models.PaymentEventAlreadyProcessed)
+		return ctx.Status(200).JSON(fiber.Map{"status": "already_processed"})
+	case err != nil:
+		fmt.Printf("[Payment Callback] %s: tx=%s: %v\n", providerName, transaction.ID, err)
+		finishPaymentEvent(event, err.Error())
+		return ctx.Status(200).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	}
+
+	finishPaymentEvent(event, models.PaymentEventApplied)
+	return ctx.Status(200).JSON(fiber.Map{"status": "ok"})
+}
+
+// paymentEventID identifies a callback: the provider's payment ID plus the reported status,
+// or the body hash when the provider sends no payment ID
+func paymentEventID(result *services.PaymentResult, body []byte) string {
+	if result.PaymentID != "" {
+		return result.PaymentID + ":" + string(result.Status)
+	}
+	return hashToken(string(body))
+}
+
+// recordPaymentEvent stores the callback; isNew is false when the same event was already stored
+func recordPaymentEvent(provider string, result *services.PaymentResult, body []byte, transactionID string) (*models.PaymentEvent, bool, error) {
+	event := models.PaymentEvent{
+		ID:            generateID(),
+		Provider:      provider,
+		EventID:       paymentEventID(result, body),
+		TransactionID: transactionID,
+		Status:        string(result.Status),
+		Amount:        result.Amount,
+		Payload:       string(body),
+	}
+	insert := initializers.Db.Clauses(clause.OnConflict{DoNothing: true}).Create(&event)
+	if insert.Error != nil {
+		return nil, false, insert.Error
+	}
+	return &event, insert.RowsAffected == 1, nil
+}
+
+// finishPaymentEvent records what processing the event did
+func finishPaymentEvent(event *models.PaymentEvent, outcome string) {
+	now := time.Now()
+	initializers.Db.Model(event).Updates(map[string]interface{}{"result": outcome, "processed_at": now})
+}
+
+// errTransactionNotPending is returned when the transaction already left the pending state
+var errTransactionNotPending = errors.New("transaction already processed")
+
+// settlementRejectedError is returned by settleTransaction when the paid item can no longer be granted
+type settlementRejectedError struct {
+	reason string
+}
+
+func (e *settlementRejectedError) Error() string {
+	return "settlement rejected: " + e.reason
+}
+
+// errPaymentNeedsRefund is returned when money arrived for a transaction that could not be settled;
+// the reason is stored in Transaction.PaymentIssue for an admin to refund
+var errPaymentNeedsRefund = errors.New("payment could not be applied and needs a refund")
+
+// applyPaymentResult moves a pending transaction to the state the provider reported, settling paid ones.
+// The row stays locked (SELECT ... FOR UPDATE) for the whole transition, so a callback and a status poll
+// racing on the same payment can't both see it pending: credits are granted exactly once.
+// A payment for a bill that already expired or failed here is settled late, or flagged for a refund.
+func applyPaymentResult(transaction *models.Transaction, result *services.PaymentResult) error {
+	if result.Status == services.PaymentStatusPending {
+		return nil
+	}
+
+	needsRefund := false
+	err := initializers.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", transaction.ID).First(transaction).Error; err != nil {
+			return err
+		}
+		if result.Status == services.PaymentStatusPaid &&
+			(transaction.Status == models.TransactionStatusExpired || transaction.Status == models.TransactionStatusFailed) {
+			issue := settleLatePayment(tx, transaction, result)
+			if issue == "" {
+				return nil
+			}
+			needsRefund = true
+			updates := map[string]interface{}{"payment_issue": issue}
+			if result.Amount > 0 && result.Amount != transaction.Amount {
+				updates["paid_amount"] = result.Amount
+			}
+			if result.PaymentID != "" {
+				updates["external_id"] = result.PaymentID
+			}
+			return tx.Model(&models.Transaction{}).Where("id = ?", transaction.ID).Updates(updates).Error
+		}
+		if transaction.Status != models.TransactionStatusPending {
+			return errTransactionNotPending
+		}
+
+		if result.PaymentID != "" {
+			transaction.ExternalID = result.PaymentID
+		}
+		if result.Method != "" {
+			transaction.PaymentMethod = transaction.Provider + ":" + result.Method
+		}
+
+		switch result.Status {
+		case services.PaymentStatusPaid:
+			// Never settle for a different amount than was billed. The money did arrive, so the
+			// bill is closed as failed (the reconciler stops expiring it) and flagged for a refund.
+			if result.Amount > 0 && result.Amount != transaction.Amount {
+				transaction.Status = models.TransactionStatusFailed
+				transaction.PaidAmount = result.Amount
+				transaction.PaymentIssue = fmt.Sprintf("amount mismatch: billed %d IDR, paid %d IDR", transaction.Amount, result.Amount)
+				needsRefund = true
+				fmt.Printf("[Payment] ⚠️ Needs refund: tx=%s: %s\n", transaction.ID, transaction.PaymentIssue)
+				return tx.Save(transaction).Error
+			}
+
+			// In a savepoint, so a rejected settlement can still record the payment for a refund
+			err := tx.Transaction(func(stx *gorm.DB) error {
+				now := time.Now()
+				transaction.Status = models.TransactionStatusSettlement
+				transaction.PaidAt = &now
+				if err := stx.Save(transaction).Error; err != nil {
+					return err
+				}
+				return settleTransaction(stx, transaction)
+			})
+			var rejected *settlementRejectedError
+			if errors.As(err, &rejected) {
+				transaction.Status = models.TransactionStatusFailed
+				transaction.PaidAt = nil
+				transaction.PaymentIssue = "paid, but " + rejected.reason
+				needsRefund = true
+				fmt.Printf("[Payment] ⚠️ Needs refund: tx=%s: %s\n", transaction.ID, transaction.PaymentIssue)
+				return tx.Save(transaction).Error
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Printf("[Payment] ✅ Payment successful: tx=%s user=%s amount=%d\n", transaction.ID, transaction.UserID, transaction.Amount)
+		case services.PaymentStatusExpired:
+			transaction.Status = models.TransactionStatusExpired
+			if err := tx.Save(transaction).Error; err != nil {
+				return err
+			}
+			fmt.Printf("[Payment] ❌ Payment cancelled/expired: tx=%s\n", transaction.ID)
+		case services.PaymentStatusFailed:
+			transaction.Status = models.TransactionStatusFailed
+			if err := tx.Save(transaction).Error; err != nil {
+				return err
+			}
+			fmt.Printf("[Payment] ❌ Payment failed: tx=%s\n", transaction.ID)
+		default:
+			fmt.Printf("[Payment] Unexpected status '%s' for pending tx=%s\n", result.Status, transaction.ID)
+		}
+		return nil
+	})
+	if err == nil && needsRefund {
+		return errPaymentNeedsRefund
+	}
+	return err
+}
+
+// settleLatePayment settles a transaction that was paid after it expired or failed here, inside a savepoint
+// of tx. It returns why the payment could not be applied, or "" once it is settled.
+func settleLatePayment(tx *gorm.DB, transaction *models.Transaction, result *services.PaymentResult) string {
+	previousStatus := transaction.Status
+	if result.Amount > 0 && result.Amount != transaction.Amount {
+		issue := fmt.Sprintf("paid %d IDR after the bill was %s, but %d IDR was billed", result.Amount, previousStatus, transaction.Amount)
+		fmt.Printf("[Payment] ⚠️ Needs refund: tx=%s: %s\n", transaction.ID, issue)
+		return issue
+	}
+
+	err := tx.Transaction(func(stx *gorm.DB) error {
+		now := time.Now()
+		transaction.Status = models.TransactionStatusSettlement
+		transaction.PaidAt = &now
+		if result.PaymentID != "" {
+			transaction.ExternalID = result.PaymentID
+		}
+		if result.Method != "" {
+			transaction.PaymentMethod = transaction.Provider + ":" + result.Method
+		}
+		if err := stx.Save(transaction).Error; err != nil {
+			return err
+		}
+		return settleTransaction(stx, transaction)
+	})
+	if err != nil {
+		transaction.Status = previousStatus
+		transaction.PaidAt = nil
+		issue := fmt.Sprintf("paid after the bill was %s and could not be settled: %v", previousStatus, err)
+		fmt.Printf("[Payment] ⚠️ Needs refund: tx=%s: %s\n", transaction.ID, issue)
+		return issue
+	}
+	fmt.Printf("[Payment] ✅ Late payment settled: tx=%s user=%s amount=%d (was %s)\n", transaction.ID, transaction.UserID, transaction.Amount, previousStatus)
+	return ""
+}
+
+// ========================================
+// Check Transaction Status
+// ========================================
+
+// CheckTransaction returns the transaction status.
+// If the transaction is still pending, actively asks its payment provider.
+func (c *PaymentController) CheckTransaction(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	txID := ctx.Params("id")
+	if txID == "" {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Transaction ID is required"})
+	}
+
+	var transaction models.Transaction
+	if err := initializers.Db.Where("id = ? AND user_id = ?", txID, user.ID).First(&transaction).Error; err != nil {
+		return ctx.Status(404).JSON(fiber.Map{"error": "Transaction not found"})
+	}
+
+	if transaction.Status == models.TransactionStatusPending && transaction.Provider != "" && transaction.ProviderRef != "" {
+		if _, err := syncPendingTransaction(&transaction); err != nil {
+			fmt.Printf("[Payment Check] tx=%s provider_ref=%s: %v\n", transaction.ID, transaction.ProviderRef, err)
+		}
+	}
+
+	return ctx.JSON(fiber.Map{
+		"id":     transaction.ID,
+		"status": transaction.Status,
+		"amount": transaction.Amount,
+		"type":   transaction.TxType,
+	})
+}
+
+// syncPendingTransaction queries the transaction's provider and applies the reported payment state.
+// A transaction that left the pending state in the meantime is not an error; transaction is reloaded.
+func syncPendingTransaction(transaction *models.Transaction) (*services.PaymentResult, error) {
+	provider, err := GetPaymentProvider(transaction.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := provider.QueryStatus(transaction.ID, transaction.ProviderRef)
+	if err != nil {
+		return nil, fmt.Errorf("status check failed: %w", err)
+	}
+
+	if err := applyPaymentResult(transaction, result); err != nil && !errors.Is(err, errTransactionNotPending) {
+		return result, err
+	}
+	return result, nil
+}
+
+// ========================================
+// Background Reconciliation
+// ========================================
+
+// reconcileBackoff is the wait before each status check of a pending transaction; the last step repeats
+// until the bill expires
+var reconcileBackoff = []time.Duration{
+	1 * time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	30 * time.Minute,
+	1 * time.Hour,
+	3 * time.Hour,
+	6 * time.Hour,
+}
+
+// reconcileBatchSize caps the transactions checked per run; the rest are picked up by the next run
+const reconcileBatchSize = 100
+
+// reconcileMu keeps runs in this process from overlapping (a scheduled run and an admin trigger)
+var reconcileMu sync.Mutex
+
+// errReconcileRunning is returned when a run is requested while another is in progress
+var errReconcileRunning = errors.New("a reconciliation run is already in progress")
+
+// billValidity is how long an unpaid bill stays payable
+func billValidity() time.Duration {
+	hours, err := strconv.Atoi(GetConfigValue(models.ConfigBillValidityHours, "24"))
+	if err != nil || hours < 1 {
+		hours = 24
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// nextReconcileDelay returns the backoff after the given number of checks
+func nextReconcileDelay(attempts int) time.Duration {
+	if attempts >= len(reconcileBackoff) {
+		return reconcileBackoff[len(reconcileBackoff)-1]
+	}
+	return reconcileBackoff[attempts]
+}
+
+// StartPaymentReconciler checks due pending transactions every interval until the process exits.
+// A non-positive interval disables the worker.
+func StartPaymentReconciler(interval time.Duration) {
+	if interval <= 0 {
+		fmt.Println("[Reconcile] Payment reconciler disabled")
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			run, err := ReconcilePendingTransactions(models.ReconcileTriggerSchedule)
+			if errors.Is(err, errReconcileRunning) {
+				continue
+			}
+			if err != nil {
+				fmt.Printf("[Reconcile] Run failed: %v\n", err)
+				continue
+			}
+			if run.Checked > 0 {
+				fmt.Printf("[Reconcile] Run %s: checked=%d settled=%d failed=%d expired=%d pending=%d refunded=%d errors=%d\n", run.ID,
+					run.Checked, run.Settled, run.Failed, run.Expired, run.StillPending, run.Refunded, run.Errors)
+			}
+		}
+	}()
+}
+
+// ReconcilePendingTransactions asks the providers about pending transactions whose next check is due,
+// applies what they report, expires bills past their validity window, completes stalled refunds
+// and stores the run's report.
+// Settlement goes through applyPaymentResult, so a run racing a callback still grants credits once.
+func ReconcilePendingTransactions(trigger string) (*models.PaymentReconcileRun, error) {
+	if !reconcileMu.TryLock() {
+		return nil, errReconcileRunning
+	}
+	defer reconcileMu.Unlock()
+
+	run := models.PaymentReconcileRun{
+		ID:        generateID(),
+		Trigger:   trigger,
+		StartedAt: time.Now(),
+		Items:     []models.PaymentReconcileItem{},
+	}
+
+	var transactions []models.Transaction
+	if err := initializers.Db.
+		Where("status = ? AND provider <> ''", models.TransactionStatusPending).
+		Where("next_check_at IS NULL OR next_check_at <= ?", run.StartedAt).
+		Order("created_at ASC").
+		Limit(reconcileBatchSize).
+		Find(&transactions).Error; err != nil {
+		return nil, err
+	}
+
+	validity := billValidity()
+	for i := range transactions {
+		item := reconcileTransaction(&transactions[i], validity)
+		run.Checked++
+		switch item.Outcome {
+		case models.TransactionStatusSettlement:
+			run.Settled++
+		case models.TransactionStatusFailed:
+			run.Failed++
+		case models.TransactionStatusExpired:
+			run.Expired++
+		case models.TransactionStatusPending:
+			run.StillPending++
+		default:
+			run.Errors++
+		}
+		run.Items = append(run.Items, item)
+	}
+
+	// Refunds left half-finished are completed too
+	for _, item := range resumePendingRefunds(run.StartedAt) {
+		run.Checked++
+		if item.Outcome == models.TransactionStatusRefunded {
+			run.Refunded++
+		} else {
+			run.Errors++
+		}
+		run.Items = append(run.Items, item)
+	}
+
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+	// Scheduled runs that found nothing due are not worth a report
+	if run.Checked == 0 && trigger == models.ReconcileTriggerSchedule {
+		return &run, nil
+	}
+	if err := initializers.Db.Create(&run).Error; err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// reconcileTransaction checks one pending transaction and schedules its next check if it stays pending
+func reconcileTransaction(transaction *models.Transaction, validity time.Duration) models.PaymentReconcileItem {
+	item := models.PaymentReconcileItem{TransactionID: transaction.ID, Provider: transaction.Provider}
+	now := time.Now()
+	expiresAt := transaction.CreatedAt.Add(validity)
+	if transaction.ExpiresAt != nil {
+		expiresAt = *transaction.ExpiresAt
+	}
+
+	// A transaction without a provider reference never got its bill, so nothing can have been paid
+	var err error
+	if transaction.ProviderRef != "" {
+		_, err = syncPendingTransaction(transaction)
+	}
+
+	// Past the validity window and the provider still reports no payment: nobody can pay it anymore
+	if err == nil && transaction.Status == models.TransactionStatusPending && now.After(expiresAt) {
+		err = applyPaymentResult(transaction, &services.PaymentResult{Status: services.PaymentStatusExpired})
+		if errors.Is(err, errTransactionNotPending) {
+			err = nil
+		}
+	}
+
+	if err != nil {
+		item.Outcome = "error"
+		item.Error = err.Error()
+	} else {
+		item.Outcome = transaction.Status
+	}
+
+	if transaction.Status == models.TransactionStatusPending {
+		attempts := transaction.CheckAttempts + 1
+		nextCheckAt := now.Add(nextReconcileDelay(attempts))
+		// Always take one last look right after the bill expires
+		if now.Before(expiresAt) && nextCheckAt.After(expiresAt) {
+			nextCheckAt = expiresAt
+		}
+		initializers.Db.Model(&models.Transaction{}).
+			Where("id = ? AND status = ?", transaction.ID, models.TransactionStatusPending).
+			Updates(map[string]interface{}{"check_attempts": attempts, "next_check_at": nextCheckAt})
+	}
+	return item
+}
+
+// ========================================
+// Settlement Logic (shared)
+// ========================================
+
+// settleTransaction awards credits or activates the subscription for a settled transaction.
+// It must run inside tx alongside the status change; the user row is locked while it is updated.
+// The ledger posting is keyed by the transaction, so a transaction is never granted twice.
+func settleTransaction(tx *gorm.DB, transaction *models.Transaction) error {
+	var user models.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", transaction.UserID).First(&user).Error; err != nil {
+		return fmt.Errorf("user not found: %w", err)
+	}
+
+	switch transaction.TxType {
+	case models.TxTypeExtraCredit:
+		if transaction.PackageID == nil {
+			return nil
+		}
+		var pkg models.Package
+		if err := tx.Where("id = ?", *transaction.PackageID).First(&pkg).Error; err != nil {
+			return fmt.Errorf("package not found: %w", err)
+		}
+		// Award extra credits, as a batch that expires after the package's validity
+		var expiresAt *time.Time
+		if pkg.ValidityDays > 0 {
+			expiry := time.Now().AddDate(0, 0, pkg.ValidityDays)
+			expiresAt = &expiry
+		}
+		if _, err := services.PostCredits(tx, services.CreditPosting{
+			UserID:         user.ID,
+			Type:           models.CreditTypePurchase,
+			ReferenceID:    transaction.ID,
+			Description:    "Extra Credit Purchase: " + pkg.PackageName,
+			IdempotencyKey: "settle:" + transaction.ID,
+			Extra:          pkg.CreditAmount,
+			ExpiresAt:      expiresAt,
+		}); err != nil {
+			return err
+		}
+		fmt.Printf("[settle] Awarded %d extra credits to user %s\n", pkg.CreditAmount, user.ID)
+
+	case models.TxTypeSubscription:
+		if transaction.PlanID == nil {
+			return nil
+		}
+		var plan models.SubscriptionPlan
+		if err := tx.Where("id = ?", *transaction.PlanID).First(&plan).Error; err != nil {
+			return fmt.Errorf("plan not found: %w", err)
+		}
+		// Activate, renew, upgrade or pay for a scheduled downgrade
+		now := time.Now()
+		period, err := settleSubscriptionPeriod(tx, &user, transaction, &plan, now)
+		if err != nil {
+			return err
+		}
+
+		// Reset free credits to the daily amount of the plan active today
+		posted, err := services.PostCredits(tx, services.CreditPosting{
+			UserID:         user.ID,
+			Type:           models.CreditTypeSubscription,
+			ReferenceID:    transaction.ID,
+			IdempotencyKey: "settle:" + transaction.ID,
+			SetFree:        &period.DailyCredits,
+			Description: fmt.Sprintf("Subscription: %s (%d days, %d daily credits, %d min rooms)",
+				plan.PlanName, plan.BillingPeriodDays, plan.DailyFreeCredits, plan.RoomDurationMinutes),
+		})
+		if err != nil {
+			return err
+		}
+		if posted.Duplicate {
+			return nil
+		}
+
+		// Kept so a refund of the upgrade can give the old plan back; the plan may have been renewed since the quote
+		if period.ReplacedUntil != nil {
+			transaction.FromExpiresAt = period.ReplacedUntil
+			if err := tx.Model(&models.Transaction{}).Where("id = ?", transaction.ID).Update("from_expires_at", period.ReplacedUntil).Error; err != nil {
+				return err
+			}
+		}
+
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"subscription_plan_id":     period.PlanID,
+			"subscription_expires_at":  period.ExpiresAt,
+			"subscription_grace_until": nil,
+			"auto_renew":               plan.Price > 0, // free plans are claimed again by hand
+			"pending_plan_id":          period.PendingPlanID,
+			"pending_plan_at":          period.PendingPlanAt,
+			"free_credit_reset_at":     today,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := recordSubscriptionEvent(tx, user.ID, &plan.ID, period.Event, &transaction.ID, &period.ExpiresAt, period.Detail); err != nil {
+			return err
+		}
+		fmt.Printf("[settle] Subscription '%s' %s for user %s (expires %s)\n",
+			plan.PlanName, period.Event, user.ID, period.ExpiresAt.Format("2006-01-02"))
+	}
+
+	// A first paid purchase can complete the user's referral. The savepoint keeps a failed
+	// reward from undoing the settlement.
+	if transaction.Amount > 0 {
+		if err := tx.Transaction(func(tx *gorm.DB) error {
+			return rewardReferral(tx, user.ID, true)
+		}); err != nil {
+			fmt.Printf("[referral] failed to reward referral of user %s: %v\n", user.ID, err)
+		}
+	}
+	return nil
+}
+
+// ========================================
+// Refunds
+// ========================================
+
+// RefundOptions describes an admin refund
+type RefundOptions struct {
+	AdminID        string
+	Reason         string
+	AllowShortfall bool // refund even if the granted credits were partly spent, clawing back what is left
+	Manual         bool // the money is returned outside the payment provider (e.g. bank transfer)
+}
+
+var (
+	errNotRefundable          = errors.New("only settled transactions can be refunded")
+	errProviderRefundRequired = errors.New("the payment provider cannot refund this transaction; return the money manually and mark the refund as manual")
+)
+
+// creditsSpentError is returned when the credits to claw back were already spent
+type creditsSpentError struct {
+	Granted   int
+	Available int
+}
+
+func (e *creditsSpentError) Error() string {
+	return fmt.Sprintf("%d credits were granted but only %d remain", e.Granted, e.Available)
+}
+
+// grantedCredits returns the extra credits a settlement posted, falling back to the package
+// size for transactions settled before the ledger existed
+func grantedCredits(tx *gorm.DB, transaction *models.Transaction) int {
+	var granted struct{ Total int }
+	tx.Model(&models.LedgerEntry{}).
+		Select("COALESCE(SUM(ledger_entries.amount), 0) AS total").
+		Joins("JOIN ledger_journals ON ledger_journals.id = ledger_entries.journal_id").
+		Where("ledger_journals.idempotency_key = ? AND ledger_entries.account = ?", "settle:"+transaction.ID, services.UserExtraAccount(transaction.UserID)).
+		Scan(&granted)
+	if granted.Total > 0 {
+		return granted.Total
+	}
+
+	if transaction.PackageID != nil {
+		var pkg models.Package
+		if err := tx.Where("id = ?", *transaction.PackageID).First(&pkg).Error; err == nil {
+			return pkg.CreditAmount
+		}
+	}
+	return 0
+}
+
+// A pending refund untouched this long is taken over by a retry or the reconciler
+const refundLease = 2 * time.Minute
+
+var (
+	errRefundInProgress  = errors.New("this refund is already being processed")
+	errRefundUnconfirmed = errors.New("the payment provider did not confirm the refund; it stays pending and will be retried")
+)
+
+// refundTransaction reverses what a settled transaction granted and returns the money through its provider.
+// A late payment flagged with a PaymentIssue only has its money returned. No money moves inside a database
+// transaction: beginRefund records the intent as refund_pending, the provider is asked outside any lock with
+// an idempotency key, and finishRefund applies the reversal. Calling it again on a refund_pending transaction,
+// as the reconciler does, completes whatever step is missing.
+func refundTransaction(transactionID string, opts RefundOptions) (*models.Transaction, error) {
+	transaction, err := beginRefund(transactionID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if !transaction.RefundManual && transaction.Provider != "" && refundAmount(transaction) > 0 && transaction.RefundID == "" {
+		refundID, err := refundWithProvider(transaction)
+		if err != nil {
+			return nil, err
+		}
+		// Kept right away; if this write is lost, the idempotency key stops a retry from paying out again
+		transaction.RefundID = refundID
+		initializers.Db.Model(&models.Transaction{}).Where("id = ?", transaction.ID).Update("refund_id", refundID)
+	}
+
+	if err := finishRefund(transaction); err != nil {
+		fmt.Printf("[Refund] ⚠️ tx=%s: money returned (refund %q) but the reversal failed, it will be retried: %v\n",
+			transaction.ID, transaction.RefundID, err)
+		return nil, err
+	}
+	fmt.Printf("[Refund] tx=%s refunded (%s): clawback=%d shortfall=%d days=%d refund_id=%s\n", transaction.ID, transaction.RefundReason,
+		transaction.RefundClawback, transaction.RefundShortfall, transaction.RefundDays, transaction.RefundID)
+	return transaction, nil
+}
+
+// refundAmount is the money to return: what was actually paid when the provider reported a different amount
+func refundAmount(transaction *models.Transaction) int64 {
+	if transaction.PaidAmount > 0 {
+		return transaction.PaidAmount
+	}
+	return transaction.Amount
+}
+
+// isPaymentIssueRefund reports whether the transaction is a late payment that granted nothing,
+// so a refund only returns the money
+func isPaymentIssueRefund(transaction *models.Transaction, status string) bool {
+	return transaction.PaymentIssue != "" &&
+		(status == models.TransactionStatusExpired || status == models.TransactionStatusFailed)
+}
+
+// beginRefund commits the refund intent (status refund_pending). A refund that is already pending
+// is resumed with the options it was started with, unless another attempt is still working on it.
+func beginRefund(transactionID string, opts RefundOptions) (*models.Transaction, error) {
+	var transaction models.Transaction
+	err := initializers.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", transactionID).First(&transaction).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+
+		if transaction.Status == models.TransactionStatusRefundPending {
+			if transaction.RefundAttemptAt != nil && now.Sub(*transaction.RefundAttemptAt) < refundLease {
+				return errRefundInProgress
+			}
+			transaction.RefundAttemptAt = &now
+			return tx.Model(&models.Transaction{}).Where("id = ?", transaction.ID).Update("refund_attempt_at", now).Error
+		}
+
+		flagged := isPaymentIssueRefund(&transaction, transaction.Status)
+		if transaction.Status != models.TransactionStatusSettlement && !flagged {
+			return errNotRefundable
+		}
+
+		// Refuse up front rather than after the money is gone; finishRefund claws back what is left
+		if !flagged && transaction.TxType == models.TxTypeExtraCredit && !opts.AllowShortfall {
+			var user models.User
+			if err := tx.Where("id = ?", transaction.UserID).First(&user).Error; err != nil {
+				return fmt.Errorf("user not found: %w", err)
+			}
+			if granted := grantedCredits(tx, &transaction); user.Credit < granted {
+				return &creditsSpentError{Granted: granted, Available: user.Credit}
+			}
+		}
+
+		return tx.Model(&models.Transaction{}).Where("id = ?", transaction.ID).Updates(map[string]interface{}{
+			"status":            models.TransactionStatusRefundPending,
+			"refund_from":       transaction.Status,
+			"refund_key":        "refund:" + transaction.ID,
+			"refund_manual":     opts.Manual,
+			"refunded_by":       opts.AdminID,
+			"refund_reason":     opts.Reason,
+			"refund_attempt_at": now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := initializers.Db.Where("id = ?", transactionID).First(&transaction).Error; err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// refundWithProvider asks the provider to return the money and returns its refund ID.
+// When the provider certainly did not refund, the transaction goes back to its previous status.
+func refundWithProvider(transaction *models.Transaction) (string, error) {
+	provider, err := GetPaymentProvider(transaction.Provider)
+	if err != nil {
+		cancelRefund(transaction)
+		return "", err
+	}
+	refund, err := provider.Refund(services.RefundRequest{
+		Reference:      transaction.ID,
+		ProviderRef:    transaction.ProviderRef,
+		Amount:         refundAmount(transaction),
+		Reason:         transaction.RefundReason,
+		IdempotencyKey: transaction.RefundKey,
+	})
+	if errors.Is(err, services.ErrRefundUnsupported) {
+		cancelRefund(transaction)
+		return "", errProviderRefundRequired
+	}
+	if err == nil {
+		return refund.RefundID, nil
+	}
+
+	// The request may have reached the provider even though the answer did not; its payment status tells
+	status, queryErr := provider.QueryStatus(transaction.ID, transaction.ProviderRef)
+	switch {
+	case queryErr == nil && status.Status == services.PaymentStatusRefunded:
+		return transaction.RefundKey, nil
+	case queryErr == nil && status.Status == services.PaymentStatusPaid:
+		cancelRefund(transaction)
+		return "", fmt.Errorf("provider refund failed: %w", err)
+	}
+	fmt.Printf("[Refund] tx=%s: provider refund unconfirmed: %v\n", transaction.ID, err)
+	return "", errRefundUnconfirmed
+}
+
+// cancelRefund puts a refund_pending transaction back to the status it had before the refund started
+func cancelRefund(transaction *models.Transaction) {
+	initializers.Db.Model(&models.Transaction{}).
+		Where("id = ? AND status = ? AND refund_id = ''", transaction.ID, models.TransactionStatusRefundPending).
+		Updates(map[string]interface{}{
+			"status":            transaction.RefundFrom,
+			"refunded_by":       nil,
+			"refund_reason":     "",
+			"refund_attempt_at": nil,
+		})
+}
+
+// finishRefund reverses what the transaction granted and marks it refunded. The money is already back
+// with the customer, so credits spent since the refund started are recorded as a shortfall.
+func finishRefund(refunding *models.Transaction) error {
+	return initializers.Db.Transaction(func(tx *gorm.DB) error {
+		var transaction models.Transaction
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", refunding.ID).First(&transaction).Error; err != nil {
+			return err
+		}
+		if transaction.Status == models.TransactionStatusRefunded {
+			*refunding = transaction
+			return nil
+		}
+		if transaction.Status != models.TransactionStatusRefundPending {
+			return errNotRefundable
+		}
+		transaction.RefundID = refunding.RefundID
+
+		if !isPaymentIssueRefund(&transaction, transaction.RefundFrom) {
+			var user models.User
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", transaction.UserID).First(&user).Error; err != nil {
+				return fmt.Errorf("user not found: %w", err)
+			}
+
+			posting := services.CreditPosting{
+				UserID:         user.ID,
+				Type:           models.CreditTypeRefund,
+				ReferenceID:    transaction.ID,
+				IdempotencyKey: transaction.RefundKey,
+				Description:    "Refund: " + transaction.RefundReason,
+			}
+
+			switch transaction.TxType {
+			case models.TxTypeExtraCredit:
+				granted := grantedCredits(tx, &transaction)
+				clawback := granted
+				if user.Credit < clawback {
+					clawback = user.Credit
+				}
+				posting.Extra = -clawback
+				transaction.RefundClawback = clawback
+				transaction.RefundShortfall = granted - clawback
+
+			case models.TxTypeSubscription:
+				if transaction.FromPlanID != nil {
+					if err := refundUpgrade(tx, &user, &transaction, &posting); err != nil {
+						return err
+					}
+					break
+				}
+				// Only the plan this transaction paid for is shortened; a later plan change is left alone
+				if transaction.PlanID != nil && user.SubscriptionPlanID != nil && *user.SubscriptionPlanID == *transaction.PlanID && user.SubscriptionExpiresAt != nil {
+					var plan models.SubscriptionPlan
+					if err := tx.Where("id = ?", *transaction.PlanID).First(&plan).Error; err != nil {
+						return fmt.Errorf("plan not found: %w", err)
+					}
+
+					now := time.Now()
+					expiresAt := user.SubscriptionExpiresAt.AddDate(0, 0, -plan.BillingPeriodDays)
+					updates := map[string]interface{}{"subscription_expires_at": expiresAt}
+					transaction.RefundDays = plan.BillingPeriodDays
+					if !expiresAt.After(now) {
+						// Nothing left of the subscription: back to the free plan allowance
+						updates = map[string]interface{}{
+							"subscription_plan_id":     nil,
+							"subscription_expires_at":  nil,
+							"subscription_grace_until": nil,
+							"auto_renew":               false,
+						}
+						freeCredits := GetDefaultFreeCredits()
+						if user.FreeCredit > freeCredits {
+							posting.SetFree = &freeCredits
+						}
+						if user.SubscriptionExpiresAt.After(now) {
+							transaction.RefundDays = int(user.SubscriptionExpiresAt.Sub(now).Hours()/24 + 0.5)
+						} else {
+							transaction.RefundDays = 0
+						}
+					}
+					if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(updates).Error; err != nil {
+						return err
+					}
+				}
+			}
+
+			if _, err := services.PostCredits(tx, posting); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		transaction.Status = models.TransactionStatusRefunded
+		transaction.RefundedAt = &now
+		if err := tx.Save(&transaction).Error; err != nil {
+			return err
+		}
+		*refunding = transaction
+		return nil
+	})
+}
+
+// resumePendingRefunds completes refunds left half-finished, e.g. by a crash or a provider timeout
+func resumePendingRefunds(now time.Time) []models.PaymentReconcileItem {
+	var transactions []models.Transaction
+	initializers.Db.
+		Where("status = ? AND (refund_attempt_at IS NULL OR refund_attempt_at < ?)", models.TransactionStatusRefundPending, now.Add(-refundLease)).
+		Order("refund_attempt_at ASC").
+		Limit(reconcileBatchSize).
+		Find(&transactions)
+
+	items := make([]models.PaymentReconcileItem, 0, len(transactions))
+	for _, pending := range transactions {
+		item := models.PaymentReconcileItem{TransactionID: pending.ID, Provider: pending.Provider}
+		if transaction, err := refundTransaction(pending.ID, RefundOptions{}); err != nil {
+			item.Outcome = "error"
+			item.Error = err.Error()
+		} else {
+			item.Outcome = transaction.Status
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// ========================================
+// Daily Free Credit Reset
+// ========================================
+
+// ResetFreeCreditIfNeeded checks and resets the user's daily free credits if needed.
+// The reset is posted to the ledger under the user lock, and user is refreshed with the result.
+func ResetFreeCreditIfNeeded(user *models.User) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	// Check if already reset today
+	if user.FreeCreditResetAt != nil && !user.FreeCreditResetAt.Before(today) {
+		return // Already reset today
+	}
+
+	// No free credits until the email address is verified
+	if !user.IsEmailVerified() {
+		return
+	}
+
+	err := initializers.Db.Transaction(func(tx *gorm.DB) error {
+		var locked models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", user.ID).First(&locked).Error; err != nil {
+			return err
+		}
+		// Another request may have reset while we waited for the lock
+		if locked.FreeCreditResetAt != nil && !locked.FreeCreditResetAt.Before(today) {
+			*user = locked
+			return nil
+		}
+
+		// Determine daily free credits based on subscription
+		dailyCredits := GetDefaultFreeCredits()
+		updates := map[string]interface{}{"free_credit_reset_at": today}
+
+		if err := applyScheduledPlan(tx, &locked); err != nil {
+			return err
+		}
+		if locked.SubscriptionPlanID != nil {
+			if locked.HasActiveSubscription() {
+				// Active subscription, or unpaid renewal within the grace period
+				var plan models.SubscriptionPlan
+				if err := tx.Where("id = ?", *locked.SubscriptionPlanID).First(&plan).Error; err == nil {
+					dailyCredits = plan.DailyFreeCredits
+				}
+			} else if err := lapseSubscription(tx, &locked); err != nil {
+				// Subscription expired - revert to free plan
+				return err
+			}
+		}
+
+		if _, err := services.PostCredits(tx, services.CreditPosting{
+			UserID:      locked.ID,
+			Type:        models.CreditTypeFreeReset,
+			Description: "Daily free credit reset",
+			SetFree:     &dailyCredits,
+			NoHistory:   true,
+		}); err != nil {
+			return err
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", locked.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", locked.ID).First(user).Error
+	})
+	if err != nil {
+		fmt.Printf("[credits] Failed to reset free credits for user %s: %v\n", user.ID, err)
+	}
+}
+
+// GetDefaultFreeCredits returns the default daily free credits for free plan users
+func GetDefaultFreeCredits() int {
+	value := GetConfigValue(models.ConfigDailyFreeCredits, "5")
+	credits, err := strconv.Atoi(value)
+	if err != nil {
+		return 5
+	}
+	return credits
+}
+
+// GetUserRoomDuration returns the room duration in minutes for a given user
+func GetUserRoomDuration(user *models.User) int {
+	if user.HasActiveSubscription() {
+		var plan models.SubscriptionPlan
+		if err := initializers.Db.Where("id = ?", *user.SubscriptionPlanID).First(&plan).Error; err == nil {
+			return plan.RoomDurationMinutes
+		}
+	}
+	return GetRoomMaxDuration()
+}
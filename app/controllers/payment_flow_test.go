@@ -0,0 +1,308 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const testPaymentSecret = "flow-test-secret"
+
+// paymentTestDB migrates the payment tables and selects the fake provider
+func paymentTestDB(t *testing.T) {
+	t.Helper()
+	testDB(t,
+		&models.User{},
+		&models.Package{},
+		&models.SubscriptionPlan{},
+		&models.SystemConfig{},
+		&models.Transaction{},
+		&models.PaymentEvent{},
+		&models.Invoice{},
+		&models.InvoiceSequence{},
+		&models.Coupon{},
+		&models.Referral{},
+		&models.SubscriptionEvent{},
+		&models.CreditLog{},
+		&models.LedgerJournal{},
+		&models.LedgerEntry{},
+		&models.CreditBatch{},
+		&models.PaymentReconcileRun{},
+	)
+
+	for key, value := range map[string]string{
+		models.ConfigPaymentProvider:   services.PaymentProviderFake,
+		models.ConfigFakePaymentSecret: testPaymentSecret,
+	} {
+		initializers.Db.Where("key = ?", key).Delete(&models.SystemConfig{})
+		if err := initializers.Db.Create(&models.SystemConfig{ID: generateID(), Key: key, Value: value}).Error; err != nil {
+			t.Fatalf("config %s: %v", key, err)
+		}
+	}
+}
+
+// paymentTestApp serves checkout as the given user and the provider callback
+func paymentTestApp(user *models.User) *fiber.App {
+	payments := &PaymentController{}
+	app := fiber.New()
+	app.Post("/checkout", func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, user)
+		return payments.CreateBill(ctx)
+	})
+	app.Post("/callback/:provider", payments.HandleCallback)
+	return app
+}
+
+func postJSON(t *testing.T, app *fiber.App, path string, body string, headers map[string]string) map[string]interface{} {
+	t.Helper()
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("POST %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	var out map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("POST %s: decode: %v", path, err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("POST %s: status %d: %v", path, resp.StatusCode, out)
+	}
+	return out
+}
+
+func sendFakeCallback(t *testing.T, app *fiber.App, txID string, status services.PaymentStatus, amount int64, secret string) string {
+	t.Helper()
+	body := fmt.Sprintf(`{"reference":%q,"status":%q,"amount":%d}`, txID, status, amount)
+	out := postJSON(t, app, "/callback/"+services.PaymentProviderFake, body, map[string]string{
+		services.FakeSignatureHeader: services.SignFakeCallback(secret, []byte(body)),
+	})
+	result, _ := out["status"].(string)
+	return result
+}
+
+// paymentTestCustomer creates a user and a visible package, removed with everything they touched after the test
+func paymentTestCustomer(t *testing.T) (*models.User, *models.Package) {
+	t.Helper()
+	pkg := models.Package{ID: generateID(), PackageName: "Flow test", Price: 20000, CreditAmount: 10, Visibility: true}
+	user := models.User{ID: generateID(), Name: "Flow Test", Email: generateID() + "@example.test"}
+	for _, row := range []interface{}{&pkg, &user} {
+		if err := initializers.Db.Create(row).Error; err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	t.Cleanup(func() {
+		db := initializers.Db
+		db.Where("user_id = ?", user.ID).Delete(&models.CreditBatch{})
+		db.Where("user_id = ?", user.ID).Delete(&models.CreditLog{})
+		db.Where("user_id = ?", user.ID).Delete(&models.Invoice{})
+		db.Where("user_id = ?", user.ID).Delete(&models.Transaction{})
+		db.Delete(&user)
+		db.Delete(&pkg)
+	})
+	return &user, &pkg
+}
+
+// checkoutPackage starts a checkout for pkg and returns the pending transaction ID
+func checkoutPackage(t *testing.T, app *fiber.App, pkg *models.Package) string {
+	t.Helper()
+	out := postJSON(t, app, "/checkout", fmt.Sprintf(`{"package_id":%q}`, pkg.ID), nil)
+	txID, _ := out["transaction_id"].(string)
+	if txID == "" || out["provider"] != services.PaymentProviderFake {
+		t.Fatalf("checkout = %v", out)
+	}
+	return txID
+}
+
+// buyPackage checks out pkg and pays for it, returning the settled transaction ID
+func buyPackage(t *testing.T, app *fiber.App, pkg *models.Package) string {
+	t.Helper()
+	txID := checkoutPackage(t, app, pkg)
+	if status := sendFakeCallback(t, app, txID, services.PaymentStatusPaid, pkg.Price, testPaymentSecret); status != "ok" {
+		t.Fatalf("paid callback: status %q", status)
+	}
+	return txID
+}
+
+func TestPaymentFlowThroughFakeProvider(t *testing.T) {
+	paymentTestDB(t)
+	user, pkg := paymentTestCustomer(t)
+	app := paymentTestApp(user)
+
+	// Checkout creates a pending transaction and a fake bill
+	txID := checkoutPackage(t, app, pkg)
+
+	// A callback signed with the wrong secret changes nothing
+	if status := sendFakeCallback(t, app, txID, services.PaymentStatusPaid, pkg.Price, "wrong"); status != "error" {
+		t.Fatalf("forged callback: status %q", status)
+	}
+	var transaction models.Transaction
+	initializers.Db.Where("id = ?", txID).First(&transaction)
+	if transaction.Status != models.TransactionStatusPending {
+		t.Fatalf("forged callback moved the transaction to %s", transaction.Status)
+	}
+
+	// The signed callback settles it and grants the package credits once
+	if status := sendFakeCallback(t, app, txID, services.PaymentStatusPaid, pkg.Price, testPaymentSecret); status != "ok" {
+		t.Fatalf("paid callback: status %q", status)
+	}
+	if status := sendFakeCallback(t, app, txID, services.PaymentStatusPaid, pkg.Price, testPaymentSecret); status != "duplicate" {
+		t.Fatalf("repeated callback: status %q", status)
+	}
+	initializers.Db.Where("id = ?", txID).First(&transaction)
+	if transaction.Status != models.TransactionStatusSettlement {
+		t.Fatalf("transaction status = %s, want settlement", transaction.Status)
+	}
+	initializers.Db.Where("id = ?", user.ID).First(user)
+	if user.Credit != pkg.CreditAmount {
+		t.Fatalf("credit after settlement = %d, want %d", user.Credit, pkg.CreditAmount)
+	}
+
+	// Refunding claws the credits back and returns the money through the fake
+	refunded, err := refundTransaction(txID, RefundOptions{AdminID: "test", Reason: "flow test"})
+	if err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	if refunded.Status != models.TransactionStatusRefunded || refunded.RefundClawback != pkg.CreditAmount {
+		t.Fatalf("refunded transaction = %+v", refunded)
+	}
+	initializers.Db.Where("id = ?", user.ID).First(user)
+	if user.Credit != 0 {
+		t.Fatalf("credit after refund = %d, want 0", user.Credit)
+	}
+	provider, _ := GetPaymentProvider(services.PaymentProviderFake)
+	if status, _ := provider.QueryStatus(txID, transaction.ProviderRef); status.Status != services.PaymentStatusRefunded {
+		t.Fatalf("fake bill status = %s, want refunded", status.Status)
+	}
+}
+
+func TestRefundCompletesAfterInterruption(t *testing.T) {
+	paymentTestDB(t)
+	user, pkg := paymentTestCustomer(t)
+	app := paymentTestApp(user)
+	txID := buyPackage(t, app, pkg)
+
+	// The intent is committed and the provider pays out, then the process dies before the reversal
+	transaction, err := beginRefund(txID, RefundOptions{AdminID: "test", Reason: "interrupted"})
+	if err != nil {
+		t.Fatalf("begin refund: %v", err)
+	}
+	if transaction.Status != models.TransactionStatusRefundPending || transaction.RefundFrom != models.TransactionStatusSettlement {
+		t.Fatalf("refund intent = %+v", transaction)
+	}
+	provider, _ := GetPaymentProvider(services.PaymentProviderFake)
+	paidOut, err := provider.Refund(services.RefundRequest{Reference: txID, ProviderRef: transaction.ProviderRef, Amount: transaction.Amount, IdempotencyKey: transaction.RefundKey})
+	if err != nil {
+		t.Fatalf("provider refund: %v", err)
+	}
+
+	// While the first attempt holds the lease, a retry backs off
+	if _, err := refundTransaction(txID, RefundOptions{AdminID: "test", Reason: "retry"}); !errors.Is(err, errRefundInProgress) {
+		t.Fatalf("retry during the lease: %v", err)
+	}
+
+	// Once the lease runs out the reconciler finishes it without paying out again
+	initializers.Db.Model(&models.Transaction{}).Where("id = ?", txID).Update("refund_attempt_at", time.Now().Add(-time.Hour))
+	items := resumePendingRefunds(time.Now())
+	var outcome string
+	for _, item := range items {
+		if item.TransactionID == txID {
+			outcome = item.Outcome + item.Error
+		}
+	}
+	if outcome != models.TransactionStatusRefunded {
+		t.Fatalf("reconciler outcome = %q", outcome)
+	}
+	initializers.Db.Where("id = ?", txID).First(transaction)
+	if transaction.RefundID != paidOut.RefundID || transaction.RefundClawback != pkg.CreditAmount || transaction.RefundReason != "interrupted" {
+		t.Fatalf("completed refund = %+v, want refund %s", transaction, paidOut.RefundID)
+	}
+	initializers.Db.Where("id = ?", user.ID).First(user)
+	if user.Credit != 0 {
+		t.Fatalf("credit after refund = %d, want 0", user.Credit)
+	}
+
+	// Refunding again is refused
+	if _, err := refundTransaction(txID, RefundOptions{AdminID: "test", Reason: "again"}); !errors.Is(err, errNotRefundable) {
+		t.Fatalf("second refund: %v", err)
+	}
+}
+
+func TestRefundRevertsWhenProviderRefuses(t *testing.T) {
+	paymentTestDB(t)
+	user, pkg := paymentTestCustomer(t)
+	app := paymentTestApp(user)
+	txID := buyPackage(t, app, pkg)
+
+	// The fake refuses refunds larger than the bill, and still reports it paid
+	initializers.Db.Model(&models.Transaction{}).Where("id = ?", txID).Update("amount", pkg.Price*2)
+	if _, err := refundTransaction(txID, RefundOptions{AdminID: "test", Reason: "too much"}); err == nil {
+		t.Fatal("refund beyond the paid amount succeeded")
+	}
+	var transaction models.Transaction
+	initializers.Db.Where("id = ?", txID).First(&transaction)
+	if transaction.Status != models.TransactionStatusSettlement || transaction.RefundID != "" {
+		t.Fatalf("refused refund left the transaction as %+v", transaction)
+	}
+	initializers.Db.Where("id = ?", user.ID).First(user)
+	if user.Credit != pkg.CreditAmount {
+		t.Fatalf("credit after a refused refund = %d, want %d", user.Credit, pkg.CreditAmount)
+	}
+}
+
+func TestPaymentWithWrongAmountIsHeldForRefund(t *testing.T) {
+	paymentTestDB(t)
+	user, pkg := paymentTestCustomer(t)
+	app := paymentTestApp(user)
+	txID := checkoutPackage(t, app, pkg)
+
+	// Underpaying closes the bill without granting anything and flags the money for a refund
+	if status := sendFakeCallback(t, app, txID, services.PaymentStatusPaid, pkg.Price-5000, testPaymentSecret); status != "needs_refund" {
+		t.Fatalf("underpaid callback: status %q", status)
+	}
+	var transaction models.Transaction
+	initializers.Db.Where("id = ?", txID).First(&transaction)
+	if transaction.Status != models.TransactionStatusFailed || transaction.PaidAmount != pkg.Price-5000 ||
+		!strings.HasPrefix(transaction.PaymentIssue, "amount mismatch") {
+		t.Fatalf("underpaid transaction = %+v", transaction)
+	}
+	initializers.Db.Where("id = ?", user.ID).First(user)
+	if user.Credit != 0 {
+		t.Fatalf("credit after an underpayment = %d, want 0", user.Credit)
+	}
+
+	// The reconciler leaves it alone; it is no longer waiting for payment
+	run, err := ReconcilePendingTransactions("test")
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	initializers.Db.Delete(run)
+	for _, item := range run.Items {
+		if item.TransactionID == txID {
+			t.Fatalf("reconciler picked up the flagged transaction: %+v", item)
+		}
+	}
+
+	// Refunding returns what was paid, not what was billed
+	refunded, err := refundTransaction(txID, RefundOptions{AdminID: "test", Reason: "amount mismatch"})
+	if err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	if refunded.Status != models.TransactionStatusRefunded || refunded.RefundID == "" || refunded.RefundClawback != 0 {
+		t.Fatalf("refunded transaction = %+v", refunded)
+	}
+}
@@ -0,0 +1,317 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReferralController shows users their invite link and who signed up with it
+type ReferralController struct{}
+
+// errReferralCodeNotFound is returned for a sign-up with an unknown invite code
+var errReferralCodeNotFound = errors.New("referral code not found")
+
+// Reasons a referral is rejected instead of rewarded
+const (
+	referralRejectIPLimit          = "too many referred sign-ups from this IP address"
+	referralRejectDomainLimit      = "too many referrals from this email domain"
+	referralRejectReferrerInactive = "the inviting account is deleted or unverified"
+	referralRejectEmailAlias       = "the invited email is an alias of the inviting user's email"
+)
+
+// generateReferralCode generates an 8-character invite code from the same alphabet as TV short codes
+func generateReferralCode() string {
+	code := make([]byte, 8)
+	for i := range code {
+		n, _ := rand.Int(rand.Reader, big.NewInt(int64(len(shortCodeChars))))
+		code[i] = shortCodeChars[n.Int64()]
+	}
+	return string(code)
+}
+
+// ensureReferralCode returns the user's invite code, assigning one on first use
+func ensureReferralCode(user *models.User) (string, error) {
+	if user.ReferralCode != nil {
+		return *user.ReferralCode, nil
+	}
+	for i := 0; i < 5; i++ {
+		code := generateReferralCode()
+		result := initializers.Db.Model(&models.User{}).
+			Where("id = ? AND referral_code IS NULL", user.ID).
+			Update("referral_code", code)
+		if result.Error != nil {
+			// Another user already has the code; try a new one
+			continue
+		}
+		if result.RowsAffected == 0 {
+			// Assigned concurrently by another request
+			var current models.User
+			if err := initializers.Db.Select("referral_code").Where("id = ?", user.ID).First(&current).Error; err != nil {
+				return "", err
+			}
+			if current.ReferralCode == nil {
+				return "", errors.New("referral code not assigned")
+			}
+			code = *current.ReferralCode
+		}
+		user.ReferralCode = &code
+		return code, nil
+	}
+	return "", errors.New("could not generate a unique referral code")
+}
+
+// emailDomain returns the lower-cased domain of an email address
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(email[at+1:]))
+}
+
+// emailLocalBase strips "+tag" and dots from the local part, so "a.b+x@" and "ab@" compare equal
+func emailLocalBase(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	local := strings.ToLower(email[:at])
+	if plus := strings.Index(local, "+"); plus >= 0 {
+		local = local[:plus]
+	}
+	return strings.ReplaceAll(local, ".", "")
+}
+
+// findReferrer looks up the owner of an invite code
+func findReferrer(code string) (*models.User, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	var referrer models.User
+	if err := initializers.Db.Where("referral_code = ?", code).First(&referrer).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errReferralCodeNotFound
+		}
+		return nil, err
+	}
+	return &referrer, nil
+}
+
+// recordReferral stores the referral of a newly registered user. Sign-ups that trip the anti-abuse
+// checks are kept as rejected, so admins can see them, and never pay out.
+func recordReferral(referrer *models.User, referred *models.User, ip string) (*models.Referral, error) {
+	referral := models.Referral{
+		ID:          generateID(),
+		ReferrerID:  referrer.ID,
+		ReferredID:  referred.ID,
+		Code:        *referrer.ReferralCode,
+		Status:      models.ReferralStatusPending,
+		SignupIP:    ip,
+		EmailDomain: emailDomain(referred.Email),
+	}
+
+	maxPerIP, _ := strconv.Atoi(GetConfigValue(models.ConfigReferralMaxPerIP, "3"))
+	maxPerDomain, _ := strconv.Atoi(GetConfigValue(models.ConfigReferralMaxDomain, "5"))
+
+	switch {
+	case referrer.AnonymizedAt != nil || !referrer.IsEmailVerified():
+		referral.RejectReason = referralRejectReferrerInactive
+	case referral.EmailDomain == emailDomain(referrer.Email) && emailLocalBase(referred.Email) == emailLocalBase(referrer.Email):
+		referral.RejectReason = referralRejectEmailAlias
+	}
+
+	if referral.RejectReason == "" && maxPerIP > 0 {
+		var fromIP int64
+		initializers.Db.Model(&models.Referral{}).
+			Where("signup_ip = ? AND created_at > ?", ip, time.Now().Add(-24*time.Hour)).
+			Count(&fromIP)
+		if fromIP >= int64(maxPerIP) {
+			referral.RejectReason = referralRejectIPLimit
+		}
+	}
+	if referral.RejectReason == "" && maxPerDomain > 0 {
+		var fromDomain int64
+		initializers.Db.Model(&models.Referral{}).
+			Where("referrer_id = ? AND email_domain = ? AND status <> ?", referrer.ID, referral.EmailDomain, models.ReferralStatusRejected).
+			Count(&fromDomain)
+		if fromDomain >= int64(maxPerDomain) {
+			referral.RejectReason = referralRejectDomainLimit
+		}
+	}
+	if referral.RejectReason != "" {
+		referral.Status = models.ReferralStatusRejected
+	}
+
+	if err := initializers.Db.Create(&referral).Error; err != nil {
+		return nil, err
+	}
+	return &referral, nil
+}
+
+// rewardReferral pays out the referred user's pending referral once they qualify: a verified email and,
+// when referral_require_purchase is on, a paid purchase. purchased is true when called while settling one.
+// The referral row is locked until db's transaction ends, and the credit postings are idempotent.
+func rewardReferral(db *gorm.DB, referredID string, purchased bool) error {
+	var referral models.Referral
+	err := db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("referred_id = ? AND status = ?", referredID, models.ReferralStatusPending).
+		First(&referral).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var referred models.User
+	if err := db.Where("id = ?", referredID).First(&referred).Error; err != nil {
+		return err
+	}
+	if !referred.IsEmailVerified() {
+		return nil
+	}
+	if GetConfigValue(models.ConfigReferralPurchase, "false") == "true" && !purchased {
+		var paid int64
+		db.Model(&models.Transaction{}).
+			Where("user_id = ? AND amount > 0 AND status = ?", referredID, models.TransactionStatusSettlement).
+			Count(&paid)
+		if paid == 0 {
+			return nil
+		}
+	}
+
+	referrerReward, _ := strconv.Atoi(GetConfigValue(models.ConfigReferrerCredits, "5"))
+	referredReward, _ := strconv.Atoi(GetConfigValue(models.ConfigReferredCredits, "5"))
+
+	if referrerReward > 0 {
+		if _, err := services.PostCredits(db, services.CreditPosting{
+			UserID:         referral.ReferrerID,
+			Type:           models.CreditTypeReferral,
+			ReferenceID:    referral.ID,
+			Description:    "Referral reward: " + referred.Username + " joined",
+			IdempotencyKey: "referral:" + referral.ID + ":referrer",
+			Extra:          referrerReward,
+		}); err != nil {
+			return err
+		}
+	}
+	if referredReward > 0 {
+		if _, err := services.PostCredits(db, services.CreditPosting{
+			UserID:         referral.ReferredID,
+			Type:           models.CreditTypeReferral,
+			ReferenceID:    referral.ID,
+			Description:    "Welcome bonus for joining with an invite",
+			IdempotencyKey: "referral:" + referral.ID + ":referred",
+			Extra:          referredReward,
+		}); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	if err := db.Model(&models.Referral{}).Where("id = ?", referral.ID).Updates(map[string]interface{}{
+		"status":          models.ReferralStatusRewarded,
+		"referrer_reward": referrerReward,
+		"referred_reward": referredReward,
+		"rewarded_at":     now,
+	}).Error; err != nil {
+		return err
+	}
+	fmt.Printf("[referral] Rewarded referral %s (%d credits to %s, %d credits to %s)\n",
+		referral.ID, referrerReward, referral.ReferrerID, referredReward, referral.ReferredID)
+	return nil
+}
+
+// rewardReferralAfterVerification pays out a pending referral once the user's email is verified,
+// logging instead of failing the verification
+func rewardReferralAfterVerification(userID string) {
+	err := initializers.Db.Transaction(func(tx *gorm.DB) error {
+		return rewardReferral(tx, userID, false)
+	})
+	if err != nil {
+		log.Printf("[referral] failed to reward referral of user %s: %v", userID, err)
+	}
+}
+
+// maskName shows the first letter of a name, e.g. "Budi Santoso" -> "B***"
+func maskName(name string) string {
+	for _, r := range name {
+		return string(r) + "***"
+	}
+	return "***"
+}
+
+// Stats returns the user's invite link and the sign-ups it brought in
+func (c *ReferralController) Stats(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	code, err := ensureReferralCode(user)
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to create referral code"})
+	}
+
+	var referrals []models.Referral
+	initializers.Db.Preload("Referred").
+		Where("referrer_id = ?", user.ID).
+		Order("created_at DESC").
+		Find(&referrals)
+
+	var pending, rewarded, rejected, creditsEarned int
+	items := make([]fiber.Map, 0, len(referrals))
+	for _, referral := range referrals {
+		switch referral.Status {
+		case models.ReferralStatusPending:
+			pending++
+		case models.ReferralStatusRewarded:
+			rewarded++
+			creditsEarned += referral.ReferrerReward
+		case models.ReferralStatusRejected:
+			rejected++
+		}
+		name := ""
+		if referral.Referred != nil {
+			name = referral.Referred.Name
+		}
+		items = append(items, fiber.Map{
+			"name":        maskName(name),
+			"status":      referral.Status,
+			"reward":      referral.ReferrerReward,
+			"created_at":  referral.CreatedAt,
+			"rewarded_at": referral.RewardedAt,
+		})
+	}
+
+	referrerReward, _ := strconv.Atoi(GetConfigValue(models.ConfigReferrerCredits, "5"))
+	referredReward, _ := strconv.Atoi(GetConfigValue(models.ConfigReferredCredits, "5"))
+	// Without APP_URL the frontend prefixes the relative link with its own origin
+	link, _ := frontendURL("/register?ref=" + code)
+
+	return ctx.JSON(fiber.Map{
+		"code":              code,
+		"link":              link,
+		"referrer_reward":   referrerReward,
+		"referred_reward":   referredReward,
+		"requires_purchase": GetConfigValue(models.ConfigReferralPurchase, "false") == "true",
+		"total":             len(referrals),
+		"pending":           pending,
+		"rewarded":          rewarded,
+		"rejected":          rejected,
+		"credits_earned":    creditsEarned,
+		"referrals":         items,
+	})
+}
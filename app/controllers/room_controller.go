@@ -3,15 +3,22 @@ package controllers
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
+	"time"
 
 	"GoFiberMVC/app/initializers"
 	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 type RoomController struct{}
 
+// How long a websocket ticket can wait before the socket is opened with it
+const websocketTicketDuration = 30 * time.Second
+
 type CreateRoomRequest struct {
 	Name string `json:"name"`
 }
@@ -58,6 +65,10 @@ func (c *RoomController) Create(ctx *fiber.Ctx) error {
 		return ctx.Status(400).JSON(fiber.Map{"error": "Room name is required"})
 	}
 
+	if !user.IsEmailVerified() {
+		return ctx.Status(403).JSON(fiber.Map{"error": "Please verify your email before creating rooms", "email_verified": false})
+	}
+
 	// Reset free credits if needed
 	ResetFreeCreditIfNeeded(user)
 
@@ -95,30 +106,37 @@ func (c *RoomController) Create(ctx *fiber.Ctx) error {
 		MaxDuration: maxDuration,
 	}
 
-	// Deduct credits (free first, then extra)
-	if !user.DeductCredits(creationCost) {
-		return ctx.Status(400).JSON(fiber.Map{"error": "Insufficient credits"})
+	// Deduct credits (free first, then extra) and create the room in one transaction.
+	// A retried request with the same Idempotency-Key returns the room it already paid for.
+	idempotencyKey := ""
+	if key := ctx.Get("Idempotency-Key"); key != "" {
+		idempotencyKey = "room:" + user.ID + ":" + key
 	}
-	if err := initializers.Db.Save(user).Error; err != nil {
-		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to deduct credits"})
+	err := initializers.Db.Transaction(func(tx *gorm.DB) error {
+		posted, err := services.PostCredits(tx, services.CreditPosting{
+			UserID:         user.ID,
+			Type:           models.CreditTypeRoomCreation,
+			ReferenceID:    room.ID,
+			Description:    "Room creation: " + room.RoomName,
+			IdempotencyKey: idempotencyKey,
+			Spend:          creationCost,
+		})
+		if err != nil {
+			return err
+		}
+		if posted.Duplicate {
+			if err := tx.Where("id = ?", posted.Journal.ReferenceID).First(&room).Error; err != nil {
+				return err
+			}
+			maxDuration = room.MaxDuration
+			return nil
+		}
+		return tx.Create(&room).Error
+	})
+	if errors.Is(err, services.ErrInsufficientCredits) {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Insufficient credits"})
 	}
-
-	// Log credit deduction
-	creditLog := models.CreditLog{
-		ID:          generateRoomID(),
-		UserID:      user.ID,
-		Amount:      -creationCost,
-		Balance:     user.TotalCredits(),
-		Type:        models.CreditTypeRoomCreation,
-		ReferenceID: room.ID,
-		Description: "Room creation: " + room.RoomName,
-	}
-	initializers.Db.Create(&creditLog)
-
-	if err := initializers.Db.Create(&room).Error; err != nil {
-		// Refund credits on failure (add back to free first if was deducted from extra)
-		user.FreeCredit += creationCost
-		initializers.Db.Save(user)
+	if err != nil {
 		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to create room"})
 	}
 
@@ -233,6 +251,33 @@ func (c *RoomController) CheckAccess(ctx *fiber.Ctx) error {
 	})
 }
 
+// WebsocketTicket issues a one-time ticket for signing the room's websocket in as the current user.
+// Browsers can't set headers on a websocket, and a ticket in the URL is worthless once used.
+func (c *RoomController) WebsocketTicket(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var room models.Room
+	if err := initializers.Db.Where("room_key = ?", ctx.Params("roomKey")).First(&room).Error; err != nil {
+		return ctx.Status(404).JSON(fiber.Map{"error": "Room not found"})
+	}
+	if room.IsExpired(GetRoomMaxDuration()) {
+		return ctx.Status(410).JSON(fiber.Map{"error": "Room has expired"})
+	}
+
+	// Each device gets its own ticket, so earlier unused ones are left alone
+	ticket, err := createAccountToken(user.ID, models.AccountTokenWebsocket, room.RoomKey, websocketTicketDuration)
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to issue websocket ticket"})
+	}
+	return ctx.JSON(fiber.Map{
+		"ticket":     ticket,
+		"expires_in": int(websocketTicketDuration.Seconds()),
+	})
+}
+
 func getUserName(user *models.User) string {
 	if user == nil {
 		return ""
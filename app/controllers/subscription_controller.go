@@ -0,0 +1,791 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SubscriptionController lets users see their subscription, change plans and turn off auto-renewal
+type SubscriptionController struct{}
+
+// renewalBatchSize caps the renewal bills created per run; the rest are picked up by the next run
+const renewalBatchSize = 100
+
+// renewalMu keeps renewal runs in this process from overlapping
+var renewalMu sync.Mutex
+
+// errRenewalNotDue is returned when the user no longer needs a renewal bill
+var errRenewalNotDue = errors.New("renewal is not due")
+
+// renewalLeadTime is how long before expiry the renewal bill is created
+func renewalLeadTime() time.Duration {
+	days, err := strconv.Atoi(GetConfigValue(models.ConfigRenewalLeadDays, "3"))
+	if err != nil || days < 0 {
+		days = 3
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// renewalGracePeriod is how long the plan stays active after expiry while the renewal is unpaid
+func renewalGracePeriod() time.Duration {
+	days, err := strconv.Atoi(GetConfigValue(models.ConfigRenewalGraceDays, "3"))
+	if err != nil || days < 0 {
+		days = 3
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// recordSubscriptionEvent appends to the user's subscription history
+func recordSubscriptionEvent(db *gorm.DB, userID string, planID *string, event string, transactionID *string, expiresAt *time.Time, detail string) error {
+	return db.Create(&models.SubscriptionEvent{
+		ID:            generateID(),
+		UserID:        userID,
+		PlanID:        planID,
+		Event:         event,
+		TransactionID: transactionID,
+		ExpiresAt:     expiresAt,
+		Detail:        detail,
+	}).Error
+}
+
+// lapseSubscription moves a user whose subscription and grace period are over back to the free plan.
+// user must be locked in db's transaction.
+func lapseSubscription(db *gorm.DB, user *models.User) error {
+	if user.SubscriptionPlanID == nil || user.HasActiveSubscription() {
+		return nil
+	}
+	if err := db.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"subscription_plan_id":     nil,
+		"subscription_expires_at":  nil,
+		"subscription_grace_until": nil,
+		"auto_renew":               false,
+		"pending_plan_id":          nil,
+		"pending_plan_at":          nil,
+	}).Error; err != nil {
+		return err
+	}
+	if err := recordSubscriptionEvent(db, user.ID, user.SubscriptionPlanID, models.SubscriptionEventLapsed, nil, user.SubscriptionExpiresAt, ""); err != nil {
+		return err
+	}
+	fmt.Printf("[Subscription] Subscription of user %s lapsed\n", user.ID)
+	user.SubscriptionPlanID = nil
+	user.SubscriptionExpiresAt = nil
+	user.SubscriptionGraceUntil = nil
+	user.AutoRenew = false
+	user.PendingPlanID = nil
+	user.PendingPlanAt = nil
+	return nil
+}
+
+// applyScheduledPlan switches the user to the plan of a scheduled downgrade once the period it was
+// scheduled after has ended and the next period is paid. An unpaid next period is left to the grace
+// period and lapseSubscription. user must be locked in db's transaction.
+func applyScheduledPlan(db *gorm.DB, user *models.User) error {
+	now := time.Now()
+	if user.PendingPlanID == nil || user.PendingPlanAt == nil || user.PendingPlanAt.After(now) {
+		return nil
+	}
+	if user.SubscriptionExpiresAt == nil || !user.SubscriptionExpiresAt.After(now) {
+		return nil
+	}
+	planID := *user.PendingPlanID
+	if err := db.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"subscription_plan_id": planID,
+		"pending_plan_id":      nil,
+		"pending_plan_at":      nil,
+	}).Error; err != nil {
+		return err
+	}
+	if err := recordSubscriptionEvent(db, user.ID, &planID, models.SubscriptionEventDowngraded, nil, user.SubscriptionExpiresAt, ""); err != nil {
+		return err
+	}
+	fmt.Printf("[Subscription] User %s moved to scheduled plan %s\n", user.ID, planID)
+	user.SubscriptionPlanID = &planID
+	user.PendingPlanID = nil
+	user.PendingPlanAt = nil
+	return nil
+}
+
+// ========================================
+// Plan Changes
+// ========================================
+
+// subscriptionPeriod is the user's subscription after a subscription transaction settles
+type subscriptionPeriod struct {
+	PlanID        string // plan active after settlement
+	ExpiresAt     time.Time
+	DailyCredits  int        // free credits for today, from the plan active after settlement
+	PendingPlanID *string    // plan of a scheduled downgrade that is still to come
+	PendingPlanAt *time.Time // when it takes over
+	ReplacedUntil *time.Time // for an upgrade: when the replaced plan would have expired
+	Event         string
+	Detail        string
+}
+
+// settleSubscriptionPeriod works out what paying for plan does to the user's subscription:
+//   - without an active subscription the plan starts now;
+//   - an upgrade replaces the plan it was quoted against straight away, for a full new period;
+//   - paying for the plan of a scheduled downgrade adds its period, and the current plan runs until
+//     the end of the period already paid for;
+//   - otherwise the period is added to the current expiry, including the renewal grace period, so a
+//     late renewal doesn't add the grace days. Buying the current plan drops a scheduled downgrade.
+//
+// An upgrade priced against a plan the user no longer has is rejected: its discount was for days that
+// are gone or already credited to another upgrade.
+func settleSubscriptionPeriod(db *gorm.DB, user *models.User, transaction *models.Transaction, plan *models.SubscriptionPlan, now time.Time) (subscriptionPeriod, error) {
+	period := subscriptionPeriod{
+		PlanID:       plan.ID,
+		ExpiresAt:    now.AddDate(0, 0, plan.BillingPeriodDays),
+		DailyCredits: plan.DailyFreeCredits,
+		Event:        models.SubscriptionEventActivated,
+		Detail:       plan.PlanName,
+	}
+	if transaction.FromPlanID != nil {
+		if !user.HasActiveSubscription() || *user.SubscriptionPlanID != *transaction.FromPlanID || !user.SubscriptionExpiresAt.After(now) {
+			return period, &settlementRejectedError{reason: "the upgrade was priced against a plan the user is no longer on"}
+		}
+	}
+	if !user.HasActiveSubscription() {
+		return period, nil
+	}
+
+	currentPlanID := *user.SubscriptionPlanID
+	expiresAt := *user.SubscriptionExpiresAt
+	switch {
+	case transaction.FromPlanID != nil:
+		// The old plan's unused days were credited against the price. A credit worth more than the
+		// new plan lengthens its period instead of being lost.
+		if surplus := transaction.ProrationCredit - transaction.ListAmount; surplus > 0 && plan.Price > 0 {
+			days := float64(plan.BillingPeriodDays) * float64(surplus) / float64(plan.Price)
+			period.ExpiresAt = period.ExpiresAt.Add(time.Duration(days * float64(24*time.Hour)))
+		}
+		period.ReplacedUntil = &expiresAt
+		period.Event = models.SubscriptionEventUpgraded
+
+	case user.PendingPlanID != nil && *user.PendingPlanID == plan.ID && currentPlanID != plan.ID && expiresAt.After(now):
+		period.PlanID = currentPlanID
+		period.ExpiresAt = expiresAt.AddDate(0, 0, plan.BillingPeriodDays)
+		period.PendingPlanID = user.PendingPlanID
+		period.PendingPlanAt = &expiresAt
+		var current models.SubscriptionPlan
+		if err := db.Where("id = ?", currentPlanID).First(&current).Error; err == nil {
+			period.DailyCredits = current.DailyFreeCredits
+		}
+		period.Event = models.SubscriptionEventRenewed
+		period.Detail = fmt.Sprintf("%s from %s", plan.PlanName, expiresAt.Format("2006-01-02"))
+
+	default:
+		period.ExpiresAt = expiresAt.AddDate(0, 0, plan.BillingPeriodDays)
+		period.Event = models.SubscriptionEventRenewed
+	}
+	return period, nil
+}
+
+// refundUpgrade undoes a settled upgrade: the user goes back to the replaced plan until it would have
+// expired, or to the free plan when that has passed. As with other refunds, nothing changes when the
+// user has moved to another plan since.
+func refundUpgrade(tx *gorm.DB, user *models.User, transaction *models.Transaction, posting *services.CreditPosting) error {
+	if transaction.PlanID == nil || user.SubscriptionPlanID == nil || *user.SubscriptionPlanID != *transaction.PlanID || user.SubscriptionExpiresAt == nil {
+		return nil
+	}
+	var previous models.SubscriptionPlan
+	if err := tx.Where("id = ?", *transaction.FromPlanID).First(&previous).Error; err != nil {
+		return fmt.Errorf("previous plan not found: %w", err)
+	}
+
+	// Older upgrades had it filled in from the subscription history by the migration. Without it
+	// there is nothing to give back, and the user returns to the free plan.
+	now := time.Now()
+	restoreUntil := transaction.FromExpiresAt
+
+	transaction.RefundDays = 0
+	if user.SubscriptionExpiresAt.After(now) {
+		transaction.RefundDays = int(user.SubscriptionExpiresAt.Sub(now).Hours()/24 + 0.5)
+	}
+
+	updates := map[string]interface{}{
+		"subscription_grace_until": nil,
+		"pending_plan_id":          nil,
+		"pending_plan_at":          nil,
+	}
+	dailyCredits := previous.DailyFreeCredits
+	event := models.SubscriptionEventDowngraded
+	if restoreUntil != nil && restoreUntil.After(now) {
+		updates["subscription_plan_id"] = previous.ID
+		updates["subscription_expires_at"] = *restoreUntil
+	} else {
+		event = models.SubscriptionEventLapsed
+		// The replaced plan's days are used up as well: back to the free plan allowance
+		updates["subscription_plan_id"] = nil
+		updates["subscription_expires_at"] = nil
+		updates["auto_renew"] = false
+		dailyCredits = GetDefaultFreeCredits()
+	}
+	if user.FreeCredit > dailyCredits {
+		posting.SetFree = &dailyCredits
+	}
+	if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(updates).Error; err != nil {
+		return err
+	}
+	return recordSubscriptionEvent(tx, user.ID, &previous.ID, event, &transaction.ID, restoreUntil, "upgrade refunded")
+}
+
+// comparePlanRates compares the plans' price per day: negative when a is cheaper than b
+func comparePlanRates(a *models.SubscriptionPlan, b *models.SubscriptionPlan) int {
+	left := a.Price * int64(b.BillingPeriodDays)
+	right := b.Price * int64(a.BillingPeriodDays)
+	switch {
+	case left < right:
+		return -1
+	case left > right:
+		return 1
+	}
+	return 0
+}
+
+// unusedPlanValue is what the time left until expiresAt is worth at the plan's price, in IDR
+func unusedPlanValue(plan *models.SubscriptionPlan, expiresAt time.Time, now time.Time) int64 {
+	if plan.BillingPeriodDays <= 0 || !expiresAt.After(now) {
+		return 0
+	}
+	period := float64(plan.BillingPeriodDays) * float64(24*time.Hour)
+	return int64(float64(plan.Price) * float64(expiresAt.Sub(now)) / period)
+}
+
+// planChangeError explains why the subscription can't change plans; the message is shown to the user
+type planChangeError struct {
+	status               int
+	message              string
+	pendingTransactionID string // the unpaid renewal or upgrade bill that blocks the change
+}
+
+func (e *planChangeError) Error() string {
+	return e.message
+}
+
+// planChangeErrorResponse answers a plan change that was refused
+func planChangeErrorResponse(ctx *fiber.Ctx, err *planChangeError) error {
+	response := fiber.Map{"error": err.message}
+	if err.pendingTransactionID != "" {
+		response["pending_transaction_id"] = err.pendingTransactionID
+	}
+	return ctx.Status(err.status).JSON(response)
+}
+
+// planChangeCheck loads the user's current plan and the visible target plan (when planID is set), and
+// checks that the subscription can change: it must be active (not in its grace period), and no renewal
+// bill (which is for the plan being changed) or other upgrade bill may be waiting
+func planChangeCheck(user *models.User, planID string) (*models.SubscriptionPlan, *models.SubscriptionPlan, error) {
+	if user.SubscriptionPlanID == nil || user.SubscriptionExpiresAt == nil || !user.SubscriptionExpiresAt.After(time.Now()) {
+		return nil, nil, &planChangeError{status: 409, message: "You don't have an active subscription; choose a plan on the packages page"}
+	}
+
+	var renewal models.Transaction
+	if err := initializers.Db.Select("id").
+		Where("user_id = ? AND renewal = ? AND status = ?", user.ID, true, models.TransactionStatusPending).
+		First(&renewal).Error; err == nil {
+		return nil, nil, &planChangeError{
+			status:               409,
+			message:              "Please pay your renewal bill before changing plans",
+			pendingTransactionID: renewal.ID,
+		}
+	}
+
+	// The unused days of the current plan can only be credited to one upgrade bill
+	var upgrade models.Transaction
+	if err := initializers.Db.Select("id").
+		Where("user_id = ? AND from_plan_id IS NOT NULL AND status = ?", user.ID, models.TransactionStatusPending).
+		First(&upgrade).Error; err == nil {
+		return nil, nil, &planChangeError{
+			status:               409,
+			message:              "You already have an upgrade waiting for payment",
+			pendingTransactionID: upgrade.ID,
+		}
+	}
+
+	var current models.SubscriptionPlan
+	if err := initializers.Db.Where("id = ?", *user.SubscriptionPlanID).First(&current).Error; err != nil {
+		return nil, nil, &planChangeError{status: 404, message: "Current plan not found"}
+	}
+	if planID == "" {
+		return &current, nil, nil
+	}
+	var target models.SubscriptionPlan
+	if err := initializers.Db.Where("id = ? AND visibility = ?", planID, true).First(&target).Error; err != nil {
+		return nil, nil, &planChangeError{status: 404, message: "Subscription plan not found"}
+	}
+	if target.ID == current.ID {
+		return nil, nil, &planChangeError{status: 400, message: "You are already on this plan"}
+	}
+	return &current, &target, nil
+}
+
+// Upgrade moves the user to a plan with a higher price per day straight away. The unused days of the
+// current plan are credited against the new plan's price, and the difference is paid like any purchase.
+// With preview set, only the quote is returned.
+func (c *SubscriptionController) Upgrade(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req struct {
+		PlanID  string `json:"plan_id"`
+		Preview bool   `json:"preview"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.PlanID == "" {
+		return ctx.Status(400).JSON(fiber.Map{"error": "plan_id is required"})
+	}
+
+	current, target, err := planChangeCheck(user, req.PlanID)
+	var rejected *planChangeError
+	if errors.As(err, &rejected) {
+		return planChangeErrorResponse(ctx, rejected)
+	}
+	if comparePlanRates(target, current) <= 0 {
+		return ctx.Status(400).JSON(fiber.Map{"error": "This plan is not an upgrade; schedule a downgrade instead"})
+	}
+
+	credit := unusedPlanValue(current, *user.SubscriptionExpiresAt, time.Now())
+	item := checkoutItem{
+		Name:   "Upgrade: " + current.PlanName + " to " + target.PlanName,
+		Price:  target.Price,
+		TxType: models.TxTypeSubscription,
+		PlanID: &target.ID,
+	}
+	transaction := item.transaction(user.ID)
+	transaction.FromPlanID = &current.ID
+	transaction.ProrationCredit = credit
+	fromExpiresAt := *user.SubscriptionExpiresAt
+	transaction.FromExpiresAt = &fromExpiresAt
+	transaction.Amount = target.Price - credit
+	if transaction.Amount < 0 {
+		transaction.Amount = 0
+	}
+
+	if req.Preview {
+		return ctx.JSON(fiber.Map{
+			"product":          item.Name,
+			"current_plan":     current.PlanName,
+			"plan":             target.PlanName,
+			"list_amount":      transaction.ListAmount,
+			"proration_credit": credit,
+			"amount":           transaction.Amount,
+		})
+	}
+	return (&PaymentController{}).checkout(ctx, user, item.Name, transaction, "")
+}
+
+// Downgrade schedules a plan with a lower price per day for the next period. The current plan runs
+// until it expires, and auto-renewal bills the new plan.
+func (c *SubscriptionController) Downgrade(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req struct {
+		PlanID string `json:"plan_id"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.PlanID == "" {
+		return ctx.Status(400).JSON(fiber.Map{"error": "plan_id is required"})
+	}
+
+	current, target, err := planChangeCheck(user, req.PlanID)
+	var rejected *planChangeError
+	if errors.As(err, &rejected) {
+		return planChangeErrorResponse(ctx, rejected)
+	}
+	if comparePlanRates(target, current) >= 0 {
+		return ctx.Status(400).JSON(fiber.Map{"error": "This plan is not a downgrade; upgrade instead"})
+	}
+	if target.Price <= 0 {
+		return ctx.Status(400).JSON(fiber.Map{"error": "To move to the free plan, cancel auto-renewal"})
+	}
+
+	takesEffect := *user.SubscriptionExpiresAt
+	err = initializers.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"pending_plan_id": target.ID,
+			"pending_plan_at": takesEffect,
+			"auto_renew":      true, // the new plan is billed by the renewal job
+		}).Error; err != nil {
+			return err
+		}
+		return recordSubscriptionEvent(tx, user.ID, &target.ID, models.SubscriptionEventDowngradeScheduled, nil, &takesEffect,
+			current.PlanName+" to "+target.PlanName)
+	})
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to schedule downgrade"})
+	}
+
+	return ctx.JSON(fiber.Map{
+		"message":      fmt.Sprintf("Your plan changes to %s on %s", target.PlanName, takesEffect.Format("2 January 2006")),
+		"pending_plan": target.PlanName,
+		"takes_effect": takesEffect,
+		"auto_renew":   true,
+	})
+}
+
+// CancelDowngrade keeps the current plan for the next period
+func (c *SubscriptionController) CancelDowngrade(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	if user.PendingPlanID == nil {
+		return ctx.Status(409).JSON(fiber.Map{"error": "No downgrade is scheduled"})
+	}
+	current, _, err := planChangeCheck(user, "")
+	var rejected *planChangeError
+	if errors.As(err, &rejected) {
+		return planChangeErrorResponse(ctx, rejected)
+	}
+
+	pendingPlanID := *user.PendingPlanID
+	err = initializers.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"pending_plan_id": nil,
+			"pending_plan_at": nil,
+		}).Error; err != nil {
+			return err
+		}
+		return recordSubscriptionEvent(tx, user.ID, &pendingPlanID, models.SubscriptionEventDowngradeCancelled, nil, user.SubscriptionExpiresAt, "")
+	})
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to cancel downgrade"})
+	}
+	return ctx.JSON(fiber.Map{"message": "Scheduled downgrade cancelled, you keep " + current.PlanName})
+}
+
+// ========================================
+// Renewal Job
+// ========================================
+
+// StartSubscriptionRenewer bills and lapses subscriptions every interval until the process exits.
+// A non-positive interval or a missing APP_URL disables the worker.
+func StartSubscriptionRenewer(interval time.Duration) {
+	if interval <= 0 {
+		fmt.Println("[Subscription] Renewal worker disabled")
+		return
+	}
+	// Renewal bills carry links to the payment status page in the provider redirect and the email
+	if _, err := frontendURL(""); err != nil {
+		fmt.Printf("[Subscription] ERROR: renewal worker disabled: %v\n", err)
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			billed, lapsed, err := RenewSubscriptions()
+			if err != nil {
+				fmt.Printf("[Subscription] Renewal run failed: %v\n", err)
+				continue
+			}
+			if billed > 0 || lapsed > 0 {
+				fmt.Printf("[Subscription] Renewal run: billed=%d lapsed=%d\n", billed, lapsed)
+			}
+		}
+	}()
+}
+
+// RenewSubscriptions creates renewal bills for auto-renewing subscriptions that expire within the lead
+// time, switches paid-up users to their scheduled downgrade, and moves subscriptions past their grace
+// period back to the free plan. Each subscription period
+// gets one renewal bill; when it expires or fails, the plan lapses at the end of the grace period.
+func RenewSubscriptions() (billed int, lapsed int, err error) {
+	if !renewalMu.TryLock() {
+		return 0, 0, nil
+	}
+	defer renewalMu.Unlock()
+
+	now := time.Now()
+	lead := renewalLeadTime()
+
+	var due []models.User
+	if err := initializers.Db.
+		Where("auto_renew = ? AND anonymized_at IS NULL AND subscription_plan_id IS NOT NULL", true).
+		Where("subscription_expires_at > ? AND subscription_expires_at <= ?", now, now.Add(lead)).
+		Where("NOT EXISTS (SELECT 1 FROM transactions t WHERE t.user_id = users.id AND t.renewal = ? AND t.created_at >= users.subscription_expires_at - (? * INTERVAL '1 second'))",
+			true, int64(lead.Seconds())).
+		Limit(renewalBatchSize).
+		Find(&due).Error; err != nil {
+		return 0, 0, err
+	}
+	for i := range due {
+		if err := createRenewalBill(&due[i]); err != nil {
+			if !errors.Is(err, errRenewalNotDue) {
+				fmt.Printf("[Subscription] Failed to bill renewal for user %s: %v\n", due[i].ID, err)
+			}
+			continue
+		}
+		billed++
+	}
+
+	var scheduled []models.User
+	if err := initializers.Db.
+		Where("pending_plan_id IS NOT NULL AND pending_plan_at <= ? AND subscription_expires_at > ?", now, now).
+		Limit(renewalBatchSize).
+		Find(&scheduled).Error; err != nil {
+		return billed, 0, err
+	}
+	for i := range scheduled {
+		err := initializers.Db.Transaction(func(tx *gorm.DB) error {
+			var locked models.User
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", scheduled[i].ID).First(&locked).Error; err != nil {
+				return err
+			}
+			return applyScheduledPlan(tx, &locked)
+		})
+		if err != nil {
+			fmt.Printf("[Subscription] Failed to apply scheduled plan of user %s: %v\n", scheduled[i].ID, err)
+		}
+	}
+
+	var expired []models.User
+	if err := initializers.Db.
+		Where("subscription_plan_id IS NOT NULL AND subscription_expires_at <= ?", now).
+		Where("subscription_grace_until IS NULL OR subscription_grace_until <= ?", now).
+		Limit(renewalBatchSize).
+		Find(&expired).Error; err != nil {
+		return billed, 0, err
+	}
+	for i := range expired {
+		err := initializers.Db.Transaction(func(tx *gorm.DB) error {
+			var locked models.User
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", expired[i].ID).First(&locked).Error; err != nil {
+				return err
+			}
+			return lapseSubscription(tx, &locked)
+		})
+		if err != nil {
+			fmt.Printf("[Subscription] Failed to lapse subscription of user %s: %v\n", expired[i].ID, err)
+			continue
+		}
+		lapsed++
+	}
+	return billed, lapsed, nil
+}
+
+// createRenewalBill creates the bill for the user's next subscription period at the current price of
+// the plan, or of a scheduled downgrade's plan, and emails its payment link. The bill stays payable
+// until the end of the grace period.
+func createRenewalBill(user *models.User) error {
+	// A scheduled downgrade's plan is billed for the next period
+	planID := *user.SubscriptionPlanID
+	if user.PendingPlanID != nil {
+		planID = *user.PendingPlanID
+	}
+	var plan models.SubscriptionPlan
+	if err := initializers.Db.Where("id = ?", planID).First(&plan).Error; err != nil {
+		return fmt.Errorf("plan not found: %w", err)
+	}
+	if plan.Price <= 0 {
+		return errRenewalNotDue
+	}
+
+	provider, err := GetPaymentProvider("")
+	if err != nil {
+		return err
+	}
+
+	item := checkoutItem{
+		Name:   "Subscription renewal: " + plan.PlanName,
+		Price:  plan.Price,
+		TxType: models.TxTypeSubscription,
+		PlanID: &plan.ID,
+	}
+	transaction := item.transaction(user.ID)
+	graceUntil := user.SubscriptionExpiresAt.Add(renewalGracePeriod())
+	nextCheckAt := time.Now().Add(reconcileBackoff[0])
+	transaction.ID = generateTransactionID()
+	transaction.Status = models.TransactionStatusPending
+	transaction.PaymentMethod = provider.Name()
+	transaction.Provider = provider.Name()
+	transaction.ExpiresAt = &graceUntil
+	transaction.NextCheckAt = &nextCheckAt
+	transaction.Renewal = true
+
+	err = initializers.Db.Transaction(func(tx *gorm.DB) error {
+		// The user row lock keeps two runs from billing the same period
+		var locked models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", user.ID).First(&locked).Error; err != nil {
+			return err
+		}
+		if !locked.AutoRenew || locked.SubscriptionExpiresAt == nil || !locked.SubscriptionExpiresAt.Equal(*user.SubscriptionExpiresAt) {
+			return errRenewalNotDue
+		}
+		var pending int64
+		tx.Model(&models.Transaction{}).
+			Where("user_id = ? AND renewal = ? AND status = ?", user.ID, true, models.TransactionStatusPending).
+			Count(&pending)
+		if pending > 0 {
+			return errRenewalNotDue
+		}
+		if err := tx.Create(&transaction).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ?", user.ID).Update("subscription_grace_until", graceUntil).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	statusURL, _ := frontendURL("/payment/status/" + transaction.ID)
+	manageLink, _ := frontendURL("/")
+	bill, err := provider.CreateBill(services.BillRequest{
+		Reference:     transaction.ID,
+		Title:         item.Name,
+		Amount:        transaction.Amount,
+		CustomerName:  user.Name,
+		CustomerEmail: user.Email,
+		RedirectURL:   statusURL,
+		ExpiresAt:     graceUntil,
+	})
+	if err != nil {
+		// Leave the grace period in place; the next run bills the period again
+		initializers.Db.Delete(&transaction)
+		return fmt.Errorf("failed to create bill: %w", err)
+	}
+
+	transaction.ProviderRef = bill.ProviderRef
+	transaction.PaymentURL = bill.PaymentURL
+	transaction.ProviderData = bill.Data
+	initializers.Db.Save(&transaction)
+
+	if err := recordSubscriptionEvent(initializers.Db, user.ID, &plan.ID, models.SubscriptionEventRenewalBilled, &transaction.ID, user.SubscriptionExpiresAt, plan.PlanName); err != nil {
+		fmt.Printf("[Subscription] Failed to record renewal bill %s: %v\n", transaction.ID, err)
+	}
+
+	// Flip's popup needs the status page; the hosted payment page works on its own
+	link := transaction.PaymentURL
+	if link == "" {
+		link = statusURL
+	}
+	if err := services.SendTemplate(user.Email, "Renew your Karayouke subscription", "emails/subscription_renewal", fiber.Map{
+		"Name":       user.Name,
+		"PlanName":   plan.PlanName,
+		"Amount":     services.FormatIDR(transaction.Amount),
+		"ExpiresAt":  user.SubscriptionExpiresAt.Format("2 January 2006"),
+		"GraceUntil": graceUntil.Format("2 January 2006"),
+		"Link":       link,
+		"ManageLink": manageLink,
+	}); err != nil {
+		fmt.Printf("[Subscription] Failed to email renewal bill %s to user %s: %v\n", transaction.ID, user.ID, err)
+	}
+
+	fmt.Printf("[Subscription] Billed renewal %s for user %s (%s, %d IDR)\n", transaction.ID, user.ID, plan.PlanName, transaction.Amount)
+	return nil
+}
+
+// ========================================
+// User Endpoints
+// ========================================
+
+// Status returns the user's subscription, auto-renewal state, unpaid renewal bill and history
+func (c *SubscriptionController) Status(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	response := fiber.Map{
+		"active":       user.HasActiveSubscription(),
+		"plan":         nil,
+		"expires_at":   user.SubscriptionExpiresAt,
+		"grace_until":  user.SubscriptionGraceUntil,
+		"in_grace":     user.HasActiveSubscription() && user.SubscriptionExpiresAt != nil && !user.SubscriptionExpiresAt.After(time.Now()),
+		"auto_renew":   user.AutoRenew,
+		"renewal":      nil,
+		"pending_plan": nil,
+	}
+	if user.SubscriptionPlanID != nil {
+		var plan models.SubscriptionPlan
+		if err := initializers.Db.Where("id = ?", *user.SubscriptionPlanID).First(&plan).Error; err == nil {
+			response["plan"] = fiber.Map{"id": plan.ID, "plan_name": plan.PlanName, "price": plan.Price}
+		}
+	}
+	if user.PendingPlanID != nil {
+		var plan models.SubscriptionPlan
+		if err := initializers.Db.Where("id = ?", *user.PendingPlanID).First(&plan).Error; err == nil {
+			response["pending_plan"] = fiber.Map{"id": plan.ID, "plan_name": plan.PlanName, "price": plan.Price, "takes_effect": user.PendingPlanAt}
+		}
+	}
+
+	var renewal models.Transaction
+	if err := initializers.Db.
+		Where("user_id = ? AND renewal = ? AND status = ?", user.ID, true, models.TransactionStatusPending).
+		Order("created_at DESC").
+		First(&renewal).Error; err == nil {
+		response["renewal"] = fiber.Map{
+			"transaction_id": renewal.ID,
+			"amount":         renewal.Amount,
+			"payment_url":    renewal.PaymentURL,
+			"expires_at":     renewal.ExpiresAt,
+		}
+	}
+
+	var events []models.SubscriptionEvent
+	initializers.Db.Where("user_id = ?", user.ID).Order("created_at DESC").Limit(20).Find(&events)
+	response["events"] = events
+
+	return ctx.JSON(response)
+}
+
+// CancelAutoRenew stops renewal billing. The plan stays active until it expires; an unpaid renewal
+// bill can still be paid to extend it.
+func (c *SubscriptionController) CancelAutoRenew(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	err := initializers.Db.Transaction(func(tx *gorm.DB) error {
+		var locked models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", user.ID).First(&locked).Error; err != nil {
+			return err
+		}
+		if locked.SubscriptionPlanID == nil || !locked.AutoRenew {
+			return errRenewalNotDue
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", locked.ID).Updates(map[string]interface{}{
+			"auto_renew":               false,
+			"subscription_grace_until": nil,
+		}).Error; err != nil {
+			return err
+		}
+		*user = locked
+		user.AutoRenew = false
+		user.SubscriptionGraceUntil = nil
+		return recordSubscriptionEvent(tx, locked.ID, locked.SubscriptionPlanID, models.SubscriptionEventCancelled, nil, locked.SubscriptionExpiresAt, "")
+	})
+	if errors.Is(err, errRenewalNotDue) {
+		return ctx.Status(409).JSON(fiber.Map{"error": "Auto-renewal is not active"})
+	}
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to cancel auto-renewal"})
+	}
+
+	return ctx.JSON(fiber.Map{
+		"message":    "Auto-renewal cancelled",
+		"auto_renew": false,
+		"expires_at": user.SubscriptionExpiresAt,
+	})
+}
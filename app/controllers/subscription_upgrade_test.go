@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestUnusedPlanValue(t *testing.T) {
+	plan := &models.SubscriptionPlan{Price: 30000, BillingPeriodDays: 30}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		left time.Duration
+		want int64
+	}{
+		{30 * 24 * time.Hour, 30000},
+		{15 * 24 * time.Hour, 15000},
+		{36 * time.Hour, 1500},
+		{time.Hour, 41}, // 1000 IDR a day, rounded down
+		{0, 0},
+		{-time.Hour, 0},
+	} {
+		if got := unusedPlanValue(plan, now.Add(tc.left), now); got != tc.want {
+			t.Errorf("%s left: %d IDR, want %d", tc.left, got, tc.want)
+		}
+	}
+	if got := unusedPlanValue(&models.SubscriptionPlan{Price: 30000}, now.Add(time.Hour), now); got != 0 {
+		t.Errorf("plan without a period: %d IDR, want 0", got)
+	}
+}
+
+func TestUpgradeCreditedOnceAndRefunded(t *testing.T) {
+	paymentTestDB(t)
+	user, _ := paymentTestCustomer(t)
+	basic := models.SubscriptionPlan{ID: generateID(), PlanName: "Basic", Price: 30000, BillingPeriodDays: 30, DailyFreeCredits: 2, Visibility: true}
+	pro := models.SubscriptionPlan{ID: generateID(), PlanName: "Pro", Price: 90000, BillingPeriodDays: 30, DailyFreeCredits: 5, Visibility: true}
+	for _, plan := range []*models.SubscriptionPlan{&basic, &pro} {
+		if err := initializers.Db.Create(plan).Error; err != nil {
+			t.Fatalf("create plan: %v", err)
+		}
+	}
+	basicUntil := time.Now().Add(15 * 24 * time.Hour)
+	initializers.Db.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"subscription_plan_id": basic.ID, "subscription_expires_at": basicUntil,
+	})
+	user.SubscriptionPlanID, user.SubscriptionExpiresAt = &basic.ID, &basicUntil
+	t.Cleanup(func() {
+		initializers.Db.Where("user_id = ?", user.ID).Delete(&models.SubscriptionEvent{})
+		initializers.Db.Delete(&basic)
+		initializers.Db.Delete(&pro)
+	})
+
+	app := paymentTestApp(user)
+	subscriptions := &SubscriptionController{}
+	app.Post("/upgrade", func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, user)
+		return subscriptions.Upgrade(ctx)
+	})
+	upgrade := func() map[string]interface{} {
+		return postJSON(t, app, "/upgrade", fmt.Sprintf(`{"plan_id":%q}`, pro.ID), nil)
+	}
+	pay := func(txID string) string {
+		var transaction models.Transaction
+		initializers.Db.Where("id = ?", txID).First(&transaction)
+		return sendFakeCallback(t, app, txID, services.PaymentStatusPaid, transaction.Amount, testPaymentSecret)
+	}
+
+	// Half of Basic is left: 15000 IDR off Pro, and the old expiry is on the bill from the start
+	first := upgrade()
+	firstID, _ := first["transaction_id"].(string)
+	var quoted models.Transaction
+	initializers.Db.Where("id = ?", firstID).First(&quoted)
+	if quoted.ProrationCredit < 14990 || quoted.ProrationCredit > 15000 || quoted.Amount != pro.Price-quoted.ProrationCredit {
+		t.Fatalf("upgrade quote: credit %d, amount %d", quoted.ProrationCredit, quoted.Amount)
+	}
+	if quoted.FromExpiresAt == nil || quoted.FromExpiresAt.Sub(basicUntil).Abs() > time.Second {
+		t.Fatalf("quoted replaced expiry %v, want %v", quoted.FromExpiresAt, basicUntil)
+	}
+
+	// The same days can't be quoted to a second upgrade while the first is open
+	if blocked := upgrade(); blocked["pending_transaction_id"] != firstID {
+		t.Fatalf("second upgrade while the first is unpaid = %v", blocked)
+	}
+
+	// Once the first bill lapses a new upgrade can be bought, and paying the old bill late no longer
+	// gets the credit: the user isn't on Basic any more
+	initializers.Db.Model(&models.Transaction{}).Where("id = ?", firstID).Update("status", models.TransactionStatusExpired)
+	second := upgrade()
+	secondID, _ := second["transaction_id"].(string)
+	if status := pay(secondID); status != "ok" {
+		t.Fatalf("paying the second upgrade: %q", status)
+	}
+	if status := pay(firstID); status != "needs_refund" {
+		t.Fatalf("late payment of the first upgrade: %q", status)
+	}
+	initializers.Db.Where("id = ?", user.ID).First(user)
+	if *user.SubscriptionPlanID != pro.ID || user.SubscriptionExpiresAt.Before(time.Now().Add(29*24*time.Hour)) {
+		t.Fatalf("after upgrading: plan %s until %v", *user.SubscriptionPlanID, user.SubscriptionExpiresAt)
+	}
+
+	// Refunding the upgrade gives Basic back until it would have run out
+	refunded, err := refundTransaction(secondID, RefundOptions{AdminID: "test", Reason: "upgrade refund"})
+	if err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	if refunded.RefundDays < 29 {
+		t.Fatalf("refund took back %d days, want the Pro period", refunded.RefundDays)
+	}
+	initializers.Db.Where("id = ?", user.ID).First(user)
+	if user.SubscriptionPlanID == nil || *user.SubscriptionPlanID != basic.ID || user.SubscriptionExpiresAt.Sub(basicUntil).Abs() > time.Second {
+		t.Fatalf("after the refund: plan %v until %v, want Basic until %v", user.SubscriptionPlanID, user.SubscriptionExpiresAt, basicUntil)
+	}
+}
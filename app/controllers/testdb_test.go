@@ -0,0 +1,26 @@
+package controllers
+
+import (
+	"os"
+	"testing"
+
+	"GoFiberMVC/app/initializers"
+)
+
+// testDB connects to the database named by TEST_DB_NAME (the other DB_* variables as usual) and
+// migrates the given tables. Tests using it are skipped when TEST_DB_NAME is unset, so they never
+// touch the development database.
+func testDB(t *testing.T, tables ...interface{}) {
+	t.Helper()
+	name := os.Getenv("TEST_DB_NAME")
+	if name == "" {
+		t.Skip("TEST_DB_NAME not set; skipping database-backed test")
+	}
+	t.Setenv("DB_NAME", name)
+	if err := initializers.DbConnection(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if err := initializers.Db.AutoMigrate(tables...); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"GoFiberMVC/app/models"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 type TVController struct{}
@@ -119,11 +120,58 @@ func (c *TVController) GetStatus(ctx *fiber.Ctx) error {
 		})
 	}
 
-	return ctx.JSON(fiber.Map{
+	resp := fiber.Map{
 		"connected": true,
 		"room_key":  tvToken.RoomKey,
 		"room_name": room.RoomName,
+	}
+
+	// Hand over the remembered-device token exactly once
+	if tvToken.PendingDeviceID != "" {
+		deviceToken, err := issuePendingDeviceToken(&tvToken)
+		if err != nil {
+			return ctx.Status(500).JSON(fiber.Map{"error": "Failed to issue device token"})
+		}
+		if deviceToken != "" {
+			resp["device_token"] = deviceToken
+		}
+	}
+	return ctx.JSON(resp)
+}
+
+// issuePendingDeviceToken generates the token of the TV's pending remembered device and stores only its
+// hash. Clearing the pending device claims the hand-over, so concurrent polls can't both receive a token;
+// the loser gets "".
+func issuePendingDeviceToken(tvToken *models.TVToken) (string, error) {
+	deviceToken := generateToken()
+	err := initializers.Db.Transaction(func(tx *gorm.DB) error {
+		claimed := tx.Model(&models.TVToken{}).
+			Where("id = ? AND pending_device_id = ?", tvToken.ID, tvToken.PendingDeviceID).
+			Update("pending_device_id", "")
+		if claimed.Error != nil {
+			return claimed.Error
+		}
+		if claimed.RowsAffected == 0 {
+			deviceToken = ""
+			return nil
+		}
+		updated := tx.Model(&models.TVDevice{}).
+			Where("id = ? AND revoked_at IS NULL", tvToken.PendingDeviceID).
+			Update("token_hash", hashToken(deviceToken))
+		if updated.Error != nil {
+			return updated.Error
+		}
+		if updated.RowsAffected == 0 {
+			// Forgotten before the TV picked it up
+			deviceToken = ""
+		}
+		return nil
 	})
+	if err != nil {
+		return "", err
+	}
+	tvToken.PendingDeviceID = ""
+	return deviceToken, nil
 }
 
 // Connect links a TV token to a room
@@ -135,8 +183,10 @@ func (c *TVController) Connect(ctx *fiber.Ctx) error {
 	}
 
 	var req struct {
-		Code    string `json:"code"` // Either QR token or short code
-		RoomKey string `json:"room_key"`
+		Code       string `json:"code"` // Either QR token or short code
+		RoomKey    string `json:"room_key"`
+		Remember   bool   `json:"remember"`    // Remember this TV so future rooms can be pushed to it
+		DeviceName string `json:"device_name"` // Display name for the remembered TV
 	}
 
 	if err := ctx.BodyParser(&req); err != nil {
@@ -194,6 +244,29 @@ func (c *TVController) Connect(ctx *fiber.Ctx) error {
 	// Extend expiry when connected (TV stays connected for longer)
 	tvToken.ExpiresAt = time.Now().Add(24 * time.Hour)
 
+	// Remember the TV: its device token is generated and handed over on the TV's next status poll.
+	// Until then the device holds the hash of a throwaway token that nobody knows.
+	var device *models.TVDevice
+	if req.Remember {
+		name := strings.TrimSpace(req.DeviceName)
+		if name == "" {
+			name = "TV " + tvToken.ShortCode
+		}
+		now := time.Now()
+		device = &models.TVDevice{
+			ID:         generateID(),
+			UserID:     user.ID,
+			Name:       name,
+			TokenHash:  hashToken(generateToken()),
+			RoomKey:    req.RoomKey,
+			LastSeenAt: &now,
+		}
+		if err := initializers.Db.Create(device).Error; err != nil {
+			return ctx.Status(500).JSON(fiber.Map{"error": "Failed to remember TV"})
+		}
+		tvToken.PendingDeviceID = device.ID
+	}
+
 	if err := initializers.Db.Save(&tvToken).Error; err != nil {
 		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to connect TV"})
 	}
@@ -202,6 +275,7 @@ func (c *TVController) Connect(ctx *fiber.Ctx) error {
 		"success":   true,
 		"room_key":  req.RoomKey,
 		"room_name": room.RoomName,
+		"device":    device,
 	})
 }
 
@@ -228,3 +302,177 @@ func (c *TVController) Disconnect(ctx *fiber.Ctx) error {
 		"success": true,
 	})
 }
+
+// ========================================
+// Remembered TV devices
+// ========================================
+
+// findDeviceByToken looks up a non-revoked remembered TV by its raw device token
+func findDeviceByToken(token string) (*models.TVDevice, bool) {
+	if token == "" {
+		return nil, false
+	}
+	var device models.TVDevice
+	if err := initializers.Db.Where("token_hash = ? AND revoked_at IS NULL", hashToken(token)).First(&device).Error; err != nil {
+		return nil, false
+	}
+	return &device, true
+}
+
+// AuthorizeTVForRoom reports whether a pairing token or remembered-device token belongs to a TV showing the room
+func AuthorizeTVForRoom(token string, roomKey string) bool {
+	if token == "" || roomKey == "" {
+		return false
+	}
+	var tvToken models.TVToken
+	if err := initializers.Db.Where("token = ? AND room_key = ? AND expires_at > ?", token, roomKey, time.Now()).First(&tvToken).Error; err == nil {
+		return true
+	}
+	device, ok := findDeviceByToken(token)
+	return ok && device.RoomKey == roomKey
+}
+
+// DeviceStatus is polled by a remembered TV (X-TV-Device-Token header) to learn which room to show
+// This endpoint does NOT require user authentication - the device token identifies the TV
+func (c *TVController) DeviceStatus(ctx *fiber.Ctx) error {
+	device, ok := findDeviceByToken(ctx.Get("X-TV-Device-Token"))
+	if !ok {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unknown or revoked device", "revoked": true})
+	}
+
+	now := time.Now()
+	device.LastSeenAt = &now
+	initializers.Db.Model(device).Update("last_seen_at", now)
+
+	resp := fiber.Map{
+		"device_id":   device.ID,
+		"device_name": device.Name,
+		"connected":   false,
+		"room_key":    nil,
+	}
+	if device.RoomKey == "" {
+		return ctx.JSON(resp)
+	}
+
+	var room models.Room
+	if err := initializers.Db.Where("room_key = ?", device.RoomKey).First(&room).Error; err != nil || room.IsExpired(GetRoomMaxDuration()) {
+		// Room is gone or over - wait for the owner to push the next one
+		initializers.Db.Model(device).Update("room_key", "")
+		return ctx.JSON(resp)
+	}
+
+	resp["connected"] = true
+	resp["room_key"] = room.RoomKey
+	resp["room_name"] = room.RoomName
+	return ctx.JSON(resp)
+}
+
+// ListDevices returns the authenticated user's remembered TVs
+func (c *TVController) ListDevices(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var devices []models.TVDevice
+	if err := initializers.Db.Where("user_id = ? AND revoked_at IS NULL", user.ID).Order("last_seen_at DESC NULLS LAST").Find(&devices).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to fetch devices"})
+	}
+	return ctx.JSON(devices)
+}
+
+// PushRoom sends a room to some (device_ids) or all of the user's remembered TVs in one call
+func (c *TVController) PushRoom(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req struct {
+		RoomKey   string   `json:"room_key"`
+		DeviceIDs []string `json:"device_ids"` // Empty = all remembered TVs
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.RoomKey == "" {
+		return ctx.Status(400).JSON(fiber.Map{"error": "room_key is required"})
+	}
+
+	var room models.Room
+	if err := initializers.Db.Where("room_key = ?", req.RoomKey).First(&room).Error; err != nil {
+		return ctx.Status(404).JSON(fiber.Map{"error": "Room not found"})
+	}
+	if room.RoomCreator != user.ID && room.RoomMaster != user.ID {
+		return ctx.Status(403).JSON(fiber.Map{"error": "You don't have permission to connect TV to this room"})
+	}
+	if room.IsExpired(GetRoomMaxDuration()) {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Room has expired"})
+	}
+
+	query := initializers.Db.Model(&models.TVDevice{}).Where("user_id = ? AND revoked_at IS NULL", user.ID)
+	if len(req.DeviceIDs) > 0 {
+		query = query.Where("id IN ?", req.DeviceIDs)
+	}
+	result := query.Update("room_key", room.RoomKey)
+	if result.Error != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to push room"})
+	}
+
+	return ctx.JSON(fiber.Map{
+		"success":   true,
+		"room_key":  room.RoomKey,
+		"room_name": room.RoomName,
+		"devices":   result.RowsAffected,
+	})
+}
+
+// RenameDevice changes the display name of a remembered TV
+func (c *TVController) RenameDevice(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Name is required"})
+	}
+
+	var device models.TVDevice
+	if err := initializers.Db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", ctx.Params("id"), user.ID).First(&device).Error; err != nil {
+		return ctx.Status(404).JSON(fiber.Map{"error": "Device not found"})
+	}
+	device.Name = name
+	if err := initializers.Db.Save(&device).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to rename device"})
+	}
+	return ctx.JSON(device)
+}
+
+// RevokeDevice forgets a remembered TV; its device token stops working immediately
+func (c *TVController) RevokeDevice(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var device models.TVDevice
+	if err := initializers.Db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", ctx.Params("id"), user.ID).First(&device).Error; err != nil {
+		return ctx.Status(404).JSON(fiber.Map{"error": "Device not found"})
+	}
+
+	now := time.Now()
+	device.RevokedAt = &now
+	device.RoomKey = ""
+	if err := initializers.Db.Save(&device).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to revoke device"})
+	}
+	return ctx.JSON(fiber.Map{"success": true})
+}
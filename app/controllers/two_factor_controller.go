@@ -0,0 +1,327 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TwoFactorController handles TOTP enrollment and the second login step
+type TwoFactorController struct{}
+
+// Issuer shown in authenticator apps
+const totpIssuer = "Karayouke"
+
+// Login challenges must be answered within 5 minutes
+const loginChallengeDuration = 5 * time.Minute
+
+// Number of recovery codes generated at a time
+const recoveryCodeCount = 10
+
+type TwoFactorCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// getTwoFactor returns the user's TOTP record, enabled or pending
+func getTwoFactor(userID string) (*models.TwoFactorAuth, bool) {
+	var tfa models.TwoFactorAuth
+	if err := initializers.Db.Where("user_id = ?", userID).First(&tfa).Error; err != nil {
+		return nil, false
+	}
+	return &tfa, true
+}
+
+// twoFactorEnabled checks if the user has confirmed TOTP enrollment
+func twoFactorEnabled(userID string) bool {
+	tfa, ok := getTwoFactor(userID)
+	return ok && tfa.IsEnabled()
+}
+
+// checkTOTP validates a code and records its time step so it can't be replayed
+func checkTOTP(tfa *models.TwoFactorAuth, code string) bool {
+	step, ok := services.ValidateTOTP(tfa.Secret, code, time.Now())
+	if !ok {
+		return false
+	}
+	result := initializers.Db.Model(&models.TwoFactorAuth{}).
+		Where("user_id = ? AND last_used_step < ?", tfa.UserID, step).
+		Update("last_used_step", step)
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// useRecoveryCode spends one of the user's recovery codes
+func useRecoveryCode(userID string, code string) bool {
+	code = normalizeRecoveryCode(code)
+	if code == "" {
+		return false
+	}
+	result := initializers.Db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(code)).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery code
+func verifySecondFactor(userID string, req TwoFactorCodeRequest) bool {
+	tfa, ok := getTwoFactor(userID)
+	if !ok || !tfa.IsEnabled() {
+		return false
+	}
+	if req.Code != "" {
+		return checkTOTP(tfa, req.Code)
+	}
+	return useRecoveryCode(userID, req.RecoveryCode)
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+}
+
+// generateRecoveryCodes replaces the user's recovery codes and returns the new ones in plain text
+func generateRecoveryCodes(userID string) ([]string, error) {
+	if err := initializers.Db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		bytes := make([]byte, 5)
+		rand.Read(bytes)
+		raw := hex.EncodeToString(bytes)
+		code := raw[:5] + "-" + raw[5:]
+		record := models.RecoveryCode{ID: generateID(), UserID: userID, CodeHash: hashToken(normalizeRecoveryCode(code))}
+		if err := initializers.Db.Create(&record).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// sendLoginChallenge answers a correct password with a challenge instead of a session
+func sendLoginChallenge(ctx *fiber.Ctx, userID string) error {
+	challenge, err := issueAccountToken(userID, models.AccountTokenLoginChallenge, "", loginChallengeDuration)
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to start two-factor login"})
+	}
+	return ctx.JSON(fiber.Map{
+		"two_factor_required": true,
+		"challenge_token":     challenge,
+		"expires_in":          int(loginChallengeDuration.Seconds()),
+	})
+}
+
+// ThrottleChallengeKeys returns the throttle key for the login challenge in the request body, if any
+func ThrottleChallengeKeys(ctx *fiber.Ctx) []string {
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+	}
+	if err := ctx.BodyParser(&req); err != nil || req.ChallengeToken == "" {
+		return nil
+	}
+	return []string{"challenge:" + hashToken(req.ChallengeToken)[:16]}
+}
+
+// VerifyLogin completes a two-factor login with a TOTP or recovery code
+func (c *TwoFactorController) VerifyLogin(ctx *fiber.Ctx) error {
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+		TwoFactorCodeRequest
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.ChallengeToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Challenge token and code are required"})
+	}
+
+	var challenge models.AccountToken
+	if err := initializers.Db.Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?",
+		hashToken(req.ChallengeToken), models.AccountTokenLoginChallenge, time.Now()).First(&challenge).Error; err != nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Login challenge is invalid or has expired, please log in again"})
+	}
+
+	if !verifySecondFactor(challenge.UserID, req.TwoFactorCodeRequest) {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Invalid two-factor code"})
+	}
+	if _, ok := consumeAccountToken(req.ChallengeToken, models.AccountTokenLoginChallenge); !ok {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Login challenge is invalid or has expired, please log in again"})
+	}
+
+	var user models.User
+	if err := initializers.Db.Where("id = ?", challenge.UserID).First(&user).Error; err != nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Account not found"})
+	}
+
+	resp, err := signIn(ctx, user.ID, true)
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to create session"})
+	}
+	resp.User = buildUserResponse(&user)
+	return ctx.JSON(resp)
+}
+
+// Status returns whether two-factor authentication is enabled and how many recovery codes are left
+func (c *TwoFactorController) Status(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var remaining int64
+	initializers.Db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&remaining)
+
+	return ctx.JSON(fiber.Map{
+		"enabled":                  twoFactorEnabled(user.ID),
+		"required":                 IsAdmin(ctx),
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// Setup starts enrollment with a new secret and returns the provisioning URI for the QR code
+func (c *TwoFactorController) Setup(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	tfa, exists := getTwoFactor(user.ID)
+	if exists && tfa.IsEnabled() {
+		return ctx.Status(409).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+	}
+
+	secret := services.GenerateTOTPSecret()
+	if exists {
+		tfa.Secret = secret
+		tfa.LastUsedStep = 0
+		if err := initializers.Db.Save(tfa).Error; err != nil {
+			return ctx.Status(500).JSON(fiber.Map{"error": "Failed to start two-factor setup"})
+		}
+	} else {
+		tfa = &models.TwoFactorAuth{UserID: user.ID, Secret: secret}
+		if err := initializers.Db.Create(tfa).Error; err != nil {
+			return ctx.Status(500).JSON(fiber.Map{"error": "Failed to start two-factor setup"})
+		}
+	}
+
+	return ctx.JSON(fiber.Map{
+		"secret":           secret,
+		"provisioning_uri": services.TOTPProvisioningURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// Enable confirms enrollment with a code from the authenticator app and returns the recovery codes.
+// Other sessions are signed out, since they were created with the password alone.
+func (c *TwoFactorController) Enable(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req TwoFactorCodeRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	tfa, exists := getTwoFactor(user.ID)
+	if !exists {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Start two-factor setup first"})
+	}
+	if tfa.IsEnabled() {
+		return ctx.Status(409).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+	}
+	if !checkTOTP(tfa, req.Code) {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid two-factor code"})
+	}
+
+	now := time.Now()
+	if err := initializers.Db.Model(&models.TwoFactorAuth{}).Where("user_id = ?", user.ID).Update("enabled_at", now).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to enable two-factor authentication"})
+	}
+
+	codes, err := generateRecoveryCodes(user.ID)
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to generate recovery codes"})
+	}
+
+	// The code just confirmed counts as this session's second factor
+	if current := currentSession(ctx); current != nil {
+		revokeOtherSessions(user.ID, current.ID)
+		initializers.Db.Model(&models.Session{}).Where("id = ?", current.ID).Update("mfa_verified_at", now)
+		forgetAccessSession(current.ID)
+	}
+
+	return ctx.JSON(fiber.Map{
+		"success":        true,
+		"recovery_codes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes after confirming a current code
+func (c *TwoFactorController) RegenerateRecoveryCodes(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req TwoFactorCodeRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if !verifySecondFactor(user.ID, req) {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid two-factor code"})
+	}
+
+	codes, err := generateRecoveryCodes(user.ID)
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to generate recovery codes"})
+	}
+	return ctx.JSON(fiber.Map{"recovery_codes": codes})
+}
+
+// Disable turns two-factor authentication off. It requires the password (when the account has
+// one) and a current TOTP or recovery code.
+func (c *TwoFactorController) Disable(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req struct {
+		Password string `json:"password"`
+		TwoFactorCodeRequest
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if !twoFactorEnabled(user.ID) {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Two-factor authentication is not enabled"})
+	}
+	if user.Password != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+			return ctx.Status(401).JSON(fiber.Map{"error": "Invalid password"})
+		}
+	}
+	if !verifySecondFactor(user.ID, req.TwoFactorCodeRequest) {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Invalid two-factor code"})
+	}
+
+	initializers.Db.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{})
+	if err := initializers.Db.Where("user_id = ?", user.ID).Delete(&models.TwoFactorAuth{}).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to disable two-factor authentication"})
+	}
+	// Re-enabling later must not count challenges passed under the old secret
+	initializers.Db.Model(&models.Session{}).Where("user_id = ?", user.ID).Update("mfa_verified_at", nil)
+	forgetUserAccessSessions(user.ID)
+
+	return ctx.JSON(fiber.Map{"success": true})
+}
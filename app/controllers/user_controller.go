@@ -1,14 +1,27 @@
 package controllers
 
 import (
+	"log"
+	"strings"
+	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
+
 	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
-// UserController handles user-related requests
+// UserController handles self-service account management for the signed-in user
 type UserController struct {
 	// Controller dependencies or services can be injected here
 }
 
+// Email change links are valid for 24 hours
+const emailChangeDuration = 24 * time.Hour
+
 // Index handles the GET request for listing users
 func (c *UserController) Index(ctx *fiber.Ctx) error {
 	// Render index template
@@ -17,29 +30,327 @@ func (c *UserController) Index(ctx *fiber.Ctx) error {
 	})
 }
 
-// Show handles the GET request for retrieving a single user
-func (c *UserController) Show(ctx *fiber.Ctx) error {
-	// Logic to fetch and return a single user
-	userID := ctx.Params("id")
-	return ctx.SendString("User ID: " + userID)
+// Profile returns the signed-in user's profile
+func (c *UserController) Profile(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	return ctx.JSON(buildUserResponse(user))
+}
+
+// UpdateProfile changes the name and/or username
+func (c *UserController) UpdateProfile(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req struct {
+		Name     *string `json:"name"`
+		Username *string `json:"username"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	updates := map[string]interface{}{}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return ctx.Status(400).JSON(fiber.Map{"error": "Name cannot be empty"})
+		}
+		updates["name"] = name
+	}
+	if req.Username != nil {
+		username := strings.TrimSpace(*req.Username)
+		if username == "" {
+			return ctx.Status(400).JSON(fiber.Map{"error": "Username cannot be empty"})
+		}
+		if username != user.Username {
+			var count int64
+			initializers.Db.Model(&models.User{}).Where("username = ? AND id <> ?", username, user.ID).Count(&count)
+			if count > 0 {
+				return ctx.Status(409).JSON(fiber.Map{"error": "Username is already taken"})
+			}
+		}
+		updates["username"] = username
+	}
+	if len(updates) == 0 {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Nothing to update"})
+	}
+
+	if err := initializers.Db.Model(user).Updates(updates).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to update profile"})
+	}
+	return ctx.JSON(buildUserResponse(user))
+}
+
+// ChangePassword sets a new password after checking the current one, then signs out other sessions.
+// Accounts created through social login have no password and can set one without it.
+func (c *UserController) ChangePassword(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if len(req.NewPassword) < minPasswordLength {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Password must be at least 8 characters"})
+	}
+	if user.Password != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+			return ctx.Status(401).JSON(fiber.Map{"error": "Current password is incorrect"})
+		}
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to hash password"})
+	}
+	if err := initializers.Db.Model(user).Update("password", string(hashedPassword)).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to update password"})
+	}
+
+	if current := currentSession(ctx); current != nil {
+		revokeOtherSessions(user.ID, current.ID)
+	}
+	return ctx.JSON(fiber.Map{"message": "Password updated, other sessions have been signed out"})
+}
+
+// ChangeEmail emails a confirmation link to the new address. The email only changes once it is confirmed.
+func (c *UserController) ChangeEmail(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	email := strings.TrimSpace(req.Email)
+	if email == "" || !strings.Contains(email, "@") {
+		return ctx.Status(400).JSON(fiber.Map{"error": "A valid email is required"})
+	}
+	if strings.EqualFold(email, user.Email) {
+		return ctx.Status(400).JSON(fiber.Map{"error": "This is already your email"})
+	}
+	if user.Password != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+			return ctx.Status(401).JSON(fiber.Map{"error": "Password is incorrect"})
+		}
+	}
+
+	var count int64
+	initializers.Db.Model(&models.User{}).Where("LOWER(email) = ?", strings.ToLower(email)).Count(&count)
+	if count > 0 {
+		return ctx.Status(409).JSON(fiber.Map{"error": "Email is already in use"})
+	}
+
+	if _, err := frontendURL(""); err != nil {
+		log.Printf("[user] refusing to send email change confirmation: %v", err)
+		return ctx.Status(503).JSON(fiber.Map{"error": "Email change is not available"})
+	}
+	raw, err := issueAccountToken(user.ID, models.AccountTokenEmailChange, email, emailChangeDuration)
+	if err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to create confirmation token"})
+	}
+	link, _ := frontendURL("/confirm-email?token=" + raw)
+	err = services.SendTemplate(email, "Confirm your new Karayouke email", "emails/email_change", fiber.Map{
+		"Name":       user.Name,
+		"Link":       link,
+		"ValidHours": int(emailChangeDuration.Hours()),
+	})
+	if err != nil {
+		log.Printf("[user] failed to send email change confirmation for user %s: %v", user.ID, err)
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to send confirmation email"})
+	}
+
+	return ctx.JSON(fiber.Map{"message": "Check your new inbox to confirm the change"})
 }
 
-// Store handles the POST request for creating a new user
-func (c *UserController) Store(ctx *fiber.Ctx) error {
-	// Logic to create a new user based on the request data
-	return ctx.SendString("User created")
+// ConfirmEmailChange switches to the new email from an emailed token; the new address counts as verified
+func (c *UserController) ConfirmEmailChange(ctx *fiber.Ctx) error {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	token, ok := consumeAccountToken(req.Token, models.AccountTokenEmailChange)
+	if !ok {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid or expired confirmation token"})
+	}
+
+	// The address may have been taken since the link was sent
+	var count int64
+	initializers.Db.Model(&models.User{}).Where("LOWER(email) = ? AND id <> ?", strings.ToLower(token.Payload), token.UserID).Count(&count)
+	if count > 0 {
+		return ctx.Status(409).JSON(fiber.Map{"error": "Email is already in use"})
+	}
+
+	now := time.Now()
+	if err := initializers.Db.Model(&models.User{}).Where("id = ?", token.UserID).Updates(map[string]interface{}{
+		"email":             token.Payload,
+		"email_verified_at": now,
+	}).Error; err != nil {
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to update email"})
+	}
+
+	return ctx.JSON(fiber.Map{"message": "Email updated", "email": token.Payload})
 }
 
-// Update handles the PUT request for updating an existing user
-func (c *UserController) Update(ctx *fiber.Ctx) error {
-	// Logic to update an existing user based on the request data
-	userID := ctx.Params("id")
-	return ctx.SendString("User updated: " + userID)
+// Export returns everything stored about the signed-in user as a JSON download
+func (c *UserController) Export(ctx *fiber.Ctx) error {
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var transactions []models.Transaction
+	var creditLogs []models.CreditLog
+	var purchaseLogs []models.PurchaseLog
+	var rooms []models.Room
+	var sessions []models.Session
+	var identities []models.UserIdentity
+	var devices []models.TVDevice
+	var guests []models.Guest
+
+	initializers.Db.Preload("Package").Preload("Plan").Where("user_id = ?", user.ID).Order("created_at ASC").Find(&transactions)
+	initializers.Db.Where("user_id = ?", user.ID).Order("created_at ASC").Find(&creditLogs)
+	initializers.Db.Preload("Package").Where("id_user = ?", user.ID).Order("purchase_stamp ASC").Find(&purchaseLogs)
+	initializers.Db.Where("room_creator = ? OR room_master = ?", user.ID, user.ID).Order("created_at ASC").Find(&rooms)
+	initializers.Db.Where("user_id = ?", user.ID).Order("created_at ASC").Find(&sessions)
+	initializers.Db.Where("user_id = ?", user.ID).Order("created_at ASC").Find(&identities)
+	initializers.Db.Where("user_id = ?", user.ID).Order("created_at ASC").Find(&devices)
+	initializers.Db.Where("id_user = ?", user.ID).Order("created_at ASC").Find(&guests)
+
+	// Strip nested user copies; the profile is exported once at the top
+	for i := range transactions {
+		transactions[i].User = models.User{}
+	}
+	for i := range creditLogs {
+		creditLogs[i].User = models.User{}
+	}
+	for i := range purchaseLogs {
+		purchaseLogs[i].User = models.User{}
+	}
+
+	ctx.Set(fiber.HeaderContentDisposition, `attachment; filename="karayouke-export.json"`)
+	return ctx.JSON(fiber.Map{
+		"exported_at":   time.Now(),
+		"profile":       user,
+		"transactions":  transactions,
+		"credit_logs":   creditLogs,
+		"purchase_logs": purchaseLogs,
+		"rooms":         rooms,
+		"sessions":      sessions,
+		"identities":    identities,
+		"tv_devices":    devices,
+		"guests":        guests,
+	})
 }
 
-// Delete handles the DELETE request for deleting a user
+// Delete anonymizes the signed-in account. Personal data and credentials are removed;
+// transactions and credit logs are kept (pointing at the anonymized user) for accounting.
 func (c *UserController) Delete(ctx *fiber.Ctx) error {
-	// Logic to delete a user
-	userID := ctx.Params("id")
-	return ctx.SendString("User deleted: " + userID)
+	user := GetUserFromToken(ctx)
+	if user == nil {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req struct {
+		Password string `json:"password"`
+		TwoFactorCodeRequest
+	}
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if user.Password != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+			return ctx.Status(401).JSON(fiber.Map{"error": "Password is incorrect"})
+		}
+	}
+	if twoFactorEnabled(user.ID) && !verifySecondFactor(user.ID, req.TwoFactorCodeRequest) {
+		return ctx.Status(401).JSON(fiber.Map{"error": "Invalid two-factor code"})
+	}
+
+	if GetUserPermissions(user.ID)[models.PermissionAll] && countSuperAdmins("") <= 1 {
+		return ctx.Status(400).JSON(fiber.Map{"error": "The last superadmin cannot delete their account"})
+	}
+
+	if err := anonymizeUser(user.ID); err != nil {
+		log.Printf("[user] failed to anonymize user %s: %v", user.ID, err)
+		return ctx.Status(500).JSON(fiber.Map{"error": "Failed to delete account"})
+	}
+	forgetUserAccessSessions(user.ID)
+
+	return ctx.JSON(fiber.Map{"message": "Your account has been deleted"})
+}
+
+// anonymizeUser scrubs personal data and removes every credential and link of the user.
+// Everything happens in one transaction, so a failure leaves the account untouched rather than half deleted.
+func anonymizeUser(userID string) error {
+	return initializers.Db.Transaction(func(tx *gorm.DB) error {
+		// Remaining credits are forfeited through the ledger so balances still reconcile
+		zero := 0
+		if _, err := services.PostCredits(tx, services.CreditPosting{
+			UserID:      userID,
+			Type:        models.CreditTypeForfeit,
+			Description: "Credits forfeited on account deletion",
+			SetFree:     &zero,
+			SetExtra:    &zero,
+			NoHistory:   true,
+		}); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"name":                     "Deleted user",
+			"username":                 "deleted_" + userID,
+			"email":                    "deleted+" + userID + "@invalid",
+			"password":                 "",
+			"email_verified_at":        nil,
+			"subscription_plan_id":     nil,
+			"subscription_expires_at":  nil,
+			"subscription_grace_until": nil,
+			"auto_renew":               false,
+			"pending_plan_id":          nil,
+			"pending_plan_at":          nil,
+			"anonymized_at":            now,
+			"referral_code":            nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		for _, model := range []interface{}{
+			&models.RefreshToken{},
+			&models.Session{},
+			&models.AccountToken{},
+			&models.UserIdentity{},
+			&models.UserRole{},
+			&models.TwoFactorAuth{},
+			&models.RecoveryCode{},
+			&models.TVDevice{},
+			&models.APIKey{},
+		} {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.Guest{}).Where("id_user = ?", userID).Update("id_user", nil).Error
+	})
 }
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"testing"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestWebsocketTicketWorksOnceInItsRoom(t *testing.T) {
+	testDB(t, &models.User{}, &models.Room{}, &models.AccountToken{})
+
+	user := models.User{ID: generateID(), Name: "Singer", Email: generateID() + "@example.test"}
+	room := models.Room{ID: generateID(), RoomKey: generateRoomKey(), RoomCreator: user.ID, RoomMaster: user.ID, RoomName: "Ticket Test"}
+	for _, row := range []interface{}{&user, &room} {
+		if err := initializers.Db.Create(row).Error; err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	t.Cleanup(func() {
+		initializers.Db.Where("user_id = ?", user.ID).Delete(&models.AccountToken{})
+		initializers.Db.Delete(&room)
+		initializers.Db.Delete(&user)
+	})
+
+	rooms := &RoomController{}
+	app := fiber.New()
+	app.Post("/rooms/:roomKey/ws-ticket", func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, &user)
+		return rooms.WebsocketTicket(ctx)
+	})
+	ticket := func() string {
+		out := postJSON(t, app, "/rooms/"+room.RoomKey+"/ws-ticket", `{}`, nil)
+		raw, _ := out["ticket"].(string)
+		if raw == "" {
+			t.Fatalf("no ticket issued: %v", out)
+		}
+		return raw
+	}
+
+	// Tickets from two devices both work, each once
+	first, second := ticket(), ticket()
+	if got := UserFromWebsocketTicket(first, room.RoomKey); got == nil || got.ID != user.ID {
+		t.Fatalf("first ticket signed in %v", got)
+	}
+	if UserFromWebsocketTicket(first, room.RoomKey) != nil {
+		t.Fatal("a used ticket signed in again")
+	}
+	if got := UserFromWebsocketTicket(second, room.RoomKey); got == nil || got.ID != user.ID {
+		t.Fatalf("second device's ticket signed in %v", got)
+	}
+
+	if UserFromWebsocketTicket(ticket(), "OTHER1") != nil {
+		t.Fatal("a ticket was accepted for another room")
+	}
+}
@@ -1,26 +1,119 @@
 package middlewares
 
 import (
-	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/limiter"
-	"github.com/spf13/viper"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"strconv"
+	"strings"
 	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
+
+	"github.com/gofiber/fiber/v2"
 )
 
+// ThrottleMiddleware locks out clients that keep failing lookups of guessable codes or credentials.
+// A failure is any response whose status is listed in FailureStatuses (or every response when
+// CountAll is set); failures are counted per client IP and per any extra key returned by Keys
+// (e.g. the account).
 type ThrottleMiddleware struct {
+	Scope           string
+	Limiter         *services.AttemptLimiter
+	Keys            func(ctx *fiber.Ctx) []string
+	FailureStatuses []int
+	CountAll        bool                                                       // count every request, e.g. endpoints that send email
+	OnLockout       func(ctx *fiber.Ctx, keys []string, lockout time.Duration) // called when a failure triggers a lockout
+}
+
+// NewThrottleMiddleware creates a throttle for the given audit scope using the shared limiter
+func NewThrottleMiddleware(scope string, keys func(ctx *fiber.Ctx) []string) *ThrottleMiddleware {
+	return &ThrottleMiddleware{
+		Scope:           scope,
+		Limiter:         services.NewAttemptLimiter(),
+		Keys:            keys,
+		FailureStatuses: []int{fiber.StatusNotFound},
+	}
 }
 
 func (throttle *ThrottleMiddleware) Limit(ctx *fiber.Ctx) error {
-	// Throttling middleware for specific routes
-	limiterConfig := limiter.Config{
-		Max:        viper.GetInt("throttle.max"),                     // Maximum number of requests allowed within the duration
-		Expiration: viper.GetDuration("throttle.time") * time.Minute, // Duration within which the maximum number of requests is allowed
-		LimitReached: func(c *fiber.Ctx) error { // Custom handler when the limit is reached
-			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
-				"message": "Too Many Requests",
-			})
-		},
-	}
-	limiterMiddleware := limiter.New(limiterConfig)
-	return limiterMiddleware(ctx)
+	ipKey := throttle.Scope + ":ip:" + ctx.IP()
+	keys := []string{ipKey}
+	var extra []string
+	if throttle.Keys != nil {
+		for _, key := range throttle.Keys(ctx) {
+			extra = append(extra, throttle.Scope+":"+key)
+		}
+		keys = append(keys, extra...)
+	}
+
+	if wait := throttle.Limiter.RetryAfter(keys...); wait > 0 {
+		return tooManyAttempts(ctx, wait)
+	}
+
+	if err := ctx.Next(); err != nil {
+		return err
+	}
+
+	status := ctx.Response().StatusCode()
+	if !throttle.isFailure(status) {
+		return nil
+	}
+
+	lockout := throttle.Limiter.Fail(keys...)
+	throttle.audit(ctx, status, strings.Join(extra, ","), throttle.Limiter.Failures(ipKey), lockout)
+	if lockout > 0 && throttle.OnLockout != nil {
+		throttle.OnLockout(ctx, keys, lockout)
+	}
+	return nil
+}
+
+func (throttle *ThrottleMiddleware) isFailure(status int) bool {
+	if throttle.CountAll {
+		return true
+	}
+	for _, s := range throttle.FailureStatuses {
+		if status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// audit stores the failure so repeated guessing can be reviewed later
+func (throttle *ThrottleMiddleware) audit(ctx *fiber.Ctx, status int, identifier string, failures int, lockout time.Duration) {
+	entry := models.SecurityAuditLog{
+		ID:         newAuditID(),
+		Scope:      throttle.Scope,
+		IPAddress:  ctx.IP(),
+		Identifier: identifier,
+		Path:       ctx.Path(),
+		StatusCode: status,
+		Failures:   failures,
+	}
+	if lockout > 0 {
+		lockedUntil := throttle.Limiter.Now().Add(lockout)
+		entry.LockedUntil = &lockedUntil
+		log.Printf("[throttle] %s: %s locked out for %s after %d failures", throttle.Scope, ctx.IP(), lockout.Round(time.Second), failures)
+	}
+	if initializers.Db != nil {
+		initializers.Db.Create(&entry)
+	}
+}
+
+func tooManyAttempts(ctx *fiber.Ctx, wait time.Duration) error {
+	seconds := int(wait.Seconds()) + 1
+	ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return ctx.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":       "Too many failed attempts, please try again later",
+		"retry_after": seconds,
+	})
+}
+
+func newAuditID() string {
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
 }
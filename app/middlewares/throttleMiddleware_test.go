@@ -0,0 +1,128 @@
+package middlewares
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+	"GoFiberMVC/app/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type throttleClock struct{ now time.Time }
+
+func (c *throttleClock) Now() time.Time { return c.now }
+
+// throttleTestApp serves /code/:code, which only knows the code "right"
+func throttleTestApp(clock *throttleClock) (*fiber.App, *ThrottleMiddleware, *[]time.Duration) {
+	lockouts := &[]time.Duration{}
+	throttle := &ThrottleMiddleware{
+		Scope: "test_" + newAuditID()[:8],
+		Limiter: &services.AttemptLimiter{
+			Store:        services.NewMemoryAttemptStore(),
+			FreeAttempts: 2,
+			BaseLockout:  time.Minute,
+			MaxLockout:   time.Hour,
+			Window:       time.Hour,
+			Clock:        clock.Now,
+		},
+		Keys:            func(ctx *fiber.Ctx) []string { return []string{"account:a"} },
+		FailureStatuses: []int{fiber.StatusNotFound},
+		OnLockout: func(ctx *fiber.Ctx, keys []string, lockout time.Duration) {
+			*lockouts = append(*lockouts, lockout)
+		},
+	}
+	app := fiber.New()
+	app.Get("/code/:code", throttle.Limit, func(ctx *fiber.Ctx) error {
+		if ctx.Params("code") != "right" {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+		return ctx.SendStatus(fiber.StatusOK)
+	})
+	return app, throttle, lockouts
+}
+
+func throttleGet(t *testing.T, app *fiber.App, code string) (int, string) {
+	t.Helper()
+	resp, err := app.Test(httptest.NewRequest("GET", "/code/"+code, nil))
+	if err != nil {
+		t.Fatalf("GET %s: %v", code, err)
+	}
+	return resp.StatusCode, resp.Header.Get(fiber.HeaderRetryAfter)
+}
+
+func TestThrottleLocksOutAndBacksOff(t *testing.T) {
+	clock := &throttleClock{now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	app, _, lockouts := throttleTestApp(clock)
+
+	for i := 0; i < 3; i++ {
+		if status, _ := throttleGet(t, app, "wrong"); status != fiber.StatusNotFound {
+			t.Fatalf("guess %d: status %d", i+1, status)
+		}
+	}
+	// The third failure locked the client out, even for the right code
+	if status, retry := throttleGet(t, app, "right"); status != fiber.StatusTooManyRequests || retry != "61" {
+		t.Fatalf("during lockout: status %d, Retry-After %q", status, retry)
+	}
+
+	// The next failure after the lockout doubles it
+	clock.now = clock.now.Add(time.Minute)
+	throttleGet(t, app, "wrong")
+	if len(*lockouts) != 2 || (*lockouts)[0] != time.Minute || (*lockouts)[1] != 2*time.Minute {
+		t.Fatalf("lockouts = %v, want [1m 2m]", *lockouts)
+	}
+
+	// Successes are not counted as failures
+	clock.now = clock.now.Add(2 * time.Minute)
+	if status, _ := throttleGet(t, app, "right"); status != fiber.StatusOK {
+		t.Fatalf("after the lockout: status %d", status)
+	}
+	if len(*lockouts) != 2 {
+		t.Fatalf("a success triggered a lockout: %v", *lockouts)
+	}
+}
+
+func TestThrottleWritesAuditLog(t *testing.T) {
+	name := os.Getenv("TEST_DB_NAME")
+	if name == "" {
+		t.Skip("TEST_DB_NAME not set; skipping database-backed test")
+	}
+	t.Setenv("DB_NAME", name)
+	if err := initializers.DbConnection(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if err := initializers.Db.AutoMigrate(&models.SecurityAuditLog{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	clock := &throttleClock{now: time.Now()}
+	app, throttle, _ := throttleTestApp(clock)
+	t.Cleanup(func() {
+		initializers.Db.Where("scope = ?", throttle.Scope).Delete(&models.SecurityAuditLog{})
+	})
+
+	throttleGet(t, app, "right")
+	for i := 0; i < 3; i++ {
+		throttleGet(t, app, "wrong")
+	}
+
+	var entries []models.SecurityAuditLog
+	initializers.Db.Where("scope = ?", throttle.Scope).Order("failures ASC").Find(&entries)
+	if len(entries) != 3 {
+		t.Fatalf("%d audit rows, want one per failure", len(entries))
+	}
+	last := entries[2]
+	if last.Failures != 3 || last.StatusCode != fiber.StatusNotFound || last.Identifier != throttle.Scope+":account:a" || last.Path != "/code/wrong" {
+		t.Fatalf("audit row = %+v", last)
+	}
+	if last.LockedUntil == nil || last.LockedUntil.Sub(clock.now.Add(time.Minute)).Abs() > time.Second {
+		t.Fatalf("locked until %v, want %v", last.LockedUntil, clock.now.Add(time.Minute))
+	}
+	if entries[0].LockedUntil != nil {
+		t.Fatalf("a free failure recorded a lockout: %+v", entries[0])
+	}
+}
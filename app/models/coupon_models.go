@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Coupon is a promo code that discounts a package or plan at checkout. A coupon is used by every
+// pending or settled transaction that carries it, so abandoned bills give their use back.
+type Coupon struct {
+	ID                string     `gorm:"column:id;primaryKey" json:"id"`
+	Code              string     `gorm:"column:code;uniqueIndex" json:"code"` // stored upper case, matched case-insensitively
+	Description       string     `gorm:"column:description" json:"description"`
+	DiscountType      string     `gorm:"column:discount_type" json:"discount_type"`                           // percent, fixed
+	DiscountValue     int64      `gorm:"column:discount_value" json:"discount_value"`                         // percent (1-100) or IDR
+	PackageIDs        []string   `gorm:"column:package_ids;serializer:json" json:"package_ids"`               // packages it applies to
+	PlanIDs           []string   `gorm:"column:plan_ids;serializer:json" json:"plan_ids"`                     // plans it applies to; both empty means everything
+	ValidFrom         *time.Time `gorm:"column:valid_from" json:"valid_from"`                                 // nil: valid immediately
+	ValidUntil        *time.Time `gorm:"column:valid_until" json:"valid_until"`                               // nil: never expires
+	MaxUses           int        `gorm:"column:max_uses;default:0" json:"max_uses"`                           // 0 = unlimited
+	MaxUsesPerUser    int        `gorm:"column:max_uses_per_user;default:0" json:"max_uses_per_user"`         // 0 = unlimited
+	FirstPurchaseOnly bool       `gorm:"column:first_purchase_only;default:false" json:"first_purchase_only"` // only for users who never paid before
+	Active            bool       `gorm:"column:active" json:"active"`
+	CreatedBy         *string    `gorm:"column:created_by" json:"created_by"` // admin user
+	CreatedAt         time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt         time.Time  `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+}
+
+func (Coupon) TableName() string {
+	return "coupons"
+}
+
+// Coupon discount types
+const (
+	CouponDiscountPercent = "percent"
+	CouponDiscountFixed   = "fixed"
+)
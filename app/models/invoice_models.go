@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// Invoice is the numbered receipt for a settled transaction. The buyer, item and company details are
+// copied when it is issued, so a downloaded invoice doesn't change unless an admin regenerates it.
+type Invoice struct {
+	ID             string     `gorm:"column:id;primaryKey" json:"id"`
+	Number         string     `gorm:"column:number;uniqueIndex" json:"number"` // e.g. INV-2026-000042
+	Year           int        `gorm:"column:year" json:"year"`
+	Sequence       int        `gorm:"column:sequence" json:"sequence"` // 1, 2, 3... within the year
+	TransactionID  string     `gorm:"column:transaction_id;uniqueIndex" json:"transaction_id"`
+	UserID         string     `gorm:"column:user_id;index" json:"user_id"`
+	BuyerName      string     `gorm:"column:buyer_name" json:"buyer_name"`
+	BuyerEmail     string     `gorm:"column:buyer_email" json:"buyer_email"`
+	ItemName       string     `gorm:"column:item_name" json:"item_name"`
+	ItemDetail     string     `gorm:"column:item_detail" json:"item_detail"` // credits or subscription period
+	Amount         int64      `gorm:"column:amount" json:"amount"`           // Amount in IDR
+	ListAmount     int64      `gorm:"column:list_amount" json:"list_amount"` // price before the coupon
+	Discount       int64      `gorm:"column:discount" json:"discount"`
+	CouponCode     string     `gorm:"column:coupon_code" json:"coupon_code"`
+	PaymentMethod  string     `gorm:"column:payment_method" json:"payment_method"`
+	PaymentID      string     `gorm:"column:payment_id" json:"payment_id"` // the provider's payment ID
+	PaidAt         *time.Time `gorm:"column:paid_at" json:"paid_at"`
+	CompanyName    string     `gorm:"column:company_name" json:"company_name"`
+	CompanyAddress string     `gorm:"column:company_address" json:"company_address"`
+	CompanyTaxID   string     `gorm:"column:company_tax_id" json:"company_tax_id"`
+	CompanyEmail   string     `gorm:"column:company_email" json:"company_email"`
+	IssuedAt       time.Time  `gorm:"column:issued_at" json:"issued_at"`
+	RegeneratedAt  *time.Time `gorm:"column:regenerated_at" json:"regenerated_at"`
+	RegeneratedBy  *string    `gorm:"column:regenerated_by" json:"regenerated_by"` // admin user who last regenerated it
+	CreatedAt      time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+}
+
+func (Invoice) TableName() string {
+	return "invoices"
+}
+
+// InvoiceSequence holds the last invoice number used in a year. Its row is locked while a number
+// is taken, so numbers have no gaps or duplicates.
+type InvoiceSequence struct {
+	Year       int `gorm:"column:year;primaryKey;autoIncrement:false" json:"year"`
+	LastNumber int `gorm:"column:last_number" json:"last_number"`
+}
+
+func (InvoiceSequence) TableName() string {
+	return "invoice_sequences"
+}
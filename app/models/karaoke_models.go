@@ -22,16 +22,23 @@ func (SubscriptionPlan) TableName() string {
 }
 
 type User struct {
-	ID                    string     `gorm:"column:id;primaryKey" json:"id"`
-	Name                  string     `gorm:"column:name" json:"name"`
-	Username              string     `gorm:"column:username" json:"username"`
-	Email                 string     `gorm:"column:email" json:"email"`
-	Password              string     `gorm:"column:password" json:"-"`
-	Credit                int        `gorm:"column:credit" json:"extra_credit"`                             // Extra credits (purchased, never expire)
-	FreeCredit            int        `gorm:"column:free_credit;default:0" json:"free_credit"`               // Daily free credits (reset daily)
-	FreeCreditResetAt     *time.Time `gorm:"column:free_credit_reset_at" json:"free_credit_reset_at"`       // Last reset timestamp
-	SubscriptionPlanID    *string    `gorm:"column:subscription_plan_id" json:"subscription_plan_id"`       // Current subscription plan
-	SubscriptionExpiresAt *time.Time `gorm:"column:subscription_expires_at" json:"subscription_expires_at"` // When subscription expires
+	ID                     string     `gorm:"column:id;primaryKey" json:"id"`
+	Name                   string     `gorm:"column:name" json:"name"`
+	Username               string     `gorm:"column:username" json:"username"`
+	Email                  string     `gorm:"column:email" json:"email"`
+	Password               string     `gorm:"column:password" json:"-"`
+	Credit                 int        `gorm:"column:credit;<-:create" json:"extra_credit"`                     // Extra credits, tracked in CreditBatch and expiring per Package.ValidityDays; only the ledger updates it
+	FreeCredit             int        `gorm:"column:free_credit;default:0;<-:create" json:"free_credit"`       // Daily free credits (reset daily); only the ledger updates it
+	FreeCreditResetAt      *time.Time `gorm:"column:free_credit_reset_at" json:"free_credit_reset_at"`         // Last reset timestamp
+	SubscriptionPlanID     *string    `gorm:"column:subscription_plan_id" json:"subscription_plan_id"`         // Current subscription plan
+	SubscriptionExpiresAt  *time.Time `gorm:"column:subscription_expires_at" json:"subscription_expires_at"`   // When subscription expires
+	SubscriptionGraceUntil *time.Time `gorm:"column:subscription_grace_until" json:"subscription_grace_until"` // Plan stays active until then while a renewal bill is unpaid
+	AutoRenew              bool       `gorm:"column:auto_renew;default:false" json:"auto_renew"`               // Bill the next period before the subscription expires
+	PendingPlanID          *string    `gorm:"column:pending_plan_id" json:"pending_plan_id"`                   // Plan of a scheduled downgrade
+	PendingPlanAt          *time.Time `gorm:"column:pending_plan_at" json:"pending_plan_at"`                   // When the scheduled downgrade takes over (the end of the paid period)
+	EmailVerifiedAt        *time.Time `gorm:"column:email_verified_at" json:"email_verified_at"`               // Nil until the email link is confirmed
+	AnonymizedAt           *time.Time `gorm:"column:anonymized_at" json:"anonymized_at"`                       // Set when the account was deleted
+	ReferralCode           *string    `gorm:"column:referral_code;uniqueIndex" json:"referral_code"`           // Assigned when the user first opens their referral page
 }
 
 func (User) TableName() string {
@@ -43,25 +50,19 @@ func (u *User) TotalCredits() int {
 	return u.FreeCredit + u.Credit
 }
 
-// DeductCredits deducts credits using free credits first, then extra credits.
-// Returns false if insufficient total credits.
-func (u *User) DeductCredits(amount int) bool {
-	if u.TotalCredits() < amount {
-		return false
-	}
-	if u.FreeCredit >= amount {
-		u.FreeCredit -= amount
-	} else {
-		remainder := amount - u.FreeCredit
-		u.FreeCredit = 0
-		u.Credit -= remainder
-	}
-	return true
+// IsEmailVerified reports whether the user confirmed their email address
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
 }
 
-// HasActiveSubscription checks if the user has a non-expired paid subscription
+// HasActiveSubscription checks if the user has a non-expired paid subscription, or one in its
+// renewal grace period
 func (u *User) HasActiveSubscription() bool {
-	return u.SubscriptionPlanID != nil && u.SubscriptionExpiresAt != nil && u.SubscriptionExpiresAt.After(time.Now())
+	if u.SubscriptionPlanID == nil || u.SubscriptionExpiresAt == nil {
+		return false
+	}
+	now := time.Now()
+	return u.SubscriptionExpiresAt.After(now) || (u.SubscriptionGraceUntil != nil && u.SubscriptionGraceUntil.After(now))
 }
 
 // Package represents an extra credit package (one-time purchase)
@@ -71,6 +72,7 @@ type Package struct {
 	PackageDetail []byte `gorm:"column:package_detail" json:"package_detail"`
 	Price         int64  `gorm:"column:price" json:"price"` // Price in IDR
 	CreditAmount  int    `gorm:"column:credit_amount" json:"credit_amount"`
+	ValidityDays  int    `gorm:"column:validity_days;default:0" json:"validity_days"` // Days the credits stay usable (0 = never expire)
 	Visibility    bool   `gorm:"column:visibility" json:"visibility"`
 }
 
@@ -146,13 +148,15 @@ func (Song) TableName() string {
 }
 
 type Guest struct {
-	ID        string    `gorm:"column:id;primaryKey" json:"id"`
-	Name      string    `gorm:"column:name" json:"name"`
-	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
-	UserID    *string   `gorm:"column:id_user" json:"user_id"`
-	RoomID    string    `gorm:"column:id_room" json:"room_id"`
-	Room      Room      `gorm:"foreignKey:RoomID;references:ID" json:"room"`
-	User      *User     `gorm:"foreignKey:UserID;references:ID" json:"user"`
+	ID        string     `gorm:"column:id;primaryKey" json:"id"`
+	Name      string     `gorm:"column:name" json:"name"`
+	CreatedAt time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UserID    *string    `gorm:"column:id_user" json:"user_id"`
+	RoomID    string     `gorm:"column:id_room" json:"room_id"`
+	TokenHash string     `gorm:"column:token_hash;index" json:"-"`    // hash of the secret held by the guest's browser, proves ownership when claiming
+	ClaimedAt *time.Time `gorm:"column:claimed_at" json:"claimed_at"` // when the guest was linked to a registered user
+	Room      Room       `gorm:"foreignKey:RoomID;references:ID" json:"room"`
+	User      *User      `gorm:"foreignKey:UserID;references:ID" json:"user"`
 }
 
 func (Guest) TableName() string {
@@ -175,19 +179,44 @@ func (SystemConfig) TableName() string {
 type Transaction struct {
 	ID              string            `gorm:"column:id;primaryKey" json:"id"`
 	UserID          string            `gorm:"column:user_id" json:"user_id"`
-	PackageID       *string           `gorm:"column:package_id" json:"package_id"`               // For extra credit purchases
-	PlanID          *string           `gorm:"column:plan_id" json:"plan_id"`                     // For subscription purchases
-	Amount          int64             `gorm:"column:amount" json:"amount"`                       // Amount in IDR
-	Status          string            `gorm:"column:status" json:"status"`                       // pending, settlement, failed, expired, refunded
-	PaymentMethod   string            `gorm:"column:payment_method" json:"payment_method"`       // flip, free
-	TxType          string            `gorm:"column:tx_type" json:"tx_type"`                     // extra_credit, subscription
-	ExternalID      string            `gorm:"column:external_id" json:"external_id"`             // Flip bill_link_id
-	FlipURL         string            `gorm:"column:flip_url" json:"flip_url"`                   // Flip payment link URL
-	FlipCompanyCode string            `gorm:"column:flip_company_code" json:"flip_company_code"` // Flip company code (V3)
-	FlipProductCode string            `gorm:"column:flip_product_code" json:"flip_product_code"` // Flip product code (V3)
+	PackageID       *string           `gorm:"column:package_id" json:"package_id"`                       // For extra credit purchases
+	PlanID          *string           `gorm:"column:plan_id" json:"plan_id"`                             // For subscription purchases
+	Amount          int64             `gorm:"column:amount" json:"amount"`                               // Amount in IDR
+	Status          string            `gorm:"column:status" json:"status"`                               // pending, settlement, failed, expired, refund_pending, refunded
+	PaymentMethod   string            `gorm:"column:payment_method" json:"payment_method"`               // provider[:bank:type], free
+	TxType          string            `gorm:"column:tx_type" json:"tx_type"`                             // extra_credit, subscription
+	Provider        string            `gorm:"column:provider;index" json:"provider"`                     // payment provider that issued the bill (flip, midtrans, fake)
+	ProviderRef     string            `gorm:"column:provider_ref;index" json:"provider_ref"`             // the provider's bill/order ID
+	ExternalID      string            `gorm:"column:external_id" json:"external_id"`                     // the provider's payment ID, once paid
+	PaymentURL      string            `gorm:"column:payment_url" json:"payment_url"`                     // hosted payment page
+	ProviderData    map[string]string `gorm:"column:provider_data;serializer:json" json:"provider_data"` // provider-specific values for the frontend (Flip popup codes, Snap token)
+	CouponID        *string           `gorm:"column:coupon_id;index" json:"coupon_id"`
+	CouponCode      string            `gorm:"column:coupon_code" json:"coupon_code"`
+	ListAmount      int64             `gorm:"column:list_amount" json:"list_amount"`         // package/plan price before the coupon, in IDR
+	DiscountAmount  int64             `gorm:"column:discount_amount" json:"discount_amount"` // ListAmount - Amount
 	CreatedAt       time.Time         `gorm:"column:created_at;autoCreateTime" json:"created_at"`
 	UpdatedAt       time.Time         `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
 	PaidAt          *time.Time        `gorm:"column:paid_at" json:"paid_at"`
+	ExpiresAt       *time.Time        `gorm:"column:expires_at" json:"expires_at"`                   // end of the bill's validity window
+	NextCheckAt     *time.Time        `gorm:"column:next_check_at;index" json:"next_check_at"`       // when the reconciler next asks the provider
+	CheckAttempts   int               `gorm:"column:check_attempts;default:0" json:"check_attempts"` // reconciler status checks so far
+	Renewal         bool              `gorm:"column:renewal;default:false" json:"renewal"`           // bill created by subscription auto-renewal
+	FromPlanID      *string           `gorm:"column:from_plan_id" json:"from_plan_id"`               // plan replaced by an upgrade
+	ProrationCredit int64             `gorm:"column:proration_credit" json:"proration_credit"`       // value of the replaced plan's unused days, in IDR
+	FromExpiresAt   *time.Time        `gorm:"column:from_expires_at" json:"from_expires_at"`         // when the replaced plan would have expired: quoted at checkout, confirmed as the upgrade settles
+	RefundedAt      *time.Time        `gorm:"column:refunded_at" json:"refunded_at"`
+	RefundedBy      *string           `gorm:"column:refunded_by" json:"refunded_by"` // admin user who issued the refund
+	RefundReason    string            `gorm:"column:refund_reason" json:"refund_reason"`
+	RefundID        string            `gorm:"column:refund_id" json:"refund_id"`               // the provider's refund ID; empty when refunded outside the provider
+	RefundClawback  int               `gorm:"column:refund_clawback" json:"refund_clawback"`   // extra credits taken back
+	RefundDays      int               `gorm:"column:refund_days" json:"refund_days"`           // subscription days taken back
+	RefundShortfall int               `gorm:"column:refund_shortfall" json:"refund_shortfall"` // granted credits that were already spent
+	RefundKey       string            `gorm:"column:refund_key" json:"-"`                      // idempotency key sent with the provider refund
+	RefundFrom      string            `gorm:"column:refund_from" json:"refund_from"`           // status before the refund started, restored if the provider refuses it
+	RefundManual    bool              `gorm:"column:refund_manual;default:false" json:"refund_manual"`
+	RefundAttemptAt *time.Time        `gorm:"column:refund_attempt_at" json:"refund_attempt_at"` // when a pending refund was last worked on
+	PaymentIssue    string            `gorm:"column:payment_issue" json:"payment_issue"`         // why money received for this bill could not be applied; it needs a refund
+	PaidAmount      int64             `gorm:"column:paid_amount" json:"paid_amount"`             // what the provider reported paid, when it differs from Amount
 	User            User              `gorm:"foreignKey:UserID;references:ID" json:"user"`
 	Package         *Package          `gorm:"foreignKey:PackageID;references:ID" json:"package"`
 	Plan            *SubscriptionPlan `gorm:"foreignKey:PlanID;references:ID" json:"plan"`
@@ -204,6 +233,69 @@ const (
 	TransactionStatusFailed     = "failed"
 	TransactionStatusExpired    = "expired"
 	TransactionStatusRefunded   = "refunded"
+	// Refund recorded but not yet confirmed by the provider or applied to the account
+	TransactionStatusRefundPending = "refund_pending"
+)
+
+// PaymentEvent is a verified provider callback. The (provider, event_id) pair is unique, so a
+// callback the provider delivers twice is only processed once.
+type PaymentEvent struct {
+	ID            string     `gorm:"column:id;primaryKey" json:"id"`
+	Provider      string     `gorm:"column:provider;uniqueIndex:idx_payment_event" json:"provider"`
+	EventID       string     `gorm:"column:event_id;uniqueIndex:idx_payment_event" json:"event_id"` // provider payment ID + status, or a hash of the body
+	TransactionID string     `gorm:"column:transaction_id;index" json:"transaction_id"`             // empty when no transaction matched
+	Status        string     `gorm:"column:status" json:"status"`                                   // provider-neutral payment status
+	Amount        int64      `gorm:"column:amount" json:"amount"`
+	Payload       string     `gorm:"column:payload;type:text" json:"payload"` // raw callback body
+	Result        string     `gorm:"column:result" json:"result"`             // what processing did: applied, already_processed, not_found, or an error
+	ReceivedAt    time.Time  `gorm:"column:received_at;autoCreateTime" json:"received_at"`
+	ProcessedAt   *time.Time `gorm:"column:processed_at" json:"processed_at"`
+}
+
+func (PaymentEvent) TableName() string {
+	return "payment_events"
+}
+
+// Payment event results
+const (
+	PaymentEventApplied          = "applied"
+	PaymentEventAlreadyProcessed = "already_processed"
+	PaymentEventNotFound         = "not_found"
+	PaymentEventNeedsRefund      = "needs_refund"
+)
+
+// PaymentReconcileRun is the report of one pass of the pending transaction reconciler
+type PaymentReconcileRun struct {
+	ID           string                 `gorm:"column:id;primaryKey" json:"id"`
+	Trigger      string                 `gorm:"column:trigger" json:"trigger"` // schedule, admin
+	StartedAt    time.Time              `gorm:"column:started_at;index" json:"started_at"`
+	FinishedAt   *time.Time             `gorm:"column:finished_at" json:"finished_at"`
+	Checked      int                    `gorm:"column:checked" json:"checked"`
+	Settled      int                    `gorm:"column:settled" json:"settled"`
+	Failed       int                    `gorm:"column:failed" json:"failed"` // failed or cancelled at the provider
+	Expired      int                    `gorm:"column:expired" json:"expired"`
+	StillPending int                    `gorm:"column:still_pending" json:"still_pending"`
+	Refunded     int                    `gorm:"column:refunded" json:"refunded"` // half-finished refunds completed
+	Errors       int                    `gorm:"column:errors" json:"errors"`
+	Items        []PaymentReconcileItem `gorm:"column:items;serializer:json" json:"items"`
+}
+
+func (PaymentReconcileRun) TableName() string {
+	return "payment_reconcile_runs"
+}
+
+// PaymentReconcileItem is the outcome for one transaction in a run
+type PaymentReconcileItem struct {
+	TransactionID string `json:"transaction_id"`
+	Provider      string `json:"provider"`
+	Outcome       string `json:"outcome"` // settlement, failed, expired, pending, refunded, error
+	Error         string `json:"error,omitempty"`
+}
+
+// Reconcile run triggers
+const (
+	ReconcileTriggerSchedule = "schedule"
+	ReconcileTriggerAdmin    = "admin"
 )
 
 // System config key constants
@@ -215,6 +307,23 @@ const (
 	ConfigFlipSecretKey       = "flip_secret_key"       // Flip API Secret Key
 	ConfigFlipValidationToken = "flip_validation_token" // Flip Validation Token
 	ConfigFlipEnvironment     = "flip_environment"      // "production" or "sandbox"
+	ConfigPaymentProvider     = "payment_provider"      // provider for new bills: flip (default), midtrans, fake
+	ConfigMidtransServerKey   = "midtrans_server_key"   // Midtrans Server Key
+	ConfigMidtransEnvironment = "midtrans_environment"  // "production" or "sandbox"
+	ConfigFakePaymentSecret   = "fake_payment_secret"   // signs fake provider callbacks (tests/local only)
+	ConfigBillValidityHours   = "bill_validity_hours"   // pending bills expire after this many hours (default: 24)
+	ConfigInvoicePrefix       = "invoice_prefix"        // invoice numbers are <prefix>-<year>-<sequence> (default: INV)
+	ConfigCompanyName         = "company_name"          // seller shown on invoices
+	ConfigCompanyAddress      = "company_address"       // one line per address line
+	ConfigCompanyTaxID        = "company_tax_id"        // NPWP
+	ConfigCompanyEmail        = "company_email"
+	ConfigReferrerCredits     = "referral_referrer_credits" // extra credits for the inviting user (default: 5)
+	ConfigReferredCredits     = "referral_referred_credits" // extra credits for the invited user (default: 5)
+	ConfigReferralPurchase    = "referral_require_purchase" // "true": reward only after the invited user's first paid purchase
+	ConfigReferralMaxPerIP    = "referral_max_per_ip"       // referred signups allowed from one IP per day (default: 3)
+	ConfigReferralMaxDomain   = "referral_max_per_domain"   // referrals one user may collect from a single email domain (default: 5)
+	ConfigRenewalLeadDays     = "subscription_renewal_days" // days before expiry the renewal bill is created (default: 3)
+	ConfigRenewalGraceDays    = "subscription_grace_days"   // days the plan stays active after expiry while the renewal is unpaid (default: 3)
 )
 
 // Transaction type constants
@@ -248,16 +357,28 @@ const (
 	CreditTypeFreeReset    = "free_reset"   // Daily free credit reset
 	CreditTypeSubscription = "subscription" // Subscription activation
 	CreditTypeRefund       = "refund"
+	CreditTypeOpening      = "opening_balance" // Balance carried over when the ledger was introduced
+	CreditTypeForfeit      = "forfeit"         // Credits removed with a deleted account
+	CreditTypeAdjustment   = "adjustment"      // Correction posted by ledger reconciliation
+	CreditTypeReferral     = "referral"        // Reward for inviting a user, or for signing up through an invite
+	CreditTypeExpiry       = "expiry"          // Purchased credits left in a batch when it expired
 )
 
-// Session stores user authentication sessions in the database
+// Session stores user authentication sessions in the database.
+// Only the SHA-256 hash of the bearer token is kept.
 type Session struct {
-	ID        string    `gorm:"column:id;primaryKey" json:"id"`
-	Token     string    `gorm:"column:token;uniqueIndex" json:"token"`
-	UserID    string    `gorm:"column:user_id;index" json:"user_id"`
-	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
-	ExpiresAt time.Time `gorm:"column:expires_at;index" json:"expires_at"`
-	User      User      `gorm:"foreignKey:UserID;references:ID" json:"user"`
+	ID           string    `gorm:"column:id;primaryKey" json:"id"`
+	TokenHash    string    `gorm:"column:token_hash;uniqueIndex" json:"-"`
+	UserID       string    `gorm:"column:user_id;index" json:"user_id"`
+	UserAgent    string    `gorm:"column:user_agent" json:"user_agent"`
+	IP           string    `gorm:"column:ip" json:"ip"`
+	CreatedAt    time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	LastUsedAt   time.Time `gorm:"column:last_used_at" json:"last_used_at"`
+	ExpiresAt    time.Time `gorm:"column:expires_at;index" json:"expires_at"`
+	MaxExpiresAt time.Time `gorm:"column:max_expires_at" json:"max_expires_at"` // Absolute limit, rolling expiry never passes it
+	// Set when the sign-in passed a two-factor challenge; admin routes require it
+	MFAVerifiedAt *time.Time `gorm:"column:mfa_verified_at" json:"-"`
+	User          User       `gorm:"foreignKey:UserID;references:ID" json:"-"`
 }
 
 func (Session) TableName() string {
@@ -272,8 +393,28 @@ type TVToken struct {
 	RoomKey   string    `gorm:"column:room_key" json:"room_key"`                 // Connected room (empty until connected)
 	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
 	ExpiresAt time.Time `gorm:"column:expires_at;index" json:"expires_at"`
+	// Remembered device whose token is issued to the TV on its next status poll (cleared once delivered).
+	// The raw token is only generated at hand-over, so it is never stored.
+	PendingDeviceID string `gorm:"column:pending_device_id" json:"-"`
 }
 
 func (TVToken) TableName() string {
 	return "tv_tokens"
 }
+
+// TVDevice is a TV remembered by a user so new rooms can be pushed to it without re-pairing
+type TVDevice struct {
+	ID         string     `gorm:"column:id;primaryKey" json:"id"`
+	UserID     string     `gorm:"column:user_id;index" json:"user_id"`
+	Name       string     `gorm:"column:name" json:"name"`
+	TokenHash  string     `gorm:"column:token_hash;uniqueIndex" json:"-"` // SHA-256 of the device token stored on the TV
+	RoomKey    string     `gorm:"column:room_key" json:"room_key"`        // Room currently pushed to the device
+	LastSeenAt *time.Time `gorm:"column:last_seen_at" json:"last_seen_at"`
+	CreatedAt  time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	RevokedAt  *time.Time `gorm:"column:revoked_at" json:"revoked_at"`
+	User       User       `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+func (TVDevice) TableName() string {
+	return "tv_devices"
+}
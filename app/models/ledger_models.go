@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// Credit ledger: every change to a user's credits is a journal whose entries sum to zero.
+// User.Credit and User.FreeCredit are balances cached from the user's accounts.
+
+// LedgerJournal groups the entries of one credit movement
+type LedgerJournal struct {
+	ID             string        `gorm:"column:id;primaryKey" json:"id"`
+	UserID         string        `gorm:"column:user_id;index" json:"user_id"`
+	Type           string        `gorm:"column:type" json:"type"`                                   // credit log type (purchase, room_creation, ...)
+	ReferenceID    string        `gorm:"column:reference_id;index" json:"reference_id"`             // transaction_id, room_id, etc.
+	IdempotencyKey *string       `gorm:"column:idempotency_key;uniqueIndex" json:"idempotency_key"` // a retried posting with the same key is not applied twice
+	Description    string        `gorm:"column:description" json:"description"`
+	CreatedAt      time.Time     `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	Entries        []LedgerEntry `gorm:"foreignKey:JournalID;references:ID" json:"entries,omitempty"`
+}
+
+func (LedgerJournal) TableName() string {
+	return "ledger_journals"
+}
+
+// LedgerEntry moves credits into (positive) or out of (negative) one account
+type LedgerEntry struct {
+	ID        string    `gorm:"column:id;primaryKey" json:"id"`
+	JournalID string    `gorm:"column:journal_id;index" json:"journal_id"`
+	Account   string    `gorm:"column:account;index" json:"account"` // user:<id>:free, user:<id>:extra or system:<type>
+	Amount    int       `gorm:"column:amount" json:"amount"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (LedgerEntry) TableName() string {
+	return "ledger_entries"
+}
+
+// CreditBatch is a grant of extra credits that is used up, and may expire, on its own. Spending takes
+// from the batch that expires first; batches that never expire go last. The remaining amounts of a
+// user's batches add up to User.Credit.
+type CreditBatch struct {
+	ID          string     `gorm:"column:id;primaryKey" json:"id"`
+	UserID      string     `gorm:"column:user_id;index" json:"user_id"`
+	Source      string     `gorm:"column:source" json:"source"`                   // credit log type of the grant (purchase, referral, ...)
+	ReferenceID string     `gorm:"column:reference_id;index" json:"reference_id"` // the transaction that paid for it, or another source ID
+	Original    int        `gorm:"column:original" json:"original"`
+	Remaining   int        `gorm:"column:remaining" json:"remaining"`
+	ExpiresAt   *time.Time `gorm:"column:expires_at;index" json:"expires_at"` // nil: never expires
+	ExpiredAt   *time.Time `gorm:"column:expired_at" json:"expired_at"`       // set when the leftover was removed
+	CreatedAt   time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (CreditBatch) TableName() string {
+	return "credit_batches"
+}
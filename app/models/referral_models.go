@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Referral links a user to the user whose invite code they signed up with. Both are rewarded once
+// the invited user qualifies (verified email, and a first purchase when configured).
+type Referral struct {
+	ID             string     `gorm:"column:id;primaryKey" json:"id"`
+	ReferrerID     string     `gorm:"column:referrer_id;index" json:"referrer_id"`
+	ReferredID     string     `gorm:"column:referred_id;uniqueIndex" json:"referred_id"` // a user can only be referred once
+	Code           string     `gorm:"column:code" json:"code"`
+	Status         string     `gorm:"column:status;index" json:"status"`         // pending, rewarded, rejected
+	RejectReason   string     `gorm:"column:reject_reason" json:"reject_reason"` // why no reward was given
+	SignupIP       string     `gorm:"column:signup_ip;index" json:"-"`
+	EmailDomain    string     `gorm:"column:email_domain" json:"-"`
+	ReferrerReward int        `gorm:"column:referrer_reward" json:"referrer_reward"` // credits given to the referrer
+	ReferredReward int        `gorm:"column:referred_reward" json:"referred_reward"` // credits given to the referred user
+	RewardedAt     *time.Time `gorm:"column:rewarded_at" json:"rewarded_at"`
+	CreatedAt      time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	Referred       *User      `gorm:"foreignKey:ReferredID;references:ID" json:"-"`
+}
+
+func (Referral) TableName() string {
+	return "referrals"
+}
+
+// Referral status constants
+const (
+	ReferralStatusPending  = "pending"
+	ReferralStatusRewarded = "rewarded"
+	ReferralStatusRejected = "rejected"
+)
@@ -0,0 +1,296 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// SecurityAuditLog records failed attempts against guessable codes and credentials
+type SecurityAuditLog struct {
+	ID          string     `gorm:"column:id;primaryKey" json:"id"`
+	Scope       string     `gorm:"column:scope;index" json:"scope"`           // tv_code, room_key, ...
+	IPAddress   string     `gorm:"column:ip_address;index" json:"ip_address"` // client IP
+	Identifier  string     `gorm:"column:identifier" json:"identifier"`       // account key or submitted code
+	Path        string     `gorm:"column:path" json:"path"`                   // request path
+	StatusCode  int        `gorm:"column:status_code" json:"status_code"`     // response status that counted as a failure
+	Failures    int        `gorm:"column:failures" json:"failures"`           // failure count for the IP at the time
+	LockedUntil *time.Time `gorm:"column:locked_until" json:"locked_until"`   // set when this failure triggered a lockout
+	CreatedAt   time.Time  `gorm:"column:created_at;autoCreateTime;index" json:"created_at"`
+}
+
+func (SecurityAuditLog) TableName() string {
+	return "security_audit_logs"
+}
+
+// Security audit scope constants
+const (
+	AuditScopeTVCode        = "tv_code"
+	AuditScopeRoomKey       = "room_key"
+	AuditScopeLogin         = "login"
+	AuditScopeRegister      = "register"
+	AuditScopePasswordReset = "password_reset"
+	AuditScopeTwoFactor     = "two_factor"
+	AuditScopeReauth        = "reauth" // password re-entry for account changes
+	AuditScopeCoupon        = "coupon" // unknown promo codes at checkout
+)
+
+// AccountToken is a single-use, expiring secret emailed to a user (password reset, ...).
+// Only the SHA-256 hash of the token is stored.
+type AccountToken struct {
+	ID        string     `gorm:"column:id;primaryKey" json:"id"`
+	UserID    string     `gorm:"column:user_id;index" json:"user_id"`
+	Purpose   string     `gorm:"column:purpose;index" json:"purpose"`
+	TokenHash string     `gorm:"column:token_hash;uniqueIndex" json:"-"`
+	Payload   string     `gorm:"column:payload" json:"-"` // purpose-specific data
+	ExpiresAt time.Time  `gorm:"column:expires_at;index" json:"expires_at"`
+	UsedAt    *time.Time `gorm:"column:used_at" json:"used_at"`
+	CreatedAt time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (AccountToken) TableName() string {
+	return "account_tokens"
+}
+
+// Account token purpose constants
+const (
+	AccountTokenPasswordReset     = "password_reset"
+	AccountTokenEmailVerification = "email_verification"
+	AccountTokenAccountUnlock     = "account_unlock"
+	AccountTokenLoginChallenge    = "login_challenge"
+	AccountTokenEmailChange       = "email_change"
+	AccountTokenOIDCLink          = "oidc_link" // ticket that lets a top-level browser navigation start linking a provider
+	AccountTokenWebsocket         = "websocket" // ticket that signs a websocket into the room in its payload
+)
+
+// RefreshToken is one link in the rotating refresh-token chain of a JWT session.
+// Presenting a token that was already used revokes the whole session (reuse detection).
+type RefreshToken struct {
+	ID        string     `gorm:"column:id;primaryKey" json:"id"`
+	SessionID string     `gorm:"column:session_id;index" json:"session_id"`
+	UserID    string     `gorm:"column:user_id;index" json:"user_id"`
+	TokenHash string     `gorm:"column:token_hash;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `gorm:"column:expires_at;index" json:"expires_at"`
+	UsedAt    *time.Time `gorm:"column:used_at" json:"used_at"`
+	CreatedAt time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// TwoFactorAuth holds a user's TOTP secret. The secret is pending until EnabledAt is set.
+type TwoFactorAuth struct {
+	UserID       string     `gorm:"column:user_id;primaryKey" json:"user_id"`
+	Secret       string     `gorm:"column:secret" json:"-"`
+	EnabledAt    *time.Time `gorm:"column:enabled_at" json:"enabled_at"`
+	LastUsedStep int64      `gorm:"column:last_used_step" json:"-"` // last accepted TOTP time step, prevents replay
+	CreatedAt    time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time  `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+}
+
+func (TwoFactorAuth) TableName() string {
+	return "two_factor_auths"
+}
+
+// IsEnabled returns true once enrollment has been confirmed with a code
+func (t *TwoFactorAuth) IsEnabled() bool {
+	return t.EnabledAt != nil
+}
+
+// RecoveryCode is a single-use backup code for two-factor login. Only its hash is stored.
+type RecoveryCode struct {
+	ID        string     `gorm:"column:id;primaryKey" json:"id"`
+	UserID    string     `gorm:"column:user_id;index" json:"user_id"`
+	CodeHash  string     `gorm:"column:code_hash;uniqueIndex" json:"-"`
+	UsedAt    *time.Time `gorm:"column:used_at" json:"used_at"`
+	CreatedAt time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
+
+// APIKey is a named, scoped credential for scripts and integrations. Only the hash of the key is stored.
+type APIKey struct {
+	ID         string     `gorm:"column:id;primaryKey" json:"id"`
+	UserID     string     `gorm:"column:user_id;index" json:"user_id"`
+	Name       string     `gorm:"column:name" json:"name"`
+	Prefix     string     `gorm:"column:prefix" json:"prefix"` // first characters of the key, to tell keys apart
+	KeyHash    string     `gorm:"column:key_hash;uniqueIndex" json:"-"`
+	Scopes     string     `gorm:"column:scopes" json:"scopes"` // comma-separated API scopes
+	LastUsedAt *time.Time `gorm:"column:last_used_at" json:"last_used_at"`
+	LastUsedIP string     `gorm:"column:last_used_ip" json:"last_used_ip"`
+	ExpiresAt  *time.Time `gorm:"column:expires_at" json:"expires_at"` // nil = never expires
+	RevokedAt  *time.Time `gorm:"column:revoked_at" json:"revoked_at"`
+	CreatedAt  time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// HasScope checks if the key grants the scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range strings.Split(k.Scopes, ",") {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// API key scopes
+const (
+	APIScopeRoomsCreate = "rooms:create"
+	APIScopeRoomsRead   = "rooms:read"
+	APIScopeTVConnect   = "tv:connect"
+	APIScopeCreditsRead = "credits:read"
+)
+
+// AllAPIScopes lists every scope a key can be granted
+var AllAPIScopes = []string{
+	APIScopeRoomsCreate,
+	APIScopeRoomsRead,
+	APIScopeTVConnect,
+	APIScopeCreditsRead,
+}
+
+// UserIdentity links a user to an external OpenID Connect account
+type UserIdentity struct {
+	ID          string     `gorm:"column:id;primaryKey" json:"id"`
+	UserID      string     `gorm:"column:user_id;index" json:"user_id"`
+	Provider    string     `gorm:"column:provider;uniqueIndex:idx_identity_provider_subject" json:"provider"`
+	Subject     string     `gorm:"column:subject;uniqueIndex:idx_identity_provider_subject" json:"-"`
+	Email       string     `gorm:"column:email" json:"email"`
+	CreatedAt   time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	LastLoginAt *time.Time `gorm:"column:last_login_at" json:"last_login_at"`
+}
+
+func (UserIdentity) TableName() string {
+	return "user_identities"
+}
+
+// OAuthState holds a pending OpenID Connect authorization request between redirect and callback
+type OAuthState struct {
+	ID           string    `gorm:"column:id;primaryKey" json:"id"`
+	StateHash    string    `gorm:"column:state_hash;uniqueIndex" json:"-"`
+	Provider     string    `gorm:"column:provider" json:"provider"`
+	Nonce        string    `gorm:"column:nonce" json:"-"`
+	CodeVerifier string    `gorm:"column:code_verifier" json:"-"`
+	LinkUserID   *string   `gorm:"column:link_user_id" json:"link_user_id"` // Set when linking to an existing account
+	ExpiresAt    time.Time `gorm:"column:expires_at;index" json:"expires_at"`
+	CreatedAt    time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (OAuthState) TableName() string {
+	return "oauth_states"
+}
+
+// Role is a named set of admin permissions (superadmin, finance, support, ...)
+type Role struct {
+	ID          string           `gorm:"column:id;primaryKey" json:"id"`
+	Name        string           `gorm:"column:name;uniqueIndex" json:"name"`
+	Description string           `gorm:"column:description" json:"description"`
+	CreatedAt   time.Time        `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	Permissions []RolePermission `gorm:"foreignKey:RoleID;references:ID" json:"permissions"`
+}
+
+func (Role) TableName() string {
+	return "roles"
+}
+
+// RolePermission grants one permission to a role
+type RolePermission struct {
+	ID         string `gorm:"column:id;primaryKey" json:"id"`
+	RoleID     string `gorm:"column:role_id;uniqueIndex:idx_role_permission" json:"role_id"`
+	Permission string `gorm:"column:permission;uniqueIndex:idx_role_permission" json:"permission"`
+}
+
+func (RolePermission) TableName() string {
+	return "role_permissions"
+}
+
+// UserRole assigns a role to a user
+type UserRole struct {
+	ID        string    `gorm:"column:id;primaryKey" json:"id"`
+	UserID    string    `gorm:"column:user_id;uniqueIndex:idx_user_role" json:"user_id"`
+	RoleID    string    `gorm:"column:role_id;uniqueIndex:idx_user_role" json:"role_id"`
+	GrantedBy string    `gorm:"column:granted_by" json:"granted_by"` // user ID of the admin, empty for artisan bootstrap
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	Role      Role      `gorm:"foreignKey:RoleID;references:ID" json:"role"`
+}
+
+func (UserRole) TableName() string {
+	return "user_roles"
+}
+
+// Built-in role names
+const (
+	RoleSuperAdmin       = "superadmin"
+	RoleFinance          = "finance"
+	RoleSupport          = "support"
+	RoleContentModerator = "content_moderator"
+)
+
+// Admin permission constants
+const (
+	PermissionAll                = "*" // every permission (superadmin)
+	PermissionDashboardView      = "dashboard.view"
+	PermissionConfigsRead        = "configs.read"
+	PermissionConfigsWrite       = "configs.write"
+	PermissionPackagesManage     = "packages.manage"
+	PermissionPlansManage        = "plans.manage"
+	PermissionUsersRead          = "users.read"
+	PermissionCreditsAward       = "credits.award"
+	PermissionTransactionsRead   = "transactions.read"
+	PermissionTransactionsManage = "transactions.manage"
+	PermissionRoomsRead          = "rooms.read"
+	PermissionRolesManage        = "roles.manage"
+	PermissionSecurityRead       = "security.read"
+	PermissionCouponsManage      = "coupons.manage"
+)
+
+// AllPermissions lists every assignable permission
+var AllPermissions = []string{
+	PermissionDashboardView,
+	PermissionConfigsRead,
+	PermissionConfigsWrite,
+	PermissionPackagesManage,
+	PermissionPlansManage,
+	PermissionUsersRead,
+	PermissionCreditsAward,
+	PermissionTransactionsRead,
+	PermissionTransactionsManage,
+	PermissionRoomsRead,
+	PermissionRolesManage,
+	PermissionSecurityRead,
+	PermissionCouponsManage,
+}
+
+// DefaultRolePermissions are seeded when a built-in role is first created
+var DefaultRolePermissions = map[string][]string{
+	RoleSuperAdmin: {PermissionAll},
+	RoleFinance: {
+		PermissionDashboardView,
+		PermissionTransactionsRead,
+		PermissionTransactionsManage,
+		PermissionPackagesManage,
+		PermissionPlansManage,
+		PermissionUsersRead,
+		PermissionCreditsAward,
+		PermissionCouponsManage,
+	},
+	RoleSupport: {
+		PermissionDashboardView,
+		PermissionUsersRead,
+		PermissionCreditsAward,
+		PermissionTransactionsRead,
+		PermissionRoomsRead,
+		PermissionSecurityRead,
+	},
+	RoleContentModerator: {
+		PermissionDashboardView,
+		PermissionRoomsRead,
+		PermissionUsersRead,
+	},
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// SubscriptionEvent records a change in a user's subscription, for the user's history and support
+type SubscriptionEvent struct {
+	ID            string     `gorm:"column:id;primaryKey" json:"id"`
+	UserID        string     `gorm:"column:user_id;index" json:"user_id"`
+	PlanID        *string    `gorm:"column:plan_id" json:"plan_id"`
+	Event         string     `gorm:"column:event" json:"event"`                   // see the SubscriptionEvent constants
+	TransactionID *string    `gorm:"column:transaction_id" json:"transaction_id"` // the purchase or renewal bill involved
+	ExpiresAt     *time.Time `gorm:"column:expires_at" json:"expires_at"`         // subscription expiry after the event
+	Detail        string     `gorm:"column:detail" json:"detail"`
+	CreatedAt     time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (SubscriptionEvent) TableName() string {
+	return "subscription_events"
+}
+
+// Subscription event constants
+const (
+	SubscriptionEventActivated          = "activated"           // a plan was bought without an active subscription
+	SubscriptionEventRenewalBilled      = "renewal_billed"      // the renewal bill was created and emailed
+	SubscriptionEventRenewed            = "renewed"             // a period was paid while the subscription was active or in grace
+	SubscriptionEventCancelled          = "cancelled"           // the user turned off auto-renewal
+	SubscriptionEventLapsed             = "lapsed"              // the subscription ended and the user is back on the free plan
+	SubscriptionEventUpgraded           = "upgraded"            // a higher plan replaced the current one straight away
+	SubscriptionEventDowngradeScheduled = "downgrade_scheduled" // a lower plan was chosen for the next period
+	SubscriptionEventDowngradeCancelled = "downgrade_cancelled" // the scheduled lower plan was dropped
+	SubscriptionEventDowngraded         = "downgraded"          // the scheduled lower plan took over at the end of the period
+)
@@ -1,6 +1,8 @@
 package providers
 
 import (
+	"GoFiberMVC/app/services"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/template/html/v2"
 	"github.com/spf13/viper"
@@ -10,6 +12,8 @@ import (
 func AppProvider() *fiber.App {
 	//Using HTML Engine
 	engine := html.New("app/views", ".html")
+	// Email templates are rendered with the same engine
+	services.SetMailViews(engine)
 	app := fiber.New(fiber.Config{
 		Views: engine,
 	})
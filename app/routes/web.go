@@ -2,6 +2,8 @@ package routes
 
 import (
 	"GoFiberMVC/app/controllers"
+	"GoFiberMVC/app/middlewares"
+	"GoFiberMVC/app/models"
 	ws "GoFiberMVC/app/websocket"
 
 	"github.com/gofiber/fiber/v2"
@@ -24,46 +26,140 @@ func RegisterWebRoutes(app *fiber.App) {
 	roomController := &controllers.RoomController{}
 	adminController := &controllers.AdminController{}
 	packageController := &controllers.PackageController{}
-	flipController := &controllers.FlipController{}
+	paymentController := &controllers.PaymentController{}
+	invoiceController := &controllers.InvoiceController{}
+	couponController := &controllers.CouponController{}
+	referralController := &controllers.ReferralController{}
+	subscriptionController := &controllers.SubscriptionController{}
+	oidcController := &controllers.OIDCController{}
+	twoFactorController := &controllers.TwoFactorController{}
+	apiKeyController := &controllers.APIKeyController{}
+	guestController := &controllers.GuestController{}
+
+	// Brute-force protection for the short TV codes and room keys
+	tvCodeThrottle := middlewares.NewThrottleMiddleware(models.AuditScopeTVCode, controllers.ThrottleAccountKeys)
+	roomKeyThrottle := middlewares.NewThrottleMiddleware(models.AuditScopeRoomKey, controllers.ThrottleAccountKeys)
+	couponThrottle := middlewares.NewThrottleMiddleware(models.AuditScopeCoupon, controllers.ThrottleAccountKeys)
+
+	// Credential throttles: failures count per IP and per submitted email
+	loginThrottle := middlewares.NewThrottleMiddleware(models.AuditScopeLogin, controllers.ThrottleEmailKeys)
+	loginThrottle.FailureStatuses = []int{fiber.StatusUnauthorized}
+	loginThrottle.OnLockout = controllers.SendUnlockEmail
+	registerThrottle := middlewares.NewThrottleMiddleware(models.AuditScopeRegister, nil)
+	registerThrottle.FailureStatuses = []int{fiber.StatusBadRequest, fiber.StatusConflict}
+	forgotPasswordThrottle := middlewares.NewThrottleMiddleware(models.AuditScopePasswordReset, controllers.ThrottleEmailKeys)
+	forgotPasswordThrottle.CountAll = true
+	resetPasswordThrottle := middlewares.NewThrottleMiddleware(models.AuditScopePasswordReset, nil)
+	resetPasswordThrottle.FailureStatuses = []int{fiber.StatusBadRequest}
+	twoFactorThrottle := middlewares.NewThrottleMiddleware(models.AuditScopeTwoFactor, controllers.ThrottleChallengeKeys)
+	twoFactorThrottle.FailureStatuses = []int{fiber.StatusBadRequest, fiber.StatusUnauthorized}
+	twoFactorAccountThrottle := middlewares.NewThrottleMiddleware(models.AuditScopeTwoFactor, controllers.ThrottleAccountKeys)
+	twoFactorAccountThrottle.FailureStatuses = []int{fiber.StatusBadRequest, fiber.StatusUnauthorized}
+
+	// API key scopes for routes that venue integrations may call
+	tvConnectScope := controllers.RequireAPIScope(models.APIScopeTVConnect)
+	reauthThrottle := middlewares.NewThrottleMiddleware(models.AuditScopeReauth, controllers.ThrottleAccountKeys)
+	reauthThrottle.FailureStatuses = []int{fiber.StatusUnauthorized}
 
 	app.Get("", userController.Index)
 
 	// Auth routes
-	app.Post("/api/auth/register", authController.Register)
-	app.Post("/api/auth/login", authController.Login)
+	app.Post("/api/auth/register", registerThrottle.Limit, authController.Register)
+	app.Post("/api/auth/login", loginThrottle.Limit, authController.Login)
 	app.Get("/api/auth/me", authController.Me)
 	app.Post("/api/auth/logout", authController.Logout)
+	app.Post("/api/auth/refresh", authController.Refresh)
+	app.Get("/api/auth/sessions", authController.ListSessions)
+	app.Delete("/api/auth/sessions", authController.RevokeOtherSessions)
+	app.Delete("/api/auth/sessions/:id", authController.RevokeSession)
+	app.Post("/api/auth/forgot-password", forgotPasswordThrottle.Limit, authController.ForgotPassword)
+	app.Post("/api/auth/reset-password", resetPasswordThrottle.Limit, authController.ResetPassword)
+	app.Post("/api/auth/unlock", resetPasswordThrottle.Limit, authController.UnlockAccount)
+
+	// Two-factor authentication
+	app.Post("/api/auth/2fa/verify", twoFactorThrottle.Limit, twoFactorController.VerifyLogin)
+	app.Get("/api/auth/2fa", twoFactorController.Status)
+	app.Post("/api/auth/2fa/setup", twoFactorController.Setup)
+	app.Post("/api/auth/2fa/enable", twoFactorAccountThrottle.Limit, twoFactorController.Enable)
+	app.Post("/api/auth/2fa/disable", twoFactorAccountThrottle.Limit, twoFactorController.Disable)
+	app.Post("/api/auth/2fa/recovery-codes", twoFactorAccountThrottle.Limit, twoFactorController.RegenerateRecoveryCodes)
+	app.Post("/api/auth/verify-email", authController.VerifyEmail)
+	app.Post("/api/auth/resend-verification", authController.ResendVerification)
+
+	// Account self-service
+	app.Get("/api/user/profile", userController.Profile)
+	app.Put("/api/user/profile", userController.UpdateProfile)
+	app.Put("/api/user/password", reauthThrottle.Limit, userController.ChangePassword)
+	app.Post("/api/user/email", reauthThrottle.Limit, userController.ChangeEmail)
+	app.Post("/api/user/email/confirm", userController.ConfirmEmailChange)
+	app.Get("/api/user/export", userController.Export)
+	app.Delete("/api/user", reauthThrottle.Limit, userController.Delete)
+
+	// Guest history claims (link guest identities to the signed-in account)
+	app.Post("/api/user/claim-guests", guestController.Claim)
+
+	// Personal API keys (managed with a login session, never with another key)
+	app.Get("/api/user/api-keys", apiKeyController.List)
+	app.Post("/api/user/api-keys", apiKeyController.Create)
+	app.Delete("/api/user/api-keys/:id", apiKeyController.Revoke)
+
+	// Social login (OpenID Connect)
+	app.Get("/api/auth/oidc/providers", oidcController.ListProviders)
+	app.Get("/api/auth/oidc/:provider/login", oidcController.Login)
+	app.Get("/api/auth/oidc/:provider/callback", oidcController.Callback)
+	app.Post("/api/auth/oidc/:provider/link", oidcController.Link)
+	app.Get("/api/auth/oidc/:provider/link/start", oidcController.StartLink)
+	app.Get("/api/auth/identities", oidcController.ListIdentities)
+	app.Delete("/api/auth/identities/:provider", oidcController.Unlink)
 
 	// Room routes
-	app.Post("/api/rooms", roomController.Create)
-	app.Get("/api/rooms", roomController.List)
-	app.Get("/api/rooms/:roomKey", roomController.Get)
-	app.Get("/api/rooms/:roomKey/access", roomController.CheckAccess)
+	app.Post("/api/rooms", controllers.RequireAPIScope(models.APIScopeRoomsCreate), roomController.Create)
+	app.Get("/api/rooms", controllers.RequireAPIScope(models.APIScopeRoomsRead), roomController.List)
+	app.Get("/api/rooms/:roomKey", controllers.RequireAPIScope(models.APIScopeRoomsRead), roomKeyThrottle.Limit, roomController.Get)
+	app.Get("/api/rooms/:roomKey/access", controllers.RequireAPIScope(models.APIScopeRoomsRead), roomKeyThrottle.Limit, roomController.CheckAccess)
+	app.Post("/api/rooms/:roomKey/ws-ticket", roomKeyThrottle.Limit, roomController.WebsocketTicket)
 
 	// Admin check (no middleware - returns is_admin status)
 	app.Get("/api/admin/check", adminController.CheckAdmin)
 
 	// Admin routes (protected)
 	admin := app.Group("/api/admin", controllers.AdminMiddleware)
-	admin.Get("/dashboard", adminController.GetDashboardStats)
-	admin.Get("/configs", adminController.GetConfigs)
-	admin.Post("/configs", adminController.CreateConfig)
-	admin.Put("/configs/:key", adminController.UpdateConfig)
-	admin.Delete("/configs/:key", adminController.DeleteConfig)
-	admin.Get("/packages", adminController.ListPackages)
-	admin.Post("/packages", adminController.CreatePackage)
-	admin.Put("/packages/:id", adminController.UpdatePackage)
-	admin.Delete("/packages/:id", adminController.DeletePackage)
-	admin.Get("/subscription-plans", adminController.ListSubscriptionPlans)
-	admin.Post("/subscription-plans", adminController.CreateSubscriptionPlan)
-	admin.Put("/subscription-plans/:id", adminController.UpdateSubscriptionPlan)
-	admin.Delete("/subscription-plans/:id", adminController.DeleteSubscriptionPlan)
-	admin.Get("/users", adminController.ListUsers)
-	admin.Get("/users/:id", adminController.GetUser)
-	admin.Post("/credits/award", adminController.AwardCredits)
-	admin.Get("/transactions", adminController.ListTransactions)
-	admin.Put("/transactions/:id/status", adminController.UpdateTransactionStatus)
-	admin.Get("/rooms", adminController.ListRooms)
+	admin.Get("/dashboard", controllers.RequirePermission(models.PermissionDashboardView), adminController.GetDashboardStats)
+	admin.Get("/configs", controllers.RequirePermission(models.PermissionConfigsRead), adminController.GetConfigs)
+	admin.Post("/configs", controllers.RequirePermission(models.PermissionConfigsWrite), adminController.CreateConfig)
+	admin.Put("/configs/:key", controllers.RequirePermission(models.PermissionConfigsWrite), adminController.UpdateConfig)
+	admin.Delete("/configs/:key", controllers.RequirePermission(models.PermissionConfigsWrite), adminController.DeleteConfig)
+	admin.Get("/packages", controllers.RequirePermission(models.PermissionPackagesManage), adminController.ListPackages)
+	admin.Post("/packages", controllers.RequirePermission(models.PermissionPackagesManage), adminController.CreatePackage)
+	admin.Put("/packages/:id", controllers.RequirePermission(models.PermissionPackagesManage), adminController.UpdatePackage)
+	admin.Delete("/packages/:id", controllers.RequirePermission(models.PermissionPackagesManage), adminController.DeletePackage)
+	admin.Get("/subscription-plans", controllers.RequirePermission(models.PermissionPlansManage), adminController.ListSubscriptionPlans)
+	admin.Post("/subscription-plans", controllers.RequirePermission(models.PermissionPlansManage), adminController.CreateSubscriptionPlan)
+	admin.Put("/subscription-plans/:id", controllers.RequirePermission(models.PermissionPlansManage), adminController.UpdateSubscriptionPlan)
+	admin.Delete("/subscription-plans/:id", controllers.RequirePermission(models.PermissionPlansManage), adminController.DeleteSubscriptionPlan)
+	admin.Get("/users", controllers.RequirePermission(models.PermissionUsersRead), adminController.ListUsers)
+	admin.Get("/users/:id", controllers.RequirePermission(models.PermissionUsersRead), adminController.GetUser)
+	admin.Post("/credits/award", controllers.RequirePermission(models.PermissionCreditsAward), adminController.AwardCredits)
+	admin.Get("/transactions", controllers.RequirePermission(models.PermissionTransactionsRead), adminController.ListTransactions)
+	admin.Put("/transactions/:id/status", controllers.RequirePermission(models.PermissionTransactionsManage), adminController.UpdateTransactionStatus)
+	admin.Post("/transactions/:id/refund", controllers.RequirePermission(models.PermissionTransactionsManage), adminController.RefundTransaction)
+	admin.Get("/transactions/:id/invoice", controllers.RequirePermission(models.PermissionTransactionsRead), adminController.DownloadInvoice)
+	admin.Post("/transactions/:id/invoice/regenerate", controllers.RequirePermission(models.PermissionTransactionsManage), adminController.RegenerateInvoice)
+	admin.Get("/transactions/reconcile-runs", controllers.RequirePermission(models.PermissionTransactionsRead), adminController.ListReconcileRuns)
+	admin.Post("/transactions/reconcile", controllers.RequirePermission(models.PermissionTransactionsManage), adminController.RunReconcile)
+	admin.Get("/coupons", controllers.RequirePermission(models.PermissionCouponsManage), adminController.ListCoupons)
+	admin.Post("/coupons", controllers.RequirePermission(models.PermissionCouponsManage), adminController.CreateCoupon)
+	admin.Put("/coupons/:id", controllers.RequirePermission(models.PermissionCouponsManage), adminController.UpdateCoupon)
+	admin.Delete("/coupons/:id", controllers.RequirePermission(models.PermissionCouponsManage), adminController.DeleteCoupon)
+	admin.Get("/rooms", controllers.RequirePermission(models.PermissionRoomsRead), adminController.ListRooms)
+	admin.Get("/permissions", controllers.RequirePermission(models.PermissionRolesManage), adminController.ListPermissions)
+	admin.Get("/roles", controllers.RequirePermission(models.PermissionRolesManage), adminController.ListRoles)
+	admin.Post("/roles", controllers.RequirePermission(models.PermissionRolesManage), adminController.CreateRole)
+	admin.Put("/roles/:id", controllers.RequirePermission(models.PermissionRolesManage), adminController.UpdateRole)
+	admin.Delete("/roles/:id", controllers.RequirePermission(models.PermissionRolesManage), adminController.DeleteRole)
+	admin.Post("/users/:id/roles", controllers.RequirePermission(models.PermissionRolesManage), adminController.AssignRole)
+	admin.Delete("/users/:id/roles/:role", controllers.RequirePermission(models.PermissionRolesManage), adminController.RevokeRole)
+	admin.Get("/security/failed-logins", controllers.RequirePermission(models.PermissionSecurityRead), adminController.ListFailedLogins)
 
 	// Public package/plan routes
 	app.Get("/api/packages", packageController.ListPublic)
@@ -71,29 +167,49 @@ func RegisterWebRoutes(app *fiber.App) {
 	app.Get("/api/free-plan-info", packageController.GetFreePlanInfo)
 	app.Get("/api/transactions", packageController.MyTransactions)
 	app.Get("/api/transactions/:id", packageController.GetTransaction)
-	app.Get("/api/credits", packageController.GetMyCredits)
+	app.Get("/api/transactions/:id/invoice", invoiceController.Download)
+	app.Get("/api/credits", controllers.RequireAPIScope(models.APIScopeCreditsRead), packageController.GetMyCredits)
 
-	// Flip payment routes
-	app.Post("/api/flip/create-bill", flipController.CreateBill)
-	app.Post("/api/flip/callback", flipController.HandleCallback)
-	app.Get("/api/flip/check/:id", flipController.CheckTransaction)
+	// Payment routes (the active provider is selected by the payment_provider config)
+	app.Post("/api/payments/create-bill", couponThrottle.Limit, paymentController.CreateBill)
+	app.Post("/api/payments/callback/:provider", paymentController.HandleCallback)
+	app.Get("/api/payments/check/:id", paymentController.CheckTransaction)
+	app.Post("/api/coupons/validate", couponThrottle.Limit, couponController.Validate)
+	app.Get("/api/referrals", referralController.Stats)
+	app.Get("/api/subscription", subscriptionController.Status)
+	app.Post("/api/subscription/cancel-auto-renew", subscriptionController.CancelAutoRenew)
+	app.Post("/api/subscription/upgrade", subscriptionController.Upgrade)
+	app.Post("/api/subscription/downgrade", subscriptionController.Downgrade)
+	app.Delete("/api/subscription/downgrade", subscriptionController.CancelDowngrade)
+
+	// Legacy Flip routes, kept for the callback URL registered in the Flip dashboard
+	app.Post("/api/flip/create-bill", couponThrottle.Limit, paymentController.CreateBill)
+	app.Post("/api/flip/callback", paymentController.HandleCallback)
+	app.Get("/api/flip/check/:id", paymentController.CheckTransaction)
 
 	// TV connection routes
 	tvController := &controllers.TVController{}
-	app.Post("/api/tv/token", tvController.GenerateToken)          // Generate new TV token (no auth - TV device)
-	app.Get("/api/tv/status/:token", tvController.GetStatus)       // Check TV connection status (no auth - TV polls)
-	app.Post("/api/tv/connect", tvController.Connect)              // Connect TV to room (requires auth - room master)
-	app.Post("/api/tv/disconnect/:token", tvController.Disconnect) // Disconnect TV from room
+	app.Post("/api/tv/token", tvController.GenerateToken)                                                // Generate new TV token (no auth - TV device)
+	app.Get("/api/tv/status/:token", tvController.GetStatus)                                             // Check TV connection status (no auth - TV polls)
+	app.Post("/api/tv/connect", tvConnectScope, tvCodeThrottle.Limit, tvController.Connect)              // Connect TV to room (requires auth - room master)
+	app.Post("/api/tv/disconnect/:token", tvConnectScope, tvCodeThrottle.Limit, tvController.Disconnect) // Disconnect TV from room
+
+	// Remembered TV devices
+	app.Get("/api/tv/device/status", tvController.DeviceStatus) // Remembered TV polls with X-TV-Device-Token (no user auth)
+	app.Get("/api/tv/devices", tvConnectScope, tvController.ListDevices)
+	app.Post("/api/tv/devices/push", tvConnectScope, tvController.PushRoom)
+	app.Put("/api/tv/devices/:id", tvController.RenameDevice)
+	app.Delete("/api/tv/devices/:id", tvController.RevokeDevice)
 
 	// WebSocket routes for karaoke rooms
 	// Support both /ws/:roomKey and /parties/main/:roomKey for compatibility
 	app.Use("/ws", ws.WebSocketUpgrade)
-	app.Get("/ws/:roomKey", websocket.New(ws.HandleWebSocket))
+	app.Get("/ws/:roomKey", roomKeyThrottle.Limit, ws.RequireRoom, websocket.New(ws.HandleWebSocket))
 
 	// PartyKit-compatible route
 	app.Use("/parties/main", ws.WebSocketUpgrade)
-	app.Get("/parties/main/:roomKey", websocket.New(ws.HandleWebSocket))
+	app.Get("/parties/main/:roomKey", roomKeyThrottle.Limit, ws.RequireRoom, websocket.New(ws.HandleWebSocket))
 
 	// HTTP API for room state
-	app.Get("/api/room/:roomKey", ws.GetRoomState)
+	app.Get("/api/room/:roomKey", roomKeyThrottle.Limit, ws.GetRoomState)
 }
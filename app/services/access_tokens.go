@@ -0,0 +1,138 @@
+package services
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/spf13/viper"
+)
+
+// Auth modes selected by auth.mode in config.json
+const (
+	AuthModeSession = "session" // opaque bearer token checked against the sessions table
+	AuthModeJWT     = "jwt"     // short-lived RS256 access token plus a rotating refresh token
+)
+
+// AccessTokenIssuer is the iss claim of access tokens minted by this app
+const AccessTokenIssuer = "karayouke"
+
+// AccessClaims are the claims carried by an access token
+type AccessClaims struct {
+	SessionID string `json:"sid"`
+	jwt.StandardClaims
+}
+
+type accessTokenKeys struct {
+	private *rsa.PrivateKey
+	public  *rsa.PublicKey
+	err     error
+}
+
+var (
+	tokenKeys     accessTokenKeys
+	tokenKeysOnce sync.Once
+)
+
+// loadAccessTokenKeys reads the RSA key pair configured under oauth.* once per process
+func loadAccessTokenKeys() accessTokenKeys {
+	tokenKeysOnce.Do(func() {
+		privatePEM, err := os.ReadFile(viper.GetString("oauth.privateKey"))
+		if err != nil {
+			tokenKeys.err = fmt.Errorf("failed to read private key: %w", err)
+			return
+		}
+		publicPEM, err := os.ReadFile(viper.GetString("oauth.publicKey"))
+		if err != nil {
+			tokenKeys.err = fmt.Errorf("failed to read public key: %w", err)
+			return
+		}
+		if tokenKeys.private, err = jwt.ParseRSAPrivateKeyFromPEM(privatePEM); err != nil {
+			tokenKeys.err = fmt.Errorf("failed to parse private key: %w", err)
+			return
+		}
+		if tokenKeys.public, err = jwt.ParseRSAPublicKeyFromPEM(publicPEM); err != nil {
+			tokenKeys.err = fmt.Errorf("failed to parse public key: %w", err)
+		}
+	})
+	return tokenKeys
+}
+
+// AuthMode returns the configured auth mode (auth.mode), defaulting to session tokens
+func AuthMode() string {
+	if strings.ToLower(viper.GetString("auth.mode")) == AuthModeJWT {
+		return AuthModeJWT
+	}
+	return AuthModeSession
+}
+
+// AccessTokenTTL returns the lifetime of access tokens (auth.accessTokenMinutes, default 15)
+func AccessTokenTTL() time.Duration {
+	minutes := viper.GetInt("auth.accessTokenMinutes")
+	if minutes <= 0 {
+		minutes = 15
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// RefreshTokenTTL returns the lifetime of a single refresh token (auth.refreshTokenDays, default 30)
+func RefreshTokenTTL() time.Duration {
+	days := viper.GetInt("auth.refreshTokenDays")
+	if days <= 0 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// SignAccessToken mints an RS256 access token for the user's session
+func SignAccessToken(userID string, sessionID string, tokenID string) (string, error) {
+	keys := loadAccessTokenKeys()
+	if keys.err != nil {
+		return "", keys.err
+	}
+	now := time.Now()
+	claims := AccessClaims{
+		SessionID: sessionID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        tokenID,
+			Subject:   userID,
+			Issuer:    AccessTokenIssuer,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(AccessTokenTTL()).Unix(),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(keys.private)
+}
+
+// VerifyAccessToken checks the signature, issuer and lifetime of an access token without touching the database
+func VerifyAccessToken(raw string) (*AccessClaims, error) {
+	keys := loadAccessTokenKeys()
+	if keys.err != nil {
+		return nil, keys.err
+	}
+	claims := &AccessClaims{}
+	token, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != jwt.SigningMethodRS256.Name {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return keys.public, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid || !claims.VerifyIssuer(AccessTokenIssuer, true) || claims.Subject == "" {
+		return nil, errors.New("invalid access token")
+	}
+	return claims, nil
+}
+
+// LooksLikeJWT reports whether a bearer token is a JWT rather than an opaque session token
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
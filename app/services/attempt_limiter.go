@@ -0,0 +1,207 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// AttemptRecord tracks failed attempts for a single key (an IP, an account, ...)
+type AttemptRecord struct {
+	Failures    int
+	FirstFailAt time.Time
+	LastFailAt  time.Time
+	LockedUntil time.Time
+}
+
+// AttemptStore persists failed-attempt records for the AttemptLimiter.
+// Update must apply fn atomically so concurrent failures are all counted.
+type AttemptStore interface {
+	Get(key string) (AttemptRecord, bool)
+	Update(key string, fn func(record *AttemptRecord)) AttemptRecord
+	Delete(key string)
+}
+
+// MemoryAttemptStore is an in-process AttemptStore for tests and single-node deployments
+type MemoryAttemptStore struct {
+	mu      sync.Mutex
+	records map[string]AttemptRecord
+}
+
+// NewMemoryAttemptStore creates an empty in-memory store
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{records: make(map[string]AttemptRecord)}
+}
+
+func (s *MemoryAttemptStore) Get(key string) (AttemptRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[key]
+	return record, ok
+}
+
+func (s *MemoryAttemptStore) Update(key string, fn func(record *AttemptRecord)) AttemptRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := s.records[key]
+	fn(&record)
+	s.records[key] = record
+	return record
+}
+
+func (s *MemoryAttemptStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+}
+
+// Sweep drops records that are unlocked and have not failed since the given time
+func (s *MemoryAttemptStore) Sweep(before time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, record := range s.records {
+		if record.LastFailAt.Before(before) && record.LockedUntil.Before(now) {
+			delete(s.records, key)
+		}
+	}
+}
+
+// AttemptLimiter applies exponential-backoff lockouts to keys that keep failing
+type AttemptLimiter struct {
+	Store        AttemptStore
+	FreeAttempts int              // failures allowed before the first lockout
+	BaseLockout  time.Duration    // first lockout, doubled for every further failure
+	MaxLockout   time.Duration    // upper bound for a single lockout
+	Window       time.Duration    // failures older than this are forgotten
+	Clock        func() time.Time // time source, time.Now when nil
+}
+
+// Now returns the limiter's current time
+func (l *AttemptLimiter) Now() time.Time {
+	if l.Clock != nil {
+		return l.Clock()
+	}
+	return time.Now()
+}
+
+// RetryAfter returns the longest remaining lockout among the given keys (0 if none is locked)
+func (l *AttemptLimiter) RetryAfter(keys ...string) time.Duration {
+	now := l.Now()
+	var wait time.Duration
+	for _, key := range keys {
+		record, ok := l.Store.Get(key)
+		if !ok {
+			continue
+		}
+		if remaining := record.LockedUntil.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait
+}
+
+// Fail records a failed attempt for every key and returns the longest lockout it triggered
+func (l *AttemptLimiter) Fail(keys ...string) time.Duration {
+	now := l.Now()
+	var longest time.Duration
+	for _, key := range keys {
+		record := l.Store.Update(key, func(record *AttemptRecord) {
+			// Start over once the previous failures fall out of the window
+			if record.Failures > 0 && now.Sub(record.LastFailAt) > l.Window && now.After(record.LockedUntil) {
+				*record = AttemptRecord{}
+			}
+			if record.Failures == 0 {
+				record.FirstFailAt = now
+			}
+			record.Failures++
+			record.LastFailAt = now
+			if lockout := l.lockoutFor(record.Failures); lockout > 0 {
+				record.LockedUntil = now.Add(lockout)
+			}
+		})
+		if remaining := record.LockedUntil.Sub(now); remaining > longest {
+			longest = remaining
+		}
+	}
+	return longest
+}
+
+// Failures returns the current failure count for a key
+func (l *AttemptLimiter) Failures(key string) int {
+	record, ok := l.Store.Get(key)
+	if !ok {
+		return 0
+	}
+	return record.Failures
+}
+
+// Reset clears the records for the given keys (e.g. after a successful login)
+func (l *AttemptLimiter) Reset(keys ...string) {
+	for _, key := range keys {
+		l.Store.Delete(key)
+	}
+}
+
+// lockoutFor returns the lockout triggered by the n-th consecutive failure
+func (l *AttemptLimiter) lockoutFor(failures int) time.Duration {
+	over := failures - l.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+	lockout := l.BaseLockout
+	for i := 1; i < over; i++ {
+		lockout *= 2
+		if lockout >= l.MaxLockout {
+			return l.MaxLockout
+		}
+	}
+	if lockout > l.MaxLockout {
+		return l.MaxLockout
+	}
+	return lockout
+}
+
+var (
+	attemptStore     *MemoryAttemptStore
+	attemptStoreOnce sync.Once
+)
+
+// DefaultAttemptStore returns the process-wide in-memory attempt store
+func DefaultAttemptStore() *MemoryAttemptStore {
+	attemptStoreOnce.Do(func() {
+		attemptStore = NewMemoryAttemptStore()
+		go func() {
+			ticker := time.NewTicker(10 * time.Minute)
+			for range ticker.C {
+				attemptStore.Sweep(time.Now().Add(-24 * time.Hour))
+			}
+		}()
+	})
+	return attemptStore
+}
+
+// NewAttemptLimiter builds a limiter on the default store using the "throttle" settings from config.json
+func NewAttemptLimiter() *AttemptLimiter {
+	limiter := &AttemptLimiter{
+		Store:        DefaultAttemptStore(),
+		FreeAttempts: viper.GetInt("throttle.freeAttempts"),
+		BaseLockout:  time.Duration(viper.GetInt("throttle.baseLockoutSeconds")) * time.Second,
+		MaxLockout:   time.Duration(viper.GetInt("throttle.maxLockoutMinutes")) * time.Minute,
+		Window:       time.Duration(viper.GetInt("throttle.windowMinutes")) * time.Minute,
+	}
+	if limiter.FreeAttempts <= 0 {
+		limiter.FreeAttempts = 5
+	}
+	if limiter.BaseLockout <= 0 {
+		limiter.BaseLockout = 30 * time.Second
+	}
+	if limiter.MaxLockout <= 0 {
+		limiter.MaxLockout = time.Hour
+	}
+	if limiter.Window <= 0 {
+		limiter.Window = 15 * time.Minute
+	}
+	return limiter
+}
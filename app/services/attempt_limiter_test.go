@@ -0,0 +1,95 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// testClock is a hand-wound clock for limiter tests
+type testClock struct{ now time.Time }
+
+func (c *testClock) Now() time.Time          { return c.now }
+func (c *testClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestLimiter(clock *testClock) *AttemptLimiter {
+	return &AttemptLimiter{
+		Store:        NewMemoryAttemptStore(),
+		FreeAttempts: 3,
+		BaseLockout:  30 * time.Second,
+		MaxLockout:   5 * time.Minute,
+		Window:       15 * time.Minute,
+		Clock:        clock.Now,
+	}
+}
+
+func TestAttemptLimiterLockoutGrowsExponentially(t *testing.T) {
+	clock := &testClock{now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	limiter := newTestLimiter(clock)
+
+	for i := 1; i <= 3; i++ {
+		if lockout := limiter.Fail("ip:1"); lockout != 0 {
+			t.Fatalf("free attempt %d locked out for %s", i, lockout)
+		}
+	}
+
+	// Every failure past the free ones doubles the lockout, up to the maximum
+	for _, want := range []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		if lockout := limiter.Fail("ip:1"); lockout != want {
+			t.Fatalf("failure %d: lockout %s, want %s", limiter.Failures("ip:1"), lockout, want)
+		}
+		if wait := limiter.RetryAfter("ip:1"); wait != want {
+			t.Fatalf("retry after %s, want %s", wait, want)
+		}
+		clock.Advance(want)
+		if wait := limiter.RetryAfter("ip:1"); wait != 0 {
+			t.Fatalf("still locked for %s after the lockout ran out", wait)
+		}
+	}
+}
+
+func TestAttemptLimiterKeysAndWindow(t *testing.T) {
+	clock := &testClock{now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	limiter := newTestLimiter(clock)
+
+	// The longest lockout among the keys wins
+	for i := 0; i < 4; i++ {
+		limiter.Fail("ip:1", "account:a")
+	}
+	limiter.Fail("ip:2")
+	if wait := limiter.RetryAfter("ip:2", "account:a"); wait != 30*time.Second {
+		t.Fatalf("retry after %s, want the account's 30s", wait)
+	}
+
+	// Failures older than the window are forgotten once no lockout is running
+	clock.Advance(16 * time.Minute)
+	if lockout := limiter.Fail("account:a"); lockout != 0 || limiter.Failures("account:a") != 1 {
+		t.Fatalf("after the window: lockout %s, failures %d", lockout, limiter.Failures("account:a"))
+	}
+}
+
+func TestAttemptLimiterResetOnSuccess(t *testing.T) {
+	clock := &testClock{now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	limiter := newTestLimiter(clock)
+
+	for i := 0; i < 5; i++ {
+		limiter.Fail("ip:1", "account:a")
+	}
+	if limiter.RetryAfter("account:a") == 0 {
+		t.Fatal("account not locked after five failures")
+	}
+
+	limiter.Reset("account:a")
+	if wait := limiter.RetryAfter("account:a"); wait != 0 || limiter.Failures("account:a") != 0 {
+		t.Fatalf("after reset: retry after %s, failures %d", wait, limiter.Failures("account:a"))
+	}
+	if limiter.Failures("ip:1") != 5 {
+		t.Fatalf("reset of one key cleared another: %d failures", limiter.Failures("ip:1"))
+	}
+
+	// The count starts from zero again, so the free attempts are back
+	for i := 0; i < 3; i++ {
+		if lockout := limiter.Fail("account:a"); lockout != 0 {
+			t.Fatalf("free attempt %d after reset locked out for %s", i+1, lockout)
+		}
+	}
+}
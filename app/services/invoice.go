@@ -0,0 +1,162 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"GoFiberMVC/app/models"
+)
+
+// FormatIDR formats an amount in rupiah with dots between thousands, e.g. "Rp 150.000"
+func FormatIDR(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	digits := fmt.Sprintf("%d", amount)
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte('.')
+		}
+		b.WriteRune(d)
+	}
+	return sign + "Rp " + b.String()
+}
+
+// DescribePaymentMethod turns a Transaction.PaymentMethod such as "flip:bca:bank_account" into
+// "Flip (BCA, bank account)"
+func DescribePaymentMethod(method string) string {
+	parts := strings.Split(method, ":")
+	if parts[0] == "" {
+		return "-"
+	}
+	name := strings.ToUpper(parts[0][:1]) + parts[0][1:]
+	if len(parts) == 1 {
+		return name
+	}
+	details := []string{strings.ToUpper(parts[1])}
+	if len(parts) > 2 && parts[2] != "" {
+		details = append(details, strings.ReplaceAll(parts[2], "_", " "))
+	}
+	return name + " (" + strings.Join(details, ", ") + ")"
+}
+
+// RenderInvoicePDF lays out the invoice on one A4 page. Refunded invoices keep their number
+// and are marked as refunded.
+func RenderInvoicePDF(invoice *models.Invoice, refunded bool) []byte {
+	const (
+		left  = 50.0
+		right = PDFPageWidth - 50
+	)
+	doc := NewPDFDocument()
+
+	// Invoices and transactions from before coupons have no list price
+	listAmount := invoice.ListAmount
+	if listAmount == 0 {
+		listAmount = invoice.Amount
+	}
+
+	// Seller
+	y := 70.0
+	doc.Text(left, y, 18, true, invoice.CompanyName)
+	y += 18
+	for _, line := range strings.Split(invoice.CompanyAddress, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			doc.Text(left, y, 9, false, line)
+			y += 12
+		}
+	}
+	if invoice.CompanyEmail != "" {
+		doc.Text(left, y, 9, false, invoice.CompanyEmail)
+		y += 12
+	}
+	if invoice.CompanyTaxID != "" {
+		doc.Text(left, y, 9, false, "NPWP: "+invoice.CompanyTaxID)
+		y += 12
+	}
+
+	// Invoice heading
+	status := "PAID"
+	if refunded {
+		status = "REFUNDED"
+	}
+	doc.TextRight(right, 70, 22, true, "INVOICE")
+	doc.TextRight(right, 88, 10, false, invoice.Number)
+	doc.TextRight(right, 102, 9, false, "Issued "+invoice.IssuedAt.Format("2 January 2006"))
+	doc.TextRight(right, 118, 11, true, status)
+
+	if y < 135 {
+		y = 135
+	}
+	doc.Line(left, y, right, y)
+
+	// Buyer and payment
+	y += 25
+	doc.Text(left, y, 9, true, "BILLED TO")
+	doc.Text(310, y, 9, true, "PAYMENT")
+	y += 15
+	doc.Text(left, y, 10, false, invoice.BuyerName)
+	doc.Text(310, y, 10, false, DescribePaymentMethod(invoice.PaymentMethod))
+	y += 13
+	doc.Text(left, y, 10, false, invoice.BuyerEmail)
+	if invoice.PaidAt != nil {
+		doc.Text(310, y, 9, false, "Paid "+invoice.PaidAt.Format("2 January 2006 15:04 MST"))
+	}
+	y += 13
+	doc.Text(310, y, 9, false, "Transaction "+invoice.TransactionID)
+	if invoice.PaymentID != "" {
+		y += 12
+		doc.Text(310, y, 9, false, "Payment ID "+invoice.PaymentID)
+	}
+
+	// Items
+	y += 35
+	doc.FillRect(left, y-14, right-left, 22, 0.92)
+	doc.Text(left+8, y, 9, true, "DESCRIPTION")
+	doc.TextRight(390, y, 9, true, "QTY")
+	doc.TextRight(right-8, y, 9, true, "AMOUNT (IDR)")
+	y += 28
+	doc.Text(left+8, y, 10, false, invoice.ItemName)
+	doc.TextRight(390, y, 10, false, "1")
+	doc.TextRight(right-8, y, 10, false, FormatIDR(listAmount))
+	if invoice.ItemDetail != "" {
+		y += 13
+		doc.Text(left+8, y, 8.5, false, invoice.ItemDetail)
+	}
+	y += 14
+	doc.Line(left, y, right, y)
+
+	if invoice.Discount > 0 {
+		y += 20
+		doc.Text(330, y, 10, false, "Subtotal")
+		doc.TextRight(right-8, y, 10, false, FormatIDR(listAmount))
+		y += 16
+		label := "Discount"
+		if invoice.CouponCode != "" {
+			label += " (" + invoice.CouponCode + ")"
+		}
+		doc.Text(330, y, 10, false, label)
+		doc.TextRight(right-8, y, 10, false, "-"+FormatIDR(invoice.Discount))
+	}
+
+	y += 22
+	doc.Text(330, y, 11, true, "TOTAL")
+	doc.TextRight(right-8, y, 11, true, FormatIDR(invoice.Amount))
+	if refunded {
+		y += 18
+		doc.Text(330, y, 9, false, "This payment was refunded.")
+	}
+
+	// Footer
+	footer := "This receipt was generated electronically and is valid without a signature."
+	if invoice.RegeneratedAt != nil {
+		footer += " Reissued " + invoice.RegeneratedAt.Format("2 January 2006") + "."
+	}
+	doc.Text(left, PDFPageHeight-50, 8, false, footer)
+	doc.TextRight(right, PDFPageHeight-50, 8, false, "Printed "+time.Now().Format("2006-01-02 15:04"))
+
+	return doc.Bytes()
+}
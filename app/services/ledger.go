@@ -0,0 +1,401 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"GoFiberMVC/app/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInsufficientCredits is returned when a spend exceeds the user's free + extra credits
+	ErrInsufficientCredits = errors.New("insufficient credits")
+	// ErrNegativeBalance is returned when a posting would take a balance below zero
+	ErrNegativeBalance = errors.New("credit balance cannot go below zero")
+)
+
+// Ledger account names
+const (
+	ledgerUserPrefix   = "user:"
+	ledgerSystemPrefix = "system:"
+)
+
+// UserFreeAccount is the ledger account holding the user's daily free credits
+func UserFreeAccount(userID string) string {
+	return ledgerUserPrefix + userID + ":free"
+}
+
+// UserExtraAccount is the ledger account holding the user's purchased credits
+func UserExtraAccount(userID string) string {
+	return ledgerUserPrefix + userID + ":extra"
+}
+
+// CreditPosting describes one credit movement for a user. Changes are applied in order:
+// SetFree/SetExtra, then Free/Extra deltas, then Spend (free credits first, then extra).
+type CreditPosting struct {
+	UserID         string
+	Type           string // models.CreditType*, also names the system account on the other side
+	ReferenceID    string
+	Description    string
+	IdempotencyKey string     // optional; a posting with a key that was already used is not applied again
+	Free           int        // change to free credits
+	Extra          int        // change to extra credits
+	SetFree        *int       // replace free credits (daily reset, plan change)
+	SetExtra       *int       // replace extra credits (forfeit)
+	Spend          int        // credits to deduct, free first, then from the extra credit batch that expires first
+	NoHistory      bool       // skip the CreditLog row shown in the user's history (e.g. daily resets)
+	ExpiresAt      *time.Time // when extra credits granted by this posting expire; nil: never
+}
+
+// LedgerResult reports the journal and the balances after posting
+type LedgerResult struct {
+	Journal    *models.LedgerJournal // nil when the posting changed nothing and kept no history
+	FreeCredit int
+	Credit     int
+	Duplicate  bool // the idempotency key had been used; nothing was posted
+}
+
+// PostCredits applies a credit movement atomically: the user row is locked, the journal, its
+// entries, the cached balances and the credit log are written in one database transaction.
+// db may already be a transaction; the posting then commits or rolls back with it.
+func PostCredits(db *gorm.DB, posting CreditPosting) (*LedgerResult, error) {
+	result := &LedgerResult{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", posting.UserID).First(&user).Error; err != nil {
+			return err
+		}
+
+		// Checked under the user lock, so a concurrent retry waits and then sees the first journal
+		if posting.IdempotencyKey != "" {
+			var existing models.LedgerJournal
+			if err := tx.Where("idempotency_key = ?", posting.IdempotencyKey).First(&existing).Error; err == nil {
+				result.Journal = &existing
+				result.FreeCredit = user.FreeCredit
+				result.Credit = user.Credit
+				result.Duplicate = true
+				return nil
+			}
+		}
+
+		free, extra := user.FreeCredit, user.Credit
+		if posting.SetFree != nil {
+			free = *posting.SetFree
+		}
+		if posting.SetExtra != nil {
+			extra = *posting.SetExtra
+		}
+		free += posting.Free
+		extra += posting.Extra
+		if posting.Spend > 0 {
+			if free+extra < posting.Spend {
+				return ErrInsufficientCredits
+			}
+			fromFree := posting.Spend
+			if fromFree > free {
+				fromFree = free
+			}
+			free -= fromFree
+			extra -= posting.Spend - fromFree
+		}
+		if free < 0 || extra < 0 {
+			return ErrNegativeBalance
+		}
+
+		// Nothing to record (e.g. a daily reset to the same allowance)
+		if free == user.FreeCredit && extra == user.Credit && posting.NoHistory && posting.IdempotencyKey == "" {
+			result.FreeCredit = free
+			result.Credit = extra
+			return nil
+		}
+
+		journal, err := writeJournal(tx, user.ID, posting.Type, posting.ReferenceID, posting.Description,
+			posting.IdempotencyKey, free-user.FreeCredit, extra-user.Credit)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).
+			Updates(map[string]interface{}{"free_credit": free, "credit": extra}).Error; err != nil {
+			return err
+		}
+		if err := updateCreditBatches(tx, user.ID, posting, extra-user.Credit); err != nil {
+			return err
+		}
+
+		delta := (free - user.FreeCredit) + (extra - user.Credit)
+		if !posting.NoHistory {
+			creditLog := models.CreditLog{
+				ID:          journal.ID,
+				UserID:      user.ID,
+				Amount:      delta,
+				Balance:     free + extra,
+				Type:        posting.Type,
+				ReferenceID: posting.ReferenceID,
+				Description: posting.Description,
+			}
+			if err := tx.Create(&creditLog).Error; err != nil {
+				return err
+			}
+		}
+
+		result.Journal = journal
+		result.FreeCredit = free
+		result.Credit = extra
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// updateCreditBatches keeps the user's credit batches in step with a change to their extra credits.
+// A gain becomes a new batch. A loss is taken from the batches of the posting's reference first (the
+// purchase being refunded, or the batch being expired), then from the batch that expires first.
+// Credits from before batches existed have no batch and are used last.
+func updateCreditBatches(tx *gorm.DB, userID string, posting CreditPosting, delta int) error {
+	if delta > 0 {
+		return tx.Create(&models.CreditBatch{
+			ID:          uuid.New().String(),
+			UserID:      userID,
+			Source:      posting.Type,
+			ReferenceID: posting.ReferenceID,
+			Original:    delta,
+			Remaining:   delta,
+			ExpiresAt:   posting.ExpiresAt,
+		}).Error
+	}
+
+	needed := -delta
+	if needed == 0 {
+		return nil
+	}
+	var batches []models.CreditBatch
+	if err := tx.Where("user_id = ? AND remaining > 0", userID).
+		Order("expires_at IS NULL, expires_at ASC, created_at ASC").
+		Find(&batches).Error; err != nil {
+		return err
+	}
+	if posting.ReferenceID != "" {
+		sort.SliceStable(batches, func(i, j int) bool {
+			return matchesReference(&batches[i], posting.ReferenceID) && !matchesReference(&batches[j], posting.ReferenceID)
+		})
+	}
+
+	for _, batch := range batches {
+		if needed == 0 {
+			break
+		}
+		taken := batch.Remaining
+		if taken > needed {
+			taken = needed
+		}
+		if err := tx.Model(&models.CreditBatch{}).Where("id = ?", batch.ID).
+			Update("remaining", batch.Remaining-taken).Error; err != nil {
+			return err
+		}
+		needed -= taken
+	}
+	return nil
+}
+
+// matchesReference reports whether the batch is the one a posting refers to
+func matchesReference(batch *models.CreditBatch, referenceID string) bool {
+	return batch.ID == referenceID || batch.ReferenceID == referenceID
+}
+
+// writeJournal records the changes to the user's free and extra accounts, each balanced by the
+// opposite entry on the system account for the type
+func writeJournal(tx *gorm.DB, userID string, journalType string, referenceID string, description string, idempotencyKey string, freeDelta int, extraDelta int) (*models.LedgerJournal, error) {
+	journal := models.LedgerJournal{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		Type:        journalType,
+		ReferenceID: referenceID,
+		Description: description,
+	}
+	if idempotencyKey != "" {
+		journal.IdempotencyKey = &idempotencyKey
+	}
+
+	systemAccount := ledgerSystemPrefix + journalType
+	for _, change := range []struct {
+		account string
+		amount  int
+	}{
+		{UserFreeAccount(userID), freeDelta},
+		{UserExtraAccount(userID), extraDelta},
+	} {
+		if change.amount == 0 {
+			continue
+		}
+		journal.Entries = append(journal.Entries,
+			models.LedgerEntry{ID: uuid.New().String(), Account: change.account, Amount: change.amount},
+			models.LedgerEntry{ID: uuid.New().String(), Account: systemAccount, Amount: -change.amount},
+		)
+	}
+	if err := tx.Create(&journal).Error; err != nil {
+		return nil, err
+	}
+	return &journal, nil
+}
+
+// ========================================
+// Reconciliation
+// ========================================
+
+// BalanceMismatch is a user whose cached balances differ from their ledger accounts
+type BalanceMismatch struct {
+	UserID      string `json:"user_id"`
+	FreeCredit  int    `json:"free_credit"`
+	LedgerFree  int    `json:"ledger_free"`
+	Credit      int    `json:"credit"`
+	LedgerExtra int    `json:"ledger_extra"`
+}
+
+// UnbalancedJournal is a journal whose entries don't sum to zero
+type UnbalancedJournal struct {
+	JournalID string `json:"journal_id"`
+	Sum       int    `json:"sum"`
+}
+
+// ReconcileReport is the outcome of comparing User balances with the ledger
+type ReconcileReport struct {
+	Users      int                 `json:"users"`
+	Mismatches []BalanceMismatch   `json:"mismatches"`
+	Unbalanced []UnbalancedJournal `json:"unbalanced"`
+}
+
+// OK reports whether every balance and journal checked out
+func (r *ReconcileReport) OK() bool {
+	return len(r.Mismatches) == 0 && len(r.Unbalanced) == 0
+}
+
+// ReconcileLedger checks that every journal balances and that each user's cached balances
+// equal the sum of their ledger accounts
+func ReconcileLedger(db *gorm.DB) (*ReconcileReport, error) {
+	report := &ReconcileReport{Mismatches: []BalanceMismatch{}, Unbalanced: []UnbalancedJournal{}}
+
+	if err := db.Model(&models.LedgerEntry{}).
+		Select("journal_id, SUM(amount) AS sum").
+		Group("journal_id").
+		Having("SUM(amount) <> 0").
+		Scan(&report.Unbalanced).Error; err != nil {
+		return nil, err
+	}
+
+	var sums []struct {
+		Account string
+		Total   int
+	}
+	if err := db.Model(&models.LedgerEntry{}).
+		Select("account, SUM(amount) AS total").
+		Where("account LIKE ?", ledgerUserPrefix+"%").
+		Group("account").
+		Scan(&sums).Error; err != nil {
+		return nil, err
+	}
+	ledger := make(map[string]int, len(sums))
+	for _, sum := range sums {
+		ledger[sum.Account] = sum.Total
+	}
+
+	var users []models.User
+	if err := db.Select("id, credit, free_credit").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	report.Users = len(users)
+	for _, user := range users {
+		free := ledger[UserFreeAccount(user.ID)]
+		extra := ledger[UserExtraAccount(user.ID)]
+		if free != user.FreeCredit || extra != user.Credit {
+			report.Mismatches = append(report.Mismatches, BalanceMismatch{
+				UserID:      user.ID,
+				FreeCredit:  user.FreeCredit,
+				LedgerFree:  free,
+				Credit:      user.Credit,
+				LedgerExtra: extra,
+			})
+		}
+	}
+	return report, nil
+}
+
+// PostOpeningBalances records the current balance of every user that has no ledger entries yet
+func PostOpeningBalances(db *gorm.DB) (int, error) {
+	var users []models.User
+	err := db.Select("id, credit, free_credit").
+		Where("credit <> 0 OR free_credit <> 0").
+		Where("NOT EXISTS (SELECT 1 FROM ledger_journals WHERE ledger_journals.user_id = users.id)").
+		Find(&users).Error
+	if err != nil {
+		return 0, err
+	}
+
+	for _, user := range users {
+		_, err := writeJournal(db, user.ID, models.CreditTypeOpening, "", "Opening balance",
+			"opening:"+user.ID, user.FreeCredit, user.Credit)
+		if err != nil {
+			return 0, fmt.Errorf("user %s: %w", user.ID, err)
+		}
+	}
+	return len(users), nil
+}
+
+// BackfillCreditBatches gives every user whose extra credits exceed their batches a batch that never
+// expires for the difference, so credits from before batches existed are tracked too
+func BackfillCreditBatches(db *gorm.DB) (int, error) {
+	var users []struct {
+		ID      string
+		Credit  int
+		Batched int
+	}
+	err := db.Model(&models.User{}).
+		Select("users.id, users.credit, COALESCE((SELECT SUM(remaining) FROM credit_batches WHERE credit_batches.user_id = users.id), 0) AS batched").
+		Where("users.credit > 0").
+		Scan(&users).Error
+	if err != nil {
+		return 0, err
+	}
+
+	created := 0
+	for _, user := range users {
+		if user.Credit <= user.Batched {
+			continue
+		}
+		batch := models.CreditBatch{
+			ID:        uuid.New().String(),
+			UserID:    user.ID,
+			Source:    models.CreditTypeOpening,
+			Original:  user.Credit - user.Batched,
+			Remaining: user.Credit - user.Batched,
+		}
+		if err := db.Create(&batch).Error; err != nil {
+			return created, fmt.Errorf("user %s: %w", user.ID, err)
+		}
+		created++
+	}
+	return created, nil
+}
+
+// AdjustLedger posts adjustment journals so each mismatched user's ledger matches their cached balances
+func AdjustLedger(db *gorm.DB, mismatches []BalanceMismatch) error {
+	for _, m := range mismatches {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			_, err := writeJournal(tx, m.UserID, models.CreditTypeAdjustment, "", "Ledger reconciliation adjustment",
+				"", m.FreeCredit-m.LedgerFree, m.Credit-m.LedgerExtra)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("user %s: %w", m.UserID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MailMessage is a rendered email ready to be delivered
+type MailMessage struct {
+	To       string
+	Subject  string
+	HTMLBody string
+}
+
+// Mailer delivers outgoing email
+type Mailer interface {
+	Send(msg MailMessage) error
+}
+
+// SMTPMailer sends email through an SMTP server
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(msg MailMessage) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	var body strings.Builder
+	body.WriteString("From: " + m.From + "\r\n")
+	body.WriteString("To: " + msg.To + "\r\n")
+	body.WriteString("Subject: " + msg.Subject + "\r\n")
+	body.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/html; charset=UTF-8\r\n\r\n")
+	body.WriteString(msg.HTMLBody)
+
+	if err := smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{msg.To}, []byte(body.String())); err != nil {
+		return fmt.Errorf("smtp send failed: %w", err)
+	}
+	return nil
+}
+
+// LogMailer writes email to files in Dir (or only to the log when Dir is empty).
+// Used for local development and tests.
+type LogMailer struct {
+	Dir string
+	mu  sync.Mutex
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+func (m *LogMailer) Send(msg MailMessage) error {
+	log.Printf("[mail] to=%s subject=%q", msg.To, msg.Subject)
+	if m.Dir == "" {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	name := fmt.Sprintf("%s_%s.html", time.Now().Format("20060102T150405.000000000"), unsafeFileChars.ReplaceAllString(msg.To, "_"))
+	content := fmt.Sprintf("<!-- To: %s -->\n<!-- Subject: %s -->\n%s", msg.To, msg.Subject, msg.HTMLBody)
+	if err := os.WriteFile(filepath.Join(m.Dir, name), []byte(content), 0o644); err != nil {
+		return fmt.Errorf("failed to write mail file: %w", err)
+	}
+	return nil
+}
+
+var (
+	mailer     Mailer
+	mailerOnce sync.Once
+)
+
+// DefaultMailer returns the mailer selected by MAIL_DRIVER ("smtp" or "log", default "log")
+func DefaultMailer() Mailer {
+	mailerOnce.Do(func() {
+		if mailer != nil {
+			return
+		}
+		mailer = NewMailerFromEnv()
+	})
+	return mailer
+}
+
+// SetMailer replaces the default mailer (e.g. with a LogMailer in tests)
+func SetMailer(m Mailer) {
+	mailerOnce.Do(func() {})
+	mailer = m
+}
+
+// NewMailerFromEnv builds a mailer from the MAIL_* / SMTP_* environment variables
+func NewMailerFromEnv() Mailer {
+	if strings.ToLower(strings.TrimSpace(os.Getenv("MAIL_DRIVER"))) == "smtp" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+	}
+	return &LogMailer{Dir: os.Getenv("MAIL_LOG_DIR")}
+}
+
+var mailViews fiber.Views
+
+// SetMailViews registers the application's view engine used to render email templates
+func SetMailViews(views fiber.Views) {
+	mailViews = views
+}
+
+// SendTemplate renders app/views/<template>.html with the registered view engine and sends it
+func SendTemplate(to string, subject string, template string, data fiber.Map) error {
+	if mailViews == nil {
+		return fmt.Errorf("mail views are not configured")
+	}
+	var body bytes.Buffer
+	if err := mailViews.Render(&body, template, data); err != nil {
+		return fmt.Errorf("failed to render %s: %w", template, err)
+	}
+	return DefaultMailer().Send(MailMessage{To: to, Subject: subject, HTMLBody: body.String()})
+}
@@ -0,0 +1,365 @@
+package services
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// OIDCProvider is an OpenID Connect identity provider (Google, ...) configured from the environment
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	HTTPClient   *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+}
+
+// IDTokenClaims are the verified claims we use from an ID token
+type IDTokenClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Known issuers so only client credentials need to be configured for common providers
+var defaultOIDCIssuers = map[string]string{
+	"google": "https://accounts.google.com",
+}
+
+// Allowed clock skew when checking exp/iat
+const oidcClockSkew = time.Minute
+
+var (
+	oidcProviders     map[string]*OIDCProvider
+	oidcProvidersOnce sync.Once
+)
+
+// OIDCProviders returns the providers listed in OIDC_PROVIDERS (e.g. "google"), each configured with
+// OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET, OIDC_<NAME>_REDIRECT_URL and optionally OIDC_<NAME>_ISSUER
+func OIDCProviders() map[string]*OIDCProvider {
+	oidcProvidersOnce.Do(func() {
+		oidcProviders = make(map[string]*OIDCProvider)
+		for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			prefix := "OIDC_" + strings.ToUpper(name) + "_"
+			issuer := os.Getenv(prefix + "ISSUER")
+			if issuer == "" {
+				issuer = defaultOIDCIssuers[name]
+			}
+			provider := &OIDCProvider{
+				Name:         name,
+				Issuer:       strings.TrimRight(issuer, "/"),
+				ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+				ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+				RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+				Scopes:       []string{"openid", "email", "profile"},
+			}
+			if provider.Issuer == "" || provider.ClientID == "" {
+				continue
+			}
+			oidcProviders[name] = provider
+		}
+	})
+	return oidcProviders
+}
+
+// RegisterOIDCProvider adds or replaces a provider (e.g. a local stand-in provider in tests)
+func RegisterOIDCProvider(provider *OIDCProvider) {
+	OIDCProviders()[provider.Name] = provider
+}
+
+// GetOIDCProvider looks up a configured provider by name
+func GetOIDCProvider(name string) (*OIDCProvider, bool) {
+	provider, ok := OIDCProviders()[strings.ToLower(name)]
+	return provider, ok
+}
+
+// PKCEChallenge returns the S256 code challenge for a code verifier
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL builds the authorization redirect for the authorization code flow with PKCE
+func (p *OIDCProvider) AuthCodeURL(state string, nonce string, codeVerifier string) (string, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(p.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", PKCEChallenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades an authorization code for the raw ID token
+func (p *OIDCProvider) Exchange(code string, codeVerifier string) (string, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequest("POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var tokenResponse struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := p.doJSON(req, &tokenResponse); err != nil {
+		return "", err
+	}
+	if tokenResponse.Error != "" {
+		return "", fmt.Errorf("token endpoint error: %s %s", tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+	if tokenResponse.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+	return tokenResponse.IDToken, nil
+}
+
+// VerifyIDToken checks the ID token signature against the provider's JWKS and validates
+// issuer, audience, expiry and nonce
+func (p *OIDCProvider) VerifyIDToken(raw string, nonce string) (*IDTokenClaims, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != jwt.SigningMethodRS256.Name {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.publicKey(discovery, kid)
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid id token: %v", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid id token claims")
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != strings.TrimRight(discovery.Issuer, "/") {
+		return nil, fmt.Errorf("unexpected issuer %q", iss)
+	}
+	if !audienceContains(claims["aud"], p.ClientID) {
+		return nil, errors.New("id token was not issued for this client")
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, errors.New("id token has expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(oidcClockSkew)) {
+		return nil, errors.New("id token issued in the future")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce == "" || tokenNonce != nonce {
+		return nil, errors.New("id token nonce mismatch")
+	}
+
+	result := &IDTokenClaims{}
+	result.Subject, _ = claims["sub"].(string)
+	result.Email, _ = claims["email"].(string)
+	result.Name, _ = claims["name"].(string)
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		result.EmailVerified = v
+	case string:
+		result.EmailVerified = v == "true"
+	}
+	if result.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+	return result, nil
+}
+
+// discover fetches and caches the provider's OpenID configuration
+func (p *OIDCProvider) discover() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequest("GET", p.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create discovery request: %w", err)
+	}
+	var discovery oidcDiscovery
+	if err := p.doJSON(req, &discovery); err != nil {
+		return nil, fmt.Errorf("%s discovery failed: %w", p.Name, err)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("%s discovery document is incomplete", p.Name)
+	}
+	if discovery.Issuer == "" {
+		discovery.Issuer = p.Issuer
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// publicKey returns the signing key for kid, refetching the JWKS once if the key is unknown (key rotation)
+func (p *OIDCProvider) publicKey(discovery *oidcDiscovery, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	// Don't hammer the JWKS endpoint with unknown key IDs
+	if time.Since(p.keysFetchedAt) < 10*time.Second {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	req, err := http.NewRequest("GET", discovery.JWKSURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create jwks request: %w", err)
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.doJSON(req, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey finds a cached key; a token without kid is accepted only when the JWKS has a single key
+func (p *OIDCProvider) lookupKey(kid string) *rsa.PublicKey {
+	if key, ok := p.keys[kid]; ok {
+		return key
+	}
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return nil
+}
+
+func (p *OIDCProvider) doJSON(req *http.Request, out interface{}) error {
+	client := p.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 15 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to parse response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("provider returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// audienceContains handles both the string and array forms of the "aud" claim
+func audienceContains(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package services_test
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"GoFiberMVC/app/services"
+	"GoFiberMVC/app/services/oidctest"
+)
+
+var testClaims = map[string]interface{}{
+	"sub":            "subject-1",
+	"email":          "singer@example.test",
+	"email_verified": true,
+	"name":           "Test Singer",
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	server := oidctest.NewServer("client-1")
+	defer server.Close()
+	provider := server.Provider("stand-in")
+
+	authURL, err := provider.AuthCodeURL("state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	if !strings.HasPrefix(authURL, server.URL+"/authorize?") {
+		t.Fatalf("authorization URL %q does not use the discovered endpoint", authURL)
+	}
+	parsed, _ := url.Parse(authURL)
+	if got := parsed.Query().Get("code_challenge"); got != services.PKCEChallenge("verifier-1") {
+		t.Fatalf("code_challenge = %q", got)
+	}
+
+	code, state, err := server.Authorize(authURL, testClaims)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if state != "state-1" {
+		t.Fatalf("state = %q", state)
+	}
+	rawIDToken, err := provider.Exchange(code, "verifier-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	claims, err := provider.VerifyIDToken(rawIDToken, "nonce-1")
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	want := services.IDTokenClaims{Subject: "subject-1", Email: "singer@example.test", EmailVerified: true, Name: "Test Singer"}
+	if *claims != want {
+		t.Fatalf("claims = %+v, want %+v", *claims, want)
+	}
+
+	// Codes are single-use
+	if _, err := provider.Exchange(code, "verifier-1"); err == nil {
+		t.Fatal("exchanged the same code twice")
+	}
+}
+
+func TestOIDCExchangeRequiresPKCEVerifier(t *testing.T) {
+	server := oidctest.NewServer("client-1")
+	defer server.Close()
+	provider := server.Provider("stand-in")
+
+	authURL, _ := provider.AuthCodeURL("state-1", "nonce-1", "verifier-1")
+	code, _, err := server.Authorize(authURL, testClaims)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if _, err := provider.Exchange(code, "stolen-code-without-verifier"); err == nil {
+		t.Fatal("exchange succeeded with the wrong code verifier")
+	}
+}
+
+func TestOIDCVerifyIDTokenRejections(t *testing.T) {
+	server := oidctest.NewServer("client-1")
+	defer server.Close()
+	provider := server.Provider("stand-in")
+
+	valid := func(extra map[string]interface{}) map[string]interface{} {
+		claims := map[string]interface{}{"sub": "subject-1", "nonce": "nonce-1"}
+		for key, value := range extra {
+			claims[key] = value
+		}
+		return claims
+	}
+	if _, err := provider.VerifyIDToken(server.SignIDToken(valid(nil)), "nonce-1"); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+
+	cases := map[string]string{
+		"nonce mismatch": server.SignIDToken(valid(nil)),
+		"no nonce":       server.SignIDToken(map[string]interface{}{"sub": "subject-1"}),
+		"wrong audience": server.SignIDToken(valid(map[string]interface{}{"aud": "someone-else"})),
+		"wrong issuer":   server.SignIDToken(valid(map[string]interface{}{"iss": "https://evil.example"})),
+		"expired":        server.SignIDToken(valid(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"no subject":     server.SignIDToken(valid(map[string]interface{}{"sub": ""})),
+	}
+	nonces := map[string]string{"nonce mismatch": "other-nonce"}
+	for name, token := range cases {
+		nonce := "nonce-1"
+		if n, ok := nonces[name]; ok {
+			nonce = n
+		}
+		if _, err := provider.VerifyIDToken(token, nonce); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+
+	// A token signed by another provider's key is not in the JWKS
+	other := oidctest.NewServer("client-1")
+	defer other.Close()
+	forged := other.SignIDToken(valid(map[string]interface{}{"iss": server.URL}))
+	if _, err := provider.VerifyIDToken(forged, "nonce-1"); err == nil {
+		t.Error("token signed with an unknown key accepted")
+	}
+}
@@ -0,0 +1,200 @@
+// Package oidctest runs a local OpenID Connect provider for tests: discovery, JWKS, an authorization
+// step that skips the consent screen, and a token endpoint that enforces PKCE.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"GoFiberMVC/app/services"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// ClientSecret is the secret the token endpoint expects from providers built by Server.Provider
+const ClientSecret = "oidctest-secret"
+
+// Server is a stand-in identity provider
+type Server struct {
+	*httptest.Server
+	ClientID string
+
+	mu     sync.Mutex
+	key    *rsa.PrivateKey
+	kid    string
+	codes  map[string]grant
+	issued int
+}
+
+// grant is an authorization waiting to be exchanged
+type grant struct {
+	challenge   string
+	redirectURI string
+	nonce       string
+	claims      map[string]interface{}
+}
+
+// NewServer starts a provider that issues ID tokens for clientID
+func NewServer(clientID string) *Server {
+	s := &Server{ClientID: clientID, codes: map[string]grant{}}
+	s.RotateKey()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/jwks", s.jwks)
+	mux.HandleFunc("/token", s.token)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Provider returns a provider configured against this server
+func (s *Server) Provider(name string) *services.OIDCProvider {
+	return &services.OIDCProvider{
+		Name:         name,
+		Issuer:       s.URL,
+		ClientID:     s.ClientID,
+		ClientSecret: ClientSecret,
+		RedirectURL:  "http://app.test/api/auth/oidc/" + name + "/callback",
+		Scopes:       []string{"openid", "email", "profile"},
+		HTTPClient:   s.Client(),
+	}
+}
+
+// Authorize plays the consent screen for an authorization URL and returns the code and state the
+// provider would send back to the redirect URI. The ID token will carry claims (sub, email, ...).
+func (s *Server) Authorize(authURL string, claims map[string]interface{}) (code string, state string, err error) {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	query := parsed.Query()
+	if query.Get("client_id") != s.ClientID {
+		return "", "", fmt.Errorf("unexpected client_id %q", query.Get("client_id"))
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		return "", "", fmt.Errorf("authorization request has no S256 code challenge")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.issued++
+	code = fmt.Sprintf("code-%d", s.issued)
+	s.codes[code] = grant{
+		challenge:   query.Get("code_challenge"),
+		redirectURI: query.Get("redirect_uri"),
+		nonce:       query.Get("nonce"),
+		claims:      claims,
+	}
+	return code, query.Get("state"), nil
+}
+
+// SignIDToken signs claims with the current key, filling in iss, aud, iat and exp when missing
+func (s *Server) SignIDToken(claims map[string]interface{}) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.signLocked(claims)
+}
+
+// RotateKey replaces the signing key; tokens signed afterwards carry a new kid
+func (s *Server) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.key = key
+	s.kid = fmt.Sprintf("key-%d", time.Now().UnixNano())
+}
+
+func (s *Server) signLocked(claims map[string]interface{}) string {
+	now := time.Now()
+	mapClaims := jwt.MapClaims{
+		"iss": s.URL,
+		"aud": s.ClientID,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for key, value := range claims {
+		mapClaims[key] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, mapClaims)
+	token.Header["kid"] = s.kid
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 s.URL,
+		"authorization_endpoint": s.URL + "/authorize",
+		"token_endpoint":         s.URL + "/token",
+		"jwks_uri":               s.URL + "/jwks",
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": s.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+		}},
+	})
+}
+
+// token exchanges a code once, checking the client credentials, redirect URI and PKCE verifier
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Method != http.MethodPost {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if r.PostForm.Get("client_id") != s.ClientID || r.PostForm.Get("client_secret") != ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code := r.PostForm.Get("code")
+	g, ok := s.codes[code]
+	delete(s.codes, code)
+	if !ok || r.PostForm.Get("redirect_uri") != g.redirectURI ||
+		services.PKCEChallenge(r.PostForm.Get("code_verifier")) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := map[string]interface{}{"nonce": g.nonce}
+	for key, value := range g.claims {
+		claims[key] = value
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": "access-" + code,
+		"token_type":   "Bearer",
+		"id_token":     s.signLocked(claims),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FakeSignatureHeader carries the HMAC-SHA256 of a fake callback body, keyed with the fake secret
+const FakeSignatureHeader = "x-fake-signature"
+
+// fakePayments is shared by every FakeProvider so bills survive across requests
+var fakePayments = struct {
+	sync.Mutex
+	bills    map[string]*PaymentResult
+	expiries map[string]time.Time
+	refunds  map[string]*RefundResult // by idempotency key
+}{bills: map[string]*PaymentResult{}, expiries: map[string]time.Time{}, refunds: map[string]*RefundResult{}}
+
+// FakeProvider is a deterministic in-memory provider for tests and local development.
+// Nothing leaves the process: bills start pending and only change through SetStatus or a signed callback.
+// Like the real providers, it refuses payment once the bill's expiry has passed.
+type FakeProvider struct {
+	Secret string // callbacks are rejected while empty
+}
+
+// NewFakeProvider returns a fake provider whose callbacks are signed with secret
+func NewFakeProvider(secret string) *FakeProvider {
+	return &FakeProvider{Secret: secret}
+}
+
+func (p *FakeProvider) Name() string {
+	return PaymentProviderFake
+}
+
+// CreateBill records a pending bill; the payment page is the redirect URL itself
+func (p *FakeProvider) CreateBill(req BillRequest) (*Bill, error) {
+	if req.Amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+
+	fakePayments.Lock()
+	defer fakePayments.Unlock()
+	providerRef := "fake_" + req.Reference
+	fakePayments.bills[req.Reference] = &PaymentResult{
+		Reference:   req.Reference,
+		ProviderRef: providerRef,
+		Status:      PaymentStatusPending,
+		Amount:      req.Amount,
+	}
+	if !req.ExpiresAt.IsZero() {
+		fakePayments.expiries[req.Reference] = req.ExpiresAt
+	}
+	return &Bill{ProviderRef: providerRef, PaymentURL: req.RedirectURL, Data: map[string]string{}}, nil
+}
+
+// SetStatus moves a bill to the given status, as if the customer had paid (or abandoned) it.
+// Paying a bill past its expiry expires it instead.
+func (p *FakeProvider) SetStatus(reference string, status PaymentStatus) *PaymentResult {
+	fakePayments.Lock()
+	defer fakePayments.Unlock()
+	bill, ok := fakePayments.bills[reference]
+	if !ok {
+		return nil
+	}
+	if expiresAt, ok := fakePayments.expiries[reference]; ok && status == PaymentStatusPaid && time.Now().After(expiresAt) {
+		status = PaymentStatusExpired
+	}
+	bill.Status = status
+	if status == PaymentStatusPaid {
+		bill.PaymentID = "fake_pay_" + reference
+		bill.Method = "fake:test"
+	}
+	snapshot := *bill
+	return &snapshot
+}
+
+// SignFakeCallback returns the signature header value for a fake callback body
+func SignFakeCallback(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyCallback accepts a JSON PaymentResult-shaped body signed with the fake secret
+func (p *FakeProvider) VerifyCallback(contentType string, body []byte, headers map[string]string) (*PaymentResult, error) {
+	if p.Secret == "" {
+		return nil, fmt.Errorf("%w: fake provider secret not configured", ErrInvalidCallback)
+	}
+	if !hmac.Equal([]byte(SignFakeCallback(p.Secret, body)), []byte(headers[FakeSignatureHeader])) {
+		return nil, fmt.Errorf("%w: signature mismatch", ErrInvalidCallback)
+	}
+
+	var callback struct {
+		Reference string        `json:"reference"`
+		Status    PaymentStatus `json:"status"`
+		Amount    int64         `json:"amount"`
+	}
+	if err := json.Unmarshal(body, &callback); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCallback, err)
+	}
+
+	result := p.SetStatus(callback.Reference, callback.Status)
+	if result == nil {
+		// Bills created by another process (e.g. before a restart) are reported as-is
+		result = &PaymentResult{Reference: callback.Reference, ProviderRef: "fake_" + callback.Reference, Status: callback.Status}
+	}
+	if callback.Amount > 0 {
+		result.Amount = callback.Amount
+	}
+	return result, nil
+}
+
+// QueryStatus returns the bill as last set; unknown bills are pending
+func (p *FakeProvider) QueryStatus(reference string, providerRef string) (*PaymentResult, error) {
+	fakePayments.Lock()
+	defer fakePayments.Unlock()
+	bill, ok := fakePayments.bills[reference]
+	if !ok {
+		return &PaymentResult{Reference: reference, ProviderRef: providerRef, Status: PaymentStatusPending}, nil
+	}
+	snapshot := *bill
+	return &snapshot, nil
+}
+
+// Refund succeeds for paid bills, up to the bill amount; a repeated idempotency key returns the first refund
+func (p *FakeProvider) Refund(req RefundRequest) (*RefundResult, error) {
+	fakePayments.Lock()
+	defer fakePayments.Unlock()
+	if refund, ok := fakePayments.refunds[req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		snapshot := *refund
+		return &snapshot, nil
+	}
+	bill, ok := fakePayments.bills[req.Reference]
+	if !ok || bill.Status != PaymentStatusPaid {
+		return nil, fmt.Errorf("bill %s is not paid", req.Reference)
+	}
+	if req.Amount <= 0 || req.Amount > bill.Amount {
+		return nil, fmt.Errorf("invalid refund amount %d", req.Amount)
+	}
+	if req.Amount == bill.Amount {
+		bill.Status = PaymentStatusRefunded
+	}
+	refund := &RefundResult{RefundID: fmt.Sprintf("fake_refund_%d", len(fakePayments.refunds)+1), Amount: req.Amount}
+	key := req.IdempotencyKey
+	if key == "" {
+		key = refund.RefundID
+	}
+	fakePayments.refunds[key] = refund
+	snapshot := *refund
+	return &snapshot, nil
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+const testFakeSecret = "fake-secret"
+
+// fakeCallback delivers a callback body signed with the test secret
+func fakeCallback(t *testing.T, p *FakeProvider, body string) (*PaymentResult, error) {
+	t.Helper()
+	headers := map[string]string{FakeSignatureHeader: SignFakeCallback(testFakeSecret, []byte(body))}
+	return p.VerifyCallback("application/json", []byte(body), headers)
+}
+
+func TestFakeProviderBillLifecycle(t *testing.T) {
+	p := NewFakeProvider(testFakeSecret)
+	bill, err := p.CreateBill(BillRequest{Reference: "TX-FAKE-1", Amount: 15000, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("CreateBill: %v", err)
+	}
+	if bill.ProviderRef != "fake_TX-FAKE-1" {
+		t.Fatalf("provider ref = %q", bill.ProviderRef)
+	}
+
+	if status, _ := p.QueryStatus("TX-FAKE-1", bill.ProviderRef); status.Status != PaymentStatusPending {
+		t.Fatalf("new bill status = %s, want pending", status.Status)
+	}
+	if _, err := p.Refund(RefundRequest{Reference: "TX-FAKE-1", ProviderRef: bill.ProviderRef, Amount: 15000, Reason: "test", IdempotencyKey: "refund:TX-FAKE-1"}); err == nil {
+		t.Fatal("refunded an unpaid bill")
+	}
+
+	result, err := fakeCallback(t, p, `{"reference":"TX-FAKE-1","status":"paid","amount":15000}`)
+	if err != nil {
+		t.Fatalf("VerifyCallback: %v", err)
+	}
+	if result.Status != PaymentStatusPaid || result.Amount != 15000 || result.PaymentID == "" {
+		t.Fatalf("callback result = %+v", result)
+	}
+	if status, _ := p.QueryStatus("TX-FAKE-1", bill.ProviderRef); status.Status != PaymentStatusPaid {
+		t.Fatalf("paid bill status = %s", status.Status)
+	}
+
+	if _, err := p.Refund(RefundRequest{Reference: "TX-FAKE-1", ProviderRef: bill.ProviderRef, Amount: 20000, Reason: "test"}); err == nil {
+		t.Fatal("refunded more than the bill amount")
+	}
+	refund, err := p.Refund(RefundRequest{Reference: "TX-FAKE-1", ProviderRef: bill.ProviderRef, Amount: 15000, Reason: "test", IdempotencyKey: "refund:TX-FAKE-1"})
+	if err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if refund.Amount != 15000 || refund.RefundID == "" {
+		t.Fatalf("refund = %+v", refund)
+	}
+	replayed, err := p.Refund(RefundRequest{Reference: "TX-FAKE-1", ProviderRef: bill.ProviderRef, Amount: 15000, Reason: "retry", IdempotencyKey: "refund:TX-FAKE-1"})
+	if err != nil || replayed.RefundID != refund.RefundID {
+		t.Fatalf("retried refund = %+v, %v; want the first refund %s", replayed, err, refund.RefundID)
+	}
+	if status, _ := p.QueryStatus("TX-FAKE-1", bill.ProviderRef); status.Status != PaymentStatusRefunded {
+		t.Fatalf("refunded bill status = %s", status.Status)
+	}
+}
+
+func TestFakeProviderRejectsUnsignedCallbacks(t *testing.T) {
+	p := NewFakeProvider(testFakeSecret)
+	if _, err := p.CreateBill(BillRequest{Reference: "TX-FAKE-2", Amount: 5000}); err != nil {
+		t.Fatalf("CreateBill: %v", err)
+	}
+	body := []byte(`{"reference":"TX-FAKE-2","status":"paid","amount":5000}`)
+
+	cases := map[string]struct {
+		provider *FakeProvider
+		headers  map[string]string
+	}{
+		"missing signature": {p, map[string]string{}},
+		"wrong secret":      {p, map[string]string{FakeSignatureHeader: SignFakeCallback("other", body)}},
+		"no secret":         {NewFakeProvider(""), map[string]string{FakeSignatureHeader: SignFakeCallback("", body)}},
+	}
+	for name, tc := range cases {
+		if _, err := tc.provider.VerifyCallback("application/json", body, tc.headers); !errors.Is(err, ErrInvalidCallback) {
+			t.Errorf("%s: err = %v, want ErrInvalidCallback", name, err)
+		}
+	}
+
+	if status, _ := p.QueryStatus("TX-FAKE-2", ""); status.Status != PaymentStatusPending {
+		t.Fatalf("rejected callbacks changed the bill to %s", status.Status)
+	}
+}
+
+func TestFakeProviderExpiredBillCannotBePaid(t *testing.T) {
+	p := NewFakeProvider(testFakeSecret)
+	if _, err := p.CreateBill(BillRequest{Reference: "TX-FAKE-3", Amount: 5000, ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("CreateBill: %v", err)
+	}
+	if result := p.SetStatus("TX-FAKE-3", PaymentStatusPaid); result.Status != PaymentStatusExpired {
+		t.Fatalf("paying an expired bill gave %s, want expired", result.Status)
+	}
+	if p.SetStatus("TX-UNKNOWN", PaymentStatusPaid) != nil {
+		t.Fatal("SetStatus invented a bill")
+	}
+}
@@ -0,0 +1,323 @@
+package services
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ========================================
+// Flip Configuration
+// ========================================
+
+const (
+	flipProductionURL = "https://bigflip.id/api"
+	flipSandboxURL    = "https://bigflip.id/big_sandbox_api"
+)
+
+// FlipProvider collects payments through Flip Accept Payment (checkout popup)
+type FlipProvider struct {
+	SecretKey       string
+	ValidationToken string
+	Production      bool
+}
+
+func (p *FlipProvider) Name() string {
+	return PaymentProviderFlip
+}
+
+func (p *FlipProvider) baseURL() string {
+	if p.Production {
+		return flipProductionURL
+	}
+	return flipSandboxURL
+}
+
+func (p *FlipProvider) authHeader() string {
+	// Basic Auth: Base64Encode("secret_key" + ":")
+	encoded := base64.StdEncoding.EncodeToString([]byte(p.SecretKey + ":"))
+	return "Basic " + encoded
+}
+
+// ========================================
+// Flip API Call Helper
+// ========================================
+
+// call makes a request to the Flip API; body may be empty for GET requests
+func (p *FlipProvider) call(method string, endpoint string, body string, contentType string) (map[string]interface{}, error) {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, p.baseURL()+endpoint, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", p.authHeader())
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Flip API error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v (body: %s)", err, string(respBody))
+	}
+
+	fmt.Printf("[Flip] %s %s response (status %d): %s\n", method, endpoint, resp.StatusCode, string(respBody))
+
+	if resp.StatusCode >= 400 {
+		msg := "Flip API error"
+		if m, ok := result["message"].(string); ok {
+			msg = m
+		}
+		return result, fmt.Errorf("%s (HTTP %d)", msg, resp.StatusCode)
+	}
+
+	return result, nil
+}
+
+// ========================================
+// Create Bill
+// ========================================
+
+// CreateBill creates a Flip bill for the popup checkout flow.
+// V3 supports the popup (company_code/product_code) but is NOT available in sandbox, so 404 falls back to V2.
+func (p *FlipProvider) CreateBill(req BillRequest) (*Bill, error) {
+	billFields := map[string]interface{}{
+		"title":        req.Title,
+		"type":         "SINGLE",
+		"amount":       req.Amount,
+		"step":         "checkout_seamless",
+		"redirect_url": req.RedirectURL,
+		"reference_id": req.Reference,
+		"sender_name":  req.CustomerName,
+		"sender_email": req.CustomerEmail,
+	}
+	expiredDate := flipExpiredDate(req.ExpiresAt)
+	if expiredDate != "" {
+		billFields["expired_date"] = expiredDate
+	}
+	billBody, _ := json.Marshal(billFields)
+
+	result, err := p.call("POST", "/v3/pwf/bill", string(billBody), "application/json")
+
+	if err != nil && strings.Contains(err.Error(), "404") {
+		fmt.Printf("[Flip] V3 returned 404, falling back to V2\n")
+
+		// V2 uses application/x-www-form-urlencoded with step=2 (integer)
+		v2Form := url.Values{}
+		v2Form.Set("title", req.Title)
+		v2Form.Set("type", "SINGLE")
+		v2Form.Set("amount", fmt.Sprintf("%d", req.Amount))
+		v2Form.Set("step", "2") // V2: step 2 = payment method selection
+		v2Form.Set("redirect_url", req.RedirectURL)
+		v2Form.Set("sender_name", req.CustomerName)
+		v2Form.Set("sender_email", req.CustomerEmail)
+		if expiredDate != "" {
+			v2Form.Set("expired_date", expiredDate)
+		}
+
+		result, err = p.call("POST", "/v2/pwf/bill", v2Form.Encode(), "application/x-www-form-urlencoded")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	bill := &Bill{
+		ProviderRef: flipID(result["link_id"]),
+		Data:        map[string]string{},
+	}
+	if lu, ok := result["link_url"].(string); ok {
+		bill.PaymentURL = lu
+	}
+	if cc, ok := result["company_code"].(string); ok {
+		bill.Data["company_code"] = cc
+	}
+	if pc, ok := result["product_code"].(string); ok {
+		bill.Data["product_code"] = pc
+	}
+	return bill, nil
+}
+
+// flipExpiredDate formats a bill expiry the way Flip expects it: "YYYY-MM-DD HH:mm" in Jakarta time.
+// Minutes are rounded down, so Flip never accepts payment after the transaction has expired here.
+func flipExpiredDate(expiresAt time.Time) string {
+	if expiresAt.IsZero() {
+		return ""
+	}
+	return expiresAt.In(jakartaTime).Format("2006-01-02 15:04")
+}
+
+// ========================================
+// Accept Payment Callback
+// ========================================
+
+// VerifyCallback parses a Flip accept payment callback.
+// Flip sends application/x-www-form-urlencoded with data=JSON&token=VALIDATION_TOKEN.
+func (p *FlipProvider) VerifyCallback(contentType string, body []byte, headers map[string]string) (*PaymentResult, error) {
+	rawBody := string(body)
+	dataStr := ""
+	token := ""
+	if form, err := url.ParseQuery(rawBody); err == nil {
+		dataStr = form.Get("data")
+		token = form.Get("token")
+	}
+
+	// Fallback: V3 may send a JSON body instead of form-urlencoded, with the token as a field
+	if dataStr == "" {
+		trimmed := strings.TrimSpace(rawBody)
+		if strings.HasPrefix(trimmed, "{") {
+			dataStr = trimmed
+			var envelope struct {
+				Token string `json:"token"`
+			}
+			if json.Unmarshal([]byte(trimmed), &envelope) == nil {
+				token = envelope.Token
+			}
+		}
+	}
+	if dataStr == "" {
+		return nil, fmt.Errorf("%w: no callback data", ErrInvalidCallback)
+	}
+
+	// Every callback must carry the validation token; without one configured none can be trusted
+	if p.ValidationToken == "" {
+		return nil, fmt.Errorf("%w: validation token not configured", ErrInvalidCallback)
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(p.ValidationToken)) != 1 {
+		return nil, fmt.Errorf("%w: validation token missing or mismatched", ErrInvalidCallback)
+	}
+
+	var callbackData struct {
+		ID             string      `json:"id"`
+		BillLinkID     interface{} `json:"bill_link_id"` // Can be int or string
+		ReferenceID    string      `json:"reference_id"`
+		SenderBank     string      `json:"sender_bank"`
+		SenderBankType string      `json:"sender_bank_type"`
+		Amount         float64     `json:"amount"`
+		Status         string      `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(dataStr), &callbackData); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCallback, err)
+	}
+
+	return &PaymentResult{
+		Reference:   callbackData.ReferenceID,
+		ProviderRef: flipID(callbackData.BillLinkID),
+		PaymentID:   callbackData.ID,
+		Status:      flipStatus(callbackData.Status),
+		Amount:      int64(callbackData.Amount),
+		Method:      flipMethod(callbackData.SenderBank, callbackData.SenderBankType),
+	}, nil
+}
+
+// ========================================
+// Query Status
+// ========================================
+
+// QueryStatus calls Flip's Get Payment API for the bill. Tries V3 first, falls back to V2 on 404.
+func (p *FlipProvider) QueryStatus(reference string, providerRef string) (*PaymentResult, error) {
+	if providerRef == "" {
+		return nil, fmt.Errorf("Flip bill ID is required")
+	}
+
+	result, err := p.call("GET", fmt.Sprintf("/v3/pwf/%s/payment", providerRef), "", "")
+	if err != nil && strings.Contains(err.Error(), "404") {
+		fmt.Printf("[Flip Check] V3 returned 404, falling back to V2\n")
+		result, err = p.call("GET", fmt.Sprintf("/v2/pwf/%s/payment", providerRef), "", "")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status := &PaymentResult{Reference: reference, ProviderRef: providerRef, Status: PaymentStatusPending}
+
+	// A SINGLE bill has at most one payment; the first one that isn't pending decides
+	dataArr, _ := result["data"].([]interface{})
+	for _, item := range dataArr {
+		payment, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		paymentStatus, _ := payment["status"].(string)
+		if flipStatus(paymentStatus) == PaymentStatusPending {
+			continue
+		}
+
+		status.Status = flipStatus(paymentStatus)
+		status.PaymentID = flipID(payment["id"])
+		if amount, ok := payment["amount"].(float64); ok {
+			status.Amount = int64(amount)
+		}
+		senderBank, _ := payment["sender_bank"].(string)
+		bankType, _ := payment["sender_bank_type"].(string)
+		status.Method = flipMethod(senderBank, bankType)
+		break
+	}
+	return status, nil
+}
+
+// Refund is not available: Flip Accept Payment has no refund API, refunds are settled manually
+func (p *FlipProvider) Refund(req RefundRequest) (*RefundResult, error) {
+	return nil, ErrRefundUnsupported
+}
+
+// ========================================
+// Helpers
+// ========================================
+
+// flipID normalises IDs that Flip returns as either numbers or strings
+func flipID(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return fmt.Sprintf("%.0f", v)
+	case string:
+		return v
+	}
+	return ""
+}
+
+// flipStatus maps Flip payment statuses: SUCCESSFUL, CANCELLED, FAILED (anything else is still pending)
+func flipStatus(status string) PaymentStatus {
+	switch strings.ToUpper(status) {
+	case "SUCCESSFUL":
+		return PaymentStatusPaid
+	case "CANCELLED":
+		return PaymentStatusExpired
+	case "FAILED":
+		return PaymentStatusFailed
+	}
+	return PaymentStatusPending
+}
+
+func flipMethod(senderBank string, bankType string) string {
+	if senderBank == "" {
+		return ""
+	}
+	if bankType == "" {
+		bankType = "bank"
+	}
+	return senderBank + ":" + bankType
+}
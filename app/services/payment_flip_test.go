@@ -0,0 +1,55 @@
+package services
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+const flipCallbackData = `{"id":"FT123","bill_link_id":4567,"reference_id":"TX-FLIP-1","sender_bank":"bca","sender_bank_type":"virtual_account","amount":25000,"status":"SUCCESSFUL"}`
+
+func flipForm(token string) []byte {
+	form := url.Values{"data": {flipCallbackData}}
+	if token != "" {
+		form.Set("token", token)
+	}
+	return []byte(form.Encode())
+}
+
+func TestFlipVerifyCallbackAcceptsValidationToken(t *testing.T) {
+	p := &FlipProvider{ValidationToken: "flip-token"}
+
+	result, err := p.VerifyCallback("application/x-www-form-urlencoded", flipForm("flip-token"), nil)
+	if err != nil {
+		t.Fatalf("VerifyCallback: %v", err)
+	}
+	want := PaymentResult{Reference: "TX-FLIP-1", ProviderRef: "4567", PaymentID: "FT123", Status: PaymentStatusPaid, Amount: 25000, Method: "bca:virtual_account"}
+	if *result != want {
+		t.Fatalf("result = %+v, want %+v", *result, want)
+	}
+
+	jsonBody := []byte(`{"token":"flip-token","id":"FT123","bill_link_id":"4567","reference_id":"TX-FLIP-1","amount":25000,"status":"SUCCESSFUL"}`)
+	if _, err := p.VerifyCallback("application/json", jsonBody, nil); err != nil {
+		t.Fatalf("VerifyCallback (JSON): %v", err)
+	}
+}
+
+func TestFlipVerifyCallbackRejectsBadToken(t *testing.T) {
+	cases := map[string]struct {
+		configured string
+		body       []byte
+	}{
+		"missing token":    {"flip-token", flipForm("")},
+		"wrong token":      {"flip-token", flipForm("guess")},
+		"token prefix":     {"flip-token", flipForm("flip")},
+		"not configured":   {"", flipForm("")},
+		"missing in JSON":  {"flip-token", []byte(flipCallbackData)},
+		"no callback data": {"flip-token", []byte("token=flip-token")},
+	}
+	for name, tc := range cases {
+		p := &FlipProvider{ValidationToken: tc.configured}
+		if _, err := p.VerifyCallback("application/x-www-form-urlencoded", tc.body, nil); !errors.Is(err, ErrInvalidCallback) {
+			t.Errorf("%s: err = %v, want ErrInvalidCallback", name, err)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	midtransSnapProductionURL = "https://app.midtrans.com/snap/v1"
+	midtransSnapSandboxURL    = "https://app.sandbox.midtrans.com/snap/v1"
+	midtransAPIProductionURL  = "https://api.midtrans.com/v2"
+	midtransAPISandboxURL     = "https://api.sandbox.midtrans.com/v2"
+)
+
+// MidtransProvider collects payments through Midtrans Snap. Our transaction ID is the Midtrans order_id.
+type MidtransProvider struct {
+	ServerKey  string
+	Production bool
+}
+
+func (p *MidtransProvider) Name() string {
+	return PaymentProviderMidtrans
+}
+
+func (p *MidtransProvider) snapURL() string {
+	if p.Production {
+		return midtransSnapProductionURL
+	}
+	return midtransSnapSandboxURL
+}
+
+func (p *MidtransProvider) apiURL() string {
+	if p.Production {
+		return midtransAPIProductionURL
+	}
+	return midtransAPISandboxURL
+}
+
+// call makes a JSON request to Midtrans, authenticated with the server key
+func (p *MidtransProvider) call(method string, url string, payload interface{}) (map[string]interface{}, error) {
+	var reader io.Reader
+	if payload != nil {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		reader = strings.NewReader(string(body))
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(p.ServerKey+":")))
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Midtrans API error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v (body: %s)", err, string(respBody))
+	}
+
+	fmt.Printf("[Midtrans] %s %s response (status %d): %s\n", method, url, resp.StatusCode, string(respBody))
+
+	if resp.StatusCode >= 400 {
+		return result, fmt.Errorf("%s (HTTP %d)", midtransMessage(result), resp.StatusCode)
+	}
+	return result, nil
+}
+
+// CreateBill creates a Snap transaction and returns its hosted payment page
+func (p *MidtransProvider) CreateBill(req BillRequest) (*Bill, error) {
+	body := map[string]interface{}{
+		"transaction_details": map[string]interface{}{
+			"order_id":     req.Reference,
+			"gross_amount": req.Amount,
+		},
+		"item_details": []map[string]interface{}{{
+			"id":       req.Reference,
+			"name":     truncate(req.Title, 50),
+			"price":    req.Amount,
+			"quantity": 1,
+		}},
+		"customer_details": map[string]interface{}{
+			"first_name": req.CustomerName,
+			"email":      req.CustomerEmail,
+		},
+		"callbacks": map[string]interface{}{
+			"finish": req.RedirectURL,
+		},
+	}
+	if expiry := midtransExpiry(req.ExpiresAt); expiry != nil {
+		body["expiry"] = expiry
+	}
+	result, err := p.call("POST", p.snapURL()+"/transactions", body)
+	if err != nil {
+		return nil, err
+	}
+
+	bill := &Bill{ProviderRef: req.Reference, Data: map[string]string{}}
+	if url, ok := result["redirect_url"].(string); ok {
+		bill.PaymentURL = url
+	}
+	if token, ok := result["token"].(string); ok {
+		bill.Data["snap_token"] = token
+	}
+	return bill, nil
+}
+
+// midtransExpiry turns a bill expiry into Snap's start time plus duration, in whole minutes rounded down.
+// A zero expiry returns nil, leaving Midtrans' default.
+func midtransExpiry(expiresAt time.Time) map[string]interface{} {
+	if expiresAt.IsZero() {
+		return nil
+	}
+	start := time.Now()
+	minutes := int(expiresAt.Sub(start) / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
+	return map[string]interface{}{
+		"start_time": start.In(jakartaTime).Format("2006-01-02 15:04:05 -0700"),
+		"unit":       "minute",
+		"duration":   minutes,
+	}
+}
+
+// midtransNotification is the shape of both HTTP notifications and status responses
+type midtransNotification struct {
+	OrderID           string `json:"order_id"`
+	TransactionID     string `json:"transaction_id"`
+	TransactionStatus string `json:"transaction_status"`
+	FraudStatus       string `json:"fraud_status"`
+	StatusCode        string `json:"status_code"`
+	GrossAmount       string `json:"gross_amount"`
+	PaymentType       string `json:"payment_type"`
+	SignatureKey      string `json:"signature_key"`
+}
+
+func (n midtransNotification) result() *PaymentResult {
+	amount, _ := strconv.ParseFloat(n.GrossAmount, 64)
+	return &PaymentResult{
+		Reference:   n.OrderID,
+		ProviderRef: n.OrderID,
+		PaymentID:   n.TransactionID,
+		Status:      midtransStatus(n.TransactionStatus, n.FraudStatus),
+		Amount:      int64(amount),
+		Method:      n.PaymentType,
+	}
+}
+
+// VerifyCallback checks the notification signature: SHA512(order_id + status_code + gross_amount + server_key)
+func (p *MidtransProvider) VerifyCallback(contentType string, body []byte, headers map[string]string) (*PaymentResult, error) {
+	var notification midtransNotification
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCallback, err)
+	}
+	if p.ServerKey == "" || notification.OrderID == "" {
+		return nil, ErrInvalidCallback
+	}
+
+	sum := sha512.Sum512([]byte(notification.OrderID + notification.StatusCode + notification.GrossAmount + p.ServerKey))
+	expected := hex.EncodeToString(sum[:])
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(notification.SignatureKey))) != 1 {
+		return nil, fmt.Errorf("%w: signature mismatch", ErrInvalidCallback)
+	}
+	return notification.result(), nil
+}
+
+// QueryStatus fetches the order status from the core API
+func (p *MidtransProvider) QueryStatus(reference string, providerRef string) (*PaymentResult, error) {
+	if providerRef == "" {
+		providerRef = reference
+	}
+	result, err := p.call("GET", p.apiURL()+"/"+providerRef+"/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	// The core API answers HTTP 200 and reports 404 in the body until the customer picks a payment method
+	if code, _ := result["status_code"].(string); code == "404" {
+		return &PaymentResult{Reference: reference, ProviderRef: providerRef, Status: PaymentStatusPending}, nil
+	}
+
+	raw, _ := json.Marshal(result)
+	var notification midtransNotification
+	if err := json.Unmarshal(raw, &notification); err != nil {
+		return nil, err
+	}
+	return notification.result(), nil
+}
+
+// Refund refunds a settled order, fully or partially
+func (p *MidtransProvider) Refund(req RefundRequest) (*RefundResult, error) {
+	providerRef := req.ProviderRef
+	if providerRef == "" {
+		providerRef = req.Reference
+	}
+	// Midtrans answers a repeated refund_key with the refund it already made
+	refundKey := req.IdempotencyKey
+	if refundKey == "" {
+		refundKey = fmt.Sprintf("%s-%d", req.Reference, time.Now().Unix())
+	}
+	result, err := p.call("POST", p.apiURL()+"/"+providerRef+"/refund", map[string]interface{}{
+		"refund_key": refundKey,
+		"amount":     req.Amount,
+		"reason":     req.Reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if code, _ := result["status_code"].(string); code != "200" {
+		return nil, fmt.Errorf("%s (status %s)", midtransMessage(result), code)
+	}
+
+	refund := &RefundResult{RefundID: refundKey, Amount: req.Amount}
+	if id, ok := result["refund_key"].(string); ok && id != "" {
+		refund.RefundID = id
+	}
+	return refund, nil
+}
+
+// midtransStatus maps transaction_status (and fraud_status for card captures)
+func midtransStatus(status string, fraudStatus string) PaymentStatus {
+	switch status {
+	case "settlement", "partial_refund":
+		return PaymentStatusPaid
+	case "capture":
+		if fraudStatus == "" || fraudStatus == "accept" {
+			return PaymentStatusPaid
+		}
+	case "deny", "failure":
+		return PaymentStatusFailed
+	case "cancel", "expire":
+		return PaymentStatusExpired
+	case "refund":
+		return PaymentStatusRefunded
+	}
+	return PaymentStatusPending
+}
+
+func midtransMessage(result map[string]interface{}) string {
+	if m, ok := result["status_message"].(string); ok {
+		return m
+	}
+	if messages, ok := result["error_messages"].([]interface{}); ok && len(messages) > 0 {
+		if m, ok := messages[0].(string); ok {
+			return m
+		}
+	}
+	return "Midtrans API error"
+}
+
+func truncate(value string, max int) string {
+	runes := []rune(value)
+	if len(runes) <= max {
+		return value
+	}
+	return string(runes[:max])
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Payment provider names, stored on transactions and selected through SystemConfig
+const (
+	PaymentProviderFlip     = "flip"
+	PaymentProviderMidtrans = "midtrans"
+	PaymentProviderFake     = "fake"
+)
+
+// jakartaTime is Western Indonesian Time (UTC+7), the zone Flip and Midtrans read bill expiry dates in
+var jakartaTime = time.FixedZone("WIB", 7*60*60)
+
+// PaymentStatus is the provider-neutral outcome of a payment
+type PaymentStatus string
+
+const (
+	PaymentStatusPending  PaymentStatus = "pending"
+	PaymentStatusPaid     PaymentStatus = "paid"
+	PaymentStatusFailed   PaymentStatus = "failed"
+	PaymentStatusExpired  PaymentStatus = "expired"
+	PaymentStatusRefunded PaymentStatus = "refunded"
+)
+
+var (
+	// ErrRefundUnsupported is returned by providers that can't refund through their API
+	ErrRefundUnsupported = errors.New("refunds are not supported by this payment provider")
+	// ErrInvalidCallback is returned when a callback fails signature or token validation
+	ErrInvalidCallback = errors.New("invalid payment callback")
+)
+
+// BillRequest describes a payment to collect
+type BillRequest struct {
+	Reference     string // our transaction ID
+	Title         string
+	Amount        int64 // in IDR
+	CustomerName  string
+	CustomerEmail string
+	RedirectURL   string    // where the customer lands after paying
+	ExpiresAt     time.Time // the provider stops accepting payment after this; zero leaves the provider's default
+}
+
+// Bill is what a provider returns after creating a payment
+type Bill struct {
+	ProviderRef string            // the provider's ID for the bill/order
+	PaymentURL  string            // hosted payment page
+	Data        map[string]string // provider-specific values the frontend needs (e.g. Flip popup codes)
+}
+
+// PaymentResult is a payment state reported by a callback or a status query
+type PaymentResult struct {
+	Reference   string // our transaction ID, when the provider echoes it back
+	ProviderRef string
+	PaymentID   string // the provider's ID for the actual payment
+	Status      PaymentStatus
+	Amount      int64
+	Method      string // e.g. "bca:virtual_account"
+}
+
+// RefundRequest asks a provider to return the money paid for a bill
+type RefundRequest struct {
+	Reference      string // our transaction ID
+	ProviderRef    string
+	Amount         int64
+	Reason         string
+	IdempotencyKey string // repeating a refund with the same key returns the first refund instead of paying out twice
+}
+
+// RefundResult is a provider's answer to a refund request
+type RefundResult struct {
+	RefundID string
+	Amount   int64
+}
+
+// PaymentProvider is implemented by each payment gateway
+type PaymentProvider interface {
+	Name() string
+	CreateBill(req BillRequest) (*Bill, error)
+	// VerifyCallback authenticates and parses a raw callback request
+	VerifyCallback(contentType string, body []byte, headers map[string]string) (*PaymentResult, error)
+	// QueryStatus asks the provider for the current state of a payment
+	QueryStatus(reference string, providerRef string) (*PaymentResult, error)
+	// Refund returns money for a paid bill
+	Refund(req RefundRequest) (*RefundResult, error)
+}
+
+// PaymentConfig carries the provider settings read from SystemConfig
+type PaymentConfig struct {
+	FlipSecretKey       string
+	FlipValidationToken string
+	FlipEnvironment     string
+	MidtransServerKey   string
+	MidtransEnvironment string
+	FakeSecret          string
+}
+
+// NewPaymentProvider builds the named provider
+func NewPaymentProvider(name string, config PaymentConfig) (PaymentProvider, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case PaymentProviderFlip, "":
+		return &FlipProvider{
+			SecretKey:       config.FlipSecretKey,
+			ValidationToken: config.FlipValidationToken,
+			Production:      config.FlipEnvironment == "production",
+		}, nil
+	case PaymentProviderMidtrans:
+		return &MidtransProvider{
+			ServerKey:  config.MidtransServerKey,
+			Production: config.MidtransEnvironment == "production",
+		}, nil
+	case PaymentProviderFake:
+		return NewFakeProvider(config.FakeSecret), nil
+	}
+	return nil, fmt.Errorf("unknown payment provider: %s", name)
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A4 page size in points
+const (
+	PDFPageWidth  = 595.28
+	PDFPageHeight = 841.89
+)
+
+// helveticaWidths are the Helvetica glyph widths (1/1000 em) for ASCII 32..126
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space to /
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, // 0-9
+	278, 278, 584, 584, 584, 556, 1015, // : to @
+	667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, // A-M
+	722, 778, 667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, // N-Z
+	278, 278, 278, 469, 556, 333, // [ to `
+	556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, // a-m
+	556, 556, 556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, // n-z
+	334, 260, 334, 584, // { to ~
+}
+
+// PDFDocument writes simple A4 documents with the built-in Helvetica fonts: text, lines and
+// shaded boxes. Coordinates are in points from the top-left corner of the page.
+type PDFDocument struct {
+	pages []*bytes.Buffer
+}
+
+// NewPDFDocument returns a document with one empty page
+func NewPDFDocument() *PDFDocument {
+	d := &PDFDocument{}
+	d.AddPage()
+	return d
+}
+
+// AddPage starts a new page; later drawing goes to it
+func (d *PDFDocument) AddPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+}
+
+func (d *PDFDocument) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// Text draws text with its baseline at y
+func (d *PDFDocument) Text(x float64, y float64, size float64, bold bool, text string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.page(), "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, PDFPageHeight-y, pdfString(text))
+}
+
+// TextRight draws text ending at x
+func (d *PDFDocument) TextRight(x float64, y float64, size float64, bold bool, text string) {
+	d.Text(x-TextWidth(text, size), y, size, bold, text)
+}
+
+// Line draws a thin gray line
+func (d *PDFDocument) Line(x1 float64, y1 float64, x2 float64, y2 float64) {
+	fmt.Fprintf(d.page(), "0.6 G 0.5 w %.2f %.2f m %.2f %.2f l S 0 G\n", x1, PDFPageHeight-y1, x2, PDFPageHeight-y2)
+}
+
+// FillRect shades a box whose top-left corner is at x, y; gray runs from 0 (black) to 1 (white)
+func (d *PDFDocument) FillRect(x float64, y float64, w float64, h float64, gray float64) {
+	fmt.Fprintf(d.page(), "%.2f g %.2f %.2f %.2f %.2f re f 0 g\n", gray, x, PDFPageHeight-y-h, w, h)
+}
+
+// TextWidth estimates the width of text in points; bold text is slightly wider than this
+func TextWidth(text string, size float64) float64 {
+	total := 0
+	for _, r := range text {
+		if r >= 32 && r <= 126 {
+			total += helveticaWidths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// Bytes renders the document
+func (d *PDFDocument) Bytes() []byte {
+	var out bytes.Buffer
+	offsets := []int{}
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+
+	// Objects 1-4 are fixed; each page is a page object followed by its content stream
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+i*2)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, content := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			PDFPageWidth, PDFPageHeight, 6+i*2))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// pdfString escapes text for a PDF string literal. The fonts use WinAnsi, so Latin-1 characters
+// are kept and anything else becomes "?".
+func pdfString(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 32 && r <= 126:
+			b.WriteRune(r)
+		case r >= 160 && r <= 255:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -1,28 +1,68 @@
 package websocket
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"sync"
 
 	"github.com/gofiber/websocket/v2"
 )
 
+// Connection roles
+const (
+	RoleMaster = "master"
+	RoleCoHost = "co-host"
+	RoleGuest  = "guest"
+	RoleTV     = "tv"
+)
+
 // Connection wraps a WebSocket connection
 type Connection struct {
-	Conn   *websocket.Conn
-	Room   *Room
-	mu     sync.Mutex
-	closed bool
+	ID      string
+	Conn    *websocket.Conn
+	Room    *Room
+	UserID  string // set for authenticated users
+	GuestID string // set for guests; their song requests are recorded under it
+	Name    string
+	mu      sync.Mutex
+	closed  bool
+	// role is changed by the master's goroutine while this connection's read loop checks it
+	roleMu     sync.RWMutex
+	role       string // master, co-host, guest or tv
+	guestToken string // newly issued guest token, sent once in the welcome message
 }
 
 // NewConnection creates a new connection wrapper
 func NewConnection(conn *websocket.Conn, room *Room) *Connection {
 	return &Connection{
+		ID:     generateConnectionID(),
 		Conn:   conn,
 		Room:   room,
 		closed: false,
+		role:   RoleGuest,
 	}
 }
 
+// Role returns the connection's current role
+func (c *Connection) Role() string {
+	c.roleMu.RLock()
+	defer c.roleMu.RUnlock()
+	return c.role
+}
+
+// SetRole changes the connection's role
+func (c *Connection) SetRole(role string) {
+	c.roleMu.Lock()
+	defer c.roleMu.Unlock()
+	c.role = role
+}
+
+// CanControlTV reports whether the connection may send remote commands to the TV
+func (c *Connection) CanControlTV() bool {
+	role := c.Role()
+	return role == RoleMaster || role == RoleCoHost
+}
+
 // Send sends a message to the connection
 func (c *Connection) Send(message []byte) {
 	c.mu.Lock()
@@ -41,3 +81,9 @@ func (c *Connection) Close() {
 	defer c.mu.Unlock()
 	c.closed = true
 }
+
+func generateConnectionID() string {
+	bytes := make([]byte, 8)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
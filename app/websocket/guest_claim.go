@@ -0,0 +1,35 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+
+	"GoFiberMVC/app/controllers"
+)
+
+// claimGuests lets a signed-in connection take over the guest identities its browser held,
+// e.g. right after registering from inside the room
+func (r *Room) claimGuests(conn *Connection, payload map[string]interface{}) {
+	if conn.UserID == "" {
+		sendError(conn, "Sign in to keep your guest history")
+		return
+	}
+
+	raw, _ := payload["guestTokens"].([]interface{})
+	tokens := make([]string, 0, len(raw))
+	for _, t := range raw {
+		if token, ok := t.(string); ok && token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+
+	result, err := controllers.ClaimGuestsInRoom(conn.UserID, tokens)
+	if err != nil {
+		log.Printf("WebSocket: guest claim failed in room %s: %v", r.Key, err)
+		sendError(conn, "Could not claim guest history")
+		return
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{"type": "guest-claimed", "result": result})
+	conn.Send(data)
+}
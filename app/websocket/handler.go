@@ -25,6 +25,17 @@ func WebSocketUpgrade(c *fiber.Ctx) error {
 	return fiber.ErrUpgradeRequired
 }
 
+// RequireRoom rejects upgrades for unknown room keys with a plain 404,
+// so failed guesses are visible to the throttle before the socket is opened
+func RequireRoom(c *fiber.Ctx) error {
+	roomKey := c.Params("roomKey")
+	var dbRoom models.Room
+	if roomKey == "" || initializers.Db.Where("room_key = ?", roomKey).First(&dbRoom).Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Room not found"})
+	}
+	return c.Next()
+}
+
 // HandleWebSocket handles WebSocket connections for karaoke rooms
 func HandleWebSocket(c *websocket.Conn) {
 	// Get room key from path parameter
@@ -65,14 +76,19 @@ func HandleWebSocket(c *websocket.Conn) {
 
 	// Get or create room
 	room := roomManager.GetOrCreateRoom(roomKey)
+	room.mu.Lock()
+	room.ID = dbRoom.ID
+	room.mu.Unlock()
 
 	// Create connection wrapper
 	conn := NewConnection(c, room)
+	identifyConnection(c, conn, &dbRoom, room)
 
 	// Add connection to room
 	room.AddConnection(conn)
 
-	// Send initial state
+	// Send role and initial state
+	room.SendWelcome(conn)
 	room.SendState(conn)
 
 	// Start expiration checker goroutine
@@ -104,6 +120,39 @@ func HandleWebSocket(c *websocket.Conn) {
 	}
 }
 
+// identifyConnection assigns the connection's role from its query string:
+// ?tv=<pairing or device token> for the TV player, ?ticket=<one-time ticket from
+// POST /api/rooms/:roomKey/ws-ticket> for signed-in users,
+// and ?guest=<guest token>&name=<display name> for guests returning to the room
+func identifyConnection(c *websocket.Conn, conn *Connection, dbRoom *models.Room, room *Room) {
+	if controllers.AuthorizeTVForRoom(c.Query("tv"), dbRoom.RoomKey) {
+		conn.SetRole(RoleTV)
+		conn.Name = "TV"
+		return
+	}
+
+	user := controllers.UserFromWebsocketTicket(c.Query("ticket"), dbRoom.RoomKey)
+	if user == nil {
+		guest, token, err := controllers.JoinRoomAsGuest(dbRoom.ID, c.Query("guest"), c.Query("name"))
+		if err != nil {
+			log.Printf("WebSocket: failed to register guest in room %s: %v", dbRoom.RoomKey, err)
+			return
+		}
+		conn.GuestID = guest.ID
+		conn.Name = guest.Name
+		conn.guestToken = token
+		return
+	}
+	conn.UserID = user.ID
+	conn.Name = user.Name
+	switch {
+	case user.ID == dbRoom.RoomCreator || user.ID == dbRoom.RoomMaster:
+		conn.SetRole(RoleMaster)
+	case room.IsCoHost(user.ID):
+		conn.SetRole(RoleCoHost)
+	}
+}
+
 // checkExpirationAndKick checks if the room has expired and kicks all users
 func checkExpirationAndKick(roomKey string, room *Room, stop chan struct{}) {
 	ticker := time.NewTicker(30 * time.Second) // Check every 30 seconds
@@ -18,6 +18,7 @@ type Video struct {
 	SingerName string  `json:"singerName"`
 	CreatedAt  string  `json:"createdAt"`
 	PlayedAt   *string `json:"playedAt"`
+	songID     string  // the songs row recording the request
 }
 
 // RoomMeta contains room metadata
@@ -41,10 +42,14 @@ type RoomState struct {
 // Room represents a karaoke room with WebSocket connections
 type Room struct {
 	Key         string
+	ID          string // rooms table ID, for recording song requests
 	State       RoomState
 	Connections map[*Connection]bool
+	CoHosts     map[string]bool // user IDs promoted to co-host by the master
 	mu          sync.RWMutex
 	lastAccess  time.Time
+
+	pendingCommands map[string]pendingTVCommand // TV commands awaiting acknowledgement, by command ID
 }
 
 // RoomManager manages all karaoke rooms
@@ -80,8 +85,10 @@ func (rm *RoomManager) GetOrCreateRoom(roomKey string) *Room {
 			Settings: RoomSettings{OrderByFairness: true},
 			Meta:     nil,
 		},
-		Connections: make(map[*Connection]bool),
-		lastAccess:  time.Now(),
+		Connections:     make(map[*Connection]bool),
+		CoHosts:         make(map[string]bool),
+		lastAccess:      time.Now(),
+		pendingCommands: make(map[string]pendingTVCommand),
 	}
 	rm.rooms[roomKey] = room
 	return room
@@ -126,6 +133,87 @@ func (r *Room) Broadcast(message []byte) {
 	}
 }
 
+// IsCoHost reports whether the user was promoted to co-host in this room
+func (r *Room) IsCoHost(userID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return userID != "" && r.CoHosts[userID]
+}
+
+// SendWelcome tells a new connection its ID and role
+func (r *Room) SendWelcome(conn *Connection) {
+	msg := map[string]interface{}{
+		"type":         "welcome",
+		"connectionId": conn.ID,
+		"role":         conn.Role(),
+	}
+	if conn.guestToken != "" {
+		// Kept by the browser to rejoin as the same guest and to claim its songs after signing up
+		msg["guestToken"] = conn.guestToken
+	}
+	data, _ := json.Marshal(msg)
+	conn.Send(data)
+}
+
+// setCoHost lets the master promote or demote a signed-in user; live connections switch role immediately
+func (r *Room) setCoHost(conn *Connection, payload map[string]interface{}) {
+	if conn.Role() != RoleMaster {
+		sendError(conn, "Only the room master can manage co-hosts")
+		return
+	}
+	userID, _ := payload["userId"].(string)
+	enabled, _ := payload["enabled"].(bool)
+	if userID == "" {
+		return
+	}
+
+	r.mu.Lock()
+	if enabled {
+		r.CoHosts[userID] = true
+	} else {
+		delete(r.CoHosts, userID)
+	}
+	newRole := RoleGuest
+	if enabled {
+		newRole = RoleCoHost
+	}
+	changed := []*Connection{}
+	for c := range r.Connections {
+		if role := c.Role(); c.UserID != userID || role == RoleMaster || role == RoleTV {
+			continue
+		}
+		c.SetRole(newRole)
+		changed = append(changed, c)
+	}
+	r.mu.Unlock()
+
+	data, _ := json.Marshal(map[string]interface{}{"type": "role", "role": newRole})
+	for _, c := range changed {
+		c.Send(data)
+	}
+}
+
+// sendParticipants sends the list of connected clients to the master or a co-host
+func (r *Room) sendParticipants(conn *Connection) {
+	if !conn.CanControlTV() {
+		return
+	}
+	r.mu.RLock()
+	participants := make([]map[string]interface{}, 0, len(r.Connections))
+	for c := range r.Connections {
+		participants = append(participants, map[string]interface{}{
+			"connectionId": c.ID,
+			"role":         c.Role(),
+			"userId":       c.UserID,
+			"name":         c.Name,
+		})
+	}
+	r.mu.RUnlock()
+
+	data, _ := json.Marshal(map[string]interface{}{"type": "participants", "participants": participants})
+	conn.Send(data)
+}
+
 // SendState sends the current state to a specific connection
 func (r *Room) SendState(conn *Connection) {
 	r.mu.RLock()
@@ -200,6 +288,7 @@ func (r *Room) HandleMessage(conn *Connection, message []byte) {
 	case "add-video":
 		r.mu.Lock()
 		id, _ := payload["id"].(string)
+		var added *Video
 
 		// Check if already queued
 		alreadyQueued := false
@@ -237,7 +326,9 @@ func (r *Room) HandleMessage(conn *Connection, message []byte) {
 				SingerName: singerName,
 				CreatedAt:  time.Now().UTC().Format(time.RFC3339),
 				PlayedAt:   nil,
+				songID:     newSongID(),
 			}
+			added = &newVideo
 
 			insertPos, _ := payload["insertPosition"].(string)
 			currentIndex := -1
@@ -262,7 +353,11 @@ func (r *Room) HandleMessage(conn *Connection, message []byte) {
 				r.State.Playlist = append(r.State.Playlist, newVideo)
 			}
 		}
+		roomID := r.ID
 		r.mu.Unlock()
+		if added != nil {
+			recordSongRequest(roomID, conn, added)
+		}
 
 	case "reorder-upcoming":
 		r.mu.Lock()
@@ -308,22 +403,29 @@ func (r *Room) HandleMessage(conn *Connection, message []byte) {
 	case "remove-video":
 		r.mu.Lock()
 		id, _ := payload["id"].(string)
+		var removed Video
 		for i, v := range r.State.Playlist {
 			if v.ID == id {
+				removed = v
 				r.State.Playlist = append(r.State.Playlist[:i], r.State.Playlist[i+1:]...)
 				break
 			}
 		}
 		r.mu.Unlock()
+		if removed.songID != "" && removed.PlayedAt == nil {
+			forgetSongRequest(removed.songID)
+		}
 
 	case "mark-as-played":
 		r.mu.Lock()
 		id, _ := payload["id"].(string)
 		changed := false
+		playedSongID := ""
 		for i, v := range r.State.Playlist {
 			if v.ID == id && v.PlayedAt == nil {
 				now := time.Now().UTC().Format(time.RFC3339)
 				r.State.Playlist[i].PlayedAt = &now
+				playedSongID = v.songID
 				changed = true
 				break
 			}
@@ -336,11 +438,30 @@ func (r *Room) HandleMessage(conn *Connection, message []byte) {
 		// Clean up old played songs to prevent memory accumulation (keep last 10 played)
 		r.cleanupOldPlayedSongs()
 		r.mu.Unlock()
+		markSongPlayed(playedSongID)
+
+	case "tv-command":
+		r.handleTVCommand(conn, message)
+		return
+
+	case "tv-command-ack":
+		r.handleTVCommandAck(conn, message)
+		return
+
+	case "set-co-host":
+		r.setCoHost(conn, payload)
+		return
+
+	case "list-participants":
+		r.sendParticipants(conn)
+		return
+
+	case "claim-guest":
+		r.claimGuests(conn, payload)
+		return
 
 	case "horn":
-		msg := map[string]string{"type": "horn"}
-		data, _ := json.Marshal(msg)
-		r.Broadcast(data)
+		r.handleHorn(conn)
 		return
 
 	case "emoji":
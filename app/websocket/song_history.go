@@ -0,0 +1,56 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"GoFiberMVC/app/initializers"
+	"GoFiberMVC/app/models"
+)
+
+// The live playlist stays in memory; each request is also written to the songs table under the
+// user or guest who made it, which is the history a guest takes along when claiming it.
+
+func newSongID() string {
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+// recordSongRequest stores a newly queued video
+func recordSongRequest(roomID string, conn *Connection, video *Video) {
+	if roomID == "" {
+		return
+	}
+	song := models.Song{
+		ID:       video.songID,
+		RoomID:   roomID,
+		Title:    video.Title,
+		Artist:   video.Artist,
+		CoverURL: video.CoverURL,
+		Duration: video.Duration,
+	}
+	if conn.UserID != "" {
+		song.RequestedByUserID = &conn.UserID
+	} else if conn.GuestID != "" {
+		song.RequestedByGuestID = &conn.GuestID
+	}
+	if err := initializers.Db.Create(&song).Error; err != nil {
+		log.Printf("WebSocket: failed to record song request in room %s: %v", roomID, err)
+	}
+}
+
+// markSongPlayed records when a queued song was played
+func markSongPlayed(songID string) {
+	if songID == "" {
+		return
+	}
+	initializers.Db.Model(&models.Song{}).Where("id = ? AND played_at IS NULL", songID).Update("played_at", time.Now())
+}
+
+// forgetSongRequest drops a request removed from the queue before it was played
+func forgetSongRequest(songID string) {
+	initializers.Db.Where("id = ? AND played_at IS NULL", songID).Delete(&models.Song{})
+}
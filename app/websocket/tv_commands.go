@@ -0,0 +1,162 @@
+package websocket
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// TVCommand is a remote-control action a host can send to the TV player
+type TVCommand string
+
+const (
+	TVCommandVolumeUp         TVCommand = "volume-up"
+	TVCommandVolumeDown       TVCommand = "volume-down"
+	TVCommandToggleMute       TVCommand = "toggle-mute"
+	TVCommandToggleFullscreen TVCommand = "toggle-fullscreen"
+	TVCommandRestartSong      TVCommand = "restart-song"
+	TVCommandShowQueue        TVCommand = "show-queue"
+	TVCommandHideQueue        TVCommand = "hide-queue"
+)
+
+var validTVCommands = map[TVCommand]bool{
+	TVCommandVolumeUp:         true,
+	TVCommandVolumeDown:       true,
+	TVCommandToggleMute:       true,
+	TVCommandToggleFullscreen: true,
+	TVCommandRestartSong:      true,
+	TVCommandShowQueue:        true,
+	TVCommandHideQueue:        true,
+}
+
+// Pending commands waiting for a TV acknowledgement are forgotten after this long
+const tvCommandAckTimeout = time.Minute
+
+// TVCommandMessage is sent by a controller ("tv-command") and forwarded to TV connections
+type TVCommandMessage struct {
+	Type      string    `json:"type"`
+	Command   TVCommand `json:"command"`
+	CommandID string    `json:"commandId"`
+	From      string    `json:"from,omitempty"` // sender's display name, set by the server
+}
+
+// TVCommandAck is sent by the TV ("tv-command-ack") and routed back to the sender
+type TVCommandAck struct {
+	Type      string    `json:"type"`
+	CommandID string    `json:"commandId"`
+	Command   TVCommand `json:"command,omitempty"`
+	Status    string    `json:"status"` // ok, error, no-tv
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// pendingTVCommand remembers who sent a command so the ack can be routed back
+type pendingTVCommand struct {
+	sender  *Connection
+	command TVCommand
+	sentAt  time.Time
+}
+
+// handleTVCommand validates a remote command and delivers it to the room's TV connections only
+func (r *Room) handleTVCommand(conn *Connection, message []byte) {
+	var msg TVCommandMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return
+	}
+	if !conn.CanControlTV() {
+		sendError(conn, "Only the room master or a co-host can control the TV")
+		return
+	}
+	if !validTVCommands[msg.Command] {
+		sendError(conn, "Unknown TV command: "+string(msg.Command))
+		return
+	}
+	if msg.CommandID == "" {
+		msg.CommandID = generateConnectionID()
+	}
+	msg.Type = "tv-command"
+	msg.From = conn.Name
+
+	data, _ := json.Marshal(msg)
+
+	r.mu.Lock()
+	now := time.Now()
+	for id, pending := range r.pendingCommands {
+		if now.Sub(pending.sentAt) > tvCommandAckTimeout {
+			delete(r.pendingCommands, id)
+		}
+	}
+	delivered := r.sendToTVsLocked(data)
+	if delivered > 0 {
+		r.pendingCommands[msg.CommandID] = pendingTVCommand{sender: conn, command: msg.Command, sentAt: now}
+	}
+	r.mu.Unlock()
+
+	if delivered == 0 {
+		ack, _ := json.Marshal(TVCommandAck{
+			Type:      "tv-command-ack",
+			CommandID: msg.CommandID,
+			Command:   msg.Command,
+			Status:    "no-tv",
+			Detail:    "No TV is connected to this room",
+		})
+		conn.Send(ack)
+	}
+}
+
+// handleHorn plays the horn on the room's TVs; like TV commands it is for the master and co-hosts
+func (r *Room) handleHorn(conn *Connection) {
+	if !conn.CanControlTV() {
+		sendError(conn, "Only the room master or a co-host can sound the horn")
+		return
+	}
+	data, _ := json.Marshal(map[string]string{"type": "horn", "from": conn.Name})
+	r.mu.RLock()
+	r.sendToTVsLocked(data)
+	r.mu.RUnlock()
+}
+
+// sendToTVsLocked sends a message to the room's TV connections and returns how many got it.
+// The caller holds r.mu.
+func (r *Room) sendToTVsLocked(message []byte) int {
+	delivered := 0
+	for c := range r.Connections {
+		if c.Role() == RoleTV {
+			c.Send(message)
+			delivered++
+		}
+	}
+	return delivered
+}
+
+// handleTVCommandAck routes a TV's acknowledgement back to the controller that sent the command
+func (r *Room) handleTVCommandAck(conn *Connection, message []byte) {
+	if conn.Role() != RoleTV {
+		return
+	}
+	var ack TVCommandAck
+	if err := json.Unmarshal(message, &ack); err != nil || ack.CommandID == "" {
+		return
+	}
+
+	r.mu.Lock()
+	pending, ok := r.pendingCommands[ack.CommandID]
+	delete(r.pendingCommands, ack.CommandID)
+	_, senderConnected := r.Connections[pending.sender]
+	r.mu.Unlock()
+
+	if !ok || !senderConnected {
+		return
+	}
+	ack.Type = "tv-command-ack"
+	ack.Command = pending.command
+	if ack.Status == "" {
+		ack.Status = "ok"
+	}
+	data, _ := json.Marshal(ack)
+	pending.sender.Send(data)
+}
+
+// sendError sends an error message to a single connection
+func sendError(conn *Connection, message string) {
+	data, _ := json.Marshal(map[string]string{"type": "error", "error": message})
+	conn.Send(data)
+}
@@ -1,4 +1,5 @@
 import { useState, useEffect, createContext, useContext } from 'react';
+import { claimGuestHistory } from './guestTokens.js';
 
 const AUTH_TOKEN_KEY = 'karayouke:auth:token';
 const AUTH_USER_KEY = 'karayouke:auth:user';
@@ -109,14 +110,15 @@ export const AuthProvider = ({ children }) => {
 		setToken(data.token);
 		setUser(data.user);
 		storeAuth(data.token, data.user);
+		claimGuestHistory(API_BASE, data.token);
 		return data.user;
 	};
 
-	const register = async (name, username, email, password) => {
+	const register = async (name, username, email, password, referralCode) => {
 		const response = await fetch(`${API_BASE}/api/auth/register`, {
 			method: 'POST',
 			headers: { 'Content-Type': 'application/json' },
-			body: JSON.stringify({ name, username, email, password }),
+			body: JSON.stringify({ name, username, email, password, referral_code: referralCode || undefined }),
 		});
 
 		if (!response.ok) {
@@ -128,6 +130,7 @@ export const AuthProvider = ({ children }) => {
 		setToken(data.token);
 		setUser(data.user);
 		storeAuth(data.token, data.user);
+		claimGuestHistory(API_BASE, data.token);
 		return data.user;
 	};
 
@@ -172,3 +175,20 @@ export const fetchWithAuth = async (url, options = {}) => {
 	}
 	return fetch(url, { ...options, headers });
 };
+
+// downloadWithAuth saves an authenticated file response (e.g. an invoice PDF) under the server's filename
+export const downloadWithAuth = async (url, options = {}) => {
+	const response = await fetchWithAuth(url, options);
+	if (!response.ok) {
+		const data = await response.json().catch(() => ({}));
+		throw new Error(data.error || 'Download failed');
+	}
+	const disposition = response.headers.get('Content-Disposition') || '';
+	const match = disposition.match(/filename="([^"]+)"/);
+	const blobUrl = URL.createObjectURL(await response.blob());
+	const link = document.createElement('a');
+	link.href = blobUrl;
+	link.download = match ? match[1] : 'download';
+	link.click();
+	URL.revokeObjectURL(blobUrl);
+};
@@ -0,0 +1,33 @@
+// Each room hands a guest a token on first connect. The browser keeps it to rejoin as the same
+// guest and, once the guest signs up or logs in, to move their song requests to the account.
+const GUEST_TOKEN_PREFIX = 'karayouke:guest-token:';
+
+export const getGuestToken = (roomKey) => localStorage.getItem(`${GUEST_TOKEN_PREFIX}${roomKey}`);
+
+export const setGuestToken = (roomKey, token) => {
+	localStorage.setItem(`${GUEST_TOKEN_PREFIX}${roomKey}`, token);
+};
+
+const guestTokenKeys = () => Object.keys(localStorage).filter((key) => key.startsWith(GUEST_TOKEN_PREFIX));
+
+// claimGuestHistory links every guest this browser played as to the signed-in account
+export const claimGuestHistory = async (apiBase, authToken) => {
+	const tokens = guestTokenKeys().map((key) => localStorage.getItem(key)).filter(Boolean);
+	if (!authToken || tokens.length === 0) return;
+	try {
+		const response = await fetch(`${apiBase}/api/user/claim-guests`, {
+			method: 'POST',
+			headers: { 'Content-Type': 'application/json', Authorization: `Bearer ${authToken}` },
+			body: JSON.stringify({ guest_tokens: tokens.slice(0, 50) }),
+		});
+		if (response.ok) {
+			// Claimed (or already someone else's): either way the tokens are spent
+			const result = await response.json();
+			if (result.claimed?.length || result.conflict?.length) {
+				guestTokenKeys().forEach((key) => localStorage.removeItem(key));
+			}
+		}
+	} catch {
+		// Best effort: the tokens stay for the next sign-in
+	}
+};
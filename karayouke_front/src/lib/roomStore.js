@@ -1,5 +1,6 @@
 import { useEffect, useMemo, useState } from 'react';
 import { getAuthToken } from './auth.jsx';
+import { getGuestToken, setGuestToken } from './guestTokens.js';
 
 const ROOMS_KEY = 'karayouke:rooms';
 const connections = new Map();
@@ -69,8 +70,14 @@ const ensureConnection = (roomKey) => {
 	const expiredListeners = new Set();
 	const emojiListeners = new Set();
 
-	// Use native WebSocket instead of PartySocket
-	const wsUrl = `${getWebSocketHost()}/ws/${roomKey}`;
+	// Use native WebSocket instead of PartySocket; returning guests rejoin with their guest token
+	const guestQuery = new URLSearchParams();
+	const guestToken = getGuestToken(roomKey);
+	const guestName = safeParse(localStorage.getItem(`karayouke:guest:${roomKey}`), null)?.name;
+	if (guestToken) guestQuery.set('guest', guestToken);
+	if (guestName) guestQuery.set('name', guestName);
+	const query = guestQuery.toString();
+	const wsUrl = `${getWebSocketHost()}/ws/${roomKey}${query ? `?${query}` : ''}`;
 	const socket = new WebSocket(wsUrl);
 
 	const notify = (nextState) => {
@@ -103,6 +110,8 @@ const ensureConnection = (roomKey) => {
 		}
 		if (payload?.type === 'state') {
 			notify(payload.state || { playlist: [], meta: null });
+		} else if (payload?.type === 'welcome' && payload.guestToken) {
+			setGuestToken(roomKey, payload.guestToken);
 		} else if (payload?.type === 'room_expired') {
 			// Room has expired, notify listeners
 			notifyExpired();
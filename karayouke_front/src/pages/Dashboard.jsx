@@ -18,6 +18,10 @@ const Dashboard = () => {
 	const [isSubmitting, setIsSubmitting] = useState(false);
 	const [createError, setCreateError] = useState(null);
 	const [creditInfo, setCreditInfo] = useState(null);
+	const [referralInfo, setReferralInfo] = useState(null);
+	const [linkCopied, setLinkCopied] = useState(false);
+	const [renewal, setRenewal] = useState(null);
+	const [cancellingRenewal, setCancellingRenewal] = useState(false);
 	const [showExpiredRooms, setShowExpiredRooms] = useState(false);
 	const [menuOpen, setMenuOpen] = useState(false);
 	const navigate = useNavigate();
@@ -61,6 +65,79 @@ const Dashboard = () => {
 		};
 	}, [isAuthenticated]);
 
+	useEffect(() => {
+		let isActive = true;
+		const fetchReferrals = async () => {
+			if (!isAuthenticated) return;
+			try {
+				const response = await fetchWithAuth(`${API_BASE}/api/referrals`);
+				if (!response.ok) return;
+				const data = await response.json();
+				if (isActive) {
+					setReferralInfo(data);
+				}
+			} catch {
+				// ignore
+			}
+		};
+		void fetchReferrals();
+		return () => {
+			isActive = false;
+		};
+	}, [isAuthenticated]);
+
+	useEffect(() => {
+		let isActive = true;
+		const fetchSubscription = async () => {
+			if (!isAuthenticated) return;
+			try {
+				const response = await fetchWithAuth(`${API_BASE}/api/subscription`);
+				if (!response.ok) return;
+				const data = await response.json();
+				if (isActive) {
+					setRenewal(data.renewal);
+				}
+			} catch {
+				// ignore
+			}
+		};
+		void fetchSubscription();
+		return () => {
+			isActive = false;
+		};
+	}, [isAuthenticated]);
+
+	const handleCancelAutoRenew = async () => {
+		if (!window.confirm('Turn off auto-renewal? Your plan stays active until it expires.')) return;
+		setCancellingRenewal(true);
+		try {
+			const response = await fetchWithAuth(`${API_BASE}/api/subscription/cancel-auto-renew`, { method: 'POST' });
+			if (response.ok) {
+				setCreditInfo((prev) => prev && ({
+					...prev,
+					subscription: prev.subscription && { ...prev.subscription, auto_renew: false, grace_until: null },
+				}));
+			}
+		} catch {
+			// ignore
+		} finally {
+			setCancellingRenewal(false);
+		}
+	};
+
+	const handleCopyReferralLink = async () => {
+		if (!referralInfo?.link) return;
+		try {
+			// The API returns a relative link when the server has no APP_URL
+			const link = referralInfo.link.startsWith('/') ? window.location.origin + referralInfo.link : referralInfo.link;
+			await navigator.clipboard.writeText(link);
+			setLinkCopied(true);
+			setTimeout(() => setLinkCopied(false), 2000);
+		} catch {
+			// ignore
+		}
+	};
+
 	const handleCreateRoom = async (event) => {
 		event.preventDefault();
 		if (!roomName.trim()) return;
@@ -221,8 +298,24 @@ const Dashboard = () => {
 											🎁 {creditInfo.subscription.daily_free_credits} daily credits &bull; 🕐 {creditInfo.subscription.room_duration_minutes}min rooms
 										</div>
 										<div className="dashboard-info-subtext" style={{ marginTop: '4px', fontSize: '0.78rem', opacity: 0.7 }}>
-											Expires {new Date(creditInfo.subscription.expires_at).toLocaleDateString('id-ID', { day: 'numeric', month: 'short', year: 'numeric' })}
+											{creditInfo.subscription.auto_renew ? 'Renews' : 'Expires'} {new Date(creditInfo.subscription.expires_at).toLocaleDateString('id-ID', { day: 'numeric', month: 'short', year: 'numeric' })}
 										</div>
+										{renewal && (
+											<div className="dashboard-info-subtext" style={{ marginTop: '4px', fontSize: '0.78rem' }}>
+												Renewal payment due{' '}
+												<Link to={`/payment/status/${renewal.transaction_id}`}>Pay now</Link>
+											</div>
+										)}
+										{creditInfo.subscription.auto_renew && (
+											<button
+												className="dashboard-toggle"
+												style={{ marginTop: '6px' }}
+												onClick={handleCancelAutoRenew}
+												disabled={cancellingRenewal}
+											>
+												{cancellingRenewal ? 'Cancelling…' : 'Cancel auto-renew'}
+											</button>
+										)}
 									</>
 								) : (
 									<div className="dashboard-info-subtext">
@@ -243,6 +336,11 @@ const Dashboard = () => {
 										</>
 									) : 'Use credits to create rooms'}
 								</div>
+								{creditInfo?.expiring?.length > 0 && (
+									<div className="dashboard-info-subtext">
+										⏳ {creditInfo.expiring[0].amount} credits expire {new Date(creditInfo.expiring[0].expires_at).toLocaleDateString('id-ID', { day: 'numeric', month: 'short', year: 'numeric' })}
+									</div>
+								)}
 								<Link to="/packages" className="dashboard-buy-credits-btn">
 									Get More Credits
 								</Link>
@@ -255,6 +353,22 @@ const Dashboard = () => {
 									View History
 								</Link>
 							</div>
+							{referralInfo && (
+								<div className="dashboard-info-card">
+									<div className="dashboard-info-label">Invite Friends</div>
+									<div className="dashboard-info-value">{referralInfo.code}</div>
+									<div className="dashboard-info-subtext">
+										You get {referralInfo.referrer_reward} credits, they get {referralInfo.referred_reward}
+										{referralInfo.requires_purchase ? ' after their first purchase' : ' once they verify their email'}
+									</div>
+									<div className="dashboard-info-subtext" style={{ marginTop: '4px', fontSize: '0.78rem', opacity: 0.7 }}>
+										{referralInfo.rewarded} joined · {referralInfo.pending} pending · {referralInfo.credits_earned} credits earned
+									</div>
+									<button className="dashboard-history-btn" onClick={handleCopyReferralLink}>
+										{linkCopied ? 'Link Copied' : 'Copy Invite Link'}
+									</button>
+								</div>
+							)}
 						</div>
 					</section>
 
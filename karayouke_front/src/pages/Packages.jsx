@@ -22,6 +22,8 @@ const Packages = () => {
 	const [loading, setLoading] = useState(true);
 	const [error, setError] = useState(null);
 	const [purchasing, setPurchasing] = useState(null); // id of item being purchased
+	// Campaign links can carry the code, e.g. /packages?coupon=KARAOKE50
+	const [couponCode, setCouponCode] = useState(() => new URLSearchParams(window.location.search).get('coupon') || '');
 
 	const fetchData = useCallback(async () => {
 		try {
@@ -71,13 +73,19 @@ const Packages = () => {
 			const body = type === 'subscription'
 				? { plan_id: id }
 				: { package_id: id };
-			const response = await fetchWithAuth(`${API_BASE}/api/flip/create-bill`, {
+			if (couponCode.trim()) body.coupon_code = couponCode.trim();
+			const response = await fetchWithAuth(`${API_BASE}/api/payments/create-bill`, {
 				method: 'POST',
 				headers: { 'Content-Type': 'application/json' },
 				body: JSON.stringify(body),
 			});
 			if (!response.ok) {
 				const errData = await response.json();
+				// An unpaid bill already holds this coupon: continue paying that one
+				if (errData.pending_transaction_id) {
+					navigate(`/payment/status/${errData.pending_transaction_id}`);
+					return;
+				}
 				throw new Error(errData.error || 'Failed to create payment');
 			}
 			const data = await response.json();
@@ -92,9 +100,71 @@ const Packages = () => {
 			navigate(`/payment/status/${data.transaction_id}`, {
 				state: {
 					autoOpen: true,
-					companyCode: data.company_code,
-					productCode: data.product_code,
-					linkUrl: data.link_url,
+					companyCode: data.provider_data?.company_code,
+					productCode: data.provider_data?.product_code,
+					linkUrl: data.payment_url,
+				},
+			});
+		} catch (err) {
+			setError(err.message);
+			setPurchasing(null);
+		}
+	};
+
+	// Moving to a plan that costs more per day happens now, with the unused days credited; a cheaper
+	// plan is scheduled for the end of the current period
+	const handleChangePlan = async (plan) => {
+		const current = plans.find((p) => p.id === userInfo?.subscription_plan_id);
+		const isUpgrade = !current || plan.price * current.billing_period_days > current.price * plan.billing_period_days;
+		setPurchasing(plan.id);
+		try {
+			if (isUpgrade) {
+				const quoteRes = await fetchWithAuth(`${API_BASE}/api/subscription/upgrade`, {
+					method: 'POST',
+					headers: { 'Content-Type': 'application/json' },
+					body: JSON.stringify({ plan_id: plan.id, preview: true }),
+				});
+				const quote = await quoteRes.json();
+				if (!quoteRes.ok) {
+					if (quote.pending_transaction_id) {
+						navigate(`/payment/status/${quote.pending_transaction_id}`);
+						return;
+					}
+					throw new Error(quote.error || 'Failed to upgrade');
+				}
+				if (!window.confirm(`Upgrade to ${quote.plan} now? ${format(quote.proration_credit)} for your unused ${quote.current_plan} days is deducted, so you pay ${format(quote.amount)}.`)) {
+					setPurchasing(null);
+					return;
+				}
+			} else if (!window.confirm(`Switch to ${plan.plan_name} when your current period ends?`)) {
+				setPurchasing(null);
+				return;
+			}
+
+			const response = await fetchWithAuth(`${API_BASE}/api/subscription/${isUpgrade ? 'upgrade' : 'downgrade'}`, {
+				method: 'POST',
+				headers: { 'Content-Type': 'application/json' },
+				body: JSON.stringify({ plan_id: plan.id }),
+			});
+			const data = await response.json();
+			if (!response.ok) {
+				if (data.pending_transaction_id) {
+					navigate(`/payment/status/${data.pending_transaction_id}`);
+					return;
+				}
+				throw new Error(data.error || 'Failed to change plan');
+			}
+			if (!isUpgrade) {
+				window.alert(data.message);
+				setPurchasing(null);
+				return;
+			}
+			navigate(`/payment/status/${data.transaction_id}`, {
+				state: data.free ? undefined : {
+					autoOpen: true,
+					companyCode: data.provider_data?.company_code,
+					productCode: data.provider_data?.product_code,
+					linkUrl: data.payment_url,
 				},
 			});
 		} catch (err) {
@@ -140,6 +210,18 @@ const Packages = () => {
 				<section className="packages-hero">
 					<h1>Plans &amp; Pricing</h1>
 					<p>Start with a generous free plan. Upgrade for more daily credits and longer room sessions!</p>
+					{isAuthenticated && (
+						<div className="packages-coupon">
+							<input
+								type="text"
+								placeholder="Promo code"
+								value={couponCode}
+								onChange={(e) => setCouponCode(e.target.value.toUpperCase())}
+								maxLength={32}
+							/>
+							{couponCode && <span className="packages-coupon-hint">Applied at checkout</span>}
+						</div>
+					)}
 				</section>
 
 				{loading ? (
@@ -232,7 +314,9 @@ const Packages = () => {
 												<button
 													className="package-buy-btn"
 													disabled={purchasing === plan.id}
-													onClick={() => handlePurchase('subscription', plan.id)}
+													onClick={() => (userInfo?.subscription_plan_id && userInfo.subscription_plan_id !== plan.id
+														? handleChangePlan(plan)
+														: handlePurchase('subscription', plan.id))}
 												>
 													{(() => {
 											const isCurrentPlan = userInfo?.subscription_plan_id === plan.id;
@@ -253,7 +337,7 @@ const Packages = () => {
 						{packages.length > 0 && (
 							<section className="packages-section">
 								<h2>Extra Credits</h2>
-								<p className="packages-section-desc">Purchase additional credits that never expire. Used after your daily free credits run out.</p>
+								<p className="packages-section-desc">Purchase additional credits, used after your daily free credits run out.</p>
 								<div className="packages-grid">
 									{packages.map((pkg) => {
 										const details = parseDetail(pkg.package_detail);
@@ -267,6 +351,9 @@ const Packages = () => {
 														<span className="credits-amount">{pkg.credit_amount}</span>
 														<span className="credits-label">extra credits</span>
 													</div>
+													<div className="credits-label">
+														{pkg.validity_days ? `Valid for ${pkg.validity_days} days` : 'Never expires'}
+													</div>
 												</div>
 												<div className="package-price">
 													<span className="price-main">{format(pkg.price)}</span>
@@ -300,11 +387,11 @@ const Packages = () => {
 					<div className="faq-grid">
 						<div className="faq-item">
 							<h4>How do credits work?</h4>
-							<p>Credits are used to create karaoke rooms. Each room costs 1 credit. Free credits reset daily, extra credits never expire.</p>
+							<p>Credits are used to create karaoke rooms. Each room costs 1 credit. Free credits reset daily, extra credits last until their package's expiry date, if it has one.</p>
 						</div>
 						<div className="faq-item">
 							<h4>What's the difference between free and extra credits?</h4>
-							<p>Free credits are given daily based on your subscription plan (5 for free users). Extra credits are purchased and never expire. Free credits are used first.</p>
+							<p>Free credits are given daily based on your subscription plan (5 for free users). Extra credits are purchased and some packages expire after a set number of days. Free credits are used first, then the extra credits that expire soonest.</p>
 						</div>
 						<div className="faq-item">
 							<h4>What payment methods are accepted?</h4>
@@ -1,6 +1,6 @@
 import { useState, useEffect, useCallback, useRef } from 'react';
 import { Link, useParams, useLocation, useNavigate } from 'react-router-dom';
-import { useAuth, fetchWithAuth, getAuthToken } from '../lib/auth.jsx';
+import { useAuth, fetchWithAuth, getAuthToken, downloadWithAuth } from '../lib/auth.jsx';
 import { useCurrency } from '../lib/currency.jsx';
 
 const API_BASE = (() => {
@@ -75,9 +75,9 @@ const PaymentStatus = () => {
 				// Auto-open popup if navigated from Packages page with autoOpen flag
 				if (navState.autoOpen && !popupTriggered.current && tx && tx.status === 'pending') {
 					popupTriggered.current = true;
-					const cc = navState.companyCode || tx.flip_company_code;
-					const pc = navState.productCode || tx.flip_product_code;
-					const url = navState.linkUrl || tx.flip_url;
+					const cc = navState.companyCode || tx.provider_data?.company_code;
+					const pc = navState.productCode || tx.provider_data?.product_code;
+					const url = navState.linkUrl || tx.payment_url;
 					if (cc || url) {
 						setTimeout(() => openFlipPayment(cc, pc, url), 500);
 					}
@@ -101,12 +101,20 @@ const PaymentStatus = () => {
 	const handleContinuePayment = () => {
 		if (!transaction) return;
 		openFlipPayment(
-			transaction.flip_company_code,
-			transaction.flip_product_code,
-			transaction.flip_url,
+			transaction.provider_data?.company_code,
+			transaction.provider_data?.product_code,
+			transaction.payment_url,
 		);
 	};
 
+	const handleDownloadInvoice = async () => {
+		try {
+			await downloadWithAuth(`${API_BASE}/api/transactions/${transactionId}/invoice`);
+		} catch (err) {
+			setError(err.message);
+		}
+	};
+
 	const getStatusIcon = (status) => {
 		switch (status) {
 			case 'settlement': return '✅';
@@ -192,7 +200,7 @@ const PaymentStatus = () => {
 	const packageName = transaction?.item_name || navState.packageName;
 	const credits = transaction?.credit_amount;
 	const amount = transaction?.amount || 0;
-	const hasPaymentLink = !!(transaction?.flip_company_code || transaction?.flip_url);
+	const hasPaymentLink = !!(transaction?.provider_data?.company_code || transaction?.payment_url);
 
 	return (
 		<div className="payment-status-page">
@@ -295,6 +303,9 @@ const PaymentStatus = () => {
 					{status === 'settlement' && (
 						<>
 							<Link to="/" className="btn-primary">Go to Dashboard</Link>
+							{transaction?.amount > 0 && (
+								<button className="btn-secondary" onClick={handleDownloadInvoice}>Download Invoice</button>
+							)}
 							<Link to="/payment/history" className="btn-secondary">View Payment History</Link>
 						</>
 					)}
@@ -1,5 +1,5 @@
 import { useState } from 'react';
-import { Link, useNavigate } from 'react-router-dom';
+import { Link, useNavigate, useSearchParams } from 'react-router-dom';
 import { useAuth } from '../lib/auth.jsx';
 
 const Register = () => {
@@ -8,6 +8,8 @@ const Register = () => {
 	const [email, setEmail] = useState('');
 	const [password, setPassword] = useState('');
 	const [confirmPassword, setConfirmPassword] = useState('');
+	const [searchParams] = useSearchParams();
+	const [referralCode, setReferralCode] = useState(searchParams.get('ref') || '');
 	const [error, setError] = useState('');
 	const [isLoading, setIsLoading] = useState(false);
 	const { register } = useAuth();
@@ -30,7 +32,7 @@ const Register = () => {
 		setIsLoading(true);
 
 		try {
-			await register(name, username, email, password);
+			await register(name, username, email, password, referralCode.trim());
 			navigate('/', { replace: true });
 		} catch (err) {
 			setError(err.message);
@@ -124,6 +126,19 @@ const Register = () => {
 							/>
 						</div>
 
+						<div className="auth-field">
+							<label htmlFor="referralCode">Invite Code (optional)</label>
+							<input
+								id="referralCode"
+								type="text"
+								className="auth-input"
+								placeholder="ABCD2345"
+								value={referralCode}
+								onChange={(e) => setReferralCode(e.target.value.toUpperCase())}
+								autoComplete="off"
+							/>
+						</div>
+
 						<button type="submit" className="auth-button" disabled={isLoading}>
 							{isLoading ? (
 								<span className="auth-spinner" />
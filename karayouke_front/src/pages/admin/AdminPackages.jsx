@@ -20,6 +20,7 @@ const AdminPackages = () => {
 		package_name: '',
 		package_detail: '',
 		credit_amount: '',
+		validity_days: '',
 		price: '',
 		visibility: true,
 	});
@@ -44,7 +45,7 @@ const AdminPackages = () => {
 
 	const openCreateModal = () => {
 		setEditingPackage(null);
-		setFormData({ package_name: '', package_detail: '', credit_amount: '', price: '', visibility: true });
+		setFormData({ package_name: '', package_detail: '', credit_amount: '', validity_days: '', price: '', visibility: true });
 		setShowModal(true);
 	};
 
@@ -64,6 +65,7 @@ const AdminPackages = () => {
 			package_name: pkg.package_name || '',
 			package_detail: detail,
 			credit_amount: (pkg.credit_amount || 0).toString(),
+			validity_days: pkg.validity_days ? pkg.validity_days.toString() : '',
 			price: (pkg.price || 0).toString(),
 			visibility: pkg.visibility !== false,
 		});
@@ -78,6 +80,7 @@ const AdminPackages = () => {
 				package_name: formData.package_name,
 				package_detail: formData.package_detail,
 				credit_amount: parseInt(formData.credit_amount, 10),
+				validity_days: parseInt(formData.validity_days, 10) || 0,
 				price: parseInt(formData.price, 10) || 0,
 				visibility: formData.visibility,
 			};
@@ -147,6 +150,7 @@ const AdminPackages = () => {
 								<tr>
 									<th>Name</th>
 									<th>Credits</th>
+									<th>Expires</th>
 									<th>Price (IDR)</th>
 									<th>Visible</th>
 									<th>Actions</th>
@@ -169,6 +173,7 @@ const AdminPackages = () => {
 												)}
 											</td>
 											<td>{pkg.credit_amount}</td>
+											<td>{pkg.validity_days ? `${pkg.validity_days} days` : 'Never'}</td>
 											<td>{formatIDR(pkg.price)}</td>
 											<td>
 												<span
@@ -258,6 +263,17 @@ const AdminPackages = () => {
 									/>
 								</div>
 							</div>
+							<div className="admin-form-group">
+								<label>Credits Expire After (days)</label>
+								<input
+									type="number"
+									className="admin-input"
+									value={formData.validity_days}
+									onChange={(e) => setFormData({ ...formData, validity_days: e.target.value })}
+									min="0"
+									placeholder="Leave empty to never expire"
+								/>
+							</div>
 							<div className="admin-form-group">
 								<label className="admin-checkbox-label">
 									<input
@@ -27,6 +27,23 @@ const AdminSettings = () => {
 		flip_secret_key: { label: 'Flip API Secret Key', type: 'password', critical: true },
 		flip_validation_token: { label: 'Flip Validation Token', type: 'password', critical: true },
 		flip_environment: { label: 'Flip Environment (sandbox/production)', type: 'text', critical: true },
+		payment_provider: { label: 'Payment Provider (flip/midtrans/fake)', type: 'text', critical: true },
+		midtrans_server_key: { label: 'Midtrans Server Key', type: 'password', critical: true },
+		midtrans_environment: { label: 'Midtrans Environment (sandbox/production)', type: 'text', critical: true },
+		fake_payment_secret: { label: 'Fake Provider Callback Secret (testing only)', type: 'password' },
+		bill_validity_hours: { label: 'Unpaid Bill Validity (hours)', type: 'number' },
+		invoice_prefix: { label: 'Invoice Number Prefix', type: 'text' },
+		company_name: { label: 'Company Name (invoices)', type: 'text' },
+		company_address: { label: 'Company Address (invoices)', type: 'text' },
+		company_tax_id: { label: 'Company NPWP (invoices)', type: 'text' },
+		company_email: { label: 'Company Email (invoices)', type: 'text' },
+		referral_referrer_credits: { label: 'Referral Reward for Inviter (credits)', type: 'number' },
+		referral_referred_credits: { label: 'Referral Reward for Invited User (credits)', type: 'number' },
+		referral_require_purchase: { label: 'Referral Requires First Purchase (true/false)', type: 'text' },
+		referral_max_per_ip: { label: 'Referred Sign-ups per IP per Day', type: 'number' },
+		referral_max_per_domain: { label: 'Referrals per Email Domain per Inviter', type: 'number' },
+		subscription_renewal_days: { label: 'Renewal Bill Lead Time (days before expiry)', type: 'number' },
+		subscription_grace_days: { label: 'Renewal Grace Period (days)', type: 'number' },
 	};
 
 	useEffect(() => {
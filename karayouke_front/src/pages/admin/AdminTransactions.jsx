@@ -1,5 +1,5 @@
 import { useEffect, useState } from 'react';
-import { fetchWithAuth } from '../../lib/auth.jsx';
+import { fetchWithAuth, downloadWithAuth } from '../../lib/auth.jsx';
 import { formatIDR } from '../../lib/currency.jsx';
 import AdminLayout from './AdminLayout.jsx';
 
@@ -15,6 +15,7 @@ const statusColors = {
 	settlement: 'success',
 	failed: 'danger',
 	expired: 'muted',
+	refund_pending: 'warning',
 	refunded: 'info',
 };
 
@@ -23,11 +24,41 @@ const AdminTransactions = () => {
 	const [loading, setLoading] = useState(true);
 	const [error, setError] = useState(null);
 	const [updating, setUpdating] = useState({});
+	const [runs, setRuns] = useState([]);
+	const [reconciling, setReconciling] = useState(false);
 
 	useEffect(() => {
 		fetchTransactions();
+		fetchRuns();
 	}, []);
 
+	const fetchRuns = async () => {
+		try {
+			const response = await fetchWithAuth(`${API_BASE}/api/admin/transactions/reconcile-runs?limit=5`);
+			if (!response.ok) throw new Error('Failed to fetch reconciliation runs');
+			const data = await response.json();
+			setRuns(data.runs || []);
+		} catch (err) {
+			setError(err.message);
+		}
+	};
+
+	const handleReconcile = async () => {
+		setReconciling(true);
+		try {
+			const response = await fetchWithAuth(`${API_BASE}/api/admin/transactions/reconcile`, { method: 'POST' });
+			if (!response.ok) {
+				const data = await response.json();
+				throw new Error(data.error || 'Failed to check pending transactions');
+			}
+			await Promise.all([fetchTransactions(), fetchRuns()]);
+		} catch (err) {
+			setError(err.message);
+		} finally {
+			setReconciling(false);
+		}
+	};
+
 	const fetchTransactions = async () => {
 		try {
 			const response = await fetchWithAuth(`${API_BASE}/api/admin/transactions`);
@@ -59,6 +90,60 @@ const AdminTransactions = () => {
 		}
 	};
 
+	const handleRefund = async (id, pendingReason) => {
+		// A pending refund is retried with the reason it was started with
+		const reason = pendingReason || prompt('Reason for the refund:');
+		if (!reason || !reason.trim()) return;
+		setUpdating((prev) => ({ ...prev, [id]: true }));
+		try {
+			const refund = (options) => fetchWithAuth(`${API_BASE}/api/admin/transactions/${id}/refund`, {
+				method: 'POST',
+				headers: { 'Content-Type': 'application/json' },
+				body: JSON.stringify({ reason, ...options }),
+			});
+			let response = await refund({});
+			if (response.status === 409) {
+				const data = await response.json();
+				if (data.granted !== undefined) {
+					// Some credits were already spent
+					if (!confirm(`${data.granted} credits were granted but only ${data.available} remain. Refund anyway and claw back what is left?`)) return;
+					response = await refund({ allow_shortfall: true });
+				} else if (data.manual_required) {
+					if (!confirm(`${data.error}\n\nHas the money already been returned manually?`)) return;
+					response = await refund({ manual: true });
+				} else {
+					throw new Error(data.error || 'Failed to refund transaction');
+				}
+			}
+			if (!response.ok) {
+				const data = await response.json();
+				throw new Error(data.error || 'Failed to refund transaction');
+			}
+			if (response.status === 202) {
+				// The provider has not confirmed yet; the reconciler finishes the refund
+				const data = await response.json();
+				setError(data.error);
+			}
+			await fetchTransactions();
+		} catch (err) {
+			setError(err.message);
+		} finally {
+			setUpdating((prev) => ({ ...prev, [id]: false }));
+		}
+	};
+
+	const handleInvoice = async (id, regenerate) => {
+		setUpdating((prev) => ({ ...prev, [id]: true }));
+		try {
+			const url = `${API_BASE}/api/admin/transactions/${id}/invoice`;
+			await (regenerate ? downloadWithAuth(`${url}/regenerate`, { method: 'POST' }) : downloadWithAuth(url));
+		} catch (err) {
+			setError(err.message);
+		} finally {
+			setUpdating((prev) => ({ ...prev, [id]: false }));
+		}
+	};
+
 	return (
 		<AdminLayout title="Transactions">
 			{loading ? (
@@ -67,8 +152,55 @@ const AdminTransactions = () => {
 				</div>
 			) : (
 				<div className="admin-transactions">
+					<div className="admin-toolbar">
+						<button className="admin-btn admin-btn-primary" onClick={handleReconcile} disabled={reconciling}>
+							{reconciling ? 'Checking...' : 'Check Pending Payments'}
+						</button>
+					</div>
+
 					{error && <div className="admin-error">{error}</div>}
 
+					{runs.length > 0 && (
+						<div className="admin-card">
+							<h3 className="admin-card-title">Payment Reconciliation</h3>
+							<p className="admin-card-subtitle">Recent background checks of pending transactions</p>
+							<div className="admin-table-container">
+								<table className="admin-table">
+									<thead>
+										<tr>
+											<th>Started</th>
+											<th>Trigger</th>
+											<th>Checked</th>
+											<th>Settled</th>
+											<th>Failed</th>
+											<th>Expired</th>
+											<th>Pending</th>
+											<th>Refunded</th>
+											<th>Errors</th>
+										</tr>
+									</thead>
+									<tbody>
+										{runs.map((run) => (
+											<tr key={run.id}>
+												<td>{new Date(run.started_at).toLocaleString('id-ID')}</td>
+												<td>{run.trigger}</td>
+												<td>{run.checked}</td>
+												<td>{run.settled}</td>
+												<td>{run.failed}</td>
+												<td>{run.expired}</td>
+												<td>{run.still_pending}</td>
+												<td>{run.refunded || 0}</td>
+												<td title={(run.items || []).filter((item) => item.error).map((item) => `${item.transaction_id}: ${item.error}`).join('\n')}>
+													{run.errors}
+												</td>
+											</tr>
+										))}
+									</tbody>
+								</table>
+							</div>
+						</div>
+					)}
+
 					<div className="admin-table-container">
 						<table className="admin-table">
 							<thead>
@@ -140,9 +272,70 @@ const AdminTransactions = () => {
 													</div>
 												)}
 												{tx.status === 'settlement' && (
-													<span className="admin-cell-secondary">Completed</span>
+													<div className="admin-actions">
+														<button
+															className="admin-btn admin-btn-sm"
+															onClick={() => handleInvoice(tx.id, false)}
+															disabled={updating[tx.id]}
+														>
+															Invoice
+														</button>
+														<button
+															className="admin-btn admin-btn-sm"
+															onClick={() => handleInvoice(tx.id, true)}
+															disabled={updating[tx.id]}
+															title="Refresh buyer and company details, keeping the invoice number"
+														>
+															Regenerate
+														</button>
+														<button
+															className="admin-btn admin-btn-sm admin-btn-danger"
+															onClick={() => handleRefund(tx.id)}
+															disabled={updating[tx.id]}
+														>
+															Refund
+														</button>
+													</div>
+												)}
+												{tx.status === 'refunded' && (
+													<div className="admin-actions">
+														<span className="admin-cell-secondary" title={tx.refund_reason}>Refunded</span>
+														<button
+															className="admin-btn admin-btn-sm"
+															onClick={() => handleInvoice(tx.id, false)}
+															disabled={updating[tx.id]}
+														>
+															Invoice
+														</button>
+													</div>
+												)}
+												{tx.status === 'refund_pending' && (
+													<div className="admin-actions">
+														<span className="admin-cell-secondary" title={tx.refund_reason}>Refund pending</span>
+														<button
+															className="admin-btn admin-btn-sm"
+															onClick={() => handleRefund(tx.id, tx.refund_reason)}
+															disabled={updating[tx.id]}
+														>
+															Retry
+														</button>
+													</div>
+												)}
+												{tx.payment_issue && (tx.status === 'expired' || tx.status === 'failed') && (
+													<div className="admin-actions">
+														<span className="admin-badge danger" title={tx.payment_issue}>
+															{tx.paid_amount ? 'Wrong amount' : 'Paid late'}
+														</span>
+														<button
+															className="admin-btn admin-btn-sm admin-btn-danger"
+															onClick={() => handleRefund(tx.id)}
+															disabled={updating[tx.id]}
+														>
+															Refund
+														</button>
+													</div>
 												)}
-												{tx.status === 'failed' && (
+												{tx.status === 'failed' && !tx.payment_issue && (
 													<span className="admin-cell-secondary">-</span>
 												)}
 											</td>
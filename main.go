@@ -4,12 +4,16 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"GoFiberMVC/app/artisan"
+	"GoFiberMVC/app/controllers"
 	"GoFiberMVC/app/initializers"
 	"GoFiberMVC/app/models"
 	"GoFiberMVC/app/providers"
 	"GoFiberMVC/app/routes"
+
+	"github.com/spf13/viper"
 )
 
 func main() {
@@ -29,6 +33,15 @@ func main() {
 	} else {
 		// Auto-migrate to add any new columns (e.g., Room.MaxDuration)
 		initializers.Db.AutoMigrate(&models.Room{})
+
+		// Settle or expire pending payments whose callback never arrived
+		controllers.StartPaymentReconciler(time.Duration(viper.GetInt("payments.reconcileIntervalSeconds")) * time.Second)
+
+		// Bill auto-renewing subscriptions ahead of expiry and lapse those past their grace period
+		controllers.StartSubscriptionRenewer(time.Duration(viper.GetInt("payments.renewalIntervalSeconds")) * time.Second)
+
+		// Take expired purchased credits off users' balances
+		controllers.StartCreditExpiry(time.Duration(viper.GetInt("payments.creditExpiryIntervalSeconds")) * time.Second)
 	}
 
 	routes.RegisterWebRoutes(app)